@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/istio"
+	"smanalyzer/pkg/progress"
+	"smanalyzer/pkg/report"
+	"smanalyzer/pkg/telemetry"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var generateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Write a synthetic scan report for demos and testing",
+	Long: `Fabricates a scan report of healthy services with faults injected into a
+few of them, without a cluster. The anomalies are found by running detection
+over the generated metrics, so the report replays like a recorded scan:
+
+  smanalyzer generate --services 10 --anomalies 3 --out report.json
+  smanalyzer replay report.json`,
+	Args: cobra.NoArgs,
+	Run:  runGenerate,
+}
+
+var (
+	generateServices  int
+	generateAnomalies int
+	generateOut       string
+	generateSeed      int64
+)
+
+func init() {
+	rootCmd.AddCommand(generateCmd)
+
+	generateCmd.Flags().IntVar(&generateServices, "services", 10, "Number of services to generate")
+	generateCmd.Flags().IntVar(&generateAnomalies, "anomalies", 3, "Number of services to inject a fault into, each raising one anomaly")
+	generateCmd.Flags().StringVar(&generateOut, "out", "report.json", "File to write the report to")
+	generateCmd.Flags().Int64Var(&generateSeed, "seed", 0, "Random seed, for a reproducible report (0 picks one)")
+}
+
+func runGenerate(cmd *cobra.Command, args []string) {
+	cfg, err := config.Load(viper.GetViper())
+	if err != nil {
+		log.Fatalf("Generate failed: %v", err)
+	}
+
+	seed := generateSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	r, err := generateReport(generateServices, generateAnomalies, rand.New(rand.NewSource(seed)), time.Now(), cfg)
+	if err != nil {
+		log.Fatalf("Generate failed: %v", err)
+	}
+	if err := report.Write(generateOut, r); err != nil {
+		log.Fatalf("Generate failed: %v", err)
+	}
+	progress.Printf("✓ Wrote %d services and %d anomalies to %s\n", len(r.Metrics), len(r.Anomalies), generateOut)
+}
+
+// demoServiceNames name the generated services; more are numbered.
+var demoServiceNames = []string{
+	"productpage", "reviews", "ratings", "details", "cart", "checkout",
+	"payments", "inventory", "shipping", "catalog", "search", "auth",
+}
+
+// fault turns a healthy service's signals into ones detection flags with
+// the default thresholds. Error rate, traffic, retry and timeout detection
+// compare against earlier scans, so only faults visible in a single scan
+// are injected.
+type fault func(n *telemetry.Normalized, rng *rand.Rand)
+
+var demoFaults = []fault{
+	// Upstream hosts ejected by outlier detection
+	func(n *telemetry.Normalized, rng *rand.Rand) {
+		n.EjectionsActive = float64(1 + rng.Intn(4))
+	},
+	// A circuit breaker close to tripping, 85-99% used
+	func(n *telemetry.Normalized, rng *rand.Rand) {
+		n.CircuitBreakerUsage = 0.85 + float64(rng.Intn(15))/100
+	},
+	// Tail latency amplification, P99 at 15-25x P50
+	func(n *telemetry.Normalized, rng *rand.Rand) {
+		n.LatencyP99 = n.LatencyP50 * time.Duration(15+rng.Intn(10))
+		n.LatencyP95 = n.LatencyP99 / 2
+	},
+	// An open circuit breaker
+	func(n *telemetry.Normalized, rng *rand.Rand) {
+		n.CircuitBreakersOpen = 1
+	},
+}
+
+// generateReport fabricates a scan of services in the demo namespace, at
+// at, with a fault injected into anomalies of them, and runs detection over
+// it with cfg.
+func generateReport(services, anomalies int, rng *rand.Rand, at time.Time, cfg *config.Config) (*report.Report, error) {
+	if services < 1 {
+		return nil, fmt.Errorf("--services must be at least 1, got %d", services)
+	}
+	if anomalies < 0 || anomalies > services {
+		return nil, fmt.Errorf("--anomalies must be between 0 and --services (%d), got %d", services, anomalies)
+	}
+
+	r := &report.Report{GeneratedAt: at, Namespace: "demo"}
+	faulty := rng.Perm(services)[:anomalies]
+	faults := make(map[int]fault, anomalies)
+	for i, service := range faulty {
+		faults[service] = demoFaults[i%len(demoFaults)]
+	}
+
+	for i := 0; i < services; i++ {
+		name := demoServiceNames[i%len(demoServiceNames)]
+		if i >= len(demoServiceNames) {
+			name = fmt.Sprintf("%s-%d", name, i/len(demoServiceNames)+1)
+		}
+
+		n := healthySignals(rng)
+		if inject, ok := faults[i]; ok {
+			inject(&n, rng)
+		}
+
+		metrics := &istio.ServiceMeshMetrics{
+			ServiceName: name,
+			Namespace:   r.Namespace,
+			Timestamp:   at,
+			Labels:      map[string]string{"app": name},
+			Replicas:    2 + rng.Intn(3),
+		}
+		metrics.ApplyNormalized(n)
+		r.Metrics = append(r.Metrics, metrics)
+	}
+
+	found, err := report.Replay([]*report.Report{r}, cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.Anomalies = found
+	return r, nil
+}
+
+// healthySignals are the signals of a service well inside the default
+// thresholds.
+func healthySignals(rng *rand.Rand) telemetry.Normalized {
+	requests := float64(600 + rng.Intn(5400))
+	p50 := time.Duration(10+rng.Intn(30)) * time.Millisecond
+	return telemetry.Normalized{
+		Requests:          requests,
+		Errors4xx:         requests * rng.Float64() * 0.005,
+		Errors5xx:         requests * rng.Float64() * 0.004,
+		Retries:           float64(rng.Intn(20)),
+		Timeouts:          float64(rng.Intn(3)),
+		LatencyP50:        p50,
+		LatencyP90:        p50 * 2,
+		LatencyP95:        p50 * 3,
+		LatencyP99:        p50 * time.Duration(4+rng.Intn(3)),
+		InboundBytes:      requests * 512,
+		OutboundBytes:     requests * 2048,
+		RequestSizeMean:   512,
+		ResponseSizeMean:  2048,
+		ActiveConnections: float64(5 + rng.Intn(40)),
+		PendingRequests:   float64(rng.Intn(5)),
+		CPUUsage:          0.2 + rng.Float64()*0.4,
+		MemoryUsage:       0.3 + rng.Float64()*0.3,
+	}
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/report"
+)
+
+func TestGenerateReport_Counts(t *testing.T) {
+	at := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+
+	for seed := int64(1); seed <= 20; seed++ {
+		r, err := generateReport(10, 3, rand.New(rand.NewSource(seed)), at, config.DefaultConfig())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if len(r.Metrics) != 10 {
+			t.Errorf("seed %d: expected 10 services, got %d", seed, len(r.Metrics))
+		}
+		if len(r.Anomalies) != 3 {
+			t.Errorf("seed %d: expected 3 anomalies, got %+v", seed, r.Anomalies)
+		}
+		anomalous := make(map[string]bool)
+		for _, a := range r.Anomalies {
+			anomalous[a.ServiceName] = true
+		}
+		if len(anomalous) != 3 {
+			t.Errorf("seed %d: expected anomalies on 3 services, got %v", seed, anomalous)
+		}
+	}
+}
+
+func TestGenerateReport_Replays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	generated, err := generateReport(30, 5, rand.New(rand.NewSource(7)), time.Now(), config.DefaultConfig())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := report.Write(path, generated); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	read, err := report.Read(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	replayed, err := report.Replay([]*report.Report{read}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(read.Metrics) != 30 || len(replayed) != 5 {
+		t.Errorf("Expected 30 services replaying to 5 anomalies, got %d and %+v", len(read.Metrics), replayed)
+	}
+
+	names := make(map[string]bool)
+	for _, m := range read.Metrics {
+		if names[m.ServiceName] {
+			t.Errorf("Expected unique service names, got %s twice", m.ServiceName)
+		}
+		names[m.ServiceName] = true
+	}
+}
+
+func TestGenerateReport_InvalidCounts(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	if _, err := generateReport(0, 0, rng, time.Now(), nil); err == nil {
+		t.Error("Expected an error without services")
+	}
+	if _, err := generateReport(2, 3, rng, time.Now(), nil); err == nil {
+		t.Error("Expected an error with more anomalies than services")
+	}
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/history"
+	"smanalyzer/pkg/output"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history <file>",
+	Short: "Query the anomalies recorded with 'scan --history'",
+	Long: `Lists past anomalies from a history recorded with 'scan --history',
+filtered by service, namespace, type, severity and time. With --by-day the
+matching anomalies are counted per UTC day instead, e.g. the error anomalies
+of checkout over the last week:
+
+  smanalyzer history anomalies.db --service checkout --type error_rate_high --since 168h --by-day
+
+With --recent the services are ranked by their recent trouble score
+instead: the severities of their anomalies summed, each halved for every
+history.half_life of its age, so fresh incidents outweigh old ones.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runHistory,
+}
+
+var (
+	historyService     string
+	historyNamespace   string
+	historyType        string
+	historyMinSeverity float64
+	historySince       time.Duration
+	historyByDay       bool
+	historyRecent      bool
+)
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().StringVar(&historyService, "service", "", "Only anomalies of this service")
+	historyCmd.Flags().StringVarP(&historyNamespace, "namespace", "n", "", "Only anomalies in this namespace")
+	historyCmd.Flags().StringVar(&historyType, "type", "", "Only anomalies of this type, e.g. error_rate_high")
+	historyCmd.Flags().Float64Var(&historyMinSeverity, "min-severity", 0, "Only anomalies at least this severe")
+	historyCmd.Flags().DurationVar(&historySince, "since", 0, "Only anomalies from this long ago onwards, e.g. 168h (0 for all)")
+	historyCmd.Flags().BoolVar(&historyByDay, "by-day", false, "Count the matching anomalies per day instead of listing them")
+	historyCmd.Flags().BoolVar(&historyRecent, "recent", false, "Rank the services by recent trouble, weighting each anomaly's severity down by its age (see history.half_life)")
+}
+
+func runHistory(cmd *cobra.Command, args []string) {
+	cfg, err := config.Load(viper.GetViper())
+	if err != nil {
+		log.Fatalf("History failed: %v", err)
+	}
+
+	filter := history.Filter{
+		Service:     historyService,
+		Namespace:   historyNamespace,
+		Type:        anomaly.AnomalyType(historyType),
+		MinSeverity: historyMinSeverity,
+	}
+	if historySince > 0 {
+		filter.Since = time.Now().Add(-historySince)
+	}
+
+	if historyRecent {
+		if historyByDay {
+			log.Fatalf("History failed: --recent and --by-day can't be combined")
+		}
+		if err := rankRecentTrouble(os.Stdout, args[0], filter, cfg.History.HalfLife, time.Now()); err != nil {
+			log.Fatalf("History failed: %v", err)
+		}
+		return
+	}
+	if err := queryHistory(os.Stdout, args[0], filter, historyByDay, cfg); err != nil {
+		log.Fatalf("History failed: %v", err)
+	}
+}
+
+// openHistory opens the existing history at path; a missing file is an
+// error rather than a new, empty history.
+func openHistory(path string) (history.Store, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open anomaly history: %w", err)
+	}
+	return history.Open(path)
+}
+
+// queryHistory writes the anomalies in the history at path matching filter
+// to out, or their daily counts with byDay.
+func queryHistory(out io.Writer, path string, filter history.Filter, byDay bool, cfg *config.Config) error {
+	store, err := openHistory(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if byDay {
+		counts, err := store.CountByDay(filter)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(out, formatDailyCounts(counts))
+		return nil
+	}
+
+	anomalies, err := store.Query(filter)
+	if err != nil {
+		return err
+	}
+
+	formatter := output.NewFormatter(cfg.Output.Format)
+	formatter.SetColumns(cfg.Output.Columns)
+	descriptions, err := cfg.ToDescriptionTemplates()
+	if err != nil {
+		return err
+	}
+	formatter.SetDescriptionTemplates(descriptions)
+	fmt.Fprint(out, formatter.FormatAnomalies(anomalies))
+	return nil
+}
+
+// rankRecentTrouble writes the services with anomalies in the history at
+// path matching filter to out, most recent trouble first, as of now.
+func rankRecentTrouble(out io.Writer, path string, filter history.Filter, halfLife time.Duration, now time.Time) error {
+	store, err := openHistory(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	anomalies, err := store.Query(filter)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(out, formatTroubleScores(anomaly.RecentTroubleScores(anomalies, now, halfLife)))
+	return nil
+}
+
+func formatTroubleScores(scores map[string]float64) string {
+	if len(scores) == 0 {
+		return "No anomalies recorded.\n"
+	}
+
+	services := make([]string, 0, len(scores))
+	for service := range scores {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if scores[services[i]] != scores[services[j]] {
+			return scores[services[i]] > scores[services[j]]
+		}
+		return services[i] < services[j]
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-40s  %s\n", "SERVICE", "RECENT TROUBLE")
+	for _, service := range services {
+		fmt.Fprintf(&b, "%-40s  %.2f\n", service, scores[service])
+	}
+	return b.String()
+}
+
+func formatDailyCounts(counts []history.DailyCount) string {
+	if len(counts) == 0 {
+		return "No anomalies recorded.\n"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-10s  %5s  %s\n", "DAY", "COUNT", "WORST")
+	for _, c := range counts {
+		fmt.Fprintf(&b, "%-10s  %5d  %s\n", c.Day.Format("2006-01-02"), c.Count, anomaly.SeverityText(c.MaxSeverity))
+	}
+	return b.String()
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/istio"
+	"smanalyzer/pkg/progress"
+	"smanalyzer/pkg/telemetry"
+)
+
+// fakeMesh is an istio.Discoverer serving a scripted series of scrapes:
+// every service's n-th collection returns the n-th entry of its script.
+type fakeMesh struct {
+	scripts   map[string][]telemetry.Normalized
+	collected map[string]int
+}
+
+func newFakeMesh(scripts map[string][]telemetry.Normalized) *fakeMesh {
+	return &fakeMesh{scripts: scripts, collected: make(map[string]int)}
+}
+
+func (f *fakeMesh) DiscoverServices(ctx context.Context, namespace string) ([]string, error) {
+	var services []string
+	for key := range f.scripts {
+		if _, ns, _ := strings.Cut(key, "."); namespace == "" || ns == namespace {
+			services = append(services, key)
+		}
+	}
+	return services, nil
+}
+
+func (f *fakeMesh) CollectMetrics(ctx context.Context, namespace, serviceName string) (*istio.ServiceMeshMetrics, error) {
+	key := serviceName + "." + namespace
+	script := f.scripts[key]
+	step := f.collected[key]
+	if step >= len(script) {
+		return nil, fmt.Errorf("no scripted scrape %d for %s", step, key)
+	}
+	f.collected[key]++
+
+	metrics := &istio.ServiceMeshMetrics{
+		ServiceName: serviceName,
+		Namespace:   namespace,
+		Timestamp:   time.Now(),
+	}
+	metrics.ApplyNormalized(script[step])
+	return metrics, nil
+}
+
+func steadyScrapes(n int, requests, errors5xx float64) []telemetry.Normalized {
+	var scrapes []telemetry.Normalized
+	for i := 0; i < n; i++ {
+		scrapes = append(scrapes, telemetry.Normalized{
+			Requests:   requests,
+			Errors5xx:  errors5xx,
+			LatencyP50: 10 * time.Millisecond,
+			LatencyP99: 20 * time.Millisecond,
+		})
+	}
+	return scrapes
+}
+
+// growingScrapes is n scrapes of cumulative counters that grow by requests
+// and errors5xx each time, as a proxy serving steady traffic reports them.
+func growingScrapes(n int, requests, errors5xx float64) []telemetry.Normalized {
+	scrapes := steadyScrapes(n, requests, errors5xx)
+	for i := range scrapes {
+		scrapes[i].Requests *= float64(i + 1)
+		scrapes[i].Errors5xx *= float64(i + 1)
+	}
+	return scrapes
+}
+
+// TestPipeline_ScriptedIncident runs repeated scans through discovery,
+// collection, storage, detection and formatting, sharing a data file so the
+// series accumulate. reviews' traffic quadruples with 10% errors after five
+// quiet scrapes; ratings stays steady throughout.
+func TestPipeline_ScriptedIncident(t *testing.T) {
+	var chatter bytes.Buffer
+	progress.SetOutput(&chatter)
+	progress.SetQuiet(true)
+	dataFile = filepath.Join(t.TempDir(), "series.json")
+	t.Cleanup(func() {
+		progress.SetOutput(os.Stdout)
+		progress.SetQuiet(false)
+		dataFile = ""
+	})
+
+	mesh := newFakeMesh(map[string][]telemetry.Normalized{
+		"reviews.shop": append(steadyScrapes(5, 100, 0), steadyScrapes(3, 400, 40)...),
+		"ratings.shop": steadyScrapes(8, 100, 0),
+	})
+	clusters := []istio.Cluster{{Discovery: mesh}}
+	cfg := config.DefaultConfig()
+
+	var outputs []string
+	for scan := 0; scan < 8; scan++ {
+		var stdout bytes.Buffer
+		if err := analyze(context.Background(), &stdout, cfg, clusters, nil, nil, nil, nil); err != nil {
+			t.Fatalf("Scan %d failed: %v", scan+1, err)
+		}
+		outputs = append(outputs, stdout.String())
+	}
+
+	for scan, out := range outputs[:5] {
+		if out != "No anomalies detected.\n" {
+			t.Errorf("Expected scan %d to be clean, got:\n%s", scan+1, out)
+		}
+	}
+
+	last := outputs[len(outputs)-1]
+	for _, expected := range []string{
+		"Found 2 anomalies:",
+		"Traffic spike detected: 400.00 requests",
+		"High error rate: 10.00%",
+		"Service: reviews.shop",
+		"Type: traffic_spike",
+		"Type: error_rate_high",
+	} {
+		if !strings.Contains(last, expected) {
+			t.Errorf("Expected the final scan to report %q, got:\n%s", expected, last)
+		}
+	}
+	if strings.Contains(last, "ratings") {
+		t.Errorf("Expected no anomalies for the steady ratings service, got:\n%s", last)
+	}
+}
+
+func TestPipeline_FirstOccurrenceAcrossScans(t *testing.T) {
+	progress.SetQuiet(true)
+	dataFile = filepath.Join(t.TempDir(), "series.json")
+	t.Cleanup(func() {
+		progress.SetQuiet(false)
+		dataFile = ""
+	})
+
+	mesh := newFakeMesh(map[string][]telemetry.Normalized{
+		"reviews.shop": growingScrapes(3, 100, 10),
+	})
+	clusters := []istio.Cluster{{Discovery: mesh}}
+
+	var outputs []string
+	for scan := 0; scan < 3; scan++ {
+		var stdout bytes.Buffer
+		if err := analyze(context.Background(), &stdout, config.DefaultConfig(), clusters, nil, nil, nil, nil); err != nil {
+			t.Fatalf("Scan %d failed: %v", scan+1, err)
+		}
+		outputs = append(outputs, stdout.String())
+	}
+
+	// The first scan has a single point, too few to detect on
+	if !strings.Contains(outputs[1], "1. [NEW] High error rate") {
+		t.Errorf("Expected the error flagged as new when first raised, got:\n%s", outputs[1])
+	}
+	if !strings.Contains(outputs[2], "1. High error rate") {
+		t.Errorf("Expected the ongoing error without the marker, got:\n%s", outputs[2])
+	}
+}
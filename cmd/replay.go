@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/output"
+	"smanalyzer/pkg/progress"
+	"smanalyzer/pkg/report"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <report.json>...",
+	Short: "Replay recorded scan reports through detection",
+	Long: `Loads reports recorded with 'scan --report', feeds their metrics through
+storage and detection in time order, and prints the resulting anomalies.
+Override thresholds to see how a different config would have reacted.
+
+With --replay-speed, detection runs after every report as it did during the
+recorded scans, on a clock driven by the report timestamps: 0 replays
+instantly, 1 in real time and N at N times real time.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runReplay,
+}
+
+var (
+	replayErrorThreshold   float64
+	replayTrafficThreshold float64
+	replaySpeed            float64
+	replayCooldown         time.Duration
+)
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	replayCmd.Flags().Float64Var(&replayErrorThreshold, "error-threshold", 0, "Override detection.error_rate_threshold (fraction, e.g. 0.02)")
+	replayCmd.Flags().Float64Var(&replayTrafficThreshold, "traffic-threshold", 0, "Override detection.traffic_spike_threshold")
+	replayCmd.Flags().Float64Var(&replaySpeed, "replay-speed", 0, "Step through the reports in recorded time, detecting after each: 0 instantly, 1 in real time, N at N times real time")
+	replayCmd.Flags().DurationVar(&replayCooldown, "cooldown", 0, "With --replay-speed, suppress repeats of an anomaly for a service within this much recorded time")
+}
+
+func runReplay(cmd *cobra.Command, args []string) {
+	cfg, err := config.Load(viper.GetViper())
+	if err != nil {
+		log.Fatalf("Replay failed: %v", err)
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("error-threshold") {
+		cfg.Detection.ErrorRateThreshold = replayErrorThreshold
+	}
+	if flags.Changed("traffic-threshold") {
+		cfg.Detection.TrafficSpikeThreshold = replayTrafficThreshold
+	}
+
+	var simulate *report.SimulateOptions
+	if flags.Changed("replay-speed") {
+		simulate = &report.SimulateOptions{Speed: replaySpeed, Cooldown: replayCooldown}
+	}
+
+	if err := replayReports(os.Stdout, args, cfg, simulate); err != nil {
+		log.Fatalf("Replay failed: %v", err)
+	}
+}
+
+// replayReports replays the report files with cfg and writes the formatted
+// anomalies to out. With simulate set, the reports are stepped through one
+// at a time instead of detected over once.
+func replayReports(out io.Writer, paths []string, cfg *config.Config, simulate *report.SimulateOptions) error {
+	var reports []*report.Report
+	for _, path := range paths {
+		r, err := report.Read(path)
+		if err != nil {
+			return err
+		}
+		progress.Printf("Loaded %d services from %s\n", len(r.Metrics), path)
+		reports = append(reports, r)
+	}
+
+	var anomalies []anomaly.Anomaly
+	var err error
+	if simulate != nil {
+		anomalies, err = report.Simulate(reports, cfg, *simulate)
+	} else {
+		anomalies, err = report.Replay(reports, cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	formatter := output.NewFormatter(cfg.Output.Format)
+	formatter.SetHealthWeights(cfg.Health)
+	formatter.SetColumns(cfg.Output.Columns)
+	descriptions, err := cfg.ToDescriptionTemplates()
+	if err != nil {
+		return err
+	}
+	formatter.SetDescriptionTemplates(descriptions)
+
+	progress.Println()
+	fmt.Fprint(out, formatter.FormatAnomalies(anomalies))
+	return nil
+}
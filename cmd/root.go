@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"os"
 
+	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/progress"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -11,6 +14,10 @@ import (
 var (
 	cfgFile string
 	verbose bool
+	quiet   bool
+
+	outputFormat  string
+	outputColumns []string
 )
 
 var rootCmd = &cobra.Command{
@@ -30,11 +37,19 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.smanalyzer.yaml)")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "suppress progress output, printing only the result")
+
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "format", "o", "text", "output format (text, table, json); overrides output.format in the config")
+	rootCmd.PersistentFlags().StringSliceVar(&outputColumns, "columns", nil, "comma-separated table columns to show, in order (e.g. service,severity,description); overrides output.columns in the config")
 
 	viper.BindPFlag("verbose", rootCmd.PersistentFlags().Lookup("verbose"))
+	viper.BindPFlag("output.format", rootCmd.PersistentFlags().Lookup("format"))
+	viper.BindPFlag("output.columns", rootCmd.PersistentFlags().Lookup("columns"))
 }
 
 func initConfig() {
+	progress.SetQuiet(quiet)
+
 	if cfgFile != "" {
 		viper.SetConfigFile(cfgFile)
 	} else {
@@ -47,7 +62,7 @@ func initConfig() {
 		viper.SetConfigName(".smanalyzer")
 	}
 
-	viper.AutomaticEnv()
+	config.ConfigureEnv(viper.GetViper())
 
 	if err := viper.ReadInConfig(); err == nil && viper.GetBool("verbose") {
 		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
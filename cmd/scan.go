@@ -2,20 +2,35 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"runtime/pprof"
 	"strings"
 	"time"
 
 	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/bundle"
 	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/health"
+	"smanalyzer/pkg/history"
 	"smanalyzer/pkg/istio"
 	"smanalyzer/pkg/k8s"
 	"smanalyzer/pkg/ml"
+	"smanalyzer/pkg/notify"
+	"smanalyzer/pkg/otlp"
 	"smanalyzer/pkg/output"
+	"smanalyzer/pkg/profile"
+	"smanalyzer/pkg/progress"
+	"smanalyzer/pkg/report"
+	"smanalyzer/pkg/telemetry"
 	"smanalyzer/pkg/timeseries"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var scanCmd = &cobra.Command{
@@ -27,136 +42,995 @@ and detect anomalies in network traffic, circuit breaking, retries, and timeouts
 }
 
 var (
-	namespace    string
-	duration     time.Duration
-	learningMode bool
+	namespace         string
+	namespaceSelector string
+	duration          time.Duration
+	learningMode      bool
+	cacheTTL          time.Duration
+	meshType          string
+	bundleDir         string
+	redactIPs         bool
+	dataFile          string
+	emitEvents        bool
+	kubeContexts      []string
+	failOnSeverity    float64
+	reportFile        string
+	profileScan       bool
+	cpuProfile        string
+	sampleRate        float64
+	topServices       int
+	topBy             string
+	compareBaseline   bool
+	changesOnly       bool
+	historyFile       string
+	scanSignals       []string
+	requireServices   bool
+	otlpEndpoint      string
+	dryRun            bool
+	skipControlPlane  bool
+	byRoute           bool
+	compareWindow     time.Duration
+	compareAt         string
+	skipIdle          bool
 )
 
 func init() {
 	rootCmd.AddCommand(scanCmd)
 
 	scanCmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Kubernetes namespace to scan (default: all namespaces)")
+	scanCmd.Flags().StringVar(&namespaceSelector, "namespace-selector", "", "Scan the namespaces whose labels match this selector, e.g. monitoring=enabled (default: all namespaces)")
+	scanCmd.MarkFlagsMutuallyExclusive("namespace", "namespace-selector")
 	scanCmd.Flags().DurationVarP(&duration, "duration", "d", 5*time.Minute, "Duration to scan for (e.g., 5m, 1h)")
 	scanCmd.Flags().BoolVarP(&learningMode, "learn", "l", false, "Learning mode - establish baseline behavior patterns")
+	scanCmd.Flags().StringVar(&meshType, "mesh", string(istio.MeshIstio), "Service mesh data plane (istio, istio-ambient, linkerd)")
+	scanCmd.Flags().StringVar(&bundleDir, "bundle", "", "Write metrics, time series, baseline, config, and anomalies to this directory for offline reproduction")
+	scanCmd.Flags().BoolVar(&redactIPs, "bundle-redact-ips", false, "Redact pod IPs from the bundle")
+	scanCmd.Flags().StringVar(&reportFile, "report", "", "Record the collected metrics and anomalies to this JSON file for later replay")
+	scanCmd.Flags().StringVar(&dataFile, "data-file", "", "Load time series from this file before scanning and save them back afterwards, so learning accumulates across runs")
+	scanCmd.Flags().BoolVar(&emitEvents, "emit-events", false, "Record detected anomalies as Kubernetes Events on the owning Deployment or Service")
+	scanCmd.Flags().StringSliceVar(&kubeContexts, "contexts", nil, "Kubeconfig contexts of the clusters in a multi-cluster mesh (default: current context)")
+	scanCmd.Flags().DurationVar(&cacheTTL, "cache-ttl", 0, "Reuse collected metrics for this long before scraping a service again (0 disables)")
+	scanCmd.Flags().BoolVar(&skipControlPlane, "ignore-control-plane", false, "Skip the istiod health check and scan whatever sidecars respond; results may be stale or partial while the control plane is down")
+	scanCmd.Flags().BoolVar(&byRoute, "by-route", false, "Also store and detect error anomalies per route (the request_operation label), at the cost of a series per route")
+	scanCmd.Flags().BoolVar(&requireServices, "require-services", true, "Exit with code 2 when no meshed services are found; with --require-services=false an empty mesh is only a warning")
+	scanCmd.Flags().Float64Var(&failOnSeverity, "fail-on-severity", 0, "Exit with code 3 when an anomaly reaches this severity (0 disables)")
+	scanCmd.Flags().BoolVar(&profileScan, "profile", false, "Print the time spent in each scan phase (discovery, per-service collection, detection, formatting) to stderr")
+	scanCmd.Flags().StringVar(&cpuProfile, "cpu-profile", "", "Write a pprof CPU profile of the scan to this file")
+	scanCmd.Flags().IntVar(&topServices, "top", 0, "Show only the N worst services, ranked as --top-by sets: by default anomaly count and severity, then error rate, then P99 latency (0 shows all)")
+	scanCmd.Flags().StringVar(&topBy, "top-by", string(output.ByHealth), "Rank services for --top by health (anomalies first), error-rate, p99 or rps")
+	scanCmd.Flags().BoolVar(&compareBaseline, "compare-baseline", false, "Show each service's metrics annotated with their deviation from the baseline averaged over the --data-file history")
+	scanCmd.Flags().DurationVar(&compareWindow, "compare-window", 0, "Compare each service's golden signals in this window before and after a deploy, from the --data-file history, and report regressions (0 disables)")
+	scanCmd.Flags().StringVar(&compareAt, "compare-at", "", "Split --compare-window at this RFC3339 time (default: each service's Deployment's latest rollout)")
+	scanCmd.Flags().BoolVar(&changesOnly, "changes-only", false, "Show the metrics of only the services where a metric changed since the last scan in the --data-file history")
+	scanCmd.Flags().StringVar(&historyFile, "history", "", "Append detected anomalies to this history for 'smanalyzer history': SQLite for .db/.sqlite files, JSON lines otherwise")
+	scanCmd.Flags().StringSliceVar(&scanSignals, "metrics", nil, "Collect only these signal families for a quicker, lighter scan: errors, latency, traffic, saturation (default: all)")
+	scanCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Discover services and list the pods that would be scraped, and how, without collecting metrics or running detection")
+	scanCmd.Flags().StringVar(&otlpEndpoint, "otlp-endpoint", "", "Push each service's health score, error rate, P99 latency and anomaly counts to this OTLP/HTTP endpoint, e.g. http://otel-collector:4318")
+	scanCmd.Flags().BoolVar(&skipIdle, "skip-idle", false, "Leave out services whose request count didn't grow between any scans in the --data-file history (or that never served one), re-checking them every 15m; services whose traffic stopped are reported as went_idle")
+	scanCmd.Flags().Float64Var(&sampleRate, "sample-rate", 0, "Collect only this fraction of services per scan, least recently sampled first, so every service is covered over several scans (0 or 1 collects all; requires --data-file)")
 }
 
 func runScan(cmd *cobra.Command, args []string) {
 	ctx := context.Background()
 
-	fmt.Printf("Starting Service Mesh scan...\n")
+	progress.Printf("Starting Service Mesh scan...\n")
 	if namespace != "" {
-		fmt.Printf("Namespace: %s\n", namespace)
+		progress.Printf("Namespace: %s\n", namespace)
+	} else if namespaceSelector != "" {
+		progress.Printf("Namespaces matching: %s\n", namespaceSelector)
 	} else {
-		fmt.Printf("Scanning all namespaces\n")
+		progress.Printf("Scanning all namespaces\n")
 	}
-	fmt.Printf("Duration: %v\n", duration)
-	fmt.Printf("Learning mode: %v\n", learningMode)
+	progress.Printf("Duration: %v\n", duration)
+	progress.Printf("Learning mode: %v\n", learningMode)
 
 	if err := performScan(ctx); err != nil {
+		if errors.Is(err, istio.ErrNoServices) {
+			if code := reportNoServices(os.Stderr, err); code != 0 {
+				os.Exit(code)
+			}
+			return
+		}
+		if errors.Is(err, errSeverityExceeded) {
+			os.Exit(exitSeverityExceeded)
+		}
 		log.Fatalf("Scan failed: %v", err)
 	}
 }
 
-func connectk8s(ctx context.Context) *k8s.Client {
-	k8sClient, err := k8s.NewClient()
+// exitNoServices is the scan exit code when discovery finds nothing to scan,
+// distinct from the exit code 1 used for failures.
+const exitNoServices = 2
+
+// exitSeverityExceeded is the scan exit code when an anomaly is at least as
+// severe as --fail-on-severity, so scripts can alert on it.
+const exitSeverityExceeded = 3
+
+// reportNoServices explains an empty discovery on w and returns the exit
+// code: exitNoServices with --require-services, or 0 when an empty mesh is
+// acceptable and the explanation is only a warning.
+func reportNoServices(w io.Writer, err error) int {
+	var counts *istio.NoServicesError
+	if !errors.As(err, &counts) {
+		counts = &istio.NoServicesError{}
+	}
+
+	message := noServicesMessage(namespace, istio.MeshMode(meshType), counts)
+	if !requireServices {
+		fmt.Fprint(w, "Warning: "+message)
+		return 0
+	}
+	fmt.Fprint(w, message)
+	return exitNoServices
+}
+
+// noServicesMessage explains the usual reasons discovery came back empty.
+// When discovery counted the pods it saw, only the checks that fit are
+// listed: an empty namespace points at the namespace or context, and pods
+// outside the mesh at injection.
+func noServicesMessage(namespace string, mesh istio.MeshMode, found *istio.NoServicesError) string {
+	var b strings.Builder
+
+	where := "any namespace"
+	if namespace != "" {
+		where = fmt.Sprintf("namespace %q", namespace)
+	}
+
+	checkPods, checkMesh := true, true
+	switch {
+	case found.Known && found.Pods == 0:
+		fmt.Fprintf(&b, "No meshed services found: there are no pods in %s. Check that:\n", where)
+		checkMesh = false
+	case found.Known && found.Meshed == 0:
+		fmt.Fprintf(&b, "No meshed services found: %d pods in %s, but none is part of the mesh. Check that:\n", found.Pods, where)
+		checkPods = false
+	case found.Known:
+		fmt.Fprintf(&b, "No meshed services found: %d meshed pods in %s, but none has an app, app.kubernetes.io/name or service label naming its service.\n", found.Meshed, where)
+		return b.String()
+	default:
+		fmt.Fprintf(&b, "No meshed services found in %s. Check that:\n", where)
+	}
+
+	if checkPods {
+		if namespace != "" {
+			fmt.Fprintf(&b, "  - the namespace exists and has running pods: kubectl get pods -n %s\n", namespace)
+		} else {
+			b.WriteString("  - the current kubeconfig context points at the cluster you expect\n")
+		}
+	}
+
+	if checkMesh {
+		switch mesh {
+		case istio.MeshIstioAmbient:
+			b.WriteString("  - workloads are enrolled in ambient mode: the namespace or pods are labeled istio.io/dataplane-mode=ambient\n")
+		case istio.MeshLinkerd:
+			b.WriteString("  - the Linkerd proxy is injected: pods carry linkerd.io/proxy-* annotations (linkerd.io/inject: enabled)\n")
+		default:
+			b.WriteString("  - sidecar injection is enabled: the namespace is labeled istio-injection=enabled and pods were restarted after labeling\n")
+		}
+		fmt.Fprintf(&b, "  - --mesh matches your data plane (currently %q; use istio, istio-ambient, or linkerd)\n", mesh)
+	}
+
+	return b.String()
+}
+
+// connectk8s builds the client for a kubeconfig context, the current one
+// when empty. A cluster that can't be reached yet is only a warning, since
+// discovery reports it per cluster, but a context that can't be loaded is
+// an error.
+func connectk8s(ctx context.Context, kubeContext string) (*k8s.Client, error) {
+	k8sClient, err := k8s.NewClientForContext(kubeContext)
 	if err != nil {
-		fmt.Println(err)
+		if kubeContext != "" {
+			return nil, fmt.Errorf("context %q: %w", kubeContext, err)
+		}
+		return nil, err
 	}
 
 	if err := k8sClient.CheckConnection(ctx); err != nil {
-		fmt.Println(err)
+		progress.Println(err)
+	}
+
+	return k8sClient, nil
+}
+
+// scanClusters connects to each kubeconfig context named by --contexts, or
+// the current context when none are given. Clusters are named after their
+// context; a single current-context cluster is left unnamed.
+func scanClusters(ctx context.Context, mesh istio.MeshMode, podSelection istio.PodSelectionStrategy, cfg *config.Config) ([]istio.Cluster, map[string]*k8s.Client, error) {
+	contexts := kubeContexts
+	if len(contexts) == 0 {
+		contexts = []string{""}
+	}
+
+	signals, err := istio.ParseSignals(scanSignals)
+	if err != nil {
+		return nil, nil, err
+	}
+	quantiles, err := istio.ParseQuantiles(cfg.Kubernetes.LatencyQuantiles)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return k8sClient
+	var clusters []istio.Cluster
+	clients := make(map[string]*k8s.Client)
+	for _, kubeContext := range contexts {
+		client, err := connectk8s(ctx, kubeContext)
+		if err != nil {
+			return nil, nil, err
+		}
+		discovery := istio.NewServiceDiscovery(client.Clientset, client.RestConfig)
+		discovery.SetCacheTTL(cacheTTL)
+		discovery.SetMeshMode(mesh)
+		discovery.SetPodSelection(podSelection)
+		discovery.SetReplicaCheck(cfg.Kubernetes.ReplicaCheck)
+		discovery.SetMaxPodsPerService(cfg.Kubernetes.MaxPodsPerService)
+		discovery.SetCardinalityLimit(cfg.Kubernetes.CardinalityLimit)
+		discovery.SetMetricMapping(cfg.MetricMapping)
+		discovery.SetSignals(signals)
+		discovery.SetLatencyQuantiles(quantiles)
+		discovery.SetMaxLatency(cfg.Kubernetes.MaxLatency)
+		discovery.SetIgnoreControlPlane(skipControlPlane)
+		discovery.SetRouteBreakdown(byRoute)
+		if err := discovery.SetNamespaceSelector(namespaceSelector); err != nil {
+			return nil, nil, err
+		}
+		if client.Dynamic != nil {
+			discovery.SetDynamicClient(client.Dynamic)
+		}
+
+		clusters = append(clusters, istio.Cluster{Name: kubeContext, Discovery: discovery})
+		clients[kubeContext] = client
+	}
+	return clusters, clients, nil
 }
 
-func istioConfig(ctx context.Context) (*config.Config, *istio.ServiceDiscovery) {
-	fmt.Println("Initializing Envoy metrics collection...")
+// startProfiling begins the profiling requested by --profile and
+// --cpu-profile. The returned function stops it and prints the phase
+// breakdown.
+func startProfiling(ctx context.Context) (context.Context, func(), error) {
+	var stops []func()
+	stop := func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
 
-	discovery := istio.NewServiceDiscovery(connectk8s(ctx).Clientset, connectk8s(ctx).RestConfig)
-	config := config.DefaultConfig()
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return ctx, stop, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return ctx, stop, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			f.Close()
+			progress.Printf("✓ Wrote CPU profile to %s\n", cpuProfile)
+		})
+	}
 
-	fmt.Println("✓ Ready to collect metrics from Envoy sidecars")
-	fmt.Println("Discovering Services in Mesh...")
+	if profileScan {
+		recorder := profile.NewRecorder()
+		ctx = profile.WithRecorder(ctx, recorder)
+		start := time.Now()
+		stops = append(stops, func() {
+			fmt.Fprintln(os.Stderr)
+			recorder.WriteBreakdown(os.Stderr, time.Since(start))
+		})
+	}
 
-	return config, discovery
+	return ctx, stop, nil
 }
 
 func performScan(ctx context.Context) error {
-	if connectk8s(ctx) != nil {
-		fmt.Println("Connecting to Kubernetes cluster...")
+	ctx, stopProfiling, err := startProfiling(ctx)
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	progress.Println("Connecting to Kubernetes cluster...")
+
+	mesh, err := istio.ParseMeshMode(meshType)
+	if err != nil {
+		return err
+	}
+
+	progress.Println("Initializing Envoy metrics collection...")
+
+	config, err := config.Load(viper.GetViper())
+	if err != nil {
+		return err
+	}
+	podSelection, err := istio.ParsePodSelectionStrategy(config.Kubernetes.PodSelection)
+	if err != nil {
+		return err
+	}
+	clusters, clients, err := scanClusters(ctx, mesh, podSelection, config)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		return previewScan(ctx, os.Stdout, config, clusters)
+	}
+
+	progress.Println("✓ Ready to collect metrics from Envoy sidecars")
+
+	publishers := make(map[string]*k8s.EventPublisher)
+	if emitEvents {
+		for name, client := range clients {
+			publishers[name] = k8s.NewEventPublisher(client.Clientset)
+		}
+	}
+
+	rollouts := make(map[string]*k8s.RolloutFinder)
+	if compareWindow > 0 && compareAt == "" {
+		for name, client := range clients {
+			rollouts[name] = k8s.NewRolloutFinder(client.Clientset)
+		}
 	}
 
-	config, discovery := istioConfig(ctx)
-	services, err := discovery.DiscoverServices(ctx, namespace)
+	notifier, err := scanNotifier(config)
 	if err != nil {
-		fmt.Println("failed to discover services: %w", err)
+		return err
 	}
 
-	fmt.Printf("✓ Found %d services with Istio sidecars\n", len(services))
+	exporter, err := scanExporter(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	return analyze(ctx, os.Stdout, config, clusters, publishers, rollouts, notifier, exporter)
+}
+
+// scanExporter builds the OTLP exporter for --otlp-endpoint, or returns nil
+// when no endpoint is set.
+func scanExporter(ctx context.Context, config *config.Config) (*otlp.Exporter, error) {
+	if otlpEndpoint == "" {
+		return nil, nil
+	}
+
+	client, err := istio.NewHTTPClient(config.HTTP)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlp.NewExporter(ctx, otlpEndpoint, client)
+	if err != nil {
+		return nil, err
+	}
+	exporter.SetHealthWeights(config.Health)
+	return exporter, nil
+}
+
+// previewScan runs discovery only and writes the services and pods a scan
+// would collect from to out. --sample-rate is honored, seeded from
+// --data-file like a real scan, but nothing is saved.
+func previewScan(ctx context.Context, out io.Writer, config *config.Config, clusters []istio.Cluster) error {
+	storage := timeseries.NewStorage()
+	if dataFile != "" {
+		if err := storage.Load(dataFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	sampler, err := scanSampler(storage)
+	if err != nil {
+		return err
+	}
+	restorePodSelection(storage, clusters)
+
+	progress.Println("Discovering Services in Mesh...")
+
+	plan, err := istio.PlanClusters(ctx, clusters, namespace, sampler)
+	if err != nil {
+		return err
+	}
+	progress.Println()
+	fmt.Fprint(out, output.NewFormatter(config.Output.Format).FormatPlan(plan))
+	return nil
+}
+
+// scanNotifier builds the webhook notifier configured under notify, or
+// returns nil when no webhook is set.
+func scanNotifier(config *config.Config) (*notify.Notifier, error) {
+	if config.Notify.WebhookURL == "" {
+		return nil, nil
+	}
+
+	mode, err := notify.ParseMode(config.Notify.Mode)
+	if err != nil {
+		return nil, err
+	}
+	descriptions, err := config.ToDescriptionTemplates()
+	if err != nil {
+		return nil, err
+	}
+	client, err := istio.NewHTTPClient(config.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier := notify.NewNotifier(notify.NewWebhookSender(config.Notify.WebhookURL, client), mode)
+	notifier.SetLink(config.Notify.Link)
+	notifier.SetDescriptionTemplates(descriptions)
+	return notifier, nil
+}
+
+// scanSampler builds the sampler for --sample-rate, seeded with when each
+// stored service was last collected so rotation continues across runs
+// sharing the --data-file it requires, and excluding the idle services
+// --skip-idle skips. It returns nil when every service is collected.
+func scanSampler(storage *timeseries.Storage) (*istio.ServiceSampler, error) {
+	if sampleRate < 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("--sample-rate must be between 0 and 1, got %v", sampleRate)
+	}
+	if sampleRate > 0 && sampleRate < 1 && dataFile == "" {
+		// Each run would start the rotation over and collect the same services
+		return nil, errors.New("--sample-rate needs --data-file to carry the rotation over between scans")
+	}
+	rate := sampleRate
+	if rate == 0 {
+		rate = 1
+	}
+	if rate == 1 && !skipIdle {
+		return nil, nil
+	}
+
+	sampler, err := istio.NewServiceSampler(rate)
+	if err != nil {
+		return nil, err
+	}
+	for key, at := range storage.LastSeen(telemetry.RequestCount) {
+		sampler.Seed(key, at)
+	}
+	if skipIdle {
+		idle := idleServices(storage, time.Now())
+		for _, key := range idle {
+			sampler.Exclude(key)
+		}
+		if len(idle) > 0 {
+			progress.Printf("Skipping %d services idle in every stored scan\n", len(idle))
+		}
+	}
+	return sampler, nil
+}
+
+// idleRecheck is how often --skip-idle collects an idle service anyway, so
+// one that picks up traffic is noticed.
+const idleRecheck = 15 * time.Minute
+
+// idleServices lists the stored services whose request count didn't grow
+// between any stored scans, leaving out those last collected idleRecheck or
+// more ago.
+func idleServices(storage *timeseries.Storage, now time.Time) []string {
+	var idle []string
+	for key, at := range storage.LastSeen(telemetry.RequestCount) {
+		if now.Sub(at) >= idleRecheck {
+			continue
+		}
+		if anomaly.ClassifyIdle(storage.GetLatestN(key, telemetry.RequestCount, anomaly.DefaultLookback)) == anomaly.AlwaysIdle {
+			idle = append(idle, key)
+		}
+	}
+	return idle
+}
+
+// analyze runs one scan: collect from each cluster's istio.Discoverer,
+// store, detect, and write the formatted anomalies to out. Events are
+// published through the publisher for each anomaly's cluster, if any, and
+// anomalies are sent to the notifier, if any, which is flushed once the
+// scan is done. The scan's metrics and anomalies are then pushed to the
+// exporter, if any. Without --compare-at, --compare-window splits at the
+// rollout found through each service's cluster's rollout finder.
+func analyze(ctx context.Context, out io.Writer, config *config.Config, clusters []istio.Cluster, publishers map[string]*k8s.EventPublisher, rollouts map[string]*k8s.RolloutFinder, notifier *notify.Notifier, exporter *otlp.Exporter) error {
+	scanStart := time.Now()
+	if compareBaseline && dataFile == "" {
+		return errors.New("--compare-baseline needs --data-file to load the history baselines are averaged from")
+	}
+	if changesOnly && dataFile == "" {
+		return errors.New("--changes-only needs --data-file to load the last scan's metrics to compare against")
+	}
+	if compareWindow > 0 && dataFile == "" {
+		return errors.New("--compare-window needs --data-file to load the history before and after the split")
+	}
+	order, err := output.ParseTopOrder(topBy)
+	if err != nil {
+		return fmt.Errorf("--top-by: %w", err)
+	}
+	var split time.Time
+	if compareAt != "" {
+		if compareWindow <= 0 {
+			return errors.New("--compare-at needs --compare-window")
+		}
+		if split, err = time.Parse(time.RFC3339, compareAt); err != nil {
+			return fmt.Errorf("invalid --compare-at: %w", err)
+		}
+	}
 
 	storage := timeseries.NewStorage()
+	if dataFile != "" {
+		if err := storage.Load(dataFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		storage.SetCompactionPolicy(config.ToCompactionPolicy())
+	}
+	sampler, err := scanSampler(storage)
+	if err != nil {
+		return err
+	}
+	restorePodSelection(storage, clusters)
+
+	progress.Println("Discovering Services in Mesh...")
+
+	collection, err := istio.Collect(ctx, clusters, namespace, sampler)
+	if err != nil {
+		return err
+	}
+	allMetrics := collection.Metrics
 	mlConfig := config.ToMLConfig()
 	detectionConfig := config.ToAnomalyDetectionConfig()
 
 	clusteringEngine := ml.NewClusteringEngine(mlConfig)
 	detector := anomaly.NewDetector(detectionConfig, clusteringEngine)
 	formatter := output.NewFormatter(config.Output.Format)
-
-	fmt.Println("Collecting service mesh metrics...")
+	formatter.SetHealthWeights(config.Health)
+	formatter.SetColumns(config.Output.Columns)
+	descriptions, err := config.ToDescriptionTemplates()
+	if err != nil {
+		return err
+	}
+	formatter.SetDescriptionTemplates(descriptions)
 
 	var allAnomalies []anomaly.Anomaly
+	baselines := make(map[string]health.Baseline)
+	changed := make(map[string]bool)
+	var comparisons []health.WindowComparison
+	idle := make(map[string]bool)
 
-	for _, serviceKey := range services {
-		// Parse service.namespace format
-		parts := strings.Split(serviceKey, ".")
-		if len(parts) != 2 {
-			fmt.Printf("Warning: invalid service key format: %s\n", serviceKey)
-			continue
-		}
-		serviceName := parts[0]
-		serviceNamespace := parts[1]
+	for _, metrics := range allMetrics {
+		serviceName := metrics.ServiceName
 
-		fmt.Printf("Debug: Collecting metrics for service %s in namespace %s\n", serviceName, serviceNamespace)
-		metrics, err := discovery.CollectMetrics(ctx, serviceNamespace, serviceName)
-		if err != nil {
-			fmt.Printf("Warning: failed to collect metrics for %s: %v\n", serviceName, err)
-			continue
+		seriesKey := metrics.SeriesKey()
+		if compareBaseline {
+			// Averaged before this scan is stored so it doesn't count itself
+			if baseline, ok := health.HistoricalBaseline(storage, seriesKey); ok {
+				baselines[seriesKey] = baseline
+			}
+		}
+		if changesOnly {
+			changed[seriesKey] = storage.Changed(seriesKey, metrics.Normalized.Series(), telemetry.ChangeTolerances)
 		}
 
-		// Store Istio's Four Golden Signals
-		storage.Store(serviceName, "traffic_rps", metrics.Traffic.RequestsPerSecond, metrics.Labels)
-		storage.Store(serviceName, "latency_p99", float64(metrics.Latency.P99.Milliseconds()), metrics.Labels)
-		storage.Store(serviceName, "error_rate", metrics.Errors.ErrorRate, metrics.Labels)
-		storage.Store(serviceName, "saturation_cpu", metrics.Saturation.CPUUsage, metrics.Labels)
-
-		// Legacy compatibility
-		storage.Store(serviceName, "request_count", float64(metrics.Traffic.TotalRequests), metrics.Labels)
-		storage.Store(serviceName, "response_time", float64(metrics.Latency.Mean.Milliseconds()), metrics.Labels)
+		// Store the golden signals from the mesh-agnostic form so every
+		// collector feeds detection the same series
+		series := metrics.Normalized.Series()
+		telemetry.IntervalSizeMeans(series, storage.Latest(seriesKey, telemetry.SizeCounters...))
+		for metric, value := range series {
+			storage.Store(seriesKey, metric, value, metrics.StoredLabels(config.Storage.Labels))
+		}
+		if byRoute {
+			storeRoutes(storage, metrics, config.Storage.Labels)
+		}
+		versionRates := storeVersions(storage, metrics, config.Storage.Labels)
+		var wentIdle []anomaly.Anomaly
+		if skipIdle {
+			// The count is cumulative: idle is no growth since the last scan
+			counts := storage.GetLatestN(seriesKey, telemetry.RequestCount, anomaly.DefaultLookback)
+			if anomaly.ClassifyIdle(counts) == anomaly.AlwaysIdle {
+				idle[seriesKey] = true
+				continue
+			}
+			if a, ok := anomaly.DetectWentIdle(seriesKey, counts); ok {
+				wentIdle = append(wentIdle, a)
+			}
+		}
+		if compareWindow > 0 {
+			if comparison, err := compareAroundSplit(ctx, storage, metrics, split, rollouts); err != nil {
+				progress.Printf("Warning: failed to compare %s before and after its rollout: %v\n", seriesKey, err)
+			} else {
+				comparisons = append(comparisons, comparison)
+			}
+		}
 
-		recentPoints := storage.GetLatestN(serviceName, "request_count", 50)
+		recentPoints := storage.GetLatestN(seriesKey, telemetry.RequestCount, anomaly.DefaultLookback)
 
 		if learningMode {
-			if len(recentPoints) >= detectionConfig.WindowSize {
-				if err := detector.LearnBaseline(serviceName, recentPoints); err != nil {
-					fmt.Printf("Warning: failed to learn baseline for %s: %v\n", serviceName, err)
+			if len(recentPoints) >= detectionConfig.FeatureWindow {
+				done := profile.Track(ctx, "detection")
+				err := detector.LearnBaseline(seriesKey, recentPoints)
+				done()
+				if err != nil {
+					progress.Printf("Warning: failed to learn baseline for %s: %v\n", seriesKey, err)
 				} else {
-					fmt.Printf("✓ Learned baseline for %s\n", serviceName)
+					progress.Printf("✓ Learned baseline for %s\n", seriesKey)
 				}
 			}
 		} else {
-			anomalies, err := detector.DetectAnomalies(serviceName, recentPoints)
+			done := profile.Track(ctx, "detection")
+			anomalies, err := detector.DetectFromStorage(storage, seriesKey)
+			done()
 			if err != nil {
-				fmt.Printf("Warning: failed to detect anomalies for %s: %v\n", serviceName, err)
+				progress.Printf("Warning: failed to detect anomalies for %s: %v\n", seriesKey, err)
 				continue
 			}
+			if byRoute {
+				anomalies = append(anomalies, detectRouteAnomalies(detector, storage, metrics)...)
+			}
+			anomalies = append(anomalies, wentIdle...)
+			anomalies = append(anomalies, anomaly.DetectReplicaDivergence(seriesKey, metrics.Timestamp, metrics.PodErrorRates(), metrics.PodLatencies())...)
+			anomalies = detector.GateReplicas(anomalies, metrics.Replicas)
+			if dataFile != "" {
+				// Without earlier scans to compare with, every anomaly would be new
+				anomaly.MarkFirstSeen(storage, seriesKey, anomalies)
+			}
+			for i := range anomalies {
+				anomalies[i].ServiceName = serviceName
+				anomalies[i].Namespace = metrics.Namespace
+				anomalies[i].Cluster = metrics.Cluster
+				if anomalies[i].Type == anomaly.ErrorRateHigh && anomalies[i].Labels[anomaly.RouteLabel] == "" {
+					anomalies[i].AttributeVersions(versionRates)
+					if edge, ok := metrics.WorstEdge(); ok {
+						anomalies[i].AttributeEdge(edge.Source, edge.Destination, edge.ErrorRate())
+					}
+					if route, ok := metrics.WorstRoute(); ok {
+						anomalies[i].AttributeRoute(route.Route, route.ErrorRate())
+					}
+				}
+				if anomalies[i].Type == anomaly.OutlierEjection {
+					if most, ok := metrics.MostEjected(); ok {
+						anomalies[i].AttributeEjections(most.Cluster, most.Active)
+					}
+				}
+				attachPolicy(&anomalies[i], metrics.Policy)
+			}
 			allAnomalies = append(allAnomalies, anomalies...)
 		}
 	}
 
-	if !learningMode {
-		fmt.Printf("\n%s", formatter.FormatAnomalies(allAnomalies))
+	// Grouped before publishing, so events and notifications carry the
+	// root cause labels
+	incidents := anomaly.GroupByRootCause(allAnomalies, istio.Dependencies(allMetrics))
+
+	for _, a := range allAnomalies {
+		if publisher, exists := publishers[a.Cluster]; exists {
+			if _, err := publisher.Publish(ctx, a); err != nil {
+				progress.Printf("Warning: failed to emit event for %s: %v\n", a.ServiceName, err)
+			}
+		}
+		if notifier != nil {
+			if err := notifier.Add(ctx, a); err != nil {
+				progress.Printf("Warning: failed to send notification for %s: %v\n", a.ServiceName, err)
+			}
+		}
+	}
+
+	if notifier != nil {
+		if err := notifier.Flush(ctx); err != nil {
+			progress.Printf("Warning: failed to send notification digest: %v\n", err)
+		}
+	}
+
+	if exporter != nil {
+		if err := exporter.Export(ctx, scanStart, allMetrics, allAnomalies); err != nil {
+			progress.Printf("Warning: failed to export metrics over OTLP: %v\n", err)
+		}
+		if err := exporter.Shutdown(ctx); err != nil {
+			progress.Printf("Warning: failed to shut down the OTLP exporter: %v\n", err)
+		}
+	}
+
+	// Quiet mode prints nothing at all when the scan is clean
+	if !learningMode && (!quiet || len(allAnomalies) > 0) {
+		progress.Println()
+		done := profile.Track(ctx, "formatting")
+		top := output.TopBy(topServices, order, activeServices(allMetrics, idle), allAnomalies)
+		var shown []*istio.ServiceMeshMetrics
+		showMetrics := compareBaseline || changesOnly
+		if showMetrics {
+			shown = top.Metrics
+			if changesOnly {
+				shown = changedServices(top.Metrics, changed)
+			}
+			formatter.SetBaselines(baselines)
+			if changesOnly && len(shown) == 0 {
+				progress.Println("No service metrics changed since the last scan")
+				showMetrics = false
+			}
+		}
+		if config.Output.Format == string(output.JSON) {
+			// One object, so stdout stays a single JSON document
+			err := formatter.WriteJSON(out, output.ScanResult{Metrics: shown, Comparisons: comparisons, Incidents: incidents, Anomalies: top.Anomalies, Omitted: top.Omitted})
+			done()
+			if err != nil {
+				return err
+			}
+		} else {
+			if showMetrics {
+				if err := formatter.WriteMetrics(out, shown); err != nil {
+					return err
+				}
+			}
+			if compareWindow > 0 {
+				if err := formatter.WriteComparisons(out, comparisons); err != nil {
+					return err
+				}
+			}
+			formatted := formatter.FormatIncidents(incidents) + formatter.FormatAnomalies(top.Anomalies) + formatter.FormatOmitted(top.Omitted)
+			done()
+			fmt.Fprint(out, formatted)
+		}
+	}
+
+	if dataFile != "" {
+		savePodSelection(storage, clusters)
+		if err := storage.Save(dataFile); err != nil {
+			return err
+		}
+		progress.Printf("✓ Saved time series to %s\n", dataFile)
+	}
+
+	if bundleDir != "" {
+		err := bundle.Write(bundleDir, &bundle.Bundle{
+			Config:    config,
+			Metrics:   allMetrics,
+			Series:    storage.Snapshot(),
+			Baselines: detector.Baselines(),
+			Anomalies: allAnomalies,
+		}, bundle.Options{RedactIPs: redactIPs})
+		if err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+		progress.Printf("✓ Wrote scan bundle to %s\n", bundleDir)
+	}
+
+	if historyFile != "" && !learningMode {
+		if err := appendHistory(historyFile, allAnomalies); err != nil {
+			return err
+		}
+		progress.Printf("✓ Recorded %d anomalies in %s\n", len(allAnomalies), historyFile)
+	}
+
+	if reportFile != "" && !learningMode {
+		err := report.Write(reportFile, &report.Report{
+			GeneratedAt: time.Now(),
+			Namespace:   namespace,
+			Metrics:     allMetrics,
+			Anomalies:   allAnomalies,
+		})
+		if err != nil {
+			return err
+		}
+		progress.Printf("✓ Wrote scan report to %s\n", reportFile)
+	}
+
+	if config.Output.Format == string(output.JSON) {
+		writeScanSummary(summaryOut, len(allMetrics), collection.Failed, allAnomalies, time.Since(scanStart))
+	}
+
+	if failOnSeverity > 0 && maxSeverity(allAnomalies) >= failOnSeverity {
+		return errSeverityExceeded
 	}
 
 	return nil
 }
+
+// activeServices leaves the services --skip-idle found idle out of the
+// displayed metrics.
+func activeServices(metrics []*istio.ServiceMeshMetrics, idle map[string]bool) []*istio.ServiceMeshMetrics {
+	if len(idle) == 0 {
+		return metrics
+	}
+	var active []*istio.ServiceMeshMetrics
+	for _, m := range metrics {
+		if !idle[m.SeriesKey()] {
+			active = append(active, m)
+		}
+	}
+	return active
+}
+
+// compareAroundSplit compares a service's stored series either side of
+// split or, when split is zero, of the latest rollout of its Deployment.
+func compareAroundSplit(ctx context.Context, storage *timeseries.Storage, metrics *istio.ServiceMeshMetrics, split time.Time, rollouts map[string]*k8s.RolloutFinder) (health.WindowComparison, error) {
+	if split.IsZero() {
+		finder, exists := rollouts[metrics.Cluster]
+		if !exists {
+			return health.WindowComparison{}, errors.New("no cluster connection to find the rollout through; pass --compare-at")
+		}
+		var err error
+		if split, err = finder.LastRollout(ctx, metrics.Namespace, metrics.ServiceName); err != nil {
+			return health.WindowComparison{}, err
+		}
+	}
+	return health.CompareWindow(storage, metrics.SeriesKey(), split, compareWindow), nil
+}
+
+// summaryOut receives the JSON scan summary; stdout stays the data alone.
+var summaryOut io.Writer = os.Stderr
+
+// scanSummary is the one-line result of a JSON scan, for wrapper scripts
+// that only need to decide whether to look closer.
+type scanSummary struct {
+	ServicesScanned int `json:"services_scanned"`
+	ServicesFailed  int `json:"services_failed"`
+	Anomalies       int `json:"anomalies"`
+	// MaxSeverity is the SeverityText of the worst anomaly, left out when
+	// there are none
+	MaxSeverity string `json:"max_severity,omitempty"`
+	DurationMs  int64  `json:"duration_ms"`
+}
+
+func writeScanSummary(w io.Writer, scanned, failed int, anomalies []anomaly.Anomaly, elapsed time.Duration) {
+	summary := scanSummary{
+		ServicesScanned: scanned,
+		ServicesFailed:  failed,
+		Anomalies:       len(anomalies),
+		DurationMs:      elapsed.Milliseconds(),
+	}
+	if len(anomalies) > 0 {
+		summary.MaxSeverity = anomaly.SeverityText(maxSeverity(anomalies))
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		progress.Printf("Warning: failed to marshal scan summary: %v\n", err)
+		return
+	}
+	fmt.Fprintln(w, string(data))
+}
+
+// Metrics the pod selection state is stored under in --data-file, at a
+// podSelectionKey
+const (
+	podRotationMetric = "pod_rotation"
+	podRequestsMetric = "pod_requests"
+)
+
+// podSelectionKey is the storage key of a service's rotation or a pod's
+// requests, "namespace/name", as seen by one cluster's discovery.
+func podSelectionKey(cluster, key string) string {
+	return "pod-selection " + cluster + " " + key
+}
+
+// restorePodSelection seeds each cluster's pod selection with the state
+// the previous run saved, so round-robin continues with the next replica
+// and highest-traffic remembers the busiest one.
+func restorePodSelection(storage *timeseries.Storage, clusters []istio.Cluster) {
+	for _, cluster := range clusters {
+		keeper, ok := cluster.Discovery.(istio.PodSelectionKeeper)
+		if !ok {
+			continue
+		}
+		prefix := podSelectionKey(cluster.Name, "")
+		state := istio.PodSelectionState{Rotation: make(map[string]int), PodTraffic: make(map[string]float64)}
+		for seriesKey := range storage.LastSeen(podRotationMetric) {
+			if key, ok := strings.CutPrefix(seriesKey, prefix); ok {
+				state.Rotation[key] = int(storage.Latest(seriesKey, podRotationMetric)[podRotationMetric])
+			}
+		}
+		for seriesKey := range storage.LastSeen(podRequestsMetric) {
+			if key, ok := strings.CutPrefix(seriesKey, prefix); ok {
+				state.PodTraffic[key] = storage.Latest(seriesKey, podRequestsMetric)[podRequestsMetric]
+			}
+		}
+		keeper.RestorePodSelection(state)
+	}
+}
+
+// savePodSelection stores each cluster's pod selection state for the next
+// run to restore.
+func savePodSelection(storage *timeseries.Storage, clusters []istio.Cluster) {
+	for _, cluster := range clusters {
+		keeper, ok := cluster.Discovery.(istio.PodSelectionKeeper)
+		if !ok {
+			continue
+		}
+		state := keeper.PodSelectionState()
+		for key, next := range state.Rotation {
+			storage.Store(podSelectionKey(cluster.Name, key), podRotationMetric, float64(next), nil)
+		}
+		for key, requests := range state.PodTraffic {
+			storage.Store(podSelectionKey(cluster.Name, key), podRequestsMetric, requests, nil)
+		}
+	}
+}
+
+// storeRoutes records each route's request count and error rate under its
+// own series key, so detection can run on a route like on a service.
+func storeRoutes(storage *timeseries.Storage, metrics *istio.ServiceMeshMetrics, labelKeys []string) {
+	for _, route := range metrics.Routes {
+		key := metrics.RouteSeriesKey(route.Route)
+		labels := metrics.StoredLabels(labelKeys)
+		storage.Store(key, anomaly.RequestCountMetric, route.Requests, labels)
+		storage.Store(key, anomaly.ErrorRateMetric, route.ErrorRate(), labels)
+	}
+}
+
+// storeVersions records each version's cumulative request and error counts
+// under its own series key, and returns the versions' error rates since the
+// counts stored by the last scan.
+func storeVersions(storage *timeseries.Storage, metrics *istio.ServiceMeshMetrics, labelKeys []string) map[string]float64 {
+	previous := make(map[string]istio.VersionTraffic, len(metrics.Versions))
+	for version, traffic := range metrics.Versions {
+		key := metrics.VersionSeriesKey(version)
+		last := storage.Latest(key, istio.VersionRequestsMetric, istio.VersionErrorsMetric)
+		if len(last) == 2 {
+			previous[version] = istio.VersionTraffic{Requests: last[istio.VersionRequestsMetric], Errors: last[istio.VersionErrorsMetric]}
+		}
+		labels := metrics.StoredLabels(labelKeys)
+		storage.Store(key, istio.VersionRequestsMetric, traffic.Requests, labels)
+		storage.Store(key, istio.VersionErrorsMetric, traffic.Errors, labels)
+	}
+	return metrics.VersionErrorRates(previous)
+}
+
+// detectRouteAnomalies runs detection over each route's series and keeps
+// the error rate anomalies, set against the service's aggregate.
+func detectRouteAnomalies(detector *anomaly.Detector, storage *timeseries.Storage, metrics *istio.ServiceMeshMetrics) []anomaly.Anomaly {
+	var found []anomaly.Anomaly
+	for _, route := range metrics.Routes {
+		anomalies, err := detector.DetectFromStorage(storage, metrics.RouteSeriesKey(route.Route))
+		if err != nil {
+			progress.Printf("Warning: failed to detect anomalies for route %s of %s: %v\n", route.Route, metrics.ServiceName, err)
+			continue
+		}
+		for _, a := range anomalies {
+			if a.Type == anomaly.ErrorRateHigh {
+				a.ScopeToRoute(route.Route, metrics.Normalized.ErrorRate())
+				found = append(found, a)
+			}
+		}
+	}
+	return found
+}
+
+// changedServices keeps the services whose series key is marked changed.
+func changedServices(metrics []*istio.ServiceMeshMetrics, changed map[string]bool) []*istio.ServiceMeshMetrics {
+	var shown []*istio.ServiceMeshMetrics
+	for _, m := range metrics {
+		if changed[m.SeriesKey()] {
+			shown = append(shown, m)
+		}
+	}
+	return shown
+}
+
+// attachPolicy adds the Istio policy relevant to a resilience anomaly:
+// circuit breaking lives in the DestinationRule, retries and timeouts in
+// the VirtualService.
+func attachPolicy(a *anomaly.Anomaly, policy *istio.TrafficPolicy) {
+	if policy == nil {
+		return
+	}
+	switch a.Type {
+	case anomaly.CircuitBreaker:
+		a.AttachPolicy("DestinationRule", policy.DestinationRule, policy.CircuitBreaker)
+	case anomaly.RetryStorm, anomaly.TimeoutAnomaly:
+		a.AttachPolicy("VirtualService", policy.VirtualService, policy.Retries)
+	}
+}
+
+// errSeverityExceeded reports that an anomaly reached --fail-on-severity.
+var errSeverityExceeded = errors.New("anomaly severity threshold exceeded")
+
+func maxSeverity(anomalies []anomaly.Anomaly) float64 {
+	highest := 0.0
+	for _, a := range anomalies {
+		if a.Severity > highest {
+			highest = a.Severity
+		}
+	}
+	return highest
+}
+
+// appendHistory records the scan's anomalies in the history at path.
+func appendHistory(path string, anomalies []anomaly.Anomaly) error {
+	store, err := history.Open(path)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.Append(anomalies)
+}
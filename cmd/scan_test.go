@@ -0,0 +1,977 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/history"
+	"smanalyzer/pkg/istio"
+	"smanalyzer/pkg/notify"
+	"smanalyzer/pkg/output"
+	"smanalyzer/pkg/profile"
+	"smanalyzer/pkg/progress"
+	"smanalyzer/pkg/telemetry"
+	"smanalyzer/pkg/timeseries"
+)
+
+func TestNoServicesMessage(t *testing.T) {
+	message := noServicesMessage("shop", istio.MeshIstio, &istio.NoServicesError{})
+
+	for _, expected := range []string{
+		`No meshed services found in namespace "shop"`,
+		"kubectl get pods -n shop",
+		"istio-injection=enabled",
+		"--mesh",
+	} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Expected message to mention %q, got:\n%s", expected, message)
+		}
+	}
+}
+
+func TestNoServicesMessage_MeshSpecificHint(t *testing.T) {
+	if message := noServicesMessage("", istio.MeshLinkerd, &istio.NoServicesError{}); !strings.Contains(message, "linkerd.io/inject") {
+		t.Errorf("Expected a Linkerd injection hint, got:\n%s", message)
+	}
+	if message := noServicesMessage("", istio.MeshIstioAmbient, &istio.NoServicesError{}); !strings.Contains(message, "istio.io/dataplane-mode=ambient") {
+		t.Errorf("Expected an ambient enrollment hint, got:\n%s", message)
+	}
+}
+
+// fakeDiscoverer is an istio.Discoverer returning canned metrics instead
+// of scraping a cluster.
+type fakeDiscoverer struct {
+	metrics []*istio.ServiceMeshMetrics
+}
+
+func (f fakeDiscoverer) DiscoverServices(ctx context.Context, namespace string) ([]string, error) {
+	var services []string
+	for _, m := range f.metrics {
+		if namespace == "" || m.Namespace == namespace {
+			services = append(services, m.ServiceName+"."+m.Namespace)
+		}
+	}
+	return services, nil
+}
+
+func (f fakeDiscoverer) CollectMetrics(ctx context.Context, namespace, serviceName string) (*istio.ServiceMeshMetrics, error) {
+	for _, m := range f.metrics {
+		if m.Namespace == namespace && m.ServiceName == serviceName {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("no metrics for %s.%s", serviceName, namespace)
+}
+
+func TestReportNoServices(t *testing.T) {
+	namespace = "shop"
+	defer func() { namespace = "" }()
+
+	tests := []struct {
+		name     string
+		found    istio.NoServicesError
+		expected string
+		absent   string
+	}{
+		{"empty namespace", istio.NoServicesError{Known: true}, `there are no pods in namespace "shop"`, "istio-injection"},
+		{"no sidecars", istio.NoServicesError{PodCounts: istio.PodCounts{Pods: 4}, Known: true}, `4 pods in namespace "shop", but none is part of the mesh`, "kubectl get pods"},
+		{"unlabelled", istio.NoServicesError{PodCounts: istio.PodCounts{Pods: 4, Meshed: 4}, Known: true}, "none has an app", "istio-injection"},
+	}
+	for _, tt := range tests {
+		var stderr bytes.Buffer
+		found := tt.found
+		if code := reportNoServices(&stderr, fmt.Errorf("scan: %w", &found)); code != exitNoServices {
+			t.Errorf("%s: expected exit code %d, got %d", tt.name, exitNoServices, code)
+		}
+		if !strings.Contains(stderr.String(), tt.expected) || strings.Contains(stderr.String(), tt.absent) {
+			t.Errorf("%s: expected %q and not %q, got:\n%s", tt.name, tt.expected, tt.absent, stderr.String())
+		}
+	}
+}
+
+func TestReportNoServices_NotRequired(t *testing.T) {
+	requireServices = false
+	defer func() { requireServices = true }()
+
+	var stderr bytes.Buffer
+	if code := reportNoServices(&stderr, istio.ErrNoServices); code != 0 {
+		t.Errorf("Expected an empty mesh to succeed without --require-services, got exit code %d", code)
+	}
+	if !strings.HasPrefix(stderr.String(), "Warning: No meshed services found") {
+		t.Errorf("Expected a warning, got:\n%s", stderr.String())
+	}
+}
+
+func quietScan(t *testing.T, format string, metrics ...*istio.ServiceMeshMetrics) (string, string, error) {
+	t.Helper()
+	return quietScanContext(t, context.Background(), format, metrics...)
+}
+
+func quietScanContext(t *testing.T, ctx context.Context, format string, metrics ...*istio.ServiceMeshMetrics) (string, string, error) {
+	t.Helper()
+
+	var chatter bytes.Buffer
+	progress.SetOutput(&chatter)
+	progress.SetQuiet(true)
+	quiet = true
+	summaryOut = io.Discard
+	t.Cleanup(func() {
+		progress.SetOutput(os.Stdout)
+		progress.SetQuiet(false)
+		quiet = false
+		failOnSeverity = 0
+		summaryOut = os.Stderr
+	})
+
+	cfg := config.DefaultConfig()
+	cfg.Output.Format = format
+
+	var stdout bytes.Buffer
+	err := analyze(ctx, &stdout, cfg, []istio.Cluster{{Discovery: fakeDiscoverer{metrics: metrics}}}, nil, nil, nil, nil)
+	return stdout.String(), chatter.String(), err
+}
+
+func fakeService(name string, p50, p99 time.Duration) *istio.ServiceMeshMetrics {
+	return &istio.ServiceMeshMetrics{
+		ServiceName: name,
+		Namespace:   "shop",
+		Normalized: telemetry.Normalized{
+			Requests:   100,
+			LatencyP50: p50,
+			LatencyP99: p99,
+		},
+	}
+}
+
+func TestAnalyze_QuietCleanScanPrintsNothing(t *testing.T) {
+	stdout, chatter, err := quietScan(t, "text", fakeService("reviews", 10*time.Millisecond, 20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stdout != "" {
+		t.Errorf("Expected no stdout, got %q", stdout)
+	}
+	if chatter != "" {
+		t.Errorf("Expected no progress output, got %q", chatter)
+	}
+}
+
+func TestQuiet_OnlyResultBytes(t *testing.T) {
+	// Progress shares stdout with the result, as it does on a terminal
+	scan := func(quietRun bool) string {
+		t.Helper()
+		var stdout bytes.Buffer
+		progress.SetOutput(&stdout)
+		progress.SetQuiet(quietRun)
+		quiet = quietRun
+		summaryOut = io.Discard
+		t.Cleanup(func() {
+			progress.SetOutput(os.Stdout)
+			progress.SetQuiet(false)
+			quiet = false
+			summaryOut = os.Stderr
+		})
+
+		services := fakeDiscoverer{metrics: []*istio.ServiceMeshMetrics{fakeService("reviews", 10*time.Millisecond, 500*time.Millisecond)}}
+		if err := analyze(context.Background(), &stdout, config.DefaultConfig(), []istio.Cluster{{Discovery: services}}, nil, nil, nil, nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return stdout.String()
+	}
+
+	if !strings.Contains(scan(false), "Discovering Services in Mesh") {
+		t.Fatal("Expected progress on stdout without --quiet")
+	}
+	stdout := scan(true)
+	if !strings.HasPrefix(stdout, "Found 1 anomalies:\n") || strings.Contains(stdout, "Discovering Services in Mesh") {
+		t.Errorf("Expected only the anomalies on stdout with --quiet, got:\n%s", stdout)
+	}
+}
+
+func TestAnalyze_QuietJSONOnlyAnomalies(t *testing.T) {
+	failOnSeverity = 2
+
+	stdout, chatter, err := quietScan(t, "json", fakeService("reviews", 10*time.Millisecond, 500*time.Millisecond))
+	if !errors.Is(err, errSeverityExceeded) {
+		t.Errorf("Expected errSeverityExceeded, got %v", err)
+	}
+	if chatter != "" {
+		t.Errorf("Expected no progress output, got %q", chatter)
+	}
+
+	var result output.ScanResult
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("Expected stdout to be only JSON, got %q: %v", stdout, err)
+	}
+	anomalies := result.Anomalies
+	if len(anomalies) != 1 || anomalies[0].Type != anomaly.TailLatency {
+		t.Errorf("Expected one tail latency anomaly, got %+v", anomalies)
+	}
+}
+
+func TestAnalyze_FirstSeenNeedsDataFile(t *testing.T) {
+	firstSeen := func() []bool {
+		t.Helper()
+		stdout, _, _ := quietScan(t, "json", fakeService("reviews", 10*time.Millisecond, 500*time.Millisecond))
+		var result output.ScanResult
+		if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+			t.Fatalf("Expected JSON, got %q: %v", stdout, err)
+		}
+		var flags []bool
+		for _, a := range result.Anomalies {
+			flags = append(flags, a.FirstSeen)
+		}
+		return flags
+	}
+
+	if flags := firstSeen(); len(flags) != 1 || flags[0] {
+		t.Errorf("Expected nothing marked new without a data file, got %v", flags)
+	}
+
+	dataFile = filepath.Join(t.TempDir(), "series.json")
+	t.Cleanup(func() { dataFile = "" })
+	if flags := firstSeen(); len(flags) != 1 || !flags[0] {
+		t.Errorf("Expected the first stored occurrence marked new, got %v", flags)
+	}
+	if flags := firstSeen(); len(flags) != 1 || flags[0] {
+		t.Errorf("Expected the second stored occurrence not new, got %v", flags)
+	}
+}
+
+func TestAnalyze_BelowFailOnSeverity(t *testing.T) {
+	failOnSeverity = 100
+
+	if _, _, err := quietScan(t, "json", fakeService("reviews", 10*time.Millisecond, 500*time.Millisecond)); err != nil {
+		t.Errorf("Expected no error below the severity threshold, got %v", err)
+	}
+}
+
+func TestAnalyze_AttachesDeclaredPolicy(t *testing.T) {
+	service := fakeService("reviews", 10*time.Millisecond, 20*time.Millisecond)
+	service.Normalized.CircuitBreakersOpen = 1
+	service.Policy = &istio.TrafficPolicy{
+		DestinationRule: "reviews-dr",
+		CircuitBreaker:  map[string]string{"consecutive5xxErrors": "5"},
+	}
+
+	stdout, _, err := quietScan(t, "text", service)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(stdout, "Circuit breaker tripped: 1 open; DestinationRule reviews-dr: consecutive5xxErrors=5") {
+		t.Errorf("Expected the DestinationRule settings in the output, got:\n%s", stdout)
+	}
+}
+
+func TestAnalyze_OutlierEjectionNamesCluster(t *testing.T) {
+	service := fakeService("reviews", 10*time.Millisecond, 20*time.Millisecond)
+	service.Normalized.EjectionsActive = 3
+	service.Ejections = []istio.OutlierEjections{
+		{Cluster: "outbound|9080||details.shop.svc.cluster.local", Active: 1, Total: 4},
+		{Cluster: "outbound|9080||ratings.shop.svc.cluster.local", Active: 2, Total: 2},
+	}
+
+	stdout, _, err := quietScan(t, "json", service)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var result output.ScanResult
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	anomalies := result.Anomalies
+	if len(anomalies) != 1 || anomalies[0].Type != anomaly.OutlierEjection {
+		t.Fatalf("Expected one outlier ejection anomaly, got %+v", anomalies)
+	}
+	if cluster := anomalies[0].Labels[anomaly.EjectedClusterLabel]; cluster != "outbound|9080||ratings.shop.svc.cluster.local" {
+		t.Errorf("Expected the anomaly attributed to ratings, got %q", cluster)
+	}
+	if anomalies[0].Metrics[anomaly.EjectionsActiveMetric] != 3 {
+		t.Errorf("Expected 3 hosts ejected, got %v", anomalies[0].Metrics)
+	}
+}
+
+func TestAnalyze_ByRouteFlagsHiddenRoute(t *testing.T) {
+	// Error rate detection needs two scans of history
+	byRoute = true
+	dataFile = filepath.Join(t.TempDir(), "series.json")
+	t.Cleanup(func() {
+		byRoute = false
+		dataFile = ""
+	})
+
+	service := fakeService("checkout", 10*time.Millisecond, 20*time.Millisecond)
+	service.Normalized.Requests = 1000
+	service.Normalized.Errors5xx = 5
+	service.Routes = []istio.RouteTraffic{
+		{Route: "GET /cart", Requests: 950, Errors: 1},
+		{Route: "POST /checkout", Requests: 50, Errors: 4},
+	}
+
+	if _, _, err := quietScan(t, "json", service); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The counters are cumulative, so the second scrape counts both scans
+	service.Normalized.Requests, service.Normalized.Errors5xx = 2000, 10
+	service.Routes = []istio.RouteTraffic{
+		{Route: "GET /cart", Requests: 1900, Errors: 2},
+		{Route: "POST /checkout", Requests: 100, Errors: 8},
+	}
+	stdout, _, err := quietScan(t, "json", service)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var result output.ScanResult
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	anomalies := result.Anomalies
+	if len(anomalies) != 1 || anomalies[0].Type != anomaly.ErrorRateHigh {
+		t.Fatalf("Expected one error rate anomaly, got %+v", anomalies)
+	}
+	if route := anomalies[0].Labels[anomaly.RouteLabel]; route != "POST /checkout" {
+		t.Errorf("Expected the anomaly scoped to POST /checkout, got %q", route)
+	}
+	if anomalies[0].Metrics["error_rate"] != 0.08 || anomalies[0].Metrics["service_error_rate"] != 0.005 {
+		t.Errorf("Expected the route at 8%% against a 0.5%% aggregate, got %v", anomalies[0].Metrics)
+	}
+}
+
+func TestAnalyze_ProfileRecordsPhases(t *testing.T) {
+	recorder := profile.NewRecorder()
+	ctx := profile.WithRecorder(context.Background(), recorder)
+
+	_, _, err := quietScanContext(t, ctx, "json",
+		fakeService("reviews", 10*time.Millisecond, 500*time.Millisecond),
+		fakeService("ratings", 10*time.Millisecond, 20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	detection, ok := recorder.Phase("detection")
+	if !ok || detection.Count != 2 || detection.Duration <= 0 {
+		t.Errorf("Expected detection timed once per service, got %+v", detection)
+	}
+	formatting, ok := recorder.Phase("formatting")
+	if !ok || formatting.Duration <= 0 {
+		t.Errorf("Expected formatting timed, got %+v", formatting)
+	}
+}
+
+func TestAnalyze_ReplicaDivergenceNamesBadPod(t *testing.T) {
+	service := fakeService("reviews", 10*time.Millisecond, 20*time.Millisecond)
+	service.Pods = map[string]istio.PodSignal{
+		"reviews-a": {Requests: 100, LatencyP99: 20},
+		"reviews-b": {Requests: 100, LatencyP99: 20},
+		"reviews-c": {Requests: 100, ErrorRate: 0.3, LatencyP99: 20},
+		"reviews-d": {Requests: 100, LatencyP99: 20},
+	}
+
+	stdout, _, err := quietScan(t, "json", service)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var result output.ScanResult
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	anomalies := result.Anomalies
+	if len(anomalies) != 1 || anomalies[0].Type != anomaly.ReplicaDivergence || anomalies[0].Labels[anomaly.PodLabel] != "reviews-c" {
+		t.Fatalf("Expected a replica divergence naming reviews-c, got %+v", anomalies)
+	}
+	if anomalies[0].ServiceName != "reviews" || anomalies[0].Namespace != "shop" {
+		t.Errorf("Expected the anomaly tagged with reviews in shop, got %s in %s", anomalies[0].ServiceName, anomalies[0].Namespace)
+	}
+}
+
+func TestScanClusters_UnknownContext(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://127.0.0.1:6443
+contexts:
+- name: dev
+  context:
+    cluster: dev
+current-context: dev
+`), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Setenv("KUBECONFIG", kubeconfig)
+	kubeContexts = []string{"prod"}
+	t.Cleanup(func() { kubeContexts = nil })
+
+	_, _, err := scanClusters(context.Background(), istio.MeshIstio, istio.PodSelectFirst, config.DefaultConfig())
+	if err == nil || !strings.Contains(err.Error(), `context "prod"`) {
+		t.Errorf("Expected an error naming the unknown context, got %v", err)
+	}
+}
+
+func TestScanSampler(t *testing.T) {
+	defer func() { sampleRate = 0 }()
+
+	sampleRate = 1.5
+	if _, err := scanSampler(timeseries.NewStorage()); err == nil {
+		t.Error("Expected error for a sample rate above 1")
+	}
+
+	sampleRate = 1
+	if sampler, err := scanSampler(timeseries.NewStorage()); err != nil || sampler != nil {
+		t.Errorf("Expected no sampler at rate 1, got %v, %v", sampler, err)
+	}
+
+	sampleRate = 0.5
+	if _, err := scanSampler(timeseries.NewStorage()); err == nil {
+		t.Error("Expected error for a sample rate without --data-file")
+	}
+
+	dataFile = filepath.Join(t.TempDir(), "series.json")
+	defer func() { dataFile = "" }()
+	if sampler, err := scanSampler(timeseries.NewStorage()); err != nil || sampler == nil {
+		t.Errorf("Expected a sampler at rate 0.5, got %v, %v", sampler, err)
+	}
+}
+
+func TestStoreVersions_RatesSinceLastScan(t *testing.T) {
+	storage := timeseries.NewStorage()
+	metrics := fakeService("reviews", 10, 50)
+	metrics.Versions = map[string]istio.VersionTraffic{"v1": {Requests: 1000, Errors: 10}, "v2": {Requests: 100}}
+	first := storeVersions(storage, metrics, nil)
+	if math.Abs(first["v1"]-0.01) > 1e-9 || first["v2"] != 0 {
+		t.Errorf("Expected lifetime rates on the first scan, got %v", first)
+	}
+
+	// v2 started failing; its lifetime rate would still look healthy
+	metrics.Versions = map[string]istio.VersionTraffic{"v1": {Requests: 1100, Errors: 11}, "v2": {Requests: 200, Errors: 50}}
+	second := storeVersions(storage, metrics, nil)
+	if math.Abs(second["v1"]-0.01) > 1e-9 || math.Abs(second["v2"]-0.5) > 1e-9 {
+		t.Errorf("Expected rates since the last scan, got %v", second)
+	}
+}
+
+// selectionKeeper is a fakeDiscoverer with pod selection state to save
+// and restore.
+type selectionKeeper struct {
+	fakeDiscoverer
+	state istio.PodSelectionState
+}
+
+func (k *selectionKeeper) PodSelectionState() istio.PodSelectionState {
+	return k.state
+}
+
+func (k *selectionKeeper) RestorePodSelection(state istio.PodSelectionState) {
+	k.state = state
+}
+
+func TestPodSelection_CarriesOverDataFile(t *testing.T) {
+	storage := timeseries.NewStorage()
+	east := &selectionKeeper{state: istio.PodSelectionState{Rotation: map[string]int{"shop/reviews": 3}}}
+	west := &selectionKeeper{state: istio.PodSelectionState{PodTraffic: map[string]float64{"shop/reviews-b": 500}}}
+	savePodSelection(storage, []istio.Cluster{{Name: "east", Discovery: east}, {Name: "west", Discovery: west}})
+
+	next := []istio.Cluster{{Name: "east", Discovery: &selectionKeeper{}}, {Name: "west", Discovery: &selectionKeeper{}}}
+	restorePodSelection(storage, next)
+	if restored := next[0].Discovery.(*selectionKeeper).state; restored.Rotation["shop/reviews"] != 3 || len(restored.PodTraffic) != 0 {
+		t.Errorf("Expected east's rotation restored on its own, got %+v", restored)
+	}
+	if restored := next[1].Discovery.(*selectionKeeper).state; restored.PodTraffic["shop/reviews-b"] != 500 || len(restored.Rotation) != 0 {
+		t.Errorf("Expected west's pod traffic restored on its own, got %+v", restored)
+	}
+}
+
+func TestAnalyze_FakeDiscovererNoServices(t *testing.T) {
+	if _, _, err := quietScan(t, "text"); !errors.Is(err, istio.ErrNoServices) {
+		t.Errorf("Expected ErrNoServices from an empty mesh, got %v", err)
+	}
+}
+
+func TestAnalyze_FakeDiscovererScopedToNamespace(t *testing.T) {
+	namespace = "shop"
+	defer func() { namespace = "" }()
+
+	other := fakeService("reviews", 10*time.Millisecond, 500*time.Millisecond)
+	other.Namespace = "staging"
+
+	stdout, _, err := quietScan(t, "json", fakeService("ratings", 10*time.Millisecond, 20*time.Millisecond), other)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(stdout, "staging") {
+		t.Errorf("Expected only the shop namespace scanned, got:\n%s", stdout)
+	}
+}
+
+func TestAnalyze_RecordsHistory(t *testing.T) {
+	historyFile = filepath.Join(t.TempDir(), "anomalies.db")
+	t.Cleanup(func() { historyFile = "" })
+
+	for scan := 0; scan < 2; scan++ {
+		if _, _, err := quietScan(t, "text", fakeService("reviews", 10*time.Millisecond, 500*time.Millisecond)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	filter := history.Filter{Service: "reviews", Type: anomaly.TailLatency}
+	if err := queryHistory(&out, historyFile, filter, true, config.DefaultConfig()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "  2  ") {
+		t.Errorf("Expected both scans' anomalies counted on one day, got:\n%s", out.String())
+	}
+}
+
+func TestRankRecentTrouble(t *testing.T) {
+	historyFile := filepath.Join(t.TempDir(), "anomalies.jsonl")
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	store := history.OpenJSONL(historyFile)
+	// reviews had the worse incident, but two half-lives ago
+	if err := store.Append([]anomaly.Anomaly{
+		{ServiceName: "reviews", Type: anomaly.ErrorRateHigh, Severity: 8, Timestamp: now.Add(-48 * time.Hour)},
+		{ServiceName: "ratings", Type: anomaly.ErrorRateHigh, Severity: 3, Timestamp: now.Add(-time.Hour)},
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := rankRecentTrouble(&out, historyFile, history.Filter{}, 24*time.Hour, now); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "ratings ") || !strings.HasPrefix(lines[2], "reviews ") || !strings.HasSuffix(lines[2], "2.00") {
+		t.Errorf("Expected ratings ranked above the older reviews incident, got:\n%s", out.String())
+	}
+}
+
+// countingDiscoverer is a fakeDiscoverer that counts discovery and
+// collection calls and plans one pod per service.
+type countingDiscoverer struct {
+	fakeDiscoverer
+	discovered, collected int
+}
+
+func (c *countingDiscoverer) DiscoverServices(ctx context.Context, namespace string) ([]string, error) {
+	c.discovered++
+	return c.fakeDiscoverer.DiscoverServices(ctx, namespace)
+}
+
+func (c *countingDiscoverer) CollectMetrics(ctx context.Context, namespace, serviceName string) (*istio.ServiceMeshMetrics, error) {
+	c.collected++
+	return c.fakeDiscoverer.CollectMetrics(ctx, namespace, serviceName)
+}
+
+func (c *countingDiscoverer) PlanCollection(ctx context.Context, namespace, serviceName string) ([]istio.ScrapeTarget, error) {
+	return []istio.ScrapeTarget{{Pod: serviceName + "-1", Method: "exec curl"}}, nil
+}
+
+func TestAnalyze_ChangesOnlySuppressesStaticServices(t *testing.T) {
+	changesOnly = true
+	dataFile = filepath.Join(t.TempDir(), "series.json")
+	progress.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		changesOnly = false
+		dataFile = ""
+		progress.SetOutput(os.Stdout)
+	})
+
+	scan := func(reviewsP99 time.Duration) string {
+		t.Helper()
+		var stdout bytes.Buffer
+		services := fakeDiscoverer{metrics: []*istio.ServiceMeshMetrics{
+			fakeService("reviews", 10*time.Millisecond, reviewsP99),
+			fakeService("ratings", 10*time.Millisecond, 20*time.Millisecond),
+		}}
+		if err := analyze(context.Background(), &stdout, config.DefaultConfig(), []istio.Cluster{{Discovery: services}}, nil, nil, nil, nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return stdout.String()
+	}
+
+	first := scan(20 * time.Millisecond)
+	if !strings.Contains(first, "Service: reviews.shop") || !strings.Contains(first, "Service: ratings.shop") {
+		t.Errorf("Expected every service shown on the first scan, got:\n%s", first)
+	}
+	if static := scan(20 * time.Millisecond); strings.Contains(static, "Service: ") {
+		t.Errorf("Expected no services shown when nothing changed, got:\n%s", static)
+	}
+	changed := scan(40 * time.Millisecond)
+	if !strings.Contains(changed, "Service: reviews.shop") || strings.Contains(changed, "Service: ratings.shop") {
+		t.Errorf("Expected only reviews shown after its P99 changed, got:\n%s", changed)
+	}
+}
+
+func TestAnalyze_JSONChangesOnlyIsOneObject(t *testing.T) {
+	changesOnly = true
+	dataFile = filepath.Join(t.TempDir(), "series.json")
+	cfg := config.DefaultConfig()
+	cfg.Output.Format = "json"
+	progress.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		changesOnly = false
+		dataFile = ""
+		progress.SetOutput(os.Stdout)
+	})
+
+	var stdout bytes.Buffer
+	services := fakeDiscoverer{metrics: []*istio.ServiceMeshMetrics{fakeService("reviews", 10*time.Millisecond, 500*time.Millisecond)}}
+	if err := analyze(context.Background(), &stdout, cfg, []istio.Cluster{{Discovery: services}}, nil, nil, nil, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var result output.ScanResult
+	decoder := json.NewDecoder(&stdout)
+	if err := decoder.Decode(&result); err != nil {
+		t.Fatalf("Expected stdout to be a JSON object, got %q: %v", stdout.String(), err)
+	}
+	if decoder.More() {
+		t.Errorf("Expected a single JSON document on stdout")
+	}
+	if len(result.Metrics) != 1 || result.Metrics[0].ServiceName != "reviews" {
+		t.Errorf("Expected the reviews metrics in the result, got %+v", result.Metrics)
+	}
+	if len(result.Anomalies) != 1 || result.Anomalies[0].Type != anomaly.TailLatency {
+		t.Errorf("Expected one tail latency anomaly, got %+v", result.Anomalies)
+	}
+}
+
+func TestAnalyze_CompareWindowReportsRegression(t *testing.T) {
+	compareWindow = 15 * time.Minute
+	dataFile = filepath.Join(t.TempDir(), "series.json")
+	progress.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		compareWindow = 0
+		compareAt = ""
+		dataFile = ""
+		progress.SetOutput(os.Stdout)
+	})
+
+	// A deploy ten minutes ago quadrupled the P99 and left the rest alone
+	checkout := fakeService("checkout", 10*time.Millisecond, 400*time.Millisecond)
+	split := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	compareAt = split.Format(time.RFC3339)
+	history := timeseries.NewStorage()
+	for i := -10; i < 10; i++ {
+		p99 := 100.0 + float64(i%3)*5
+		if i >= 0 {
+			p99 = 400 + float64(i%3)*5
+		}
+		at := split.Add(time.Duration(i) * 30 * time.Second)
+		history.StoreAt(checkout.SeriesKey(), telemetry.LatencyP99, p99, at, nil)
+		history.StoreAt(checkout.SeriesKey(), telemetry.LatencyP50, 10, at, nil)
+		history.StoreAt(checkout.SeriesKey(), telemetry.ErrorRate, 0, at, nil)
+	}
+	if err := history.Save(dataFile); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	services := fakeDiscoverer{metrics: []*istio.ServiceMeshMetrics{checkout}}
+	if err := analyze(context.Background(), &stdout, config.DefaultConfig(), []istio.Cluster{{Discovery: services}}, nil, nil, nil, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	out := stdout.String()
+	if !strings.Contains(out, "Before/after shop/checkout") || !strings.Contains(out, ": REGRESSED") {
+		t.Errorf("Expected checkout reported as regressed, got:\n%s", out)
+	}
+	for _, want := range []string{"latency_p99  95 → 404.1 (+325%, p=0.000) REGRESSION", "error_rate   0 → 0 (+0%, p=1.000) no significant change", "latency_p50  10 → 10 (+0%, p=1.000) no significant change"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in the comparison, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestAnalyze_JSONCompareWindowInResult(t *testing.T) {
+	compareWindow = 15 * time.Minute
+	dataFile = filepath.Join(t.TempDir(), "series.json")
+	cfg := config.DefaultConfig()
+	cfg.Output.Format = "json"
+	progress.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		compareWindow = 0
+		compareAt = ""
+		dataFile = ""
+		progress.SetOutput(os.Stdout)
+	})
+
+	checkout := fakeService("checkout", 10*time.Millisecond, 400*time.Millisecond)
+	split := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	compareAt = split.Format(time.RFC3339)
+	history := timeseries.NewStorage()
+	for i := -10; i < 10; i++ {
+		p99 := 100.0 + float64(i%3)*5
+		if i >= 0 {
+			p99 = 400 + float64(i%3)*5
+		}
+		history.StoreAt(checkout.SeriesKey(), telemetry.LatencyP99, p99, split.Add(time.Duration(i)*30*time.Second), nil)
+	}
+	if err := history.Save(dataFile); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	services := fakeDiscoverer{metrics: []*istio.ServiceMeshMetrics{checkout}}
+	if err := analyze(context.Background(), &stdout, cfg, []istio.Cluster{{Discovery: services}}, nil, nil, nil, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var result output.ScanResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("Expected stdout to be a single JSON object, got %q: %v", stdout.String(), err)
+	}
+	if len(result.Comparisons) != 1 || !result.Comparisons[0].Regressed() {
+		t.Errorf("Expected checkout's comparison to show a regression, got %+v", result.Comparisons)
+	}
+}
+
+func TestAnalyze_JSONIncludesIncidents(t *testing.T) {
+	dataFile = filepath.Join(t.TempDir(), "series.json")
+	cfg := config.DefaultConfig()
+	cfg.Output.Format = "json"
+	progress.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		dataFile = ""
+		progress.SetOutput(os.Stdout)
+	})
+
+	sent := &recordingSender{}
+	notifier := notify.NewNotifier(sent, notify.PerAnomaly)
+	templates, err := anomaly.ParseDescriptionTemplates(map[string]string{string(anomaly.ErrorRateHigh): `{{.Service}} root cause: {{.Labels.root_cause}}`})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	notifier.SetDescriptionTemplates(templates)
+
+	// productpage fails because ratings, which it calls, does
+	scan := func(requests float64) output.ScanResult {
+		t.Helper()
+		failing := func(name string) *istio.ServiceMeshMetrics {
+			m := fakeService(name, 10*time.Millisecond, 20*time.Millisecond)
+			m.Normalized.Requests = requests
+			m.Normalized.Errors5xx = requests / 5
+			return m
+		}
+		productpage, ratings := failing("productpage"), failing("ratings")
+		productpage.Edges = []istio.EdgeTraffic{{Source: "productpage", Destination: "ratings", Requests: requests, Errors: requests / 5}}
+
+		var stdout bytes.Buffer
+		services := fakeDiscoverer{metrics: []*istio.ServiceMeshMetrics{productpage, ratings}}
+		if err := analyze(context.Background(), &stdout, cfg, []istio.Cluster{{Discovery: services}}, nil, nil, notifier, nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var result output.ScanResult
+		if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+			t.Fatalf("Expected stdout to be a single JSON object, got %q: %v", stdout.String(), err)
+		}
+		return result
+	}
+
+	scan(100)
+	result := scan(200)
+	if len(result.Incidents) != 1 || result.Incidents[0].RootCause != "ratings" || strings.Join(result.Incidents[0].Collateral, ",") != "productpage" {
+		t.Errorf("Expected ratings as the root cause of productpage, got %+v", result.Incidents)
+	}
+	// Notifications go out after grouping, so they name the root cause
+	var collateral bool
+	for _, msg := range sent.messages {
+		collateral = collateral || strings.Contains(msg.Text, "productpage root cause: ratings")
+	}
+	if !collateral {
+		t.Errorf("Expected productpage's notification to name ratings as the root cause, got %+v", sent.messages)
+	}
+}
+
+// recordingSender records the messages a notifier sends.
+type recordingSender struct {
+	messages []notify.Message
+}
+
+func (r *recordingSender) Send(ctx context.Context, msg notify.Message) error {
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+// recordingDiscoverer records which services were collected.
+type recordingDiscoverer struct {
+	fakeDiscoverer
+	collected *[]string
+}
+
+func (r recordingDiscoverer) CollectMetrics(ctx context.Context, namespace, serviceName string) (*istio.ServiceMeshMetrics, error) {
+	*r.collected = append(*r.collected, serviceName)
+	return r.fakeDiscoverer.CollectMetrics(ctx, namespace, serviceName)
+}
+
+func TestAnalyze_SkipIdleSurfacesNewlyIdle(t *testing.T) {
+	skipIdle = true
+	compareBaseline = true
+	dataFile = filepath.Join(t.TempDir(), "series.json")
+	progress.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		skipIdle = false
+		compareBaseline = false
+		dataFile = ""
+		progress.SetOutput(os.Stdout)
+	})
+
+	idle := func(name string) *istio.ServiceMeshMetrics {
+		m := fakeService(name, 10*time.Millisecond, 20*time.Millisecond)
+		m.Normalized.Requests = 0
+		return m
+	}
+	busy, dormant, drained, fresh := fakeService("busy", 10*time.Millisecond, 20*time.Millisecond), idle("dormant"), idle("drained"), idle("fresh")
+	// The cumulative count stays where it stopped, not at zero
+	drained.Normalized.Requests = 540
+
+	// dormant never served a request; drained did until two scans ago
+	history := timeseries.NewStorage()
+	for i, count := range []float64{300, 540, 540} {
+		at := time.Now().Add(time.Duration(i-3) * time.Minute)
+		history.StoreAt(dormant.SeriesKey(), telemetry.RequestCount, 0, at, nil)
+		history.StoreAt(drained.SeriesKey(), telemetry.RequestCount, count, at, nil)
+	}
+	if err := history.Save(dataFile); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var collected []string
+	services := recordingDiscoverer{
+		fakeDiscoverer: fakeDiscoverer{metrics: []*istio.ServiceMeshMetrics{busy, dormant, drained, fresh}},
+		collected:      &collected,
+	}
+	var stdout bytes.Buffer
+	if err := analyze(context.Background(), &stdout, config.DefaultConfig(), []istio.Cluster{{Discovery: services}}, nil, nil, nil, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Rotation order puts services never collected before first
+	sort.Strings(collected)
+	if strings.Join(collected, ",") != "busy,drained,fresh" {
+		t.Errorf("Expected the always idle dormant service not collected, got %v", collected)
+	}
+	out := stdout.String()
+	if !strings.Contains(out, "Service: busy.shop") {
+		t.Errorf("Expected the busy service shown, got:\n%s", out)
+	}
+	// fresh served nothing in its only scan, so it's idle too
+	for _, hidden := range []string{"dormant", "fresh"} {
+		if strings.Contains(out, hidden) {
+			t.Errorf("Expected the idle %s service left out, got:\n%s", hidden, out)
+		}
+	}
+	if !strings.Contains(out, "Traffic stopped: no requests for 2m0s, after 4.0 RPS") {
+		t.Errorf("Expected drained reported as went idle, got:\n%s", out)
+	}
+}
+
+// brokenDiscoverer lists one more service than it can collect.
+type brokenDiscoverer struct {
+	fakeDiscoverer
+	broken string
+}
+
+func (b brokenDiscoverer) DiscoverServices(ctx context.Context, namespace string) ([]string, error) {
+	services, err := b.fakeDiscoverer.DiscoverServices(ctx, namespace)
+	return append(services, b.broken), err
+}
+
+func TestAnalyze_JSONSummaryOnStderr(t *testing.T) {
+	var stderr bytes.Buffer
+	summaryOut = &stderr
+	progress.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		summaryOut = os.Stderr
+		progress.SetOutput(os.Stdout)
+	})
+
+	cfg := config.DefaultConfig()
+	cfg.Output.Format = "json"
+	services := brokenDiscoverer{
+		fakeDiscoverer: fakeDiscoverer{metrics: []*istio.ServiceMeshMetrics{
+			fakeService("reviews", 10*time.Millisecond, 500*time.Millisecond),
+			fakeService("ratings", 10*time.Millisecond, 20*time.Millisecond),
+		}},
+		broken: "details.shop",
+	}
+
+	var stdout bytes.Buffer
+	if err := analyze(context.Background(), &stdout, cfg, []istio.Cluster{{Discovery: services}}, nil, nil, nil, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var result output.ScanResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("Expected stdout to be only the scan result, got %q: %v", stdout.String(), err)
+	}
+	anomalies := result.Anomalies
+
+	lines := strings.Split(strings.TrimRight(stderr.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected a single summary line on stderr, got %q", stderr.String())
+	}
+	var summary map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &summary); err != nil {
+		t.Fatalf("Expected a JSON summary, got %q: %v", lines[0], err)
+	}
+	want := map[string]any{
+		"services_scanned": 2.0,
+		"services_failed":  1.0,
+		"anomalies":        float64(len(anomalies)),
+		"max_severity":     anomaly.SeverityText(maxSeverity(anomalies)),
+	}
+	for field, value := range want {
+		if summary[field] != value {
+			t.Errorf("Expected %s=%v, got %v", field, value, summary[field])
+		}
+	}
+	if _, ok := summary["duration_ms"].(float64); !ok {
+		t.Errorf("Expected a duration_ms, got %v", summary["duration_ms"])
+	}
+	if len(anomalies) == 0 {
+		t.Error("Expected the slow reviews service to raise an anomaly")
+	}
+}
+
+func TestPreviewScan_DiscoversWithoutCollecting(t *testing.T) {
+	progress.SetQuiet(true)
+	defer progress.SetQuiet(false)
+
+	discoverer := &countingDiscoverer{fakeDiscoverer: fakeDiscoverer{metrics: []*istio.ServiceMeshMetrics{
+		fakeService("reviews", 10*time.Millisecond, 20*time.Millisecond),
+		fakeService("ratings", 10*time.Millisecond, 20*time.Millisecond),
+	}}}
+
+	var stdout bytes.Buffer
+	if err := previewScan(context.Background(), &stdout, config.DefaultConfig(), []istio.Cluster{{Discovery: discoverer}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if discoverer.discovered != 1 || discoverer.collected != 0 {
+		t.Errorf("Expected discovery without collection, got %d discoveries and %d collections", discoverer.discovered, discoverer.collected)
+	}
+	for _, expected := range []string{"2 services in 1 namespaces, 2 pods to scrape", "reviews.shop: 1 pods", "  ratings-1: exec curl"} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("Expected %q in the preview, got:\n%s", expected, stdout.String())
+		}
+	}
+}
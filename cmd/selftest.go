@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/ml"
+	"smanalyzer/pkg/telemetry"
+	"smanalyzer/pkg/timeseries"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Check that detection finds known faults in synthetic data",
+	Long: `Runs the clustering and detection pipeline, with the loaded config, over
+built-in series with known faults injected and checks that each fault is
+found and that a healthy series raises none of them. Needs no cluster, so a
+binary and config can be verified before they're deployed:
+
+  smanalyzer selftest --config prod.yaml
+
+Exits non-zero when a check fails.`,
+	Args: cobra.NoArgs,
+	Run:  runSelftest,
+}
+
+func init() {
+	rootCmd.AddCommand(selftestCmd)
+}
+
+func runSelftest(cmd *cobra.Command, args []string) {
+	cfg, err := config.Load(viper.GetViper())
+	if err != nil {
+		log.Fatalf("Self-test failed: %v", err)
+	}
+	if err := selfTest(os.Stdout, cfg); err != nil {
+		log.Fatalf("Self-test failed: %v", err)
+	}
+}
+
+// selfTestCase is a synthetic service history whose last points have a
+// fault injected, and the anomaly detection should raise for it. Faults
+// are sized from the config, so the checks hold for any sane thresholds.
+type selfTestCase struct {
+	name string
+	want anomaly.AnomalyType
+	// points is how many of the latest points carry the fault
+	points func(cfg *config.Config) int
+	inject func(n *telemetry.Normalized, cfg *config.Config)
+}
+
+var selfTestCases = []selfTestCase{
+	{
+		name: "error rate spike",
+		want: anomaly.ErrorRateHigh,
+		points: func(cfg *config.Config) int {
+			return max(3, cfg.Detection.ConsecutiveBreaches)
+		},
+		inject: func(n *telemetry.Normalized, cfg *config.Config) {
+			n.Errors4xx = 0
+			n.Errors5xx = n.Requests * math.Min(1, 4*cfg.Detection.ErrorRateThreshold)
+		},
+	},
+	{
+		name:   "traffic spike",
+		want:   anomaly.TrafficSpike,
+		points: func(cfg *config.Config) int { return 3 },
+		inject: func(n *telemetry.Normalized, cfg *config.Config) {
+			n.Requests *= 3 * cfg.Detection.TrafficSpikeThreshold
+		},
+	},
+	{
+		name:   "retry storm",
+		want:   anomaly.RetryStorm,
+		points: func(cfg *config.Config) int { return 1 },
+		inject: func(n *telemetry.Normalized, cfg *config.Config) {
+			n.Retries = float64(3 * cfg.Detection.RetryThreshold)
+		},
+	},
+	{
+		// A lasting shift in traffic, below the default spike threshold,
+		// that only the learned baseline catches
+		name:   "behavior change",
+		want:   anomaly.BehavioralAnomaly,
+		points: func(cfg *config.Config) int { return cfg.Clustering.WindowSize },
+		inject: func(n *telemetry.Normalized, cfg *config.Config) {
+			n.Requests *= 1.5
+		},
+	},
+}
+
+// selfTestInterval is the spacing of the synthetic scrapes.
+const selfTestInterval = time.Minute
+
+// selfTestRipple varies the healthy request count scrape to scrape.
+var selfTestRipple = []float64{0, 0.02, 0.01, -0.01, -0.02}
+
+// selfTest runs every case through storage, baseline learning and
+// detection built from cfg, writes a line per check to out, and fails when
+// a fault is missed or the healthy series raises one.
+func selfTest(out io.Writer, cfg *config.Config) error {
+	failed := 0
+	check := func(name string, ok bool, detail string) {
+		mark := "✓"
+		if !ok {
+			mark = "✗"
+			failed++
+		}
+		fmt.Fprintf(out, "%s %s: %s\n", mark, name, detail)
+	}
+
+	wanted := make(map[anomaly.AnomalyType]bool, len(selfTestCases))
+	for _, c := range selfTestCases {
+		wanted[c.want] = true
+	}
+
+	healthy, err := runSelfTestCase(cfg, nil)
+	if err != nil {
+		return err
+	}
+	var falsePositives []anomaly.AnomalyType
+	for _, a := range healthy {
+		if wanted[a.Type] {
+			falsePositives = append(falsePositives, a.Type)
+		}
+	}
+	check("healthy service", len(falsePositives) == 0, selfTestDetail(falsePositives, "no false positives", "unexpected"))
+
+	for _, c := range selfTestCases {
+		found, err := runSelfTestCase(cfg, &c)
+		if err != nil {
+			return err
+		}
+		var types []anomaly.AnomalyType
+		hit := false
+		for _, a := range found {
+			types = append(types, a.Type)
+			hit = hit || a.Type == c.want
+		}
+		if hit {
+			check(c.name, true, fmt.Sprintf("%s found", c.want))
+		} else {
+			check(c.name, false, selfTestDetail(types, fmt.Sprintf("expected %s, found nothing", c.want), fmt.Sprintf("expected %s, found", c.want)))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(selfTestCases)+1)
+	}
+	return nil
+}
+
+func selfTestDetail(types []anomaly.AnomalyType, none, some string) string {
+	if len(types) == 0 {
+		return none
+	}
+	return fmt.Sprintf("%s %v", some, types)
+}
+
+// runSelfTestCase stores a steady healthy history, learns a baseline from
+// it, then stores the case's faulty points (more healthy ones when c is
+// nil) and returns what detection raises.
+func runSelfTestCase(cfg *config.Config, c *selfTestCase) ([]anomaly.Anomaly, error) {
+	const key = "selftest/service"
+	window := cfg.Clustering.WindowSize
+	history := max(40, 4*window)
+	faulty := window
+	if c != nil {
+		faulty = c.points(cfg)
+	}
+
+	// A fixed seed and start keep every run identical
+	base := healthySignals(rand.New(rand.NewSource(1)))
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	storage := timeseries.NewStorage()
+	storePoint := func(i int, fault bool) {
+		n := base
+		// A repeating ripple gives the baseline some spread, and every
+		// later healthy window matches one it was learned from
+		n.Requests *= 1 + selfTestRipple[i%len(selfTestRipple)]
+		if fault {
+			c.inject(&n, cfg)
+		}
+		for metric, value := range n.Series() {
+			storage.StoreAt(key, metric, value, start.Add(time.Duration(i)*selfTestInterval), nil)
+		}
+	}
+
+	for i := 0; i < history; i++ {
+		storePoint(i, false)
+	}
+	detector := anomaly.NewDetector(cfg.ToAnomalyDetectionConfig(), ml.NewClusteringEngine(cfg.ToMLConfig()))
+	if err := detector.LearnBaseline(key, storage.GetLatestN(key, anomaly.RequestCountMetric, history)); err != nil {
+		return nil, fmt.Errorf("failed to learn the synthetic baseline: %w", err)
+	}
+
+	for i := history; i < history+faulty; i++ {
+		storePoint(i, c != nil)
+	}
+	return detector.DetectFromStorage(storage, key)
+}
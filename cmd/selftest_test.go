@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"smanalyzer/pkg/config"
+)
+
+func TestSelfTest_DefaultConfigPasses(t *testing.T) {
+	var out bytes.Buffer
+	if err := selfTest(&out, config.DefaultConfig()); err != nil {
+		t.Fatalf("Unexpected error: %v\n%s", err, out.String())
+	}
+	if lines := strings.Count(out.String(), "✓"); lines != len(selfTestCases)+1 {
+		t.Errorf("Expected %d passing checks, got:\n%s", len(selfTestCases)+1, out.String())
+	}
+}
+
+func TestSelfTest_FailsWhenDetectionMisses(t *testing.T) {
+	// With the retry check disabled the injected storm goes unnoticed
+	cfg := config.DefaultConfig()
+	cfg.Detection.RetryThreshold = 0
+
+	var out bytes.Buffer
+	err := selfTest(&out, cfg)
+	if err == nil {
+		t.Fatalf("Expected the self-test to fail, got:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "✗ retry storm: expected retry_storm") {
+		t.Errorf("Expected the retry storm check to fail, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,80 @@
+package anomaly
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// DescriptionData is what a description template can reference, e.g.
+// {{.Service}}, {{.Severity}} or {{.Metrics.error_rate}}.
+type DescriptionData struct {
+	Type      AnomalyType
+	Service   string
+	Namespace string
+	Severity  float64
+	Metrics   map[string]float64
+	Labels    map[string]string
+	// Description is the detector's own wording
+	Description string
+}
+
+// DescriptionTemplates renders anomaly descriptions by anomaly type.
+type DescriptionTemplates map[AnomalyType]*template.Template
+
+var descriptionFuncs = template.FuncMap{
+	// percent turns a fraction into a percentage, e.g. for error rates
+	"percent": func(v float64) float64 { return v * 100 },
+}
+
+// DefaultDescriptionTemplateText reproduces the detector's built-in wording.
+func DefaultDescriptionTemplateText() map[string]string {
+	return map[string]string{
+		string(TrafficSpike):      `Traffic spike detected: {{printf "%.2f" .Metrics.current_traffic}} requests`,
+		string(ErrorRateHigh):     `High error rate: {{printf "%.2f" (percent .Metrics.error_rate)}}%`,
+		string(BehavioralAnomaly): `Behavioral anomaly detected (distance: {{printf "%.2f" .Metrics.anomaly_distance}})`,
+	}
+}
+
+// ParseDescriptionTemplates parses the default templates overridden by the
+// given ones, keyed by anomaly type.
+func ParseDescriptionTemplates(overrides map[string]string) (DescriptionTemplates, error) {
+	texts := DefaultDescriptionTemplateText()
+	for anomalyType, text := range overrides {
+		texts[anomalyType] = text
+	}
+
+	templates := DescriptionTemplates{}
+	for anomalyType, text := range texts {
+		tmpl, err := template.New(anomalyType).Funcs(descriptionFuncs).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid description template for %s: %w", anomalyType, err)
+		}
+		templates[AnomalyType(anomalyType)] = tmpl
+	}
+	return templates, nil
+}
+
+// Describe renders the anomaly's description, falling back to the
+// detector's wording when no template applies or rendering fails.
+func (t DescriptionTemplates) Describe(a Anomaly) string {
+	tmpl, exists := t[a.Type]
+	if !exists {
+		return a.Description
+	}
+
+	var out strings.Builder
+	err := tmpl.Execute(&out, DescriptionData{
+		Type:        a.Type,
+		Service:     a.ServiceName,
+		Namespace:   a.Namespace,
+		Severity:    a.Severity,
+		Metrics:     a.Metrics,
+		Labels:      a.Labels,
+		Description: a.Description,
+	})
+	if err != nil {
+		return a.Description
+	}
+	return out.String()
+}
@@ -0,0 +1,67 @@
+package anomaly
+
+import (
+	"testing"
+
+	"smanalyzer/pkg/ml"
+)
+
+func TestDescriptionTemplates_DefaultsMatchDetector(t *testing.T) {
+	templates, err := ParseDescriptionTemplates(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	config := DetectionConfig{TrafficSpikeThreshold: 2.0, ErrorRateThreshold: 0.05, FeatureWindow: 3}
+	detector := NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 2}))
+
+	anomalies := detector.detectTrafficAnomalies("reviews", spikePoints())
+	anomalies = append(anomalies, detector.detectErrorRateAnomalies("reviews", constantPoints(0.2137, 3))...)
+	if len(anomalies) != 2 {
+		t.Fatalf("Expected 2 anomalies, got %d", len(anomalies))
+	}
+
+	for _, a := range anomalies {
+		if got := templates.Describe(a); got != a.Description {
+			t.Errorf("Expected default template to render %q, got %q", a.Description, got)
+		}
+	}
+}
+
+func TestDescriptionTemplates_CustomRunbook(t *testing.T) {
+	templates, err := ParseDescriptionTemplates(map[string]string{
+		string(ErrorRateHigh): `{{.Service}}.{{.Namespace}} errors at {{printf "%.1f" (percent .Metrics.error_rate)}}% (severity {{printf "%.1f" .Severity}}) - runbook: https://runbooks.example.com/{{.Type}}?service={{.Service}}`,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got := templates.Describe(Anomaly{
+		Type:        ErrorRateHigh,
+		ServiceName: "reviews",
+		Namespace:   "shop",
+		Severity:    4.2,
+		Metrics:     map[string]float64{"error_rate": 0.21},
+		Description: "High error rate: 21.00%",
+	})
+
+	expected := "reviews.shop errors at 21.0% (severity 4.2) - runbook: https://runbooks.example.com/error_rate_high?service=reviews"
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestDescriptionTemplates_InvalidTemplate(t *testing.T) {
+	if _, err := ParseDescriptionTemplates(map[string]string{string(TrafficSpike): "{{.Service"}); err == nil {
+		t.Error("Expected an error for an unparseable template")
+	}
+}
+
+func TestDescriptionTemplates_FallsBackWithoutTemplate(t *testing.T) {
+	templates, _ := ParseDescriptionTemplates(nil)
+
+	a := Anomaly{Type: CircuitBreaker, Description: "Circuit breaker open"}
+	if got := templates.Describe(a); got != a.Description {
+		t.Errorf("Expected %q, got %q", a.Description, got)
+	}
+}
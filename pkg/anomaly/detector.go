@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"math"
 	"time"
+	"smanalyzer/pkg/clock"
 	"smanalyzer/pkg/ml"
+	"smanalyzer/pkg/telemetry"
 	"smanalyzer/pkg/timeseries"
 )
 
@@ -17,17 +19,47 @@ const (
 	CircuitBreaker   AnomalyType = "circuit_breaker"
 	RetryStorm       AnomalyType = "retry_storm"
 	TimeoutAnomaly   AnomalyType = "timeout_anomaly"
+	BehavioralAnomaly AnomalyType = "behavioral_anomaly"
+	TailLatency      AnomalyType = "tail_latency"
+	PercentChange    AnomalyType = "percent_change"
+	ConnectionFailure AnomalyType = "connection_failure"
+	ReplicaDivergence AnomalyType = "replica_divergence"
+	PayloadSizeAnomaly AnomalyType = "payload_size_anomaly"
+	PercentileInversion AnomalyType = "percentile_inversion"
+	OutlierEjection  AnomalyType = "outlier_ejection"
+	RuleAlert        AnomalyType = "rule_alert"
+	WentIdle         AnomalyType = "went_idle"
 )
 
 type Anomaly struct {
 	Type        AnomalyType           `json:"type"`
 	ServiceName string                `json:"service_name"`
 	Namespace   string                `json:"namespace"`
+	Cluster     string                `json:"cluster,omitempty"`
 	Severity    float64               `json:"severity"`
 	Description string                `json:"description"`
 	Timestamp   time.Time             `json:"timestamp"`
 	Metrics     map[string]float64    `json:"metrics"`
 	Labels      map[string]string     `json:"labels"`
+	Trend       Trend                 `json:"trend,omitempty"`
+	// FirstSeen marks the first time this type was raised for the service;
+	// see MarkFirstSeen
+	FirstSeen   bool                  `json:"first_seen,omitempty"`
+}
+
+// SeverityLevels are the labels returned by SeverityText, most severe first.
+var SeverityLevels = []string{"CRITICAL", "HIGH", "MEDIUM", "LOW"}
+
+// SeverityText buckets a severity score into one of SeverityLevels.
+func SeverityText(severity float64) string {
+	if severity >= 3.0 {
+		return "CRITICAL"
+	} else if severity >= 2.0 {
+		return "HIGH"
+	} else if severity >= 1.5 {
+		return "MEDIUM"
+	}
+	return "LOW"
 }
 
 type DetectionConfig struct {
@@ -36,14 +68,108 @@ type DetectionConfig struct {
 	LatencyThreshold       time.Duration
 	RetryThreshold         int64
 	TimeoutThreshold       int64
-	WindowSize            int
+	// OutlierEjectionThreshold flags upstream hosts ejected by outlier
+	// detection once at least this many are ejected now or were ejected
+	// since the previous point. Zero disables the check.
+	OutlierEjectionThreshold int64
+	// ConnFailureSpikeFactor flags upstream connection failures this many
+	// times their earlier mean (at least one). Zero disables the check.
+	ConnFailureSpikeFactor float64
+	// PayloadSizeFactor flags the mean request or response size moving
+	// this many times away from its earlier mean, either way. Values of
+	// one or less disable the check.
+	PayloadSizeFactor float64
+	// BreakerRemainingFraction raises a warning-level circuit breaker
+	// anomaly when less than this fraction of a threshold is left. Zero
+	// disables it; an open breaker is always raised.
+	BreakerRemainingFraction float64
+	// FeatureWindow is how many consecutive points each clustering
+	// feature is computed over, both when learning a baseline and when
+	// scoring the latest window against it.
+	FeatureWindow         int
+	// Lookback is how many recent points the static detectors consider;
+	// zero considers every point they are given. It doesn't bound the
+	// points ML detection extracts its window from.
+	Lookback              int
+	// ConsecutiveBreaches is how many of the latest points must breach the
+	// error rate, latency SLO or tail amplification threshold before an
+	// anomaly is raised. Zero or one reacts to the latest point alone.
+	ConsecutiveBreaches   int
 	SensitivityLevel      float64
+	// PerClusterThreshold compares a point against the spread of its nearest
+	// baseline cluster instead of a single threshold pooled across clusters.
+	PerClusterThreshold   bool
+	// ErrorRateSource selects the error rate series detection targets:
+	// "effective" (client-visible, the default) or "upstream" (including
+	// failures masked by retries).
+	ErrorRateSource       string
+	// MinRequestVolume suppresses error rate anomalies unless the requests
+	// served over the window exceed it, so a handful of requests can't page
+	// anyone. Zero disables the gate.
+	MinRequestVolume      float64
+	// TailLatencyFactor flags tail amplification when P99 exceeds P50 by
+	// this factor. Zero disables the check.
+	TailLatencyFactor     float64
+	// TailSpikeThreshold flags a P99 spike of this factor over its earlier
+	// mean while P50 stays flat. Zero disables the check.
+	TailSpikeThreshold    float64
+	// ThresholdFloor is the smallest behavioral distance threshold, so a
+	// perfectly flat baseline (zero variance) still tolerates float noise
+	// and yields finite severities. Zero uses defaultThresholdFloor.
+	ThresholdFloor        float64
+	// PercentChangeRules flag any stored metric that moves too far from
+	// its recent average.
+	PercentChangeRules    []PercentChangeRule
+	// AlertRules are user-declared conditions on stored metrics, each
+	// optionally scoped to a service and sustained for a duration.
+	AlertRules            []AlertRule
+	// MinReplicas marks services running fewer replicas than this as
+	// naturally spiky; see GateReplicas. Zero disables the check.
+	MinReplicas           int
+	// LowReplicaAction is what GateReplicas does with their anomalies:
+	// "downgrade" (the default) or "suppress".
+	LowReplicaAction      string
+	// LatencySLO flags P99 latency above each service's latency target,
+	// LatencyThreshold unless ServiceOverrides sets one.
+	LatencySLO            bool
+	// ServiceOverrides replace the settings above for individual services,
+	// keyed by service, namespace/service or cluster/namespace/service.
+	ServiceOverrides      map[string]ServiceOverride
 }
 
+const defaultThresholdFloor = 0.01
+
+// Names of the stored series read by detection
+const (
+	RequestCountMetric      = telemetry.RequestCount
+	ErrorRateMetric         = telemetry.ErrorRate
+	UpstreamErrorRateMetric = telemetry.UpstreamErrorRate
+	LatencyP50Metric        = telemetry.LatencyP50
+	LatencyP90Metric        = telemetry.LatencyP90
+	LatencyP95Metric        = telemetry.LatencyP95
+	LatencyP99Metric        = telemetry.LatencyP99
+	RetryCountMetric        = telemetry.RetryCount
+	TimeoutCountMetric      = telemetry.TimeoutCount
+	CircuitBreakersMetric   = telemetry.CircuitBreakers
+	BreakerUsageMetric      = telemetry.BreakerUsage
+	ConnFailuresMetric      = telemetry.ConnFailures
+	EjectionsActiveMetric   = telemetry.EjectionsActive
+	EjectionsMetric         = telemetry.Ejections
+	RequestSizeMetric       = telemetry.RequestSize
+	ResponseSizeMetric      = telemetry.ResponseSize
+)
+
+// Signals holds the recent points of each stored series for a service,
+// keyed by metric name.
+type Signals map[string][]timeseries.DataPoint
+
 type Detector struct {
 	config          DetectionConfig
 	clusteringEngine *ml.ClusteringEngine
 	baselines       map[string][]ml.Cluster
+	trends          *TrendTracker
+	memo            map[string]detectionMemo
+	clock           clock.Clock
 }
 
 func NewDetector(config DetectionConfig, clusteringEngine *ml.ClusteringEngine) *Detector {
@@ -51,26 +177,181 @@ func NewDetector(config DetectionConfig, clusteringEngine *ml.ClusteringEngine)
 		config:           config,
 		clusteringEngine: clusteringEngine,
 		baselines:        make(map[string][]ml.Cluster),
+		trends:           NewTrendTracker(),
+		memo:             make(map[string]detectionMemo),
+		clock:            clock.Real{},
 	}
 }
 
+// SetClock replaces the clock used to stamp anomalies that have no data
+// point of their own.
+func (d *Detector) SetClock(c clock.Clock) {
+	d.clock = c
+}
+
 func (d *Detector) LearnBaseline(serviceName string, points []timeseries.DataPoint) error {
-	if len(points) < d.config.WindowSize {
+	if len(points) < d.config.FeatureWindow {
 		return fmt.Errorf("insufficient data points for baseline learning")
 	}
 
-	features := d.clusteringEngine.ExtractFeatures(points, d.config.WindowSize)
+	features := d.clusteringEngine.ExtractFeatures(points, d.config.FeatureWindow)
 	clusters := d.clusteringEngine.KMeans(features)
 	
 	d.baselines[serviceName] = clusters
+	delete(d.memo, serviceName)
 	
 	return nil
 }
 
+// Baselines returns the learned baseline clusters keyed by service name.
+func (d *Detector) Baselines() map[string][]ml.Cluster {
+	return d.baselines
+}
+
+// SetBaseline installs a previously learned baseline for a service.
+func (d *Detector) SetBaseline(serviceName string, clusters []ml.Cluster) {
+	d.baselines[serviceName] = clusters
+	delete(d.memo, serviceName)
+}
+
+// DetectFromStorage runs detection over the most recent points stored for a service.
+func (d *Detector) DetectFromStorage(storage *timeseries.Storage, serviceName string) ([]Anomaly, error) {
+	signals := Signals{}
+	metrics := []string{RequestCountMetric, ErrorRateMetric, UpstreamErrorRateMetric, LatencyP50Metric, LatencyP90Metric, LatencyP95Metric, LatencyP99Metric,
+		RetryCountMetric, TimeoutCountMetric, CircuitBreakersMetric, BreakerUsageMetric, ConnFailuresMetric, EjectionsActiveMetric, EjectionsMetric, RequestSizeMetric, ResponseSizeMetric}
+	for _, rule := range d.config.PercentChangeRules {
+		metrics = append(metrics, rule.Metric)
+	}
+	for _, rule := range d.config.AlertRules {
+		metrics = append(metrics, rule.Metric)
+	}
+	n := d.fetchSize()
+	for _, metric := range metrics {
+		if _, fetched := signals[metric]; fetched {
+			continue
+		}
+		if points := storage.GetLatestN(serviceName, metric, n); len(points) > 0 {
+			signals[metric] = points
+		}
+	}
+	return d.DetectSignals(serviceName, signals)
+}
+
+// DefaultLookback is the number of recent points the static detectors
+// consider when reading from storage with no lookback configured.
+const DefaultLookback = 50
+
+// fetchSize is how many points to read per series: enough for the lookback,
+// the latest feature window and every percent change rule.
+func (d *Detector) fetchSize() int {
+	n := d.config.Lookback
+	if n <= 0 {
+		n = DefaultLookback
+	}
+	if w := d.config.FeatureWindow + 1; w > n {
+		n = w
+	}
+	for _, rule := range d.config.PercentChangeRules {
+		if w := rule.window() + 1; w > n {
+			n = w
+		}
+	}
+	return n
+}
+
+// recent trims points to the lookback.
+func (d *Detector) recent(points []timeseries.DataPoint) []timeseries.DataPoint {
+	if d.config.Lookback > 0 && len(points) > d.config.Lookback {
+		return points[len(points)-d.config.Lookback:]
+	}
+	return points
+}
+
+// DetectSignals runs each detector against the series it applies to: traffic
+// and behavioral detection on request counts, error detection on the
+// configured error rate series, tail latency and SLO detection on P50 and
+// P99, resilience detection on retries, timeouts, open circuit breakers and
+// outlier ejections, spike detection on connection failures, shift
+// detection on the mean request and response sizes, and the configured
+// rules on the metrics they name.
+func (d *Detector) DetectSignals(serviceName string, signals Signals) ([]Anomaly, error) {
+	windowHash := hashSignals(signals)
+	if cached, ok := d.memoized(serviceName, windowHash); ok {
+		return cached, nil
+	}
+	
+	var anomalies []Anomaly
+	
+	// Static detectors see the lookback; percent change rules have their
+	// own windows and ML detection its feature window
+	recent := make(Signals, len(signals))
+	for metric, points := range signals {
+		recent[metric] = d.recent(points)
+	}
+	
+	requests := recent[RequestCountMetric]
+	anomalies = append(anomalies, d.detectTrafficAnomalies(serviceName, requests)...)
+	
+	var errorAnomalies []Anomaly
+	if d.hasRequestVolume(requests) {
+		errorAnomalies = d.detectErrorRateAnomalies(serviceName, recent[d.errorRateMetric()])
+	}
+	for i := range errorAnomalies {
+		// Surface both rates so masked upstream failures stay visible
+		for _, metric := range []string{ErrorRateMetric, UpstreamErrorRateMetric} {
+			if points := recent[metric]; len(points) > 0 {
+				errorAnomalies[i].Metrics[metric] = points[len(points)-1].Value
+			}
+		}
+	}
+	anomalies = append(anomalies, errorAnomalies...)
+	anomalies = append(anomalies, d.detectTailLatencyAnomalies(serviceName, recent[LatencyP50Metric], recent[LatencyP99Metric])...)
+	anomalies = append(anomalies, d.detectLatencySLOAnomalies(serviceName, recent[LatencyP99Metric])...)
+	anomalies = append(anomalies, detectPercentileInversion(serviceName, recent)...)
+	anomalies = append(anomalies, d.detectResilienceAnomalies(serviceName, recent)...)
+	anomalies = append(anomalies, d.detectConnectionFailureAnomalies(serviceName, recent[ConnFailuresMetric])...)
+	anomalies = append(anomalies, d.detectPayloadSizeAnomalies(serviceName, recent)...)
+	for _, rule := range d.config.PercentChangeRules {
+		if a, found := rule.evaluate(serviceName, signals[rule.Metric]); found {
+			anomalies = append(anomalies, a)
+		}
+	}
+	for _, rule := range d.config.AlertRules {
+		if a, found := rule.evaluate(serviceName, recent[rule.Metric]); found {
+			anomalies = append(anomalies, a)
+		}
+	}
+	
+	if clusters, exists := d.baselines[serviceName]; exists {
+		anomalies = append(anomalies, d.detectMLAnomalies(serviceName, signals[RequestCountMetric], clusters)...)
+	}
+	
+	d.trends.Observe(anomalies)
+	d.remember(serviceName, windowHash, anomalies)
+	
+	return anomalies, nil
+}
+
+func (d *Detector) errorRateMetric() string {
+	if d.config.ErrorRateSource == "upstream" {
+		return UpstreamErrorRateMetric
+	}
+	return ErrorRateMetric
+}
+
+// DetectAnomalies runs static detection over the lookback and ML detection
+// over the latest feature window of recentPoints. When the
+// window is identical to the previous call for the service, the previous
+// result is returned without recomputation.
 func (d *Detector) DetectAnomalies(serviceName string, recentPoints []timeseries.DataPoint) ([]Anomaly, error) {
+	windowHash := hashWindow(recentPoints)
+	if cached, ok := d.memoized(serviceName, windowHash); ok {
+		return cached, nil
+	}
+	
 	var anomalies []Anomaly
 	
-	staticAnomalies := d.detectStaticAnomalies(serviceName, recentPoints)
+	staticAnomalies := d.detectStaticAnomalies(serviceName, d.recent(recentPoints))
 	anomalies = append(anomalies, staticAnomalies...)
 	
 	if clusters, exists := d.baselines[serviceName]; exists {
@@ -78,12 +359,42 @@ func (d *Detector) DetectAnomalies(serviceName string, recentPoints []timeseries
 		anomalies = append(anomalies, mlAnomalies...)
 	}
 	
+	d.trends.Observe(anomalies)
+	d.remember(serviceName, windowHash, anomalies)
+	
 	return anomalies, nil
 }
 
 func (d *Detector) detectStaticAnomalies(serviceName string, points []timeseries.DataPoint) []Anomaly {
 	var anomalies []Anomaly
 	
+	anomalies = append(anomalies, d.detectTrafficAnomalies(serviceName, points)...)
+	if d.hasRequestVolume(points) {
+		anomalies = append(anomalies, d.detectErrorRateAnomalies(serviceName, points)...)
+	}
+	
+	return anomalies
+}
+
+// hasRequestVolume reports whether the requests served over the window
+// exceed the configured minimum volume for error rate detection. The
+// request count is cumulative, so the volume is how much it grew from the
+// first point to the last.
+func (d *Detector) hasRequestVolume(requests []timeseries.DataPoint) bool {
+	if d.config.MinRequestVolume <= 0 {
+		return true
+	}
+	
+	total := 0.0
+	for i := 1; i < len(requests); i++ {
+		total += counterIncrease(requests[i-1].Value, requests[i].Value)
+	}
+	return total > d.config.MinRequestVolume
+}
+
+func (d *Detector) detectTrafficAnomalies(serviceName string, points []timeseries.DataPoint) []Anomaly {
+	var anomalies []Anomaly
+	
 	if len(points) < 2 {
 		return anomalies
 	}
@@ -101,11 +412,23 @@ func (d *Detector) detectStaticAnomalies(serviceName string, points []timeseries
 		})
 	}
 	
+	return anomalies
+}
+
+func (d *Detector) detectErrorRateAnomalies(serviceName string, points []timeseries.DataPoint) []Anomaly {
+	var anomalies []Anomaly
+	
+	if len(points) < 2 {
+		return anomalies
+	}
+	
+	latest := points[len(points)-1]
+	
 	if d.isHighErrorRate(points) {
 		anomalies = append(anomalies, Anomaly{
 			Type:        ErrorRateHigh,
 			ServiceName: serviceName,
-			Severity:    latest.Value / d.config.ErrorRateThreshold,
+			Severity:    d.errorRateSeverity(latest.Value),
 			Description: fmt.Sprintf("High error rate: %.2f%%", latest.Value*100),
 			Timestamp:   latest.Timestamp,
 			Metrics:     map[string]float64{"error_rate": latest.Value},
@@ -118,34 +441,51 @@ func (d *Detector) detectStaticAnomalies(serviceName string, points []timeseries
 func (d *Detector) detectMLAnomalies(serviceName string, points []timeseries.DataPoint, baselines []ml.Cluster) []Anomaly {
 	var anomalies []Anomaly
 	
-	if len(points) < d.config.WindowSize {
+	if len(points) < d.config.FeatureWindow {
 		return anomalies
 	}
 	
-	features := d.clusteringEngine.ExtractFeatures(points, d.config.WindowSize)
+	// Only the latest window is compared, so don't extract the others
+	if tail := d.config.FeatureWindow + 1; len(points) > tail {
+		points = points[len(points)-tail:]
+	}
+	features := d.clusteringEngine.ExtractFeatures(points, d.config.FeatureWindow)
 	if len(features) == 0 {
 		return anomalies
 	}
 	
 	latest := features[len(features)-1]
+	if !allFinite(latest.Features) {
+		return anomalies
+	}
 	minDistance := math.Inf(1)
+	nearest := 0
 	
-	for _, cluster := range baselines {
+	for i, cluster := range baselines {
 		distance := d.euclideanDistance(latest.Features, cluster.Centroid)
 		if distance < minDistance {
 			minDistance = distance
+			nearest = i
 		}
 	}
 	
 	threshold := d.calculateDynamicThreshold(baselines)
+	if d.config.PerClusterThreshold && len(baselines[nearest].Points) > 0 {
+		threshold = d.calculateDynamicThreshold(baselines[nearest : nearest+1])
+	}
 	if minDistance > threshold {
 		severity := minDistance / threshold
+		// Stamp with the offending point so replays keep the data's time
+		timestamp := d.clock.Now()
+		if latest.Original != nil {
+			timestamp = latest.Original.Timestamp
+		}
 		anomalies = append(anomalies, Anomaly{
-			Type:        "behavioral_anomaly",
+			Type:        BehavioralAnomaly,
 			ServiceName: serviceName,
 			Severity:    severity,
 			Description: fmt.Sprintf("Behavioral anomaly detected (distance: %.2f)", minDistance),
-			Timestamp:   time.Now(),
+			Timestamp:   timestamp,
 			Metrics:     map[string]float64{"anomaly_distance": minDistance},
 		})
 	}
@@ -153,34 +493,62 @@ func (d *Detector) detectMLAnomalies(serviceName string, points []timeseries.Dat
 	return anomalies
 }
 
+// trafficSpikeWindow is how many of the latest points isTrafficSpike
+// averages as the current traffic.
+const trafficSpikeWindow = 3
+
+// isTrafficSpike compares the mean of the latest points against the mean of
+// the ones before them. Until there is at least one earlier point the
+// baseline would be an empty mean of zero, which every service with traffic
+// exceeds, so there is no spike.
 func (d *Detector) isTrafficSpike(points []timeseries.DataPoint) bool {
-	if len(points) < 3 {
+	if len(points) <= trafficSpikeWindow {
 		return false
 	}
 	
-	recent := points[len(points)-3:]
-	baseline := d.calculateMean(points[:len(points)-3])
+	recent := points[len(points)-trafficSpikeWindow:]
+	baseline := d.calculateMean(points[:len(points)-trafficSpikeWindow])
 	currentRate := d.calculateMean(recent)
 	
 	return currentRate > baseline*d.config.TrafficSpikeThreshold
 }
 
 func (d *Detector) isHighErrorRate(points []timeseries.DataPoint) bool {
-	if len(points) == 0 {
+	return d.breached(points, func(errorRate float64) bool {
+		return errorRate > d.config.ErrorRateThreshold
+	})
+}
+
+// breached reports whether each of the last ConsecutiveBreaches points, or
+// just the latest when unset, is over a threshold, so one noisy scrape
+// doesn't raise an anomaly on its own.
+func (d *Detector) breached(points []timeseries.DataPoint, over func(value float64) bool) bool {
+	n := max(1, d.config.ConsecutiveBreaches)
+	if len(points) < n {
 		return false
 	}
-	
-	latest := points[len(points)-1]
-	return latest.Value > d.config.ErrorRateThreshold
+	for _, p := range points[len(points)-n:] {
+		if !over(p.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *Detector) errorRateSeverity(errorRate float64) float64 {
+	if d.config.ErrorRateThreshold <= 0 {
+		return 1.0
+	}
+	return errorRate / d.config.ErrorRateThreshold
 }
 
 func (d *Detector) calculateTrafficSpikeSeverity(points []timeseries.DataPoint) float64 {
-	if len(points) < 3 {
+	if len(points) <= trafficSpikeWindow {
 		return 1.0
 	}
 	
-	recent := points[len(points)-3:]
-	baseline := d.calculateMean(points[:len(points)-3])
+	recent := points[len(points)-trafficSpikeWindow:]
+	baseline := d.calculateMean(points[:len(points)-trafficSpikeWindow])
 	currentRate := d.calculateMean(recent)
 	
 	if baseline == 0 {
@@ -202,13 +570,19 @@ func (d *Detector) calculateMean(points []timeseries.DataPoint) float64 {
 	return sum / float64(len(points))
 }
 
-func (d *Detector) euclideanDistance(a, b []float64) float64 {
-	sum := 0.0
-	for i := range a {
-		diff := a[i] - b[i]
-		sum += diff * diff
+// counterIncrease is how much a cumulative counter grew from prev to last.
+// A drop means the proxy restarted, so last is the count since the restart.
+func counterIncrease(prev, last float64) float64 {
+	if last < prev {
+		return last
 	}
-	return math.Sqrt(sum)
+	return last - prev
+}
+
+// euclideanDistance weighs the features as the clustering engine did when
+// the baseline was learned.
+func (d *Detector) euclideanDistance(a, b []float64) float64 {
+	return d.clusteringEngine.Distance(a, b)
 }
 
 func (d *Detector) calculateDynamicThreshold(clusters []ml.Cluster) float64 {
@@ -240,5 +614,21 @@ func (d *Detector) calculateDynamicThreshold(clusters []ml.Cluster) float64 {
 	}
 	
 	avgVariance := totalVariance / float64(totalPoints)
-	return math.Sqrt(avgVariance) * d.config.SensitivityLevel
+	return math.Max(math.Sqrt(avgVariance)*d.config.SensitivityLevel, d.thresholdFloor())
+}
+
+func (d *Detector) thresholdFloor() float64 {
+	if d.config.ThresholdFloor > 0 {
+		return d.config.ThresholdFloor
+	}
+	return defaultThresholdFloor
+}
+
+func allFinite(values []float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
 }
\ No newline at end of file
@@ -0,0 +1,448 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/ml"
+	"smanalyzer/pkg/timeseries"
+)
+
+func constantPoints(value float64, n int) []timeseries.DataPoint {
+	points := make([]timeseries.DataPoint, n)
+	for i := range points {
+		points[i] = timeseries.DataPoint{Timestamp: time.Now(), Value: value}
+	}
+	return points
+}
+
+func clusterAround(centroid []float64, spread float64, n int) ml.Cluster {
+	cluster := ml.Cluster{Centroid: centroid}
+	for i := 0; i < n; i++ {
+		features := append([]float64(nil), centroid...)
+		if i%2 == 0 {
+			features[0] += spread
+		} else {
+			features[0] -= spread
+		}
+		cluster.Points = append(cluster.Points, ml.ClusterPoint{Features: features})
+	}
+	return cluster
+}
+
+func TestDetector_PerClusterThreshold(t *testing.T) {
+	baselines := []ml.Cluster{
+		clusterAround([]float64{10, 0, 0, 0}, 0.5, 5),
+		clusterAround([]float64{500, 0, 0, 0}, 100, 20),
+	}
+	points := constantPoints(13, 4)
+
+	config := DetectionConfig{FeatureWindow: 3, SensitivityLevel: 2.0}
+	engine := ml.NewClusteringEngine(ml.KMeansConfig{K: 2})
+
+	pooled := NewDetector(config, engine)
+	if anomalies := pooled.detectMLAnomalies("reviews", points, baselines); len(anomalies) != 0 {
+		t.Errorf("Expected the noisy cluster to mask the anomaly with a pooled threshold, got %d anomalies", len(anomalies))
+	}
+
+	config.PerClusterThreshold = true
+	perCluster := NewDetector(config, engine)
+	if anomalies := perCluster.detectMLAnomalies("reviews", points, baselines); len(anomalies) != 1 {
+		t.Errorf("Expected 1 anomaly near the tight cluster with per-cluster thresholds, got %d", len(anomalies))
+	}
+}
+
+func spikePoints() []timeseries.DataPoint {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var points []timeseries.DataPoint
+	for i, v := range []float64{10, 11, 10, 12, 11, 10, 11, 12, 10, 11, 40, 45, 50} {
+		points = append(points, timeseries.DataPoint{Timestamp: start.Add(time.Duration(i) * time.Minute), Value: v})
+	}
+	return points
+}
+
+func newBaselineDetector(t testing.TB) *Detector {
+	config := DetectionConfig{TrafficSpikeThreshold: 2.0, ErrorRateThreshold: 0.05, FeatureWindow: 3, SensitivityLevel: 2.0}
+	detector := NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 2, MaxIter: 50, Tolerance: 0.01}))
+	if err := detector.LearnBaseline("reviews", spikePoints()[:10]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return detector
+}
+
+func TestDetector_LookbackBoundsStaticDetection(t *testing.T) {
+	var values []float64
+	for i := 0; i < 10; i++ {
+		values = append(values, 10)
+	}
+	values = append(values, 40, 40, 40, 50, 50, 50)
+	points := latencyPoints(values...)
+
+	for _, window := range []int{3, 12} {
+		config := DetectionConfig{TrafficSpikeThreshold: 2.0, FeatureWindow: window}
+		if anomalies, _ := NewDetector(config, nil).DetectAnomalies("reviews", points); countType(anomalies, TrafficSpike) != 1 {
+			t.Errorf("feature window %d: expected a spike over the full history, got %+v", window, anomalies)
+		}
+
+		config.Lookback = 6
+		if anomalies, _ := NewDetector(config, nil).DetectAnomalies("reviews", points); countType(anomalies, TrafficSpike) != 0 {
+			t.Errorf("feature window %d: expected no spike against the last 6 points, got %+v", window, anomalies)
+		}
+	}
+}
+
+func TestDetector_LookbackDoesNotBoundFeatureWindow(t *testing.T) {
+	points := spikePoints()
+	engine := ml.NewClusteringEngine(ml.KMeansConfig{K: 2, MaxIter: 50, Tolerance: 0.01})
+
+	var behavioral []int
+	var centroids [][]float64
+	for _, lookback := range []int{0, 2} {
+		config := DetectionConfig{SensitivityLevel: 2.0, FeatureWindow: 3, Lookback: lookback}
+		detector := NewDetector(config, engine)
+		if err := detector.LearnBaseline("reviews", points[:10]); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		centroids = append(centroids, detector.Baselines()["reviews"][0].Centroid)
+
+		anomalies, _ := detector.DetectAnomalies("reviews", points)
+		behavioral = append(behavioral, countType(anomalies, BehavioralAnomaly))
+	}
+
+	if behavioral[0] != 1 || behavioral[1] != 1 {
+		t.Errorf("Expected one behavioral anomaly with and without a short lookback, got %v", behavioral)
+	}
+	if !reflect.DeepEqual(centroids[0], centroids[1]) {
+		t.Errorf("Expected the lookback not to change the learned baseline, got %v and %v", centroids[0], centroids[1])
+	}
+}
+
+func TestDetector_DetectAnomalies_CachedParity(t *testing.T) {
+	points := spikePoints()
+
+	detector := newBaselineDetector(t)
+	first, _ := detector.DetectAnomalies("reviews", points)
+	cached, _ := detector.DetectAnomalies("reviews", points)
+	recomputed, _ := newBaselineDetector(t).DetectAnomalies("reviews", points)
+
+	if len(first) == 0 {
+		t.Fatal("Expected anomalies for the spike window")
+	}
+
+	if len(cached) != len(recomputed) {
+		t.Fatalf("Expected cached result to match recomputed (%d), got %d", len(recomputed), len(cached))
+	}
+
+	for i := range cached {
+		if cached[i].Type != recomputed[i].Type || cached[i].Severity != recomputed[i].Severity {
+			t.Errorf("Expected cached anomaly %s/%.2f, got %s/%.2f",
+				recomputed[i].Type, recomputed[i].Severity, cached[i].Type, cached[i].Severity)
+		}
+	}
+}
+
+func TestDetector_DetectAnomalies_RecomputesOnChange(t *testing.T) {
+	points := spikePoints()
+	detector := newBaselineDetector(t)
+
+	if anomalies, _ := detector.DetectAnomalies("reviews", points); len(anomalies) == 0 {
+		t.Fatal("Expected anomalies for the spike window")
+	}
+
+	// Replace the spike with values in line with the baseline
+	changed := append([]timeseries.DataPoint(nil), points...)
+	for i := 10; i < len(changed); i++ {
+		changed[i].Value = 0.01
+	}
+
+	for _, a := range mustDetect(t, detector, changed) {
+		if a.Type == TrafficSpike {
+			t.Error("Expected the traffic spike to clear once the window changed")
+		}
+	}
+}
+
+func mustDetect(t *testing.T, detector *Detector, points []timeseries.DataPoint) []Anomaly {
+	t.Helper()
+	anomalies, err := detector.DetectAnomalies("reviews", points)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return anomalies
+}
+
+func BenchmarkDetector_DetectAnomalies_Cached(b *testing.B) {
+	points := spikePoints()
+	detector := newBaselineDetector(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		detector.DetectAnomalies("reviews", points)
+	}
+}
+
+func BenchmarkDetector_DetectAnomalies_Recomputed(b *testing.B) {
+	points := spikePoints()
+	detector := newBaselineDetector(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		delete(detector.memo, "reviews")
+		detector.DetectAnomalies("reviews", points)
+	}
+}
+
+func errorSignals(effective, upstream float64) Signals {
+	return Signals{
+		ErrorRateMetric:         constantPoints(effective, 3),
+		UpstreamErrorRateMetric: constantPoints(upstream, 3),
+	}
+}
+
+func countType(anomalies []Anomaly, anomalyType AnomalyType) int {
+	count := 0
+	for _, a := range anomalies {
+		if a.Type == anomalyType {
+			count++
+		}
+	}
+	return count
+}
+
+func TestDetector_DetectSignals_TargetsEffectiveErrorRate(t *testing.T) {
+	config := DetectionConfig{ErrorRateThreshold: 0.05, FeatureWindow: 3}
+	detector := NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 2}))
+
+	recovered, _ := detector.DetectSignals("recovered", errorSignals(0.01, 0.21))
+	if countType(recovered, ErrorRateHigh) != 0 {
+		t.Error("Expected no error anomaly when retries masked the upstream failures")
+	}
+
+	unrecovered, _ := detector.DetectSignals("unrecovered", errorSignals(0.21, 0.21))
+	if countType(unrecovered, ErrorRateHigh) != 1 {
+		t.Fatal("Expected an error anomaly for unrecovered failures")
+	}
+
+	metrics := unrecovered[0].Metrics
+	if metrics[ErrorRateMetric] != 0.21 || metrics[UpstreamErrorRateMetric] != 0.21 {
+		t.Errorf("Expected both error rates on the anomaly, got %v", metrics)
+	}
+}
+
+func TestDetector_DetectSignals_UpstreamErrorRateSource(t *testing.T) {
+	config := DetectionConfig{ErrorRateThreshold: 0.05, FeatureWindow: 3, ErrorRateSource: "upstream"}
+	detector := NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 2}))
+
+	anomalies, _ := detector.DetectSignals("recovered", errorSignals(0.01, 0.21))
+	if countType(anomalies, ErrorRateHigh) != 1 {
+		t.Error("Expected an error anomaly when targeting the upstream error rate")
+	}
+}
+
+func TestDetector_ConsecutiveBreaches_ErrorRate(t *testing.T) {
+	config := DetectionConfig{ErrorRateThreshold: 0.05, FeatureWindow: 3, ConsecutiveBreaches: 3}
+	detector := NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 2}))
+
+	transient, _ := detector.DetectSignals("flaky", Signals{ErrorRateMetric: latencyPoints(0.01, 0.01, 0.01, 0.01, 0.30)})
+	if countType(transient, ErrorRateHigh) != 0 {
+		t.Error("Expected no error anomaly for a single breaching point")
+	}
+
+	recovered, _ := detector.DetectSignals("recovered", Signals{ErrorRateMetric: latencyPoints(0.01, 0.30, 0.30, 0.01, 0.30)})
+	if countType(recovered, ErrorRateHigh) != 0 {
+		t.Error("Expected no error anomaly when the breach was interrupted")
+	}
+
+	sustained, _ := detector.DetectSignals("failing", Signals{ErrorRateMetric: latencyPoints(0.01, 0.01, 0.30, 0.25, 0.30)})
+	if countType(sustained, ErrorRateHigh) != 1 {
+		t.Error("Expected an error anomaly for three breaching points")
+	}
+}
+
+func TestDetector_MinRequestVolume_SuppressesLowVolume(t *testing.T) {
+	config := DetectionConfig{ErrorRateThreshold: 0.05, FeatureWindow: 3, MinRequestVolume: 20}
+	detector := NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 2}))
+
+	// 3 requests with one failure is a 33% error rate, however many the
+	// cumulative count held before the window
+	signals := errorSignals(0.33, 0.33)
+	signals[RequestCountMetric] = latencyPoints(5000, 5001, 5003)
+
+	anomalies, _ := detector.DetectSignals("quiet", signals)
+	if countType(anomalies, ErrorRateHigh) != 0 {
+		t.Error("Expected no error anomaly below the minimum request volume")
+	}
+}
+
+func TestDetector_MinRequestVolume_EmitsHighVolume(t *testing.T) {
+	config := DetectionConfig{ErrorRateThreshold: 0.05, FeatureWindow: 3, MinRequestVolume: 20}
+	detector := NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 2}))
+
+	signals := errorSignals(0.33, 0.33)
+	signals[RequestCountMetric] = latencyPoints(100, 150, 200)
+
+	anomalies, _ := detector.DetectSignals("busy", signals)
+	if countType(anomalies, ErrorRateHigh) != 1 {
+		t.Error("Expected an error anomaly above the minimum request volume")
+	}
+}
+
+func TestDetector_MinRequestVolume_StaticDetection(t *testing.T) {
+	config := DetectionConfig{TrafficSpikeThreshold: 2.0, ErrorRateThreshold: 0.05, FeatureWindow: 3, MinRequestVolume: 20}
+	detector := NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 2}))
+
+	if anomalies := detector.detectStaticAnomalies("quiet", latencyPoints(1, 2, 3)); countType(anomalies, ErrorRateHigh) != 0 {
+		t.Error("Expected no error anomaly below the minimum request volume")
+	}
+	if anomalies := detector.detectStaticAnomalies("busy", latencyPoints(10, 25, 40)); countType(anomalies, ErrorRateHigh) != 1 {
+		t.Error("Expected an error anomaly above the minimum request volume")
+	}
+}
+
+func TestDetector_DetectMLAnomalies_UsesDataTimestamp(t *testing.T) {
+	baselines := []ml.Cluster{clusterAround([]float64{10, 0, 0, 0}, 0.5, 5)}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := constantPoints(13, 5)
+	for i := range points {
+		points[i].Timestamp = start.Add(time.Duration(i) * time.Minute)
+	}
+
+	config := DetectionConfig{FeatureWindow: 3, SensitivityLevel: 2.0}
+	detector := NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 1}))
+
+	anomalies := detector.detectMLAnomalies("reviews", points, baselines)
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected 1 behavioral anomaly, got %d", len(anomalies))
+	}
+	if expected := points[len(points)-1].Timestamp; !anomalies[0].Timestamp.Equal(expected) {
+		t.Errorf("Expected anomaly timestamp %v, got %v", expected, anomalies[0].Timestamp)
+	}
+}
+
+func TestDetector_CalculateDynamicThreshold_ZeroVariance(t *testing.T) {
+	detector := NewDetector(DetectionConfig{SensitivityLevel: 2.0}, ml.NewClusteringEngine(ml.KMeansConfig{K: 1}))
+	flat := []ml.Cluster{clusterAround([]float64{10, 0, 0, 0}, 0, 5)}
+
+	threshold := detector.calculateDynamicThreshold(flat)
+	if threshold != defaultThresholdFloor {
+		t.Errorf("Expected the default floor %v for a zero-variance baseline, got %v", defaultThresholdFloor, threshold)
+	}
+
+	floored := NewDetector(DetectionConfig{SensitivityLevel: 2.0, ThresholdFloor: 0.5}, ml.NewClusteringEngine(ml.KMeansConfig{K: 1}))
+	if threshold := floored.calculateDynamicThreshold(flat); threshold != 0.5 {
+		t.Errorf("Expected the configured floor 0.5, got %v", threshold)
+	}
+}
+
+func TestDetector_ConstantBaseline_FiniteSeverity(t *testing.T) {
+	config := DetectionConfig{FeatureWindow: 3, SensitivityLevel: 2.0, ThresholdFloor: 0.5}
+	detector := NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 2, MaxIter: 10, Tolerance: 0.01}))
+	if err := detector.LearnBaseline("reviews", constantPoints(10, 12)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	baselines := detector.Baselines()["reviews"]
+
+	if anomalies := detector.detectMLAnomalies("reviews", constantPoints(10, 5), baselines); len(anomalies) != 0 {
+		t.Errorf("Expected no anomaly when traffic matches a flat baseline, got %d", len(anomalies))
+	}
+
+	deviating := append(constantPoints(10, 3), constantPoints(20, 2)...)
+	anomalies := detector.detectMLAnomalies("reviews", deviating, baselines)
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected 1 anomaly when traffic leaves a flat baseline, got %d", len(anomalies))
+	}
+	if severity := anomalies[0].Severity; math.IsInf(severity, 0) || math.IsNaN(severity) {
+		t.Errorf("Expected a finite severity, got %v", severity)
+	}
+}
+
+func TestDetector_ZeroErrorRateThreshold_FiniteSeverity(t *testing.T) {
+	detector := NewDetector(DetectionConfig{}, ml.NewClusteringEngine(ml.KMeansConfig{K: 1}))
+
+	anomalies := detector.detectErrorRateAnomalies("reviews", constantPoints(0.2, 3))
+	if len(anomalies) != 1 || math.IsInf(anomalies[0].Severity, 0) {
+		t.Errorf("Expected 1 anomaly with finite severity, got %v", anomalies)
+	}
+}
+
+// newBenchmarkStorage fills storage with services × n points of request
+// counts and error rates.
+func newBenchmarkStorage(services, n int) (*timeseries.Storage, []string) {
+	storage := timeseries.NewStorage()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	names := make([]string, services)
+	for s := range names {
+		names[s] = fmt.Sprintf("service-%d", s)
+		for i := 0; i < n; i++ {
+			ts := start.Add(time.Duration(i) * time.Minute)
+			storage.StoreAt(names[s], RequestCountMetric, 100+40*math.Sin(float64(i)/229), ts, nil)
+			storage.StoreAt(names[s], ErrorRateMetric, 0.01, ts, nil)
+		}
+	}
+	return storage, names
+}
+
+func newBenchmarkDetector(storage *timeseries.Storage, names []string) *Detector {
+	config := DetectionConfig{TrafficSpikeThreshold: 2.0, ErrorRateThreshold: 0.05, FeatureWindow: 10, SensitivityLevel: 2.0}
+	engine := ml.NewClusteringEngine(ml.KMeansConfig{K: 3, MaxIter: 100, Tolerance: 0.01})
+	detector := NewDetector(config, engine)
+	for _, name := range names {
+		detector.LearnBaseline(name, storage.GetLatestN(name, RequestCountMetric, 10000))
+	}
+	return detector
+}
+
+func BenchmarkDetector_DetectFromStorage_50Services(b *testing.B) {
+	storage, names := newBenchmarkStorage(50, 10000)
+	detector := newBenchmarkDetector(storage, names)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, name := range names {
+			delete(detector.memo, name)
+			detector.DetectFromStorage(storage, name)
+		}
+	}
+}
+
+func BenchmarkDetector_LearnBaseline_10k(b *testing.B) {
+	storage, names := newBenchmarkStorage(1, 10000)
+	points := storage.GetLatestN(names[0], RequestCountMetric, 10000)
+	detector := newBenchmarkDetector(storage, nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		detector.LearnBaseline(names[0], points)
+	}
+}
+
+func TestDetector_DetectFromStorage_AllocationsBounded(t *testing.T) {
+	storage, names := newBenchmarkStorage(1, 10000)
+	detector := newBenchmarkDetector(storage, names)
+
+	allocs := testing.AllocsPerRun(10, func() {
+		delete(detector.memo, names[0])
+		detector.DetectFromStorage(storage, names[0])
+	})
+	if allocs > 10 {
+		t.Errorf("Expected at most 10 allocations per detection, got %v", allocs)
+	}
+}
+
+func TestDetector_TrafficSpike_NeedsBaseline(t *testing.T) {
+	detector := NewDetector(DetectionConfig{TrafficSpikeThreshold: 2.0}, ml.NewClusteringEngine(ml.KMeansConfig{}))
+
+	// Three points are all "recent", leaving nothing to compare against
+	if detector.isTrafficSpike(spikePoints()[:trafficSpikeWindow]) {
+		t.Error("Expected no traffic spike without baseline points")
+	}
+	if !detector.isTrafficSpike(spikePoints()) {
+		t.Error("Expected a traffic spike once there is a baseline")
+	}
+}
@@ -0,0 +1,96 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// replicaDivergenceFactor is how many times its siblings' median a
+	// replica's signal must reach to be called an outlier
+	replicaDivergenceFactor = 3.0
+	// replicaErrorGapMin and replicaLatencyGapMin ignore divergence too
+	// small to matter, e.g. 0.3% errors against 0.1%
+	replicaErrorGapMin   = 0.05
+	replicaLatencyGapMin = 50.0
+	// replicaDivergenceMinPods is the fewest replicas with a meaningful
+	// sibling median to compare against
+	replicaDivergenceMinPods = 3
+)
+
+// PodLabel names the replica a replica divergence anomaly is about.
+const PodLabel = "pod"
+
+// DetectReplicaDivergence flags a replica whose error rate (a fraction) or
+// P99 latency (in milliseconds) is an outlier against the median of its
+// siblings, a stuck or corrupted pod the service aggregate would mask.
+// Both maps are keyed by pod name, as scraped at the given time.
+func DetectReplicaDivergence(serviceName string, at time.Time, errorRates, latencies map[string]float64) []Anomaly {
+	var anomalies []Anomaly
+	anomalies = append(anomalies, replicaOutliers(serviceName, at, "error_rate", errorRates, replicaErrorGapMin)...)
+	anomalies = append(anomalies, replicaOutliers(serviceName, at, "latency_p99", latencies, replicaLatencyGapMin)...)
+	return anomalies
+}
+
+func replicaOutliers(serviceName string, at time.Time, signal string, values map[string]float64, minGap float64) []Anomaly {
+	if len(values) < replicaDivergenceMinPods {
+		return nil
+	}
+
+	pods := make([]string, 0, len(values))
+	for pod := range values {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+
+	var anomalies []Anomaly
+	for _, pod := range pods {
+		siblings := make([]float64, 0, len(pods)-1)
+		for _, other := range pods {
+			if other != pod {
+				siblings = append(siblings, values[other])
+			}
+		}
+		median := medianOf(siblings)
+
+		limit := math.Max(median*replicaDivergenceFactor, median+minGap)
+		value := values[pod]
+		if value <= limit {
+			continue
+		}
+
+		anomalies = append(anomalies, Anomaly{
+			Type:        ReplicaDivergence,
+			ServiceName: serviceName,
+			Severity:    value / limit,
+			Description: fmt.Sprintf("Replica %s diverges: %s while %d siblings median %s", pod,
+				formatReplicaSignal(signal, value), len(siblings), formatReplicaSignal(signal, median)),
+			Timestamp: at,
+			Metrics: map[string]float64{
+				signal:               value,
+				signal + "_siblings": median,
+			},
+			Labels: map[string]string{PodLabel: pod, "signal": signal},
+		})
+	}
+	return anomalies
+}
+
+func formatReplicaSignal(signal string, value float64) string {
+	if signal == "error_rate" {
+		return fmt.Sprintf("%.1f%% errors", value*100)
+	}
+	return fmt.Sprintf("P99 %.0fms", value)
+}
+
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
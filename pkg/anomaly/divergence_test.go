@@ -0,0 +1,53 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetectReplicaDivergence_BadPod(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	errorRates := map[string]float64{"reviews-a": 0.001, "reviews-b": 0.002, "reviews-c": 0.30, "reviews-d": 0.0}
+	latencies := map[string]float64{"reviews-a": 40, "reviews-b": 45, "reviews-c": 42, "reviews-d": 38}
+
+	anomalies := DetectReplicaDivergence("shop/reviews", at, errorRates, latencies)
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected 1 divergence anomaly, got %+v", anomalies)
+	}
+
+	a := anomalies[0]
+	if a.Type != ReplicaDivergence || a.Labels[PodLabel] != "reviews-c" {
+		t.Errorf("Expected reviews-c to be named, got %s for %q", a.Type, a.Labels[PodLabel])
+	}
+	if a.Labels["signal"] != "error_rate" || a.Metrics["error_rate_siblings"] != 0.001 {
+		t.Errorf("Expected the error rate against a sibling median of 0.001, got %v %v", a.Labels, a.Metrics)
+	}
+	if !a.Timestamp.Equal(at) {
+		t.Errorf("Expected timestamp %v, got %v", at, a.Timestamp)
+	}
+	expected := "Replica reviews-c diverges: 30.0% errors while 3 siblings median 0.1% errors"
+	if a.Description != expected {
+		t.Errorf("Expected %q, got %q", expected, a.Description)
+	}
+}
+
+func TestDetectReplicaDivergence_SlowPod(t *testing.T) {
+	latencies := map[string]float64{"reviews-a": 40, "reviews-b": 900, "reviews-c": 42}
+
+	anomalies := DetectReplicaDivergence("reviews", time.Time{}, nil, latencies)
+	if len(anomalies) != 1 || anomalies[0].Labels[PodLabel] != "reviews-b" || anomalies[0].Labels["signal"] != "latency_p99" {
+		t.Errorf("Expected reviews-b flagged for latency, got %+v", anomalies)
+	}
+}
+
+func TestDetectReplicaDivergence_Consistent(t *testing.T) {
+	for name, tt := range map[string]struct{ errorRates, latencies map[string]float64 }{
+		"all clean":        {map[string]float64{"a": 0, "b": 0, "c": 0.01}, map[string]float64{"a": 40, "b": 50, "c": 80}},
+		"all failing":      {map[string]float64{"a": 0.3, "b": 0.32, "c": 0.28}, nil},
+		"too few replicas": {map[string]float64{"a": 0, "b": 0.9}, map[string]float64{"a": 10, "b": 2000}},
+	} {
+		if anomalies := DetectReplicaDivergence("reviews", time.Time{}, tt.errorRates, tt.latencies); len(anomalies) != 0 {
+			t.Errorf("%s: Expected no divergence, got %+v", name, anomalies)
+		}
+	}
+}
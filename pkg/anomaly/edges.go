@@ -0,0 +1,26 @@
+package anomaly
+
+import "fmt"
+
+const (
+	// EdgeSourceLabel and EdgeDestinationLabel name the failing call an
+	// error anomaly was attributed to
+	EdgeSourceLabel      = "edge_source"
+	EdgeDestinationLabel = "edge_destination"
+)
+
+// AttributeEdge records the source workload and destination service whose
+// requests failed the most, so "errors on reviews" reads as "reviews →
+// ratings errors". errorRate is a fraction.
+func (a *Anomaly) AttributeEdge(source, destination string, errorRate float64) {
+	if a.Labels == nil {
+		a.Labels = make(map[string]string)
+	}
+	if a.Metrics == nil {
+		a.Metrics = make(map[string]float64)
+	}
+	a.Labels[EdgeSourceLabel] = source
+	a.Labels[EdgeDestinationLabel] = destination
+	a.Metrics["edge_error_rate"] = errorRate
+	a.Description += fmt.Sprintf("; %s → %s errors at %.1f%%", source, destination, errorRate*100)
+}
@@ -0,0 +1,19 @@
+package anomaly
+
+import "testing"
+
+func TestAnomaly_AttributeEdge(t *testing.T) {
+	a := Anomaly{Type: ErrorRateHigh, ServiceName: "reviews", Description: "High error rate: 6.05%"}
+	a.AttributeEdge("reviews-v2", "ratings", 0.2)
+
+	expected := "High error rate: 6.05%; reviews-v2 → ratings errors at 20.0%"
+	if a.Description != expected {
+		t.Errorf("Expected %q, got %q", expected, a.Description)
+	}
+	if a.Labels[EdgeSourceLabel] != "reviews-v2" || a.Labels[EdgeDestinationLabel] != "ratings" {
+		t.Errorf("Expected the edge labels, got %v", a.Labels)
+	}
+	if a.Metrics["edge_error_rate"] != 0.2 {
+		t.Errorf("Expected edge error rate 0.2, got %f", a.Metrics["edge_error_rate"])
+	}
+}
@@ -0,0 +1,21 @@
+package anomaly
+
+import "smanalyzer/pkg/timeseries"
+
+// OccurrenceMetric names the stored series recording each time an anomaly
+// type was raised for a service, e.g. "anomaly_error_rate_high".
+func OccurrenceMetric(t AnomalyType) string {
+	return "anomaly_" + string(t)
+}
+
+// MarkFirstSeen flags the anomalies whose type has never been recorded for
+// the service in storage, then records them all, so a type is only new the
+// first time it appears. With a persisted data file that holds across scans.
+func MarkFirstSeen(storage *timeseries.Storage, seriesKey string, anomalies []Anomaly) {
+	for i := range anomalies {
+		anomalies[i].FirstSeen = len(storage.GetLatestN(seriesKey, OccurrenceMetric(anomalies[i].Type), 1)) == 0
+	}
+	for _, a := range anomalies {
+		storage.StoreAt(seriesKey, OccurrenceMetric(a.Type), a.Severity, a.Timestamp, nil)
+	}
+}
@@ -0,0 +1,49 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+func TestMarkFirstSeen(t *testing.T) {
+	storage := timeseries.NewStorage()
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	first := []Anomaly{{Type: ErrorRateHigh, Severity: 2, Timestamp: at}}
+	MarkFirstSeen(storage, "shop/reviews", first)
+	if !first[0].FirstSeen {
+		t.Error("Expected a brand-new anomaly flagged as first seen")
+	}
+
+	again := []Anomaly{
+		{Type: ErrorRateHigh, Severity: 2, Timestamp: at.Add(time.Minute)},
+		{Type: TrafficSpike, Severity: 3, Timestamp: at.Add(time.Minute)},
+	}
+	MarkFirstSeen(storage, "shop/reviews", again)
+	if again[0].FirstSeen {
+		t.Error("Expected an ongoing anomaly not flagged")
+	}
+	if !again[1].FirstSeen {
+		t.Error("Expected a new anomaly type flagged")
+	}
+
+	other := []Anomaly{{Type: ErrorRateHigh, Severity: 2, Timestamp: at}}
+	MarkFirstSeen(storage, "shop/ratings", other)
+	if !other[0].FirstSeen {
+		t.Error("Expected first occurrences tracked per service")
+	}
+}
+
+func TestMarkFirstSeen_SameTypeTwiceInOneScan(t *testing.T) {
+	storage := timeseries.NewStorage()
+	pods := []Anomaly{
+		{Type: ReplicaDivergence, Labels: map[string]string{PodLabel: "reviews-a"}},
+		{Type: ReplicaDivergence, Labels: map[string]string{PodLabel: "reviews-b"}},
+	}
+	MarkFirstSeen(storage, "shop/reviews", pods)
+	if !pods[0].FirstSeen || !pods[1].FirstSeen {
+		t.Error("Expected both anomalies of a type first raised together flagged")
+	}
+}
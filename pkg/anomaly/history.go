@@ -0,0 +1,31 @@
+package anomaly
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultHalfLife is how long it takes an anomaly's weight in the recent
+// trouble score to halve.
+const DefaultHalfLife = 24 * time.Hour
+
+// DecayWeight is the exponential weight of an observation of the given age:
+// 1 when fresh, 0.5 after one half-life, 0.25 after two. Future timestamps
+// count as fresh, and a non-positive half-life disables decay.
+func DecayWeight(age, halfLife time.Duration) float64 {
+	if halfLife <= 0 || age <= 0 {
+		return 1
+	}
+	return math.Exp2(-float64(age) / float64(halfLife))
+}
+
+// RecentTroubleScores sums the decayed severity of past anomalies per
+// service so fresh incidents outweigh old ones. Services are keyed like
+// scan series: "cluster/service" when a cluster is set.
+func RecentTroubleScores(anomalies []Anomaly, now time.Time, halfLife time.Duration) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, a := range anomalies {
+		scores[serviceKey(a)] += a.Severity * DecayWeight(now.Sub(a.Timestamp), halfLife)
+	}
+	return scores
+}
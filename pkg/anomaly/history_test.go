@@ -0,0 +1,46 @@
+package anomaly
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDecayWeight(t *testing.T) {
+	halfLife := time.Hour
+
+	if w := DecayWeight(0, halfLife); w != 1 {
+		t.Errorf("Expected fresh weight 1, got %f", w)
+	}
+	if w := DecayWeight(time.Hour, halfLife); math.Abs(w-0.5) > 1e-9 {
+		t.Errorf("Expected weight 0.5 after one half-life, got %f", w)
+	}
+	if w := DecayWeight(2*time.Hour, halfLife); math.Abs(w-0.25) > 1e-9 {
+		t.Errorf("Expected weight 0.25 after two half-lives, got %f", w)
+	}
+	if w := DecayWeight(time.Hour, 0); w != 1 {
+		t.Errorf("Expected no decay without a half-life, got %f", w)
+	}
+}
+
+func TestRecentTroubleScores_DecaysOlderAnomalies(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	anomalies := []Anomaly{
+		// Same severity, but reviews' incident was two days ago
+		{ServiceName: "reviews", Severity: 4, Timestamp: now.Add(-48 * time.Hour)},
+		{ServiceName: "ratings", Severity: 4, Timestamp: now.Add(-10 * time.Minute)},
+		{ServiceName: "ratings", Cluster: "west", Severity: 2, Timestamp: now},
+	}
+
+	scores := RecentTroubleScores(anomalies, now, DefaultHalfLife)
+
+	if math.Abs(scores["reviews"]-1) > 1e-9 {
+		t.Errorf("Expected reviews score 1 after two half-lives, got %f", scores["reviews"])
+	}
+	if scores["ratings"] <= scores["reviews"] {
+		t.Errorf("Expected recent ratings %f to outweigh old reviews %f", scores["ratings"], scores["reviews"])
+	}
+	if scores["west/ratings"] != 2 {
+		t.Errorf("Expected west/ratings score 2, got %f", scores["west/ratings"])
+	}
+}
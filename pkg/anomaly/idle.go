@@ -0,0 +1,83 @@
+package anomaly
+
+import (
+	"fmt"
+	"time"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+// Idleness is whether a service has been serving traffic recently.
+type Idleness int
+
+const (
+	Busy Idleness = iota
+	// AlwaysIdle services served no requests in any scan considered
+	AlwaysIdle
+	// NewlyIdle services serve none now but did in an earlier scan
+	NewlyIdle
+)
+
+// ClassifyIdle reads a service's stored cumulative request counts, oldest
+// first, the latest being the current scan, and classifies it by the
+// requests served between consecutive scans. A lone scan is idle only when
+// its proxy never counted a request; no history counts as busy.
+func ClassifyIdle(counts []timeseries.DataPoint) Idleness {
+	switch len(counts) {
+	case 0:
+		return Busy
+	case 1:
+		if counts[0].Value > 0 {
+			return Busy
+		}
+		return AlwaysIdle
+	}
+
+	if _, ok := lastBusy(counts[len(counts)-2:]); ok {
+		return Busy
+	}
+	if _, ok := lastBusy(counts); ok {
+		return NewlyIdle
+	}
+	return AlwaysIdle
+}
+
+// lastBusy returns the index of the latest point whose count grew since the
+// one before it.
+func lastBusy(counts []timeseries.DataPoint) (int, bool) {
+	for i := len(counts) - 1; i > 0; i-- {
+		if counterIncrease(counts[i-1].Value, counts[i].Value) > 0 {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// DetectWentIdle raises a WentIdle anomaly for a service whose traffic
+// stopped: its request count grew between earlier scans in counts but not
+// since the previous one. A service that was never busy isn't one to raise.
+func DetectWentIdle(serviceName string, counts []timeseries.DataPoint) (Anomaly, bool) {
+	if ClassifyIdle(counts) != NewlyIdle {
+		return Anomaly{}, false
+	}
+
+	i, _ := lastBusy(counts)
+	latest, busy := counts[len(counts)-1], counts[i]
+	var rps float64
+	if interval := busy.Timestamp.Sub(counts[i-1].Timestamp).Seconds(); interval > 0 {
+		rps = counterIncrease(counts[i-1].Value, busy.Value) / interval
+	}
+	idleFor := latest.Timestamp.Sub(busy.Timestamp)
+
+	return Anomaly{
+		Type:        WentIdle,
+		ServiceName: serviceName,
+		Severity:    1.5,
+		Description: fmt.Sprintf("Traffic stopped: no requests for %v, after %.1f RPS", idleFor.Round(time.Second), rps),
+		Timestamp:   latest.Timestamp,
+		Metrics: map[string]float64{
+			"last_rps":         rps,
+			"idle_for_seconds": idleFor.Seconds(),
+		},
+	}, true
+}
@@ -0,0 +1,52 @@
+package anomaly
+
+import (
+	"testing"
+
+	"smanalyzer/pkg/telemetry"
+	"smanalyzer/pkg/timeseries"
+)
+
+func TestClassifyIdle(t *testing.T) {
+	storage := timeseries.NewStorage()
+	storeSeries(storage, "dormant", telemetry.RequestCount, 0, 0, 0)
+	// A counter left flat still holds every request served before
+	storeSeries(storage, "drained", telemetry.RequestCount, 720, 1560, 1560, 1560)
+	storeSeries(storage, "stale", telemetry.RequestCount, 900, 900, 900)
+	storeSeries(storage, "busy", telemetry.RequestCount, 0, 0, 180)
+	// A restarted proxy counting again is busy, not idle
+	storeSeries(storage, "restarted", telemetry.RequestCount, 900, 1500, 60)
+	storeSeries(storage, "single", telemetry.RequestCount, 42)
+
+	for service, want := range map[string]Idleness{
+		"dormant":   AlwaysIdle,
+		"drained":   NewlyIdle,
+		"stale":     AlwaysIdle,
+		"busy":      Busy,
+		"restarted": Busy,
+		"single":    Busy,
+		"unknown":   Busy,
+	} {
+		if got := ClassifyIdle(storage.GetLatestN(service, telemetry.RequestCount, DefaultLookback)); got != want {
+			t.Errorf("Expected %s classified %d, got %d", service, want, got)
+		}
+	}
+}
+
+func TestDetectWentIdle(t *testing.T) {
+	storage := timeseries.NewStorage()
+	storeSeries(storage, "drained", telemetry.RequestCount, 720, 1560, 1560, 1560)
+	storeSeries(storage, "dormant", telemetry.RequestCount, 0, 0, 0)
+
+	a, ok := DetectWentIdle("drained", storage.GetLatestN("drained", telemetry.RequestCount, DefaultLookback))
+	if !ok {
+		t.Fatal("Expected the drained service raised as went idle")
+	}
+	if a.Type != WentIdle || a.Metrics["last_rps"] != 14 || a.Metrics["idle_for_seconds"] != 120 {
+		t.Errorf("Expected idle for 2 minutes after 14 RPS, got %+v", a)
+	}
+
+	if _, ok := DetectWentIdle("dormant", storage.GetLatestN("dormant", telemetry.RequestCount, DefaultLookback)); ok {
+		t.Error("Expected no anomaly for a service that was never busy")
+	}
+}
@@ -0,0 +1,177 @@
+package anomaly
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+// p50FlatTolerance is how far P50 may rise over its earlier mean and still
+// count as flat when checking for a P99 spike.
+const p50FlatTolerance = 0.2
+
+// detectTailLatencyAnomalies flags latency problems that only affect a
+// subset of requests: P99 far above P50 (tail amplification), or P99
+// spiking while P50 holds steady. Both percentiles are in milliseconds and
+// are expected to be sampled together.
+func (d *Detector) detectTailLatencyAnomalies(serviceName string, p50, p99 []timeseries.DataPoint) []Anomaly {
+	var anomalies []Anomaly
+
+	if len(p50) == 0 || len(p99) == 0 {
+		return anomalies
+	}
+
+	latestP50 := p50[len(p50)-1]
+	latestP99 := p99[len(p99)-1]
+	metrics := map[string]float64{
+		LatencyP50Metric: latestP50.Value,
+		LatencyP99Metric: latestP99.Value,
+	}
+
+	if factor := d.config.TailLatencyFactor; factor > 0 && latestP50.Value > 0 {
+		ratio := latestP99.Value / latestP50.Value
+		if d.tailAmplified(p50, p99, factor) {
+			metrics["tail_ratio"] = ratio
+			anomalies = append(anomalies, Anomaly{
+				Type:        TailLatency,
+				ServiceName: serviceName,
+				Severity:    ratio / factor,
+				Description: fmt.Sprintf("Tail latency amplification: P99 %.0fms is %.1fx P50 %.0fms", latestP99.Value, ratio, latestP50.Value),
+				Timestamp:   latestP99.Timestamp,
+				Metrics:     metrics,
+			})
+			return anomalies
+		}
+	}
+
+	if threshold := d.config.TailSpikeThreshold; threshold > 0 && len(p99) > 3 && len(p50) > 3 {
+		baselineP99 := d.calculateMean(p99[:len(p99)-3])
+		recentP99 := d.calculateMean(p99[len(p99)-3:])
+		baselineP50 := d.calculateMean(p50[:len(p50)-3])
+		recentP50 := d.calculateMean(p50[len(p50)-3:])
+
+		p50Flat := recentP50 <= baselineP50*(1+p50FlatTolerance)
+		if baselineP99 > 0 && recentP99 > baselineP99*threshold && p50Flat {
+			spike := recentP99 / baselineP99
+			metrics["p99_spike"] = spike
+			anomalies = append(anomalies, Anomaly{
+				Type:        TailLatency,
+				ServiceName: serviceName,
+				Severity:    spike / threshold,
+				Description: fmt.Sprintf("Tail latency spike: P99 up %.1fx to %.0fms while P50 holds at %.0fms", spike, latestP99.Value, latestP50.Value),
+				Timestamp:   latestP99.Timestamp,
+				Metrics:     metrics,
+			})
+		}
+	}
+
+	return anomalies
+}
+
+// tailAmplified reports whether P99 is more than factor times P50 in each
+// of the last ConsecutiveBreaches samples, pairing the series from the end.
+func (d *Detector) tailAmplified(p50, p99 []timeseries.DataPoint, factor float64) bool {
+	n := max(1, d.config.ConsecutiveBreaches)
+	if len(p50) < n || len(p99) < n {
+		return false
+	}
+	for i := 1; i <= n; i++ {
+		median, tail := p50[len(p50)-i].Value, p99[len(p99)-i].Value
+		if median <= 0 || tail/median <= factor {
+			return false
+		}
+	}
+	return true
+}
+
+// latencyTarget is the P99 SLO a service is held to: its own override, or
+// LatencyThreshold for services without one.
+func (d *Detector) latencyTarget(serviceName string) time.Duration {
+	if override, exists := d.serviceOverride(serviceName); exists && override.LatencyTarget > 0 {
+		return override.LatencyTarget
+	}
+	return d.config.LatencyThreshold
+}
+
+// detectLatencySLOAnomalies flags a latest P99 (in milliseconds) above the
+// service's latency target, held for ConsecutiveBreaches points, with
+// severity as the multiple of the target.
+func (d *Detector) detectLatencySLOAnomalies(serviceName string, p99 []timeseries.DataPoint) []Anomaly {
+	target := d.latencyTarget(serviceName)
+	if !d.config.LatencySLO || target <= 0 || len(p99) == 0 {
+		return nil
+	}
+
+	latest := p99[len(p99)-1]
+	targetMs := float64(target) / float64(time.Millisecond)
+	if !d.breached(p99, func(ms float64) bool { return ms > targetMs }) {
+		return nil
+	}
+
+	return []Anomaly{{
+		Type:        LatencyAnomaly,
+		ServiceName: serviceName,
+		Severity:    latest.Value / targetMs,
+		Description: fmt.Sprintf("P99 latency %.0fms exceeds the %v SLO by %.1fx", latest.Value, target, latest.Value/targetMs),
+		Timestamp:   latest.Timestamp,
+		Metrics: map[string]float64{
+			LatencyP99Metric: latest.Value,
+			"latency_slo_ms": targetMs,
+		},
+	}}
+}
+
+// percentileInversionSeverity ranks inverted percentiles as MEDIUM: the
+// service may be fine, but its latency figures can't be trusted.
+const percentileInversionSeverity = 1.5
+
+// detectPercentileInversion flags latest latency percentiles (in
+// milliseconds) out of order, e.g. P90 below P50. No histogram produces
+// that, so it points at a parsing problem or a misbehaving proxy rather
+// than slow requests, and the mean derived from the percentiles is
+// meaningless. Percentiles reading zero weren't reported and are skipped.
+func detectPercentileInversion(serviceName string, signals Signals) []Anomaly {
+	type percentile struct {
+		name   string
+		metric string
+		latest timeseries.DataPoint
+	}
+	var reported []percentile
+	for _, p := range []percentile{
+		{name: "P50", metric: LatencyP50Metric},
+		{name: "P90", metric: LatencyP90Metric},
+		{name: "P95", metric: LatencyP95Metric},
+		{name: "P99", metric: LatencyP99Metric},
+	} {
+		points := signals[p.metric]
+		if len(points) == 0 || points[len(points)-1].Value <= 0 {
+			continue
+		}
+		p.latest = points[len(points)-1]
+		reported = append(reported, p)
+	}
+
+	var inversions []string
+	metrics := make(map[string]float64, len(reported))
+	for i, p := range reported {
+		metrics[p.metric] = p.latest.Value
+		if i > 0 && reported[i-1].latest.Value > p.latest.Value {
+			lower := reported[i-1]
+			inversions = append(inversions, fmt.Sprintf("%s %.0fms is below %s %.0fms", p.name, p.latest.Value, lower.name, lower.latest.Value))
+		}
+	}
+	if len(inversions) == 0 {
+		return nil
+	}
+
+	return []Anomaly{{
+		Type:        PercentileInversion,
+		ServiceName: serviceName,
+		Severity:    percentileInversionSeverity,
+		Description: fmt.Sprintf("Latency percentiles out of order (%s); the latency data is unreliable", strings.Join(inversions, ", ")),
+		Timestamp:   reported[len(reported)-1].latest.Timestamp,
+		Metrics:     metrics,
+	}}
+}
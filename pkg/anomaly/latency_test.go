@@ -0,0 +1,201 @@
+package anomaly
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/ml"
+	"smanalyzer/pkg/timeseries"
+)
+
+func latencyPoints(values ...float64) []timeseries.DataPoint {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := make([]timeseries.DataPoint, len(values))
+	for i, v := range values {
+		points[i] = timeseries.DataPoint{Timestamp: start.Add(time.Duration(i) * time.Minute), Value: v}
+	}
+	return points
+}
+
+func newLatencyDetector(factor, spike float64) *Detector {
+	config := DetectionConfig{FeatureWindow: 3, TailLatencyFactor: factor, TailSpikeThreshold: spike}
+	return NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 2}))
+}
+
+func TestDetector_TailLatency_FlatP50SpikingP99(t *testing.T) {
+	detector := newLatencyDetector(0, 2.0)
+	p50 := latencyPoints(20, 21, 20, 19, 20, 21, 20, 20)
+	p99 := latencyPoints(60, 62, 58, 61, 60, 180, 200, 220)
+
+	anomalies, _ := detector.DetectSignals("reviews", Signals{LatencyP50Metric: p50, LatencyP99Metric: p99})
+	if countType(anomalies, TailLatency) != 1 {
+		t.Fatalf("Expected a tail latency anomaly, got %d", countType(anomalies, TailLatency))
+	}
+
+	tail := anomalies[0]
+	if tail.Metrics[LatencyP50Metric] != 20 || tail.Metrics[LatencyP99Metric] != 220 {
+		t.Errorf("Expected both percentiles on the anomaly, got %v", tail.Metrics)
+	}
+	if !tail.Timestamp.Equal(p99[len(p99)-1].Timestamp) {
+		t.Errorf("Expected the latest P99 timestamp, got %v", tail.Timestamp)
+	}
+}
+
+func TestDetector_TailLatency_UniformSlowdownIgnored(t *testing.T) {
+	detector := newLatencyDetector(0, 2.0)
+	p50 := latencyPoints(20, 21, 20, 19, 20, 60, 62, 64)
+	p99 := latencyPoints(60, 62, 58, 61, 60, 180, 200, 220)
+
+	anomalies, _ := detector.DetectSignals("reviews", Signals{LatencyP50Metric: p50, LatencyP99Metric: p99})
+	if countType(anomalies, TailLatency) != 0 {
+		t.Error("Expected no tail anomaly when P50 rises with P99")
+	}
+}
+
+func TestDetector_TailLatency_Amplification(t *testing.T) {
+	detector := newLatencyDetector(10, 0)
+
+	anomalies, _ := detector.DetectSignals("reviews", Signals{
+		LatencyP50Metric: latencyPoints(10),
+		LatencyP99Metric: latencyPoints(250),
+	})
+	if countType(anomalies, TailLatency) != 1 {
+		t.Fatal("Expected a tail amplification anomaly")
+	}
+	if anomalies[0].Metrics["tail_ratio"] != 25 || anomalies[0].Severity != 2.5 {
+		t.Errorf("Expected ratio 25 and severity 2.5, got %v/%v", anomalies[0].Metrics["tail_ratio"], anomalies[0].Severity)
+	}
+
+	healthy, _ := detector.DetectSignals("ratings", Signals{
+		LatencyP50Metric: latencyPoints(10),
+		LatencyP99Metric: latencyPoints(50),
+	})
+	if countType(healthy, TailLatency) != 0 {
+		t.Error("Expected no anomaly below the tail factor")
+	}
+}
+
+func TestDetector_ConsecutiveBreaches_Latency(t *testing.T) {
+	detector := newSLODetector()
+	detector.config.ConsecutiveBreaches = 2
+	detector.config.TailLatencyFactor = 10
+
+	transient, _ := detector.DetectSignals("shop/ratings", Signals{
+		LatencyP50Metric: latencyPoints(100, 100, 100),
+		LatencyP99Metric: latencyPoints(300, 300, 1500),
+	})
+	if countType(transient, LatencyAnomaly) != 0 || countType(transient, TailLatency) != 0 {
+		t.Errorf("Expected no latency anomalies for a single slow point, got %v", transient)
+	}
+
+	sustained, _ := detector.DetectSignals("shop/ratings", Signals{
+		LatencyP50Metric: latencyPoints(100, 100, 100),
+		LatencyP99Metric: latencyPoints(300, 1200, 1500),
+	})
+	if countType(sustained, LatencyAnomaly) != 1 || countType(sustained, TailLatency) != 1 {
+		t.Errorf("Expected SLO and tail anomalies for two slow points, got %v", sustained)
+	}
+}
+
+func newSLODetector() *Detector {
+	config := DetectionConfig{
+		FeatureWindow:    3,
+		LatencyThreshold: time.Second,
+		LatencySLO:       true,
+		ServiceOverrides: map[string]ServiceOverride{
+			"cache":        {LatencyTarget: 10 * time.Millisecond},
+			"shop/reports": {LatencyTarget: 2 * time.Second},
+		},
+	}
+	return NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 2}))
+}
+
+func TestDetector_LatencySLO_RelativeToEachService(t *testing.T) {
+	detector := newSLODetector()
+	p99 := latencyPoints(50)
+
+	cache, _ := detector.DetectSignals("east/shop/cache", Signals{LatencyP99Metric: p99})
+	if countType(cache, LatencyAnomaly) != 1 {
+		t.Fatalf("Expected 50ms to breach the 10ms cache SLO, got %v", cache)
+	}
+	if cache[0].Severity != 5.0 || cache[0].Metrics["latency_slo_ms"] != 10 {
+		t.Errorf("Expected severity 5 against a 10ms SLO, got %f against %v", cache[0].Severity, cache[0].Metrics["latency_slo_ms"])
+	}
+
+	reports, _ := detector.DetectSignals("shop/reports", Signals{LatencyP99Metric: p99})
+	if countType(reports, LatencyAnomaly) != 0 {
+		t.Errorf("Expected 50ms to be fine for the 2s reports SLO, got %v", reports)
+	}
+}
+
+func TestDetector_LatencySLO_FallsBackToLatencyThreshold(t *testing.T) {
+	detector := newSLODetector()
+
+	anomalies, _ := detector.DetectSignals("shop/ratings", Signals{LatencyP99Metric: latencyPoints(50, 1500)})
+	if countType(anomalies, LatencyAnomaly) != 1 {
+		t.Errorf("Expected 1.5s to breach the 1s default, got %v", anomalies)
+	}
+
+	// The override key is namespaced, so reports elsewhere uses the default
+	anomalies, _ = detector.DetectSignals("staging/reports", Signals{LatencyP99Metric: latencyPoints(1500)})
+	if countType(anomalies, LatencyAnomaly) != 1 {
+		t.Errorf("Expected staging/reports held to the 1s default, got %v", anomalies)
+	}
+}
+
+func TestDetector_LatencySLO_Disabled(t *testing.T) {
+	detector := newSLODetector()
+	detector.config.LatencySLO = false
+
+	anomalies, _ := detector.DetectSignals("cache", Signals{LatencyP99Metric: latencyPoints(50)})
+	if countType(anomalies, LatencyAnomaly) != 0 {
+		t.Errorf("Expected no SLO anomalies when disabled, got %v", anomalies)
+	}
+}
+
+func percentileSignals(p50, p90, p95, p99 float64) Signals {
+	return Signals{
+		LatencyP50Metric: latencyPoints(20, p50),
+		LatencyP90Metric: latencyPoints(40, p90),
+		LatencyP95Metric: latencyPoints(50, p95),
+		LatencyP99Metric: latencyPoints(80, p99),
+	}
+}
+
+func TestDetector_PercentileInversion(t *testing.T) {
+	detector := newLatencyDetector(0, 0)
+
+	anomalies, _ := detector.DetectSignals("reviews", percentileSignals(120, 45, 150, 200))
+	if countType(anomalies, PercentileInversion) != 1 {
+		t.Fatalf("Expected a percentile inversion anomaly, got %v", anomalies)
+	}
+	inverted := anomalies[0]
+	if !strings.Contains(inverted.Description, "P90 45ms is below P50 120ms") {
+		t.Errorf("Expected the inverted pair in the description, got %q", inverted.Description)
+	}
+	if inverted.Severity != percentileInversionSeverity || inverted.Metrics[LatencyP90Metric] != 45 {
+		t.Errorf("Expected MEDIUM severity with the percentiles attached, got %v and %v", inverted.Severity, inverted.Metrics)
+	}
+
+	// Both P95 and P99 fall below P90
+	anomalies, _ = detector.DetectSignals("ratings", percentileSignals(20, 300, 100, 90))
+	if countType(anomalies, PercentileInversion) != 1 || strings.Count(anomalies[0].Description, "is below") != 2 {
+		t.Errorf("Expected one anomaly naming both inversions, got %v", anomalies)
+	}
+}
+
+func TestDetector_PercentileInversion_OrderedOrUnreported(t *testing.T) {
+	detector := newLatencyDetector(0, 0)
+
+	ordered, _ := detector.DetectSignals("reviews", percentileSignals(20, 40, 40, 80))
+	if countType(ordered, PercentileInversion) != 0 {
+		t.Errorf("Expected no anomaly for ordered percentiles, got %v", ordered)
+	}
+
+	// A proxy reporting only P50 and P99 reads zero for the others
+	partial, _ := detector.DetectSignals("reviews", percentileSignals(20, 0, 0, 80))
+	if countType(partial, PercentileInversion) != 0 {
+		t.Errorf("Expected unreported percentiles to be skipped, got %v", partial)
+	}
+}
@@ -0,0 +1,71 @@
+package anomaly
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sort"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+// detectionMemo remembers the result of the last detection for a service so
+// an unchanged window doesn't re-extract features and re-scan every cluster.
+type detectionMemo struct {
+	windowHash uint64
+	anomalies  []Anomaly
+}
+
+// hashWindow fingerprints a window by the timestamps and values of its points.
+func hashWindow(points []timeseries.DataPoint) uint64 {
+	h := fnv.New64a()
+	var buf [16]byte
+	for _, p := range points {
+		binary.LittleEndian.PutUint64(buf[:8], uint64(p.Timestamp.UnixNano()))
+		binary.LittleEndian.PutUint64(buf[8:], math.Float64bits(p.Value))
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// hashSignals fingerprints every series in a fixed metric order.
+func hashSignals(signals Signals) uint64 {
+	metrics := make([]string, 0, len(signals))
+	for metric := range signals {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, metric := range metrics {
+		h.Write([]byte(metric))
+		binary.LittleEndian.PutUint64(buf[:], hashWindow(signals[metric]))
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+func (d *Detector) memoized(serviceName string, windowHash uint64) ([]Anomaly, bool) {
+	memo, exists := d.memo[serviceName]
+	if !exists || memo.windowHash != windowHash {
+		return nil, false
+	}
+	return copyAnomalies(memo.anomalies), true
+}
+
+func (d *Detector) remember(serviceName string, windowHash uint64, anomalies []Anomaly) {
+	d.memo[serviceName] = detectionMemo{
+		windowHash: windowHash,
+		anomalies:  copyAnomalies(anomalies),
+	}
+}
+
+func copyAnomalies(anomalies []Anomaly) []Anomaly {
+	if anomalies == nil {
+		return nil
+	}
+	copied := make([]Anomaly, len(anomalies))
+	copy(copied, anomalies)
+	return copied
+}
@@ -0,0 +1,40 @@
+package anomaly
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ServiceOverride holds detection settings for one service that replace the
+// mesh-wide defaults.
+type ServiceOverride struct {
+	// LatencyTarget is the service's P99 latency SLO, used instead of
+	// LatencyThreshold when latency SLOs are evaluated
+	LatencyTarget time.Duration `yaml:"latency_target" json:"latency_target"`
+}
+
+// Validate reports settings that could never be met.
+func (o ServiceOverride) Validate(service string) error {
+	if o.LatencyTarget < 0 {
+		return fmt.Errorf("service %s has a negative latency target", service)
+	}
+	return nil
+}
+
+// serviceOverride finds the override for a series key such as
+// cluster/namespace/service. The most specific configured key wins, so
+// "shop/reviews" beats a bare "reviews".
+func (d *Detector) serviceOverride(serviceName string) (ServiceOverride, bool) {
+	key := serviceName
+	for {
+		if override, exists := d.config.ServiceOverrides[key]; exists {
+			return override, true
+		}
+		i := strings.Index(key, "/")
+		if i < 0 {
+			return ServiceOverride{}, false
+		}
+		key = key[i+1:]
+	}
+}
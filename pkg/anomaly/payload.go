@@ -0,0 +1,89 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+// minPayloadBaselinePoints is how many earlier intervals with requests the
+// size baseline needs before a payload size change is flagged.
+const minPayloadBaselinePoints = 2
+
+// detectPayloadSizeAnomalies flags the mean request or response size
+// since the previous scan moving PayloadSizeFactor times away from the mean
+// of its earlier points, in either direction: a jump usually means an endpoint returning far more
+// data than it should or a cache serving uncompressed payloads, and a drop
+// truncated responses. Services without size histograms report zero and
+// are skipped, as are the intervals without requests, which have no mean
+// size and would drag the baseline towards zero.
+func (d *Detector) detectPayloadSizeAnomalies(serviceName string, signals Signals) []Anomaly {
+	factor := d.config.PayloadSizeFactor
+	if factor <= 1 {
+		return nil
+	}
+
+	var anomalies []Anomaly
+	for _, metric := range []struct {
+		name, label string
+	}{
+		{RequestSizeMetric, "request"},
+		{ResponseSizeMetric, "response"},
+	} {
+		points := signals[metric.name]
+		if len(points) < 2 {
+			continue
+		}
+
+		latest := points[len(points)-1]
+		var sized []timeseries.DataPoint
+		for _, p := range points[:len(points)-1] {
+			if p.Value > 0 {
+				sized = append(sized, p)
+			}
+		}
+		if latest.Value <= 0 || len(sized) < minPayloadBaselinePoints {
+			continue
+		}
+		baseline := d.calculateMean(sized)
+		ratio := latest.Value / baseline
+		shift := math.Max(ratio, 1/ratio)
+		if shift < factor {
+			continue
+		}
+
+		direction := "grew"
+		if ratio < 1 {
+			direction = "shrank"
+		}
+		anomalies = append(anomalies, Anomaly{
+			Type:        PayloadSizeAnomaly,
+			ServiceName: serviceName,
+			Severity:    shift / factor,
+			Description: fmt.Sprintf("Mean %s size %s to %s from a baseline of %s (%.1fx)",
+				metric.label, direction, formatBytes(latest.Value), formatBytes(baseline), ratio),
+			Timestamp: latest.Timestamp,
+			Metrics: map[string]float64{
+				metric.name:               latest.Value,
+				metric.name + "_baseline": baseline,
+			},
+			Labels: map[string]string{"payload": metric.label},
+		})
+	}
+	return anomalies
+}
+
+// formatBytes renders a size with a binary unit, e.g. 1.5KiB.
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	unit := 0
+	for bytes >= 1024 && unit < len(units)-1 {
+		bytes /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%.0f%s", bytes, units[unit])
+	}
+	return fmt.Sprintf("%.1f%s", bytes, units[unit])
+}
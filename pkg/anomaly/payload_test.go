@@ -0,0 +1,86 @@
+package anomaly
+
+import "testing"
+
+func TestDetector_DetectPayloadSizeAnomalies(t *testing.T) {
+	detector := NewDetector(DetectionConfig{PayloadSizeFactor: 3}, nil)
+
+	// Responses jump from about 2KiB to 64KiB; requests stay flat
+	anomalies, _ := detector.DetectSignals("reviews", Signals{
+		RequestSizeMetric:  latencyPoints(512, 500, 520, 510),
+		ResponseSizeMetric: latencyPoints(2048, 2000, 2096, 65536),
+	})
+	if countType(anomalies, PayloadSizeAnomaly) != 1 {
+		t.Fatalf("Expected one payload size anomaly, got %+v", anomalies)
+	}
+
+	a := anomalies[0]
+	if a.Labels["payload"] != "response" {
+		t.Errorf("Expected the response size flagged, got %v", a.Labels)
+	}
+	if a.Metrics[ResponseSizeMetric] != 65536 || a.Metrics[ResponseSizeMetric+"_baseline"] != 2048 {
+		t.Errorf("Expected 65536 bytes observed against a 2048 byte baseline, got %v", a.Metrics)
+	}
+	if a.Severity != 32.0/3 {
+		t.Errorf("Expected severity %f, got %f", 32.0/3, a.Severity)
+	}
+	expected := "Mean response size grew to 64.0KiB from a baseline of 2.0KiB (32.0x)"
+	if a.Description != expected {
+		t.Errorf("Expected %q, got %q", expected, a.Description)
+	}
+}
+
+func TestDetector_DetectPayloadSizeAnomalies_Shrink(t *testing.T) {
+	detector := NewDetector(DetectionConfig{PayloadSizeFactor: 3}, nil)
+
+	anomalies := detector.detectPayloadSizeAnomalies("reviews", Signals{
+		ResponseSizeMetric: latencyPoints(4096, 4096, 2048),
+	})
+	if len(anomalies) != 0 {
+		t.Errorf("Expected a 2x drop under the factor to be ignored, got %+v", anomalies)
+	}
+
+	anomalies = detector.detectPayloadSizeAnomalies("reviews", Signals{
+		ResponseSizeMetric: latencyPoints(4096, 4096, 512),
+	})
+	if len(anomalies) != 1 || anomalies[0].Description != "Mean response size shrank to 512B from a baseline of 4.0KiB (0.1x)" {
+		t.Errorf("Expected an 8x drop flagged, got %+v", anomalies)
+	}
+}
+
+func TestDetector_DetectPayloadSizeAnomalies_NoSizes(t *testing.T) {
+	detector := NewDetector(DetectionConfig{PayloadSizeFactor: 3}, nil)
+
+	anomalies := detector.detectPayloadSizeAnomalies("reviews", Signals{
+		RequestSizeMetric:  latencyPoints(0, 0, 0),
+		ResponseSizeMetric: latencyPoints(0, 0, 8192),
+	})
+	if len(anomalies) != 0 {
+		t.Errorf("Expected no anomalies without a size baseline, got %+v", anomalies)
+	}
+
+	disabled := NewDetector(DetectionConfig{}, nil)
+	if anomalies := disabled.detectPayloadSizeAnomalies("reviews", Signals{ResponseSizeMetric: latencyPoints(10, 10000)}); len(anomalies) != 0 {
+		t.Errorf("Expected a zero factor to disable detection, got %+v", anomalies)
+	}
+}
+
+func TestDetector_DetectPayloadSizeAnomalies_IdleIntervals(t *testing.T) {
+	detector := NewDetector(DetectionConfig{PayloadSizeFactor: 3}, nil)
+
+	// Intervals without requests read zero between the normal 2KiB ones
+	anomalies := detector.detectPayloadSizeAnomalies("reviews", Signals{
+		ResponseSizeMetric: latencyPoints(2048, 0, 0, 2048, 0, 0, 0, 2048),
+	})
+	if len(anomalies) != 0 {
+		t.Errorf("Expected idle intervals left out of the baseline, got %+v", anomalies)
+	}
+
+	// One sized interval among the idle ones is too little to compare with
+	anomalies = detector.detectPayloadSizeAnomalies("reviews", Signals{
+		ResponseSizeMetric: latencyPoints(0, 2048, 0, 0, 65536),
+	})
+	if len(anomalies) != 0 {
+		t.Errorf("Expected no anomaly with a single sized interval, got %+v", anomalies)
+	}
+}
@@ -0,0 +1,94 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+// defaultPercentChangeWindow is how many earlier points a percent change
+// rule averages when its window is unset.
+const defaultPercentChangeWindow = 10
+
+// PercentChangeRule flags a stored metric whose latest value moved more
+// than Threshold percent, up or down, from the mean of the Window points
+// before it. It applies to any series, complementing the typed detectors.
+type PercentChangeRule struct {
+	Metric    string  `yaml:"metric" json:"metric"`
+	Threshold float64 `yaml:"threshold" json:"threshold"`
+	Window    int     `yaml:"window" json:"window"`
+}
+
+// Validate reports rules that could never fire.
+func (r PercentChangeRule) Validate() error {
+	if r.Metric == "" {
+		return fmt.Errorf("percent change rule needs a metric")
+	}
+	if r.Threshold <= 0 {
+		return fmt.Errorf("percent change rule for %s needs a positive threshold", r.Metric)
+	}
+	if r.Window < 0 {
+		return fmt.Errorf("percent change rule for %s has a negative window", r.Metric)
+	}
+	return nil
+}
+
+func (r PercentChangeRule) window() int {
+	if r.Window <= 0 {
+		return defaultPercentChangeWindow
+	}
+	return r.Window
+}
+
+// Evaluate checks the rule against the latest points stored for a service.
+func (r PercentChangeRule) Evaluate(storage *timeseries.Storage, serviceName string) (Anomaly, bool) {
+	return r.evaluate(serviceName, storage.GetLatestN(serviceName, r.Metric, r.window()+1))
+}
+
+func (r PercentChangeRule) evaluate(serviceName string, points []timeseries.DataPoint) (Anomaly, bool) {
+	if len(points) < 2 || r.Threshold <= 0 {
+		return Anomaly{}, false
+	}
+	if len(points) > r.window()+1 {
+		points = points[len(points)-r.window()-1:]
+	}
+
+	latest := points[len(points)-1]
+	previous := points[:len(points)-1]
+
+	sum := 0.0
+	for _, p := range previous {
+		sum += p.Value
+	}
+	average := sum / float64(len(previous))
+
+	// A change relative to zero has no meaningful percentage
+	if average == 0 {
+		return Anomaly{}, false
+	}
+
+	change := (latest.Value - average) / math.Abs(average) * 100
+	if math.Abs(change) <= r.Threshold {
+		return Anomaly{}, false
+	}
+
+	direction := "up"
+	if change < 0 {
+		direction = "down"
+	}
+
+	return Anomaly{
+		Type:        PercentChange,
+		ServiceName: serviceName,
+		Severity:    math.Abs(change) / r.Threshold,
+		Description: fmt.Sprintf("%s %s %.1f%% to %.2f vs recent average %.2f", r.Metric, direction, math.Abs(change), latest.Value, average),
+		Timestamp:   latest.Timestamp,
+		Metrics: map[string]float64{
+			r.Metric:         latest.Value,
+			"recent_average": average,
+			"percent_change": change,
+		},
+		Labels: map[string]string{"metric": r.Metric},
+	}, true
+}
@@ -0,0 +1,87 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+func storeSeries(storage *timeseries.Storage, service, metric string, values ...float64) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, v := range values {
+		storage.StoreAt(service, metric, v, start.Add(time.Duration(i)*time.Minute), nil)
+	}
+}
+
+func TestPercentChangeRule_Increase(t *testing.T) {
+	storage := timeseries.NewStorage()
+	storeSeries(storage, "reviews", "saturation_cpu", 40, 40, 40, 40, 100)
+
+	rule := PercentChangeRule{Metric: "saturation_cpu", Threshold: 50, Window: 4}
+	a, found := rule.Evaluate(storage, "reviews")
+	if !found {
+		t.Fatal("Expected a 150% increase to trigger")
+	}
+	if a.Type != PercentChange {
+		t.Errorf("Expected percent change anomaly, got %s", a.Type)
+	}
+	if a.Metrics["percent_change"] != 150 {
+		t.Errorf("Expected +150%% change, got %.1f", a.Metrics["percent_change"])
+	}
+	if a.Severity != 3 {
+		t.Errorf("Expected severity 3, got %.2f", a.Severity)
+	}
+}
+
+func TestPercentChangeRule_Decrease(t *testing.T) {
+	storage := timeseries.NewStorage()
+	storeSeries(storage, "reviews", "request_count", 200, 200, 200, 200, 40)
+
+	rule := PercentChangeRule{Metric: "request_count", Threshold: 50, Window: 4}
+	a, found := rule.Evaluate(storage, "reviews")
+	if !found {
+		t.Fatal("Expected an 80% drop to trigger")
+	}
+	if a.Metrics["percent_change"] != -80 {
+		t.Errorf("Expected -80%% change, got %.1f", a.Metrics["percent_change"])
+	}
+	if a.Description != "request_count down 80.0% to 40.00 vs recent average 200.00" {
+		t.Errorf("Unexpected description: %q", a.Description)
+	}
+}
+
+func TestPercentChangeRule_WithinThreshold(t *testing.T) {
+	storage := timeseries.NewStorage()
+	storeSeries(storage, "reviews", "request_count", 100, 100, 100, 120)
+	storeSeries(storage, "ratings", "request_count", 0, 0, 0, 50)
+
+	rule := PercentChangeRule{Metric: "request_count", Threshold: 50}
+	if _, found := rule.Evaluate(storage, "reviews"); found {
+		t.Error("Expected a 20% change to stay under a 50% threshold")
+	}
+	if _, found := rule.Evaluate(storage, "ratings"); found {
+		t.Error("Expected no percentage change from a zero average")
+	}
+}
+
+func TestDetector_DetectFromStorage_PercentChangeRules(t *testing.T) {
+	storage := timeseries.NewStorage()
+	storeSeries(storage, "reviews", "saturation_cpu", 40, 40, 40, 40, 100)
+
+	config := DetectionConfig{
+		TrafficSpikeThreshold: 2.0,
+		ErrorRateThreshold:    0.05,
+		FeatureWindow:         10,
+		PercentChangeRules:    []PercentChangeRule{{Metric: "saturation_cpu", Threshold: 50}},
+	}
+	detector := NewDetector(config, nil)
+
+	anomalies, err := detector.DetectFromStorage(storage, "reviews")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(anomalies) != 1 || anomalies[0].Type != PercentChange {
+		t.Errorf("Expected one percent change anomaly, got %+v", anomalies)
+	}
+}
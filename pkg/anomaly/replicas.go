@@ -0,0 +1,50 @@
+package anomaly
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Actions GateReplicas can take on a service below MinReplicas
+const (
+	LowReplicaDowngrade = "downgrade"
+	LowReplicaSuppress  = "suppress"
+)
+
+// LowReplicasLabel marks anomalies whose severity was downgraded because the
+// service runs too few replicas, with the replica count as its value.
+const LowReplicasLabel = "low_replicas"
+
+// ValidateLowReplicaAction reports an unknown LowReplicaAction. Empty means
+// the default, downgrade.
+func ValidateLowReplicaAction(action string) error {
+	switch action {
+	case "", LowReplicaDowngrade, LowReplicaSuppress:
+		return nil
+	}
+	return fmt.Errorf("unknown low replica action %q (want %s or %s)", action, LowReplicaDowngrade, LowReplicaSuppress)
+}
+
+// GateReplicas applies the low replica policy to a service's anomalies. A
+// single-replica canary is spiky by nature, so below MinReplicas severities
+// are scaled by replicas/MinReplicas, or the anomalies dropped when
+// LowReplicaAction is suppress. An unknown replica count (zero) is left
+// alone.
+func (d *Detector) GateReplicas(anomalies []Anomaly, replicas int) []Anomaly {
+	if d.config.MinReplicas <= 0 || replicas <= 0 || replicas >= d.config.MinReplicas {
+		return anomalies
+	}
+	if d.config.LowReplicaAction == LowReplicaSuppress {
+		return nil
+	}
+
+	factor := float64(replicas) / float64(d.config.MinReplicas)
+	for i := range anomalies {
+		anomalies[i].Severity *= factor
+		if anomalies[i].Labels == nil {
+			anomalies[i].Labels = make(map[string]string)
+		}
+		anomalies[i].Labels[LowReplicasLabel] = strconv.Itoa(replicas)
+	}
+	return anomalies
+}
@@ -0,0 +1,63 @@
+package anomaly
+
+import (
+	"testing"
+
+	"smanalyzer/pkg/ml"
+)
+
+func newReplicaDetector(minReplicas int, action string) *Detector {
+	config := DetectionConfig{FeatureWindow: 10, MinReplicas: minReplicas, LowReplicaAction: action}
+	return NewDetector(config, ml.NewClusteringEngine(ml.KMeansConfig{K: 3, MaxIter: 100, Tolerance: 0.01}))
+}
+
+func TestDetector_GateReplicas_DowngradesSingleReplica(t *testing.T) {
+	detector := newReplicaDetector(2, LowReplicaDowngrade)
+
+	gated := detector.GateReplicas([]Anomaly{{Type: TrafficSpike, ServiceName: "reviews-canary", Severity: 3.0}}, 1)
+	if len(gated) != 1 {
+		t.Fatalf("Expected the anomaly to be kept, got %v", gated)
+	}
+	if gated[0].Severity != 1.5 {
+		t.Errorf("Expected severity halved to 1.5, got %f", gated[0].Severity)
+	}
+	if gated[0].Labels[LowReplicasLabel] != "1" {
+		t.Errorf("Expected %s label 1, got %q", LowReplicasLabel, gated[0].Labels[LowReplicasLabel])
+	}
+}
+
+func TestDetector_GateReplicas_Suppress(t *testing.T) {
+	detector := newReplicaDetector(2, LowReplicaSuppress)
+
+	if gated := detector.GateReplicas([]Anomaly{{Type: TrafficSpike, Severity: 3.0}}, 1); len(gated) != 0 {
+		t.Errorf("Expected a single-replica service's anomalies suppressed, got %v", gated)
+	}
+	if gated := detector.GateReplicas([]Anomaly{{Type: TrafficSpike, Severity: 3.0}}, 2); len(gated) != 1 {
+		t.Errorf("Expected a service at the minimum kept, got %v", gated)
+	}
+}
+
+func TestDetector_GateReplicas_Disabled(t *testing.T) {
+	for name, tt := range map[string]struct{ minReplicas, replicas int }{
+		"no minimum":      {0, 1},
+		"unknown count":   {2, 0},
+		"enough replicas": {2, 3},
+	} {
+		detector := newReplicaDetector(tt.minReplicas, LowReplicaSuppress)
+		gated := detector.GateReplicas([]Anomaly{{Type: TrafficSpike, Severity: 3.0}}, tt.replicas)
+		if len(gated) != 1 || gated[0].Severity != 3.0 {
+			t.Errorf("%s: Expected the anomaly unchanged, got %v", name, gated)
+		}
+	}
+}
+
+func TestValidateLowReplicaAction(t *testing.T) {
+	for _, action := range []string{"", LowReplicaDowngrade, LowReplicaSuppress} {
+		if err := ValidateLowReplicaAction(action); err != nil {
+			t.Errorf("Unexpected error for %q: %v", action, err)
+		}
+	}
+	if err := ValidateLowReplicaAction("drop"); err == nil {
+		t.Error("Expected an error for an unknown action")
+	}
+}
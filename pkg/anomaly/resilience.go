@@ -0,0 +1,225 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+// detectResilienceAnomalies flags the mesh's own resilience features
+// firing: retries or timeouts above their configured thresholds, any open
+// circuit breaker, and upstream hosts ejected by outlier detection. Retry
+// and timeout counts are cumulative, so the thresholds apply to how many
+// there were since the previous scrape; a zero threshold disables that
+// check.
+func (d *Detector) detectResilienceAnomalies(serviceName string, signals Signals) []Anomaly {
+	var anomalies []Anomaly
+
+	if latest, ok := latestIncrease(signals[RetryCountMetric]); ok && d.config.RetryThreshold > 0 && latest.Value > float64(d.config.RetryThreshold) {
+		anomalies = append(anomalies, Anomaly{
+			Type:        RetryStorm,
+			ServiceName: serviceName,
+			Severity:    latest.Value / float64(d.config.RetryThreshold),
+			Description: fmt.Sprintf("Retry storm: %.0f retries since the last scan (threshold %d)", latest.Value, d.config.RetryThreshold),
+			Timestamp:   latest.Timestamp,
+			Metrics:     map[string]float64{RetryCountMetric: latest.Value},
+		})
+	}
+
+	if latest, ok := latestIncrease(signals[TimeoutCountMetric]); ok && d.config.TimeoutThreshold > 0 && latest.Value > float64(d.config.TimeoutThreshold) {
+		anomalies = append(anomalies, Anomaly{
+			Type:        TimeoutAnomaly,
+			ServiceName: serviceName,
+			Severity:    latest.Value / float64(d.config.TimeoutThreshold),
+			Description: fmt.Sprintf("Upstream timeouts: %.0f since the last scan (threshold %d)", latest.Value, d.config.TimeoutThreshold),
+			Timestamp:   latest.Timestamp,
+			Metrics:     map[string]float64{TimeoutCountMetric: latest.Value},
+		})
+	}
+
+	if latest, ok := latestPoint(signals[CircuitBreakersMetric]); ok && latest.Value > 0 {
+		anomalies = append(anomalies, Anomaly{
+			Type:        CircuitBreaker,
+			ServiceName: serviceName,
+			Severity:    breakerOpenSeverity + latest.Value - 1,
+			Description: fmt.Sprintf("Circuit breaker tripped: %.0f open", latest.Value),
+			Timestamp:   latest.Timestamp,
+			Metrics:     map[string]float64{CircuitBreakersMetric: latest.Value},
+			Labels:      map[string]string{"breaker": "open"},
+		})
+	} else if near, ok := d.detectBreakerNearTrip(serviceName, signals[BreakerUsageMetric]); ok {
+		anomalies = append(anomalies, near)
+	}
+
+	if ejected, ok := d.detectOutlierEjections(serviceName, signals); ok {
+		anomalies = append(anomalies, ejected)
+	}
+
+	return anomalies
+}
+
+// EjectedClusterLabel names the upstream cluster an outlier ejection
+// anomaly was attributed to.
+const EjectedClusterLabel = "ejected_cluster"
+
+// detectOutlierEjections flags upstream hosts ejected from load balancing
+// by outlier detection: OutlierEjectionThreshold or more ejected now, or a
+// spike of that many ejections since the previous point. The ejection
+// count is cumulative, so a drop is a restarted proxy and not a spike.
+// Severity is HIGH at the threshold and rises with each threshold's worth
+// of ejections beyond it.
+func (d *Detector) detectOutlierEjections(serviceName string, signals Signals) (Anomaly, bool) {
+	threshold := float64(d.config.OutlierEjectionThreshold)
+	if threshold <= 0 {
+		return Anomaly{}, false
+	}
+
+	latest, _ := latestPoint(signals[EjectionsActiveMetric])
+	active := latest.Value
+	var recent float64
+	if total := signals[EjectionsMetric]; len(total) >= 2 {
+		last := total[len(total)-1]
+		recent = math.Max(0, last.Value-total[len(total)-2].Value)
+		if last.Timestamp.After(latest.Timestamp) {
+			latest = last
+		}
+	}
+	ejected := math.Max(active, recent)
+	if ejected < threshold {
+		return Anomaly{}, false
+	}
+
+	return Anomaly{
+		Type:        OutlierEjection,
+		ServiceName: serviceName,
+		Severity:    2 + (ejected-threshold)/threshold,
+		Description: fmt.Sprintf("Outlier detection ejected upstream hosts: %.0f ejected now, %.0f new ejections (threshold %d)", active, recent, d.config.OutlierEjectionThreshold),
+		Timestamp:   latest.Timestamp,
+		Metrics: map[string]float64{
+			EjectionsActiveMetric:   active,
+			"outlier_ejections_new": recent,
+		},
+	}, true
+}
+
+// AttributeEjections records the upstream cluster outlier detection
+// ejected the most hosts from, e.g. outbound|9080||ratings.shop.svc.cluster.local.
+func (a *Anomaly) AttributeEjections(cluster string, active float64) {
+	if a.Labels == nil {
+		a.Labels = make(map[string]string)
+	}
+	a.Labels[EjectedClusterLabel] = cluster
+	a.Description += fmt.Sprintf("; most from %s, %.0f ejected now", cluster, active)
+}
+
+// breakerOpenSeverity is the severity of one open circuit breaker, the
+// lowest score SeverityText calls CRITICAL. A breaker close to tripping
+// scores from MEDIUM at the configured fraction up to HIGH when exhausted.
+const breakerOpenSeverity = 3.0
+
+// detectBreakerNearTrip flags a circuit breaker with less than
+// BreakerRemainingFraction of a threshold left, before it opens and starts
+// rejecting requests.
+func (d *Detector) detectBreakerNearTrip(serviceName string, points []timeseries.DataPoint) (Anomaly, bool) {
+	fraction := d.config.BreakerRemainingFraction
+	latest, ok := latestPoint(points)
+	if !ok || fraction <= 0 || latest.Value <= 0 {
+		return Anomaly{}, false
+	}
+	remaining := math.Max(0, 1-latest.Value)
+	if remaining >= fraction {
+		return Anomaly{}, false
+	}
+
+	return Anomaly{
+		Type:        CircuitBreaker,
+		ServiceName: serviceName,
+		Severity:    1.5 + (1 - remaining/fraction),
+		Description: fmt.Sprintf("Circuit breaker near tripping: %.0f%% of a threshold left (warning below %.0f%%)", remaining*100, fraction*100),
+		Timestamp:   latest.Timestamp,
+		Metrics:     map[string]float64{BreakerUsageMetric: latest.Value},
+		Labels:      map[string]string{"breaker": "near_trip"},
+	}, true
+}
+
+func latestPoint(points []timeseries.DataPoint) (timeseries.DataPoint, bool) {
+	if len(points) == 0 {
+		return timeseries.DataPoint{}, false
+	}
+	return points[len(points)-1], true
+}
+
+// latestIncrease returns the latest point of a cumulative counter with how
+// much it grew since the point before, or false without two points.
+func latestIncrease(points []timeseries.DataPoint) (timeseries.DataPoint, bool) {
+	if len(points) < 2 {
+		return timeseries.DataPoint{}, false
+	}
+	latest := points[len(points)-1]
+	latest.Value = counterIncrease(points[len(points)-2].Value, latest.Value)
+	return latest, true
+}
+
+// AttachPolicy adds the declared mesh policy behind a resilience anomaly,
+// e.g. "DestinationRule reviews: consecutive5xxErrors=5", so it can be read
+// as intended behavior or a misconfiguration. Settings are also recorded
+// as policy.* labels.
+func (a *Anomaly) AttachPolicy(kind, name string, settings map[string]string) {
+	if len(settings) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if a.Labels == nil {
+		a.Labels = make(map[string]string)
+	}
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		a.Labels["policy."+key] = settings[key]
+		pairs = append(pairs, key+"="+settings[key])
+	}
+	a.Labels["policy"] = kind + "/" + name
+	a.Description += fmt.Sprintf("; %s %s: %s", kind, name, strings.Join(pairs, ", "))
+}
+
+// detectConnectionFailureAnomalies flags a jump in upstream connection
+// failures, which usually means an upstream died or stopped accepting
+// connections. The failure count is cumulative, so the failures since the
+// previous point are compared with the mean of those between the earlier
+// points, taken as at least one so a first isolated failure isn't a spike.
+func (d *Detector) detectConnectionFailureAnomalies(serviceName string, points []timeseries.DataPoint) []Anomaly {
+	factor := d.config.ConnFailureSpikeFactor
+	if factor <= 0 || len(points) < 3 {
+		return nil
+	}
+
+	latest, _ := latestIncrease(points)
+	earlier := 0.0
+	for i := 1; i < len(points)-1; i++ {
+		earlier += counterIncrease(points[i-1].Value, points[i].Value)
+	}
+	baseline := math.Max(1, earlier/float64(len(points)-2))
+	if latest.Value <= baseline*factor {
+		return nil
+	}
+
+	return []Anomaly{{
+		Type:        ConnectionFailure,
+		ServiceName: serviceName,
+		Severity:    latest.Value / (baseline * factor),
+		Description: fmt.Sprintf("Upstream connection failures spiked to %.0f (%.1fx the recent %.1f)", latest.Value, latest.Value/baseline, baseline),
+		Timestamp:   latest.Timestamp,
+		Metrics: map[string]float64{
+			ConnFailuresMetric:             latest.Value,
+			"connection_failures_baseline": baseline,
+		},
+	}}
+}
@@ -0,0 +1,218 @@
+package anomaly
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetector_DetectResilienceAnomalies(t *testing.T) {
+	detector := NewDetector(DetectionConfig{RetryThreshold: 100, TimeoutThreshold: 10}, nil)
+
+	// The counts are cumulative: 250 new retries, but only 4 new timeouts
+	// on top of the many already counted
+	anomalies := detector.detectResilienceAnomalies("reviews", Signals{
+		RetryCountMetric:      latencyPoints(1000, 1250),
+		TimeoutCountMetric:    latencyPoints(5000, 5004),
+		CircuitBreakersMetric: latencyPoints(0, 1),
+	})
+
+	found := make(map[AnomalyType]Anomaly)
+	for _, a := range anomalies {
+		found[a.Type] = a
+	}
+	if len(anomalies) != 2 {
+		t.Fatalf("Expected retry storm and circuit breaker anomalies, got %+v", anomalies)
+	}
+	if a, ok := found[RetryStorm]; !ok || a.Severity != 2.5 {
+		t.Errorf("Expected a retry storm with severity 2.5, got %+v", a)
+	}
+	if _, ok := found[CircuitBreaker]; !ok {
+		t.Error("Expected a circuit breaker anomaly")
+	}
+	if _, ok := found[TimeoutAnomaly]; ok {
+		t.Error("Expected timeouts under the threshold to be ignored")
+	}
+}
+
+func TestDetector_DetectResilienceAnomalies_DisabledThresholds(t *testing.T) {
+	detector := NewDetector(DetectionConfig{}, nil)
+
+	anomalies := detector.detectResilienceAnomalies("reviews", Signals{
+		RetryCountMetric:   latencyPoints(0, 1000),
+		TimeoutCountMetric: latencyPoints(0, 1000),
+	})
+	if len(anomalies) != 0 {
+		t.Errorf("Expected zero thresholds to disable detection, got %+v", anomalies)
+	}
+}
+
+func TestDetector_DetectResilienceAnomalies_CountsSinceLastScan(t *testing.T) {
+	detector := NewDetector(DetectionConfig{RetryThreshold: 100, TimeoutThreshold: 10}, nil)
+
+	cases := []struct {
+		name   string
+		counts []float64
+		want   int
+	}{
+		{"single scrape", []float64{5000}, 0},
+		{"high but flat", []float64{5000, 5000}, 0},
+		{"restarted proxy", []float64{5000, 400}, 1},
+		{"burst", []float64{5000, 5200}, 1},
+	}
+	for _, c := range cases {
+		anomalies := detector.detectResilienceAnomalies("reviews", Signals{
+			RetryCountMetric:   latencyPoints(c.counts...),
+			TimeoutCountMetric: latencyPoints(c.counts...),
+		})
+		if got := countType(anomalies, RetryStorm) + countType(anomalies, TimeoutAnomaly); got != 2*c.want {
+			t.Errorf("%s: Expected %d retry and timeout anomalies, got %+v", c.name, 2*c.want, anomalies)
+		}
+	}
+}
+
+func TestDetector_DetectResilienceAnomalies_BreakerSeverity(t *testing.T) {
+	detector := NewDetector(DetectionConfig{BreakerRemainingFraction: 0.2}, nil)
+
+	cases := []struct {
+		name     string
+		signals  Signals
+		state    string
+		severity string
+	}{
+		{"plenty left", Signals{BreakerUsageMetric: latencyPoints(0.5)}, "", ""},
+		{"near trip", Signals{BreakerUsageMetric: latencyPoints(0.5, 0.85)}, "near_trip", "MEDIUM"},
+		{"nearly exhausted", Signals{BreakerUsageMetric: latencyPoints(0.98)}, "near_trip", "HIGH"},
+		{"tripped", Signals{BreakerUsageMetric: latencyPoints(1), CircuitBreakersMetric: latencyPoints(1)}, "open", "CRITICAL"},
+	}
+	for _, c := range cases {
+		anomalies := detector.detectResilienceAnomalies("reviews", c.signals)
+		if c.state == "" {
+			if len(anomalies) != 0 {
+				t.Errorf("%s: expected no anomaly, got %+v", c.name, anomalies)
+			}
+			continue
+		}
+		if len(anomalies) != 1 || anomalies[0].Type != CircuitBreaker {
+			t.Errorf("%s: expected one circuit breaker anomaly, got %+v", c.name, anomalies)
+			continue
+		}
+		a := anomalies[0]
+		if a.Labels["breaker"] != c.state || SeverityText(a.Severity) != c.severity {
+			t.Errorf("%s: expected a %s breaker at %s, got %s at %s (%.2f)",
+				c.name, c.state, c.severity, a.Labels["breaker"], SeverityText(a.Severity), a.Severity)
+		}
+	}
+
+	disabled := NewDetector(DetectionConfig{}, nil)
+	if anomalies := disabled.detectResilienceAnomalies("reviews", Signals{BreakerUsageMetric: latencyPoints(0.99)}); len(anomalies) != 0 {
+		t.Errorf("Expected a zero fraction to disable the near-trip warning, got %+v", anomalies)
+	}
+}
+
+func TestDetector_DetectOutlierEjections(t *testing.T) {
+	detector := NewDetector(DetectionConfig{OutlierEjectionThreshold: 2}, nil)
+
+	cases := []struct {
+		name     string
+		signals  Signals
+		ejected  bool
+		severity float64
+	}{
+		{"ejected now", Signals{EjectionsActiveMetric: latencyPoints(0, 3), EjectionsMetric: latencyPoints(4, 7)}, true, 2.5},
+		{"ejection spike", Signals{EjectionsActiveMetric: latencyPoints(0, 0), EjectionsMetric: latencyPoints(10, 14)}, true, 3},
+		{"below threshold", Signals{EjectionsActiveMetric: latencyPoints(0, 1), EjectionsMetric: latencyPoints(10, 11)}, false, 0},
+		{"old ejections", Signals{EjectionsActiveMetric: latencyPoints(0, 0), EjectionsMetric: latencyPoints(40, 40)}, false, 0},
+		{"proxy restart", Signals{EjectionsActiveMetric: latencyPoints(0, 0), EjectionsMetric: latencyPoints(40, 0)}, false, 0},
+	}
+	for _, c := range cases {
+		anomalies := detector.detectResilienceAnomalies("reviews", c.signals)
+		if !c.ejected {
+			if len(anomalies) != 0 {
+				t.Errorf("%s: expected no anomaly, got %+v", c.name, anomalies)
+			}
+			continue
+		}
+		if len(anomalies) != 1 || anomalies[0].Type != OutlierEjection {
+			t.Errorf("%s: expected one outlier ejection anomaly, got %+v", c.name, anomalies)
+			continue
+		}
+		if anomalies[0].Severity != c.severity {
+			t.Errorf("%s: expected severity %.1f, got %.2f", c.name, c.severity, anomalies[0].Severity)
+		}
+	}
+
+	disabled := NewDetector(DetectionConfig{}, nil)
+	if anomalies := disabled.detectResilienceAnomalies("reviews", Signals{EjectionsActiveMetric: latencyPoints(10)}); len(anomalies) != 0 {
+		t.Errorf("Expected a zero threshold to disable ejection detection, got %+v", anomalies)
+	}
+}
+
+func TestAnomaly_AttributeEjections(t *testing.T) {
+	a := Anomaly{Type: OutlierEjection, Description: "Outlier detection ejected upstream hosts"}
+	a.AttributeEjections("outbound|9080||ratings.shop.svc.cluster.local", 2)
+
+	if a.Labels[EjectedClusterLabel] != "outbound|9080||ratings.shop.svc.cluster.local" {
+		t.Errorf("Expected the ejected cluster label, got %v", a.Labels)
+	}
+	if !strings.HasSuffix(a.Description, "; most from outbound|9080||ratings.shop.svc.cluster.local, 2 ejected now") {
+		t.Errorf("Expected the cluster in the description, got %q", a.Description)
+	}
+}
+
+func TestAnomaly_AttachPolicy(t *testing.T) {
+	a := Anomaly{Type: CircuitBreaker, Description: "Circuit breaker tripped: 1 open"}
+	a.AttachPolicy("DestinationRule", "reviews-dr", map[string]string{"interval": "10s", "consecutive5xxErrors": "5"})
+
+	expected := "Circuit breaker tripped: 1 open; DestinationRule reviews-dr: consecutive5xxErrors=5, interval=10s"
+	if a.Description != expected {
+		t.Errorf("Expected %q, got %q", expected, a.Description)
+	}
+	if a.Labels["policy.consecutive5xxErrors"] != "5" {
+		t.Errorf("Expected policy label, got %v", a.Labels)
+	}
+
+	untouched := Anomaly{Description: "Retry storm"}
+	untouched.AttachPolicy("VirtualService", "reviews", nil)
+	if untouched.Description != "Retry storm" || untouched.Labels != nil {
+		t.Errorf("Expected no change without settings, got %+v", untouched)
+	}
+}
+
+func TestDetector_DetectConnectionFailureAnomalies(t *testing.T) {
+	detector := NewDetector(DetectionConfig{ConnFailureSpikeFactor: 3}, nil)
+
+	// Cumulative: 2, 1, 3 and 2 failures a scrape, then 40
+	anomalies, _ := detector.DetectSignals("reviews", Signals{ConnFailuresMetric: latencyPoints(100, 102, 103, 106, 108, 148)})
+	if countType(anomalies, ConnectionFailure) != 1 {
+		t.Fatalf("Expected a connection failure spike, got %+v", anomalies)
+	}
+	a := anomalies[0]
+	if a.Metrics[ConnFailuresMetric] != 40 || a.Metrics["connection_failures_baseline"] != 2 {
+		t.Errorf("Expected 40 failures against a baseline of 2, got %v", a.Metrics)
+	}
+	if a.Severity != 40.0/6 {
+		t.Errorf("Expected severity %f, got %f", 40.0/6, a.Severity)
+	}
+}
+
+func TestDetector_DetectConnectionFailureAnomalies_Steady(t *testing.T) {
+	detector := NewDetector(DetectionConfig{ConnFailureSpikeFactor: 3}, nil)
+
+	for name, points := range map[string][]float64{
+		"steady failures":  {500, 510, 522, 533, 546},
+		"isolated failure": {700, 700, 700, 700, 702},
+		"high but flat":    {5000, 5000, 5000},
+		"restarted proxy":  {5000, 5010, 5020, 8},
+		"two scrapes":      {0, 500},
+	} {
+		anomalies := detector.detectConnectionFailureAnomalies("reviews", latencyPoints(points...))
+		if len(anomalies) != 0 {
+			t.Errorf("%s: Expected no anomaly, got %+v", name, anomalies)
+		}
+	}
+
+	disabled := NewDetector(DetectionConfig{}, nil)
+	if anomalies := disabled.detectConnectionFailureAnomalies("reviews", latencyPoints(0, 0, 500)); len(anomalies) != 0 {
+		t.Errorf("Expected a zero factor to disable detection, got %+v", anomalies)
+	}
+}
@@ -0,0 +1,136 @@
+package anomaly
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// RootCauseLabel names the service an error anomaly was traced to
+	RootCauseLabel = "root_cause"
+	// IncidentRoleLabel is RoleRootCause or RoleCollateral
+	IncidentRoleLabel = "incident_role"
+
+	RoleRootCause  = "root_cause"
+	RoleCollateral = "collateral"
+)
+
+// Incident is a group of services failing together, traced to the one
+// furthest downstream.
+type Incident struct {
+	RootCause  string   `json:"root_cause"`
+	Collateral []string `json:"collateral"`
+}
+
+// IsError reports whether the anomaly is a service failing requests, as
+// opposed to slowing down or changing shape.
+func (a Anomaly) IsError() bool {
+	switch a.Type {
+	case ErrorRateHigh, ConnectionFailure, TimeoutAnomaly, CircuitBreaker:
+		return true
+	}
+	return false
+}
+
+// serviceKey keys an anomaly's service like RecentTroubleScores.
+func serviceKey(a Anomaly) string {
+	if a.Cluster != "" {
+		return a.Cluster + "/" + a.ServiceName
+	}
+	return a.ServiceName
+}
+
+// GroupByRootCause traces services with error anomalies through
+// dependencies, which maps a service key to the services it calls. A
+// failing service none of whose dependencies are failing is a root cause,
+// and the failing services that reach it through other failing services
+// are its collateral. Each root with collateral becomes an incident, and
+// the error anomalies involved are labeled with their role and root cause.
+// A service reaching several roots is collateral of each.
+func GroupByRootCause(anomalies []Anomaly, dependencies map[string][]string) []Incident {
+	failing := make(map[string]bool)
+	for _, a := range anomalies {
+		if a.IsError() {
+			failing[serviceKey(a)] = true
+		}
+	}
+
+	callers := make(map[string][]string)
+	var roots []string
+	for service := range failing {
+		root := true
+		for _, callee := range dependencies[service] {
+			if failing[callee] && callee != service {
+				root = false
+				callers[callee] = append(callers[callee], service)
+			}
+		}
+		if root {
+			roots = append(roots, service)
+		}
+	}
+	sort.Strings(roots)
+
+	var incidents []Incident
+	causes := make(map[string][]string)
+	for _, root := range roots {
+		collateral := failingCallers(root, callers)
+		if len(collateral) == 0 {
+			continue
+		}
+		incidents = append(incidents, Incident{RootCause: root, Collateral: collateral})
+		for _, service := range collateral {
+			causes[service] = append(causes[service], root)
+		}
+	}
+
+	affected := make(map[string]int, len(incidents))
+	for _, incident := range incidents {
+		affected[incident.RootCause] = len(incident.Collateral)
+	}
+	for i := range anomalies {
+		a := &anomalies[i]
+		if !a.IsError() {
+			continue
+		}
+		key := serviceKey(*a)
+		if n, ok := affected[key]; ok {
+			a.markIncident(RoleRootCause, key)
+			a.Description += fmt.Sprintf("; likely root cause of %d failing services", n)
+		} else if roots := causes[key]; len(roots) > 0 {
+			a.markIncident(RoleCollateral, strings.Join(roots, ","))
+			a.Description += fmt.Sprintf("; likely collateral of %s", strings.Join(roots, ", "))
+		}
+	}
+	return incidents
+}
+
+// failingCallers walks back from root through failing callers, returning
+// every service found, sorted.
+func failingCallers(root string, callers map[string][]string) []string {
+	seen := map[string]bool{root: true}
+	queue := []string{root}
+	var found []string
+	for len(queue) > 0 {
+		service := queue[0]
+		queue = queue[1:]
+		for _, caller := range callers[service] {
+			if !seen[caller] {
+				seen[caller] = true
+				found = append(found, caller)
+				queue = append(queue, caller)
+			}
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+func (a *Anomaly) markIncident(role, rootCause string) {
+	if a.Labels == nil {
+		a.Labels = make(map[string]string)
+	}
+	a.Labels[IncidentRoleLabel] = role
+	a.Labels[RootCauseLabel] = rootCause
+}
@@ -0,0 +1,79 @@
+package anomaly
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupByRootCause(t *testing.T) {
+	// productpage → reviews → ratings → mysql, search → ratings, and
+	// checkout → payments. mysql isn't scanned and details is only slow.
+	dependencies := map[string][]string{
+		"productpage": {"details", "reviews"},
+		"reviews":     {"ratings"},
+		"ratings":     {"mysql"},
+		"search":      {"ratings"},
+		"checkout":    {"payments"},
+	}
+	anomalies := []Anomaly{
+		{Type: ErrorRateHigh, ServiceName: "productpage"},
+		{Type: LatencyAnomaly, ServiceName: "details"},
+		{Type: ErrorRateHigh, ServiceName: "reviews"},
+		{Type: ConnectionFailure, ServiceName: "ratings"},
+		{Type: TimeoutAnomaly, ServiceName: "search"},
+		{Type: ErrorRateHigh, ServiceName: "checkout"},
+		{Type: CircuitBreaker, ServiceName: "payments"},
+		{Type: ErrorRateHigh, ServiceName: "inventory"},
+	}
+
+	incidents := GroupByRootCause(anomalies, dependencies)
+
+	expected := []Incident{
+		{RootCause: "payments", Collateral: []string{"checkout"}},
+		{RootCause: "ratings", Collateral: []string{"productpage", "reviews", "search"}},
+	}
+	if !reflect.DeepEqual(incidents, expected) {
+		t.Fatalf("Expected incidents %+v, got %+v", expected, incidents)
+	}
+
+	roles := make(map[string][2]string)
+	for _, a := range anomalies {
+		roles[a.ServiceName] = [2]string{a.Labels[IncidentRoleLabel], a.Labels[RootCauseLabel]}
+	}
+	expectedRoles := map[string][2]string{
+		"productpage": {RoleCollateral, "ratings"},
+		"details":     {"", ""},
+		"reviews":     {RoleCollateral, "ratings"},
+		"ratings":     {RoleRootCause, "ratings"},
+		"search":      {RoleCollateral, "ratings"},
+		"checkout":    {RoleCollateral, "payments"},
+		"payments":    {RoleRootCause, "payments"},
+		"inventory":   {"", ""},
+	}
+	if !reflect.DeepEqual(roles, expectedRoles) {
+		t.Errorf("Expected roles %v, got %v", expectedRoles, roles)
+	}
+	if got := anomalies[3].Description; got != "; likely root cause of 3 failing services" {
+		t.Errorf("Expected the root cause noted in the description, got %q", got)
+	}
+}
+
+func TestGroupByRootCause_PerCluster(t *testing.T) {
+	dependencies := map[string][]string{
+		"east/reviews": {"east/ratings"},
+		"west/reviews": {"west/ratings"},
+	}
+	anomalies := []Anomaly{
+		{Type: ErrorRateHigh, ServiceName: "reviews", Cluster: "east"},
+		{Type: ErrorRateHigh, ServiceName: "ratings", Cluster: "east"},
+		{Type: ErrorRateHigh, ServiceName: "reviews", Cluster: "west"},
+	}
+
+	incidents := GroupByRootCause(anomalies, dependencies)
+	if len(incidents) != 1 || incidents[0].RootCause != "east/ratings" {
+		t.Errorf("Expected only east/ratings as a root cause, got %+v", incidents)
+	}
+	if role := anomalies[2].Labels[IncidentRoleLabel]; role != "" {
+		t.Errorf("Expected west/reviews with a healthy dependency to stay unlabeled, got %q", role)
+	}
+}
@@ -0,0 +1,41 @@
+package anomaly
+
+import "fmt"
+
+const (
+	// RouteLabel names the route an anomaly was detected on
+	RouteLabel = "route"
+	// WorstRouteLabel names the failing route a service-level error
+	// anomaly was attributed to
+	WorstRouteLabel = "worst_route"
+)
+
+// AttributeRoute records the route whose requests failed the most, so
+// "errors on checkout" reads as "errors on checkout, mostly POST /checkout".
+// errorRate is a fraction.
+func (a *Anomaly) AttributeRoute(route string, errorRate float64) {
+	if a.Labels == nil {
+		a.Labels = make(map[string]string)
+	}
+	if a.Metrics == nil {
+		a.Metrics = make(map[string]float64)
+	}
+	a.Labels[WorstRouteLabel] = route
+	a.Metrics["route_error_rate"] = errorRate
+	a.Description += fmt.Sprintf("; worst route %s at %.1f%%", route, errorRate*100)
+}
+
+// ScopeToRoute marks an anomaly detected on one route's series and sets it
+// against the service as a whole, whose aggregate may look healthy.
+// serviceErrorRate is a fraction.
+func (a *Anomaly) ScopeToRoute(route string, serviceErrorRate float64) {
+	if a.Labels == nil {
+		a.Labels = make(map[string]string)
+	}
+	if a.Metrics == nil {
+		a.Metrics = make(map[string]float64)
+	}
+	a.Labels[RouteLabel] = route
+	a.Metrics["service_error_rate"] = serviceErrorRate
+	a.Description = fmt.Sprintf("%s: %s while the service aggregate is %.1f%%", route, a.Description, serviceErrorRate*100)
+}
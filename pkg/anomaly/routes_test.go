@@ -0,0 +1,27 @@
+package anomaly
+
+import "testing"
+
+func TestAnomaly_AttributeRoute(t *testing.T) {
+	a := Anomaly{Type: ErrorRateHigh, Description: "High error rate: 6.00%"}
+	a.AttributeRoute("POST /checkout", 0.08)
+
+	if a.Description != "High error rate: 6.00%; worst route POST /checkout at 8.0%" {
+		t.Errorf("Unexpected description %q", a.Description)
+	}
+	if a.Labels[WorstRouteLabel] != "POST /checkout" || a.Metrics["route_error_rate"] != 0.08 {
+		t.Errorf("Expected the worst route recorded, got %v and %v", a.Labels, a.Metrics)
+	}
+}
+
+func TestAnomaly_ScopeToRoute(t *testing.T) {
+	a := Anomaly{Type: ErrorRateHigh, Description: "High error rate: 8.00%"}
+	a.ScopeToRoute("POST /checkout", 0.005)
+
+	if a.Description != "POST /checkout: High error rate: 8.00% while the service aggregate is 0.5%" {
+		t.Errorf("Unexpected description %q", a.Description)
+	}
+	if a.Labels[RouteLabel] != "POST /checkout" || a.Metrics["service_error_rate"] != 0.005 {
+		t.Errorf("Expected the route and service rate recorded, got %v and %v", a.Labels, a.Metrics)
+	}
+}
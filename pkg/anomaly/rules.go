@@ -0,0 +1,158 @@
+package anomaly
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+// AlertRule is a user-declared condition on a stored metric, e.g. error_rate
+// above 0.05 for two minutes on checkout, evaluated each scan alongside the
+// built-in detectors:
+//
+//	{metric: error_rate, op: ">", value: 0.05, for: 2m, severity: high, service: checkout}
+type AlertRule struct {
+	// Name identifies the rule in anomalies; empty names it after its
+	// condition
+	Name   string  `yaml:"name" json:"name"`
+	Metric string  `yaml:"metric" json:"metric"`
+	Op     string  `yaml:"op" json:"op"`
+	Value  float64 `yaml:"value" json:"value"`
+	// For is how long the condition must have held, as in a Prometheus
+	// alerting rule; zero fires on the latest point alone
+	For time.Duration `yaml:"for" json:"for"`
+	// Severity is low, medium (the default), high or critical
+	Severity string `yaml:"severity" json:"severity"`
+	// Service limits the rule to a service, namespace/service or
+	// cluster/namespace/service; empty applies it to every service
+	Service string `yaml:"service" json:"service"`
+}
+
+// ruleSeverities map severity names to the levels SeverityText reports.
+var ruleSeverities = map[string]float64{
+	"low":      1,
+	"medium":   1.5,
+	"high":     2,
+	"critical": 3,
+}
+
+// Validate reports rules that could never be evaluated.
+func (r AlertRule) Validate() error {
+	if r.Metric == "" {
+		return fmt.Errorf("alert rule %s needs a metric", r.name())
+	}
+	if _, err := compare(r.Op, 0, 0); err != nil {
+		return fmt.Errorf("alert rule %s: %w", r.name(), err)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("alert rule %s has a negative for duration", r.name())
+	}
+	if _, exists := ruleSeverities[strings.ToLower(r.Severity)]; r.Severity != "" && !exists {
+		return fmt.Errorf("alert rule %s has severity %q: expected low, medium, high or critical", r.name(), r.Severity)
+	}
+	return nil
+}
+
+func (r AlertRule) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("%s %s %g", r.Metric, r.Op, r.Value)
+}
+
+func (r AlertRule) severity() float64 {
+	if severity, exists := ruleSeverities[strings.ToLower(r.Severity)]; exists {
+		return severity
+	}
+	return ruleSeverities["medium"]
+}
+
+// appliesTo matches the rule's service against a series key such as
+// cluster/namespace/service, the way detection.services keys are matched.
+func (r AlertRule) appliesTo(serviceName string) bool {
+	if r.Service == "" {
+		return true
+	}
+	key := serviceName
+	for {
+		if key == r.Service {
+			return true
+		}
+		i := strings.Index(key, "/")
+		if i < 0 {
+			return false
+		}
+		key = key[i+1:]
+	}
+}
+
+// compare applies a rule's operator.
+func compare(op string, value, threshold float64) (bool, error) {
+	switch op {
+	case ">":
+		return value > threshold, nil
+	case ">=":
+		return value >= threshold, nil
+	case "<":
+		return value < threshold, nil
+	case "<=":
+		return value <= threshold, nil
+	case "==":
+		return value == threshold, nil
+	case "!=":
+		return value != threshold, nil
+	}
+	return false, fmt.Errorf("invalid op %q: expected >, >=, <, <=, == or !=", op)
+}
+
+// Evaluate checks the rule against the points stored for a service.
+func (r AlertRule) Evaluate(storage *timeseries.Storage, serviceName string) (Anomaly, bool) {
+	return r.evaluate(serviceName, storage.GetLatestN(serviceName, r.Metric, DefaultLookback))
+}
+
+// evaluate fires when the condition holds on the latest point and has held
+// on every point back to at least For before it. A series too short to
+// cover For stays pending, like a Prometheus alert.
+func (r AlertRule) evaluate(serviceName string, points []timeseries.DataPoint) (Anomaly, bool) {
+	if len(points) == 0 || !r.appliesTo(serviceName) {
+		return Anomaly{}, false
+	}
+
+	latest := points[len(points)-1]
+	since := -1
+	for i := len(points) - 1; i >= 0; i-- {
+		if holds, err := compare(r.Op, points[i].Value, r.Value); err != nil || !holds {
+			break
+		}
+		since = i
+	}
+	if since < 0 {
+		return Anomaly{}, false
+	}
+	held := latest.Timestamp.Sub(points[since].Timestamp)
+	if held < r.For {
+		return Anomaly{}, false
+	}
+
+	description := fmt.Sprintf("Rule %s: %s %s %g", r.name(), r.Metric, r.Op, r.Value)
+	if r.For > 0 {
+		description += fmt.Sprintf(" for %v", r.For)
+	}
+	description += fmt.Sprintf(" (now %.4g)", latest.Value)
+
+	return Anomaly{
+		Type:        RuleAlert,
+		ServiceName: serviceName,
+		Severity:    r.severity(),
+		Description: description,
+		Timestamp:   latest.Timestamp,
+		Metrics: map[string]float64{
+			r.Metric:           latest.Value,
+			"threshold":        r.Value,
+			"held_for_seconds": held.Seconds(),
+		},
+		Labels: map[string]string{"rule": r.name(), "metric": r.Metric},
+	}, true
+}
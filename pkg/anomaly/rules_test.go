@@ -0,0 +1,93 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+func TestAlertRule_For(t *testing.T) {
+	rule := AlertRule{Name: "checkout-errors", Metric: ErrorRateMetric, Op: ">", Value: 0.05, For: 2 * time.Minute, Severity: "high"}
+
+	// One point a minute: the rate crosses 0.05 at minute 2
+	storage := timeseries.NewStorage()
+	storeSeries(storage, "shop/checkout", ErrorRateMetric, 0.01, 0.02, 0.08, 0.09)
+	if _, found := rule.Evaluate(storage, "shop/checkout"); found {
+		t.Error("Expected the rule to stay pending after one minute above the threshold")
+	}
+
+	storage.StoreAt("shop/checkout", ErrorRateMetric, 0.07, time.Date(2024, 1, 1, 0, 4, 0, 0, time.UTC), nil)
+	a, found := rule.Evaluate(storage, "shop/checkout")
+	if !found {
+		t.Fatal("Expected the rule to fire after two minutes above the threshold")
+	}
+	if a.Type != RuleAlert || a.Severity != 2 {
+		t.Errorf("Expected a high rule alert, got %s at %.1f", a.Type, a.Severity)
+	}
+	if a.Labels["rule"] != "checkout-errors" || a.Metrics["held_for_seconds"] != 120 {
+		t.Errorf("Expected checkout-errors held for 120s, got %v and %v", a.Labels, a.Metrics)
+	}
+	if a.Description != "Rule checkout-errors: error_rate > 0.05 for 2m0s (now 0.07)" {
+		t.Errorf("Unexpected description: %q", a.Description)
+	}
+
+	// A dip below the threshold restarts the clock
+	storage.StoreAt("shop/checkout", ErrorRateMetric, 0.01, time.Date(2024, 1, 1, 0, 5, 0, 0, time.UTC), nil)
+	storage.StoreAt("shop/checkout", ErrorRateMetric, 0.08, time.Date(2024, 1, 1, 0, 6, 0, 0, time.UTC), nil)
+	if _, found := rule.Evaluate(storage, "shop/checkout"); found {
+		t.Error("Expected a dip below the threshold to reset the for duration")
+	}
+}
+
+func TestAlertRule_ServiceScope(t *testing.T) {
+	storage := timeseries.NewStorage()
+	storeSeries(storage, "east/shop/checkout", RequestCountMetric, 5)
+	storeSeries(storage, "east/shop/reviews", RequestCountMetric, 5)
+
+	rule := AlertRule{Metric: RequestCountMetric, Op: "<", Value: 10, Service: "shop/checkout"}
+	a, found := rule.Evaluate(storage, "east/shop/checkout")
+	if !found {
+		t.Fatal("Expected the rule to fire on shop/checkout")
+	}
+	if a.Severity != 1.5 || a.Labels["rule"] != "request_count < 10" {
+		t.Errorf("Expected a medium alert named after its condition, got %.1f and %v", a.Severity, a.Labels)
+	}
+	if _, found := rule.Evaluate(storage, "east/shop/reviews"); found {
+		t.Error("Expected the rule not to apply to reviews")
+	}
+}
+
+func TestAlertRule_Validate(t *testing.T) {
+	valid := AlertRule{Metric: ErrorRateMetric, Op: ">=", Value: 0.05, Severity: "Critical"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	for _, rule := range []AlertRule{
+		{Op: ">", Value: 1},
+		{Metric: ErrorRateMetric, Op: "=>", Value: 1},
+		{Metric: ErrorRateMetric, Op: ">", For: -time.Minute},
+		{Metric: ErrorRateMetric, Op: ">", Severity: "page"},
+	} {
+		if err := rule.Validate(); err == nil {
+			t.Errorf("Expected an error for %+v", rule)
+		}
+	}
+}
+
+func TestDetector_AlertRules(t *testing.T) {
+	detector := NewDetector(DetectionConfig{AlertRules: []AlertRule{
+		{Name: "cpu", Metric: "saturation_cpu", Op: ">", Value: 0.9, Severity: "critical"},
+	}}, nil)
+
+	storage := timeseries.NewStorage()
+	storeSeries(storage, "shop/reviews", "saturation_cpu", 0.5, 0.95)
+	anomalies, err := detector.DetectFromStorage(storage, "shop/reviews")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if countType(anomalies, RuleAlert) != 1 {
+		t.Errorf("Expected the cpu rule to fire, got %+v", anomalies)
+	}
+}
@@ -0,0 +1,52 @@
+package anomaly
+
+import (
+	"sync"
+	"time"
+
+	"smanalyzer/pkg/clock"
+)
+
+// Suppressor drops repeats of an anomaly, by service and type, raised again
+// within the cooldown of the last one let through. Time comes from its
+// clock, so a replay suppresses by the recorded timestamps rather than by
+// how fast it runs.
+type Suppressor struct {
+	cooldown time.Duration
+	clock    clock.Clock
+
+	mutex    sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+func NewSuppressor(cooldown time.Duration, c clock.Clock) *Suppressor {
+	return &Suppressor{
+		cooldown: cooldown,
+		clock:    c,
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+// Filter returns the anomalies not suppressed and starts a new cooldown for
+// each of them. Replica divergences are keyed by pod as well, so two bad
+// replicas don't hide each other.
+func (s *Suppressor) Filter(anomalies []Anomaly) []Anomaly {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	var kept []Anomaly
+	for _, a := range anomalies {
+		key := suppressionKey(a)
+		if last, seen := s.lastSeen[key]; seen && now.Sub(last) < s.cooldown {
+			continue
+		}
+		s.lastSeen[key] = now
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+func suppressionKey(a Anomaly) string {
+	return a.Cluster + "/" + a.Namespace + "/" + a.ServiceName + "/" + string(a.Type) + "/" + a.Labels[PodLabel]
+}
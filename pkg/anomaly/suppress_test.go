@@ -0,0 +1,42 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/clock"
+)
+
+func TestSuppressor_CooldownFollowsClock(t *testing.T) {
+	simulated := clock.NewSimulated(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	suppressor := NewSuppressor(5*time.Minute, simulated)
+	errors := []Anomaly{{Type: ErrorRateHigh, ServiceName: "reviews", Namespace: "shop"}}
+
+	if kept := suppressor.Filter(errors); len(kept) != 1 {
+		t.Fatalf("Expected the first occurrence kept, got %d", len(kept))
+	}
+
+	simulated.Advance(4 * time.Minute)
+	if kept := suppressor.Filter(errors); len(kept) != 0 {
+		t.Errorf("Expected a repeat within the cooldown suppressed, got %d", len(kept))
+	}
+	if kept := suppressor.Filter([]Anomaly{{Type: TrafficSpike, ServiceName: "reviews", Namespace: "shop"}}); len(kept) != 1 {
+		t.Errorf("Expected another anomaly type kept, got %d", len(kept))
+	}
+
+	simulated.Advance(time.Minute)
+	if kept := suppressor.Filter(errors); len(kept) != 1 {
+		t.Errorf("Expected the repeat kept once the cooldown passed, got %d", len(kept))
+	}
+}
+
+func TestSuppressor_ReplicasKeyedByPod(t *testing.T) {
+	suppressor := NewSuppressor(time.Hour, clock.Real{})
+	kept := suppressor.Filter([]Anomaly{
+		{Type: ReplicaDivergence, ServiceName: "reviews", Labels: map[string]string{PodLabel: "reviews-a"}},
+		{Type: ReplicaDivergence, ServiceName: "reviews", Labels: map[string]string{PodLabel: "reviews-b"}},
+	})
+	if len(kept) != 2 {
+		t.Errorf("Expected both diverging pods kept, got %d", len(kept))
+	}
+}
@@ -0,0 +1,102 @@
+package anomaly
+
+import "sync"
+
+type Trend string
+
+const (
+	TrendWorsening Trend = "worsening"
+	TrendStable    Trend = "stable"
+	TrendImproving Trend = "improving"
+)
+
+const (
+	// Number of severity observations kept per (service, type)
+	trendHistorySize = 5
+	// Relative change in severity below which an anomaly is considered stable
+	trendTolerance = 0.1
+)
+
+// Arrow returns a compact symbol for the trend, suitable for terminal output.
+func (t Trend) Arrow() string {
+	switch t {
+	case TrendWorsening:
+		return "↑"
+	case TrendImproving:
+		return "↓"
+	case TrendStable:
+		return "→"
+	}
+	return ""
+}
+
+// TrendTracker keeps the recent severity history of each ongoing anomaly so
+// repeated detections can be annotated with whether they are escalating.
+type TrendTracker struct {
+	history map[string][]float64
+	mutex   sync.Mutex
+}
+
+func NewTrendTracker() *TrendTracker {
+	return &TrendTracker{
+		history: make(map[string][]float64),
+	}
+}
+
+// Observe records the severity of each anomaly and sets its Trend once the
+// (service, type) pair has been seen at least twice.
+func (tt *TrendTracker) Observe(anomalies []Anomaly) {
+	tt.mutex.Lock()
+	defer tt.mutex.Unlock()
+
+	for i := range anomalies {
+		key := trendKey(anomalies[i])
+
+		history := append(tt.history[key], anomalies[i].Severity)
+		if len(history) > trendHistorySize {
+			history = history[len(history)-trendHistorySize:]
+		}
+		tt.history[key] = history
+
+		anomalies[i].Trend = calculateTrend(history)
+	}
+}
+
+func trendKey(a Anomaly) string {
+	return a.Namespace + "/" + a.ServiceName + ":" + string(a.Type)
+}
+
+// calculateTrend fits a least-squares slope over the severity history and
+// compares the projected change across the window to the mean severity.
+func calculateTrend(history []float64) Trend {
+	n := len(history)
+	if n < 2 {
+		return ""
+	}
+
+	meanX := float64(n-1) / 2
+	meanY := 0.0
+	for _, v := range history {
+		meanY += v
+	}
+	meanY /= float64(n)
+
+	num, den := 0.0, 0.0
+	for i, v := range history {
+		dx := float64(i) - meanX
+		num += dx * (v - meanY)
+		den += dx * dx
+	}
+
+	if meanY == 0 {
+		return TrendStable
+	}
+
+	change := (num / den) * float64(n-1) / meanY
+	if change > trendTolerance {
+		return TrendWorsening
+	} else if change < -trendTolerance {
+		return TrendImproving
+	}
+	return TrendStable
+}
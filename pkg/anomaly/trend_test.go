@@ -0,0 +1,53 @@
+package anomaly
+
+import "testing"
+
+func observeSeverities(tracker *TrendTracker, severities []float64) Trend {
+	var trend Trend
+	for _, severity := range severities {
+		anomalies := []Anomaly{{Type: ErrorRateHigh, ServiceName: "reviews", Namespace: "shop", Severity: severity}}
+		tracker.Observe(anomalies)
+		trend = anomalies[0].Trend
+	}
+	return trend
+}
+
+func TestTrendTracker_Rising(t *testing.T) {
+	trend := observeSeverities(NewTrendTracker(), []float64{1.0, 1.5, 2.0, 2.6})
+	if trend != TrendWorsening {
+		t.Errorf("Expected trend %s, got %s", TrendWorsening, trend)
+	}
+}
+
+func TestTrendTracker_Flat(t *testing.T) {
+	trend := observeSeverities(NewTrendTracker(), []float64{2.0, 2.02, 1.98, 2.0})
+	if trend != TrendStable {
+		t.Errorf("Expected trend %s, got %s", TrendStable, trend)
+	}
+}
+
+func TestTrendTracker_Falling(t *testing.T) {
+	trend := observeSeverities(NewTrendTracker(), []float64{3.0, 2.4, 1.9, 1.2})
+	if trend != TrendImproving {
+		t.Errorf("Expected trend %s, got %s", TrendImproving, trend)
+	}
+}
+
+func TestTrendTracker_FirstObservation(t *testing.T) {
+	trend := observeSeverities(NewTrendTracker(), []float64{2.0})
+	if trend != "" {
+		t.Errorf("Expected no trend for a single observation, got %s", trend)
+	}
+}
+
+func TestTrendTracker_SeparatesServices(t *testing.T) {
+	tracker := NewTrendTracker()
+	observeSeverities(tracker, []float64{1.0, 2.0, 3.0})
+
+	anomalies := []Anomaly{{Type: ErrorRateHigh, ServiceName: "ratings", Namespace: "shop", Severity: 1.0}}
+	tracker.Observe(anomalies)
+
+	if anomalies[0].Trend != "" {
+		t.Errorf("Expected no trend for a different service, got %s", anomalies[0].Trend)
+	}
+}
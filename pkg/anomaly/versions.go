@@ -0,0 +1,57 @@
+package anomaly
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// versionDivergenceFactor is how many times the worst version's error
+	// rate must exceed the best one's to be called out
+	versionDivergenceFactor = 5.0
+	// versionDivergenceMin ignores divergence between rates that are both
+	// negligible, e.g. 0.05% against 0.01%
+	versionDivergenceMin = 0.01
+)
+
+// AttributeVersions adds a per-version error rate breakdown to the anomaly
+// when the versions of the service differ sharply, e.g. a failing canary
+// next to a healthy stable release. Rates are fractions keyed by version.
+// It reports whether the breakdown was attached.
+func (a *Anomaly) AttributeVersions(rates map[string]float64) bool {
+	if len(rates) < 2 {
+		return false
+	}
+
+	versions := make([]string, 0, len(rates))
+	for version := range rates {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if rates[versions[i]] != rates[versions[j]] {
+			return rates[versions[i]] > rates[versions[j]]
+		}
+		return versions[i] < versions[j]
+	})
+
+	worst, best := rates[versions[0]], rates[versions[len(versions)-1]]
+	if worst-best < versionDivergenceMin || worst < best*versionDivergenceFactor {
+		return false
+	}
+
+	if a.Metrics == nil {
+		a.Metrics = make(map[string]float64)
+	}
+	parts := make([]string, 0, len(versions))
+	for _, version := range versions {
+		a.Metrics["error_rate_"+version] = rates[version]
+		parts = append(parts, fmt.Sprintf("%s errors at %.1f%%", version, rates[version]*100))
+	}
+	if a.Labels == nil {
+		a.Labels = make(map[string]string)
+	}
+	a.Labels["worst_version"] = versions[0]
+	a.Description += "; " + strings.Join(parts, ", ")
+	return true
+}
@@ -0,0 +1,38 @@
+package anomaly
+
+import "testing"
+
+func TestAnomaly_AttributeVersions_Canary(t *testing.T) {
+	a := Anomaly{Type: ErrorRateHigh, ServiceName: "reviews", Description: "High error rate: 6.05%"}
+
+	if !a.AttributeVersions(map[string]float64{"v1": 0.001, "v2": 0.12}) {
+		t.Fatal("Expected the version breakdown to be attached")
+	}
+
+	expected := "High error rate: 6.05%; v2 errors at 12.0%, v1 errors at 0.1%"
+	if a.Description != expected {
+		t.Errorf("Expected %q, got %q", expected, a.Description)
+	}
+	if a.Labels["worst_version"] != "v2" {
+		t.Errorf("Expected worst version v2, got %q", a.Labels["worst_version"])
+	}
+	if a.Metrics["error_rate_v2"] != 0.12 {
+		t.Errorf("Expected v2 error rate 0.12, got %f", a.Metrics["error_rate_v2"])
+	}
+}
+
+func TestAnomaly_AttributeVersions_SimilarRates(t *testing.T) {
+	for name, rates := range map[string]map[string]float64{
+		"single version": {"v1": 0.2},
+		"both failing":   {"v1": 0.10, "v2": 0.12},
+		"both tiny":      {"v1": 0.0001, "v2": 0.002},
+	} {
+		a := Anomaly{Description: "High error rate"}
+		if a.AttributeVersions(rates) {
+			t.Errorf("%s: Expected no breakdown, got %q", name, a.Description)
+		}
+		if a.Description != "High error rate" {
+			t.Errorf("%s: Expected description unchanged, got %q", name, a.Description)
+		}
+	}
+}
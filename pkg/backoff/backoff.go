@@ -0,0 +1,57 @@
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// DefaultMaxInterval caps how far the interval grows during a failure
+// streak.
+const DefaultMaxInterval = 10 * time.Minute
+
+// Interval adapts a collection interval to failures: each consecutive
+// failed cycle doubles the wait, up to a cap, so a struggling API server
+// isn't hammered on a fixed schedule. The wait is jittered so several
+// instances backing off together don't retry in lockstep, and drops back to
+// the configured interval after the first successful cycle.
+type Interval struct {
+	base     time.Duration
+	max      time.Duration
+	failures int
+	// jitter returns a random fraction in [0, 1)
+	jitter func() float64
+}
+
+// NewInterval backs off from base up to max. A max below base disables
+// backoff.
+func NewInterval(base, max time.Duration) *Interval {
+	return &Interval{base: base, max: max, jitter: rand.Float64}
+}
+
+// Next records whether the last cycle succeeded and returns how long to wait
+// before the next one.
+func (i *Interval) Next(succeeded bool) time.Duration {
+	if succeeded {
+		i.failures = 0
+		return i.base
+	}
+	i.failures++
+
+	wait := i.base
+	for n := 0; n < i.failures && wait < i.max; n++ {
+		wait *= 2
+	}
+	if wait > i.max {
+		wait = max(i.max, i.base)
+	}
+
+	// Up to 20% either way, staying between the configured interval and
+	// the cap
+	jittered := wait + time.Duration((i.jitter()*0.4-0.2)*float64(wait))
+	return min(max(jittered, i.base), max(i.max, i.base))
+}
+
+// Failures is the length of the current failure streak.
+func (i *Interval) Failures() int {
+	return i.failures
+}
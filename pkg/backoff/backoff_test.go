@@ -0,0 +1,57 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInterval_GrowsOnFailuresAndRecovers(t *testing.T) {
+	interval := NewInterval(30*time.Second, 5*time.Minute)
+	interval.jitter = func() float64 { return 0.5 } // no jitter
+
+	var waits []time.Duration
+	for cycle := 0; cycle < 6; cycle++ {
+		waits = append(waits, interval.Next(false))
+	}
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i := range expected {
+		if waits[i] != expected[i] {
+			t.Errorf("Expected %v after %d failures, got %v", expected[i], i+1, waits[i])
+		}
+	}
+	if interval.Failures() != 6 {
+		t.Errorf("Expected a streak of 6 failures, got %d", interval.Failures())
+	}
+
+	if wait := interval.Next(true); wait != 30*time.Second {
+		t.Errorf("Expected the configured interval after a success, got %v", wait)
+	}
+	if wait := interval.Next(false); wait != time.Minute {
+		t.Errorf("Expected a new streak to start from the bottom, got %v", wait)
+	}
+}
+
+func TestInterval_JitterStaysWithinBounds(t *testing.T) {
+	for _, jitter := range []float64{0, 0.999} {
+		interval := NewInterval(30*time.Second, 5*time.Minute)
+		interval.jitter = func() float64 { return jitter }
+
+		if wait := interval.Next(false); wait < 48*time.Second || wait > 72*time.Second {
+			t.Errorf("Expected 1m ±20%%, got %v", wait)
+		}
+		for cycle := 0; cycle < 10; cycle++ {
+			if wait := interval.Next(false); wait > 5*time.Minute || wait < 30*time.Second {
+				t.Errorf("Expected the wait between 30s and the 5m cap, got %v", wait)
+			}
+		}
+	}
+}
+
+func TestInterval_NoBackoffWhenCapBelowBase(t *testing.T) {
+	interval := NewInterval(time.Minute, 0)
+	for cycle := 0; cycle < 3; cycle++ {
+		if wait := interval.Next(false); wait != time.Minute {
+			t.Errorf("Expected the configured interval, got %v", wait)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package bundle
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/istio"
+	"smanalyzer/pkg/ml"
+	"smanalyzer/pkg/timeseries"
+)
+
+// File names inside a bundle directory
+const (
+	ConfigFile     = "config.json"
+	MetricsFile    = "metrics.json"
+	TimeSeriesFile = "timeseries.json"
+	BaselineFile   = "baseline.json"
+	AnomaliesFile  = "anomalies.json"
+)
+
+const redactedIP = "REDACTED"
+
+// Bundle is everything needed to reproduce a scan's detection offline.
+type Bundle struct {
+	Config    *config.Config
+	Metrics   []*istio.ServiceMeshMetrics
+	Series    []*timeseries.TimeSeries
+	Baselines map[string][]ml.Cluster
+	Anomalies []anomaly.Anomaly
+}
+
+type Options struct {
+	// RedactIPs replaces pod source/destination IPs in access logs
+	RedactIPs bool
+}
+
+// Write creates dir if needed and writes one JSON file per bundle component.
+func Write(dir string, b *Bundle, opts Options) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+
+	metrics := b.Metrics
+	if opts.RedactIPs {
+		metrics = redactIPs(metrics)
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{ConfigFile, b.Config},
+		{MetricsFile, metrics},
+		{TimeSeriesFile, b.Series},
+		{BaselineFile, b.Baselines},
+		{AnomaliesFile, b.Anomalies},
+	}
+
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.data, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %w", file.name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file.name), data, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+	}
+
+	return nil
+}
+
+// Read loads a bundle previously written with Write.
+func Read(dir string) (*Bundle, error) {
+	b := &Bundle{}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{ConfigFile, &b.Config},
+		{MetricsFile, &b.Metrics},
+		{TimeSeriesFile, &b.Series},
+		{BaselineFile, &b.Baselines},
+		{AnomaliesFile, &b.Anomalies},
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(dir, file.name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.name, err)
+		}
+		if err := json.Unmarshal(data, file.data); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file.name, err)
+		}
+	}
+
+	if b.Config == nil {
+		b.Config = config.DefaultConfig()
+	}
+
+	return b, nil
+}
+
+// Replay runs detection over the bundled time series using the bundled
+// config and baseline model, and returns the resulting anomalies.
+func Replay(b *Bundle) ([]anomaly.Anomaly, error) {
+	cfg := b.Config
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+
+	storage := timeseries.NewStorage()
+	storage.Restore(b.Series)
+
+	detector := anomaly.NewDetector(cfg.ToAnomalyDetectionConfig(), ml.NewClusteringEngine(cfg.ToMLConfig()))
+	for service, clusters := range b.Baselines {
+		detector.SetBaseline(service, clusters)
+	}
+
+	// Replay services in a fixed order so results are comparable across runs
+	serviceSet := make(map[string]bool)
+	for _, series := range b.Series {
+		serviceSet[series.ServiceName] = true
+	}
+	services := make([]string, 0, len(serviceSet))
+	for service := range serviceSet {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	var anomalies []anomaly.Anomaly
+	for _, service := range services {
+		found, err := detector.DetectFromStorage(storage, service)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay %s: %w", service, err)
+		}
+		anomalies = append(anomalies, found...)
+	}
+
+	return anomalies, nil
+}
+
+func redactIPs(metrics []*istio.ServiceMeshMetrics) []*istio.ServiceMeshMetrics {
+	redacted := make([]*istio.ServiceMeshMetrics, len(metrics))
+	for i, m := range metrics {
+		copied := *m
+		copied.AccessLogs = make([]istio.AccessLogEntry, len(m.AccessLogs))
+		for j, entry := range m.AccessLogs {
+			if entry.SourceIP != "" {
+				entry.SourceIP = redactedIP
+			}
+			if entry.DestinationIP != "" {
+				entry.DestinationIP = redactedIP
+			}
+			copied.AccessLogs[j] = entry
+		}
+		redacted[i] = &copied
+	}
+	return redacted
+}
@@ -0,0 +1,105 @@
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/istio"
+	"smanalyzer/pkg/timeseries"
+)
+
+func newSpikeBundle(t *testing.T) *Bundle {
+	storage := timeseries.NewStorage()
+	for _, v := range []float64{10, 10, 10, 10, 10, 50, 50, 50} {
+		storage.Store("reviews", "request_count", v, map[string]string{})
+	}
+
+	b := &Bundle{
+		Config: config.DefaultConfig(),
+		Metrics: []*istio.ServiceMeshMetrics{{
+			ServiceName: "reviews",
+			Namespace:   "shop",
+			AccessLogs:  []istio.AccessLogEntry{{SourceIP: "10.0.0.1", DestinationIP: "10.0.0.2"}},
+		}},
+		Series: storage.Snapshot(),
+	}
+
+	anomalies, err := Replay(b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(anomalies) == 0 {
+		t.Fatal("Expected the spike series to produce anomalies")
+	}
+	b.Anomalies = anomalies
+
+	return b
+}
+
+func TestBundle_WriteContainsExpectedFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := Write(dir, newSpikeBundle(t), Options{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, name := range []string{ConfigFile, MetricsFile, TimeSeriesFile, BaselineFile, AnomaliesFile} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected bundle to contain %s: %v", name, err)
+		}
+	}
+}
+
+func TestBundle_ReplaysToSameAnomalies(t *testing.T) {
+	dir := t.TempDir()
+	original := newSpikeBundle(t)
+	if err := Write(dir, original, Options{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	loaded, err := Read(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	replayed, err := Replay(loaded)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(replayed) != len(loaded.Anomalies) {
+		t.Fatalf("Expected %d replayed anomalies, got %d", len(loaded.Anomalies), len(replayed))
+	}
+
+	for i := range replayed {
+		assertSameAnomaly(t, loaded.Anomalies[i], replayed[i])
+	}
+}
+
+func TestBundle_RedactIPs(t *testing.T) {
+	dir := t.TempDir()
+	if err := Write(dir, newSpikeBundle(t), Options{RedactIPs: true}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	loaded, err := Read(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	entry := loaded.Metrics[0].AccessLogs[0]
+	if entry.SourceIP != redactedIP || entry.DestinationIP != redactedIP {
+		t.Errorf("Expected IPs to be redacted, got %s -> %s", entry.SourceIP, entry.DestinationIP)
+	}
+}
+
+func assertSameAnomaly(t *testing.T, expected, actual anomaly.Anomaly) {
+	t.Helper()
+	if expected.Type != actual.Type || expected.ServiceName != actual.ServiceName || expected.Severity != actual.Severity {
+		t.Errorf("Expected %s/%s severity %.2f, got %s/%s severity %.2f",
+			expected.ServiceName, expected.Type, expected.Severity,
+			actual.ServiceName, actual.Type, actual.Severity)
+	}
+}
@@ -0,0 +1,51 @@
+// Package clock abstracts the current time so time-dependent logic can be
+// driven by recorded timestamps during replay, or pinned in tests.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock reports the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the wall clock.
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// Simulated is a clock that only moves when told to, e.g. to the timestamp
+// of each replayed scan.
+type Simulated struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewSimulated(start time.Time) *Simulated {
+	return &Simulated{now: start}
+}
+
+func (s *Simulated) Now() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.now
+}
+
+// Set moves the clock to t, backwards included.
+func (s *Simulated) Set(t time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.now = t
+}
+
+// Advance moves the clock forward by d.
+func (s *Simulated) Advance(d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.now = s.now.Add(d)
+}
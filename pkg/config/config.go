@@ -1,9 +1,18 @@
 package config
 
 import (
+	"fmt"
 	"time"
 	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/health"
+	"smanalyzer/pkg/istio"
 	"smanalyzer/pkg/ml"
+	"smanalyzer/pkg/notify"
+	"smanalyzer/pkg/output"
+	"smanalyzer/pkg/timeseries"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
 )
 
 type Config struct {
@@ -11,12 +20,43 @@ type Config struct {
 	Detection  DetectionConfig  `yaml:"detection"`
 	Clustering ClusteringConfig `yaml:"clustering"`
 	Output     OutputConfig     `yaml:"output"`
+	Health     health.Weights   `yaml:"health_weights"`
+	Storage    StorageConfig    `yaml:"storage"`
+	History    HistoryConfig    `yaml:"history"`
+	Notify     NotifyConfig     `yaml:"notify"`
+	// HTTP configures outbound calls to webhooks and OTLP collectors
+	HTTP istio.HTTPClientConfig `yaml:"http"`
+	// DescriptionTemplates override anomaly descriptions by anomaly type
+	// with Go text/templates; see anomaly.DescriptionData for the fields.
+	DescriptionTemplates map[string]string `yaml:"description_templates"`
+	// MetricMapping reads golden signals such as requests or latency_p99
+	// from custom metric names; see istio.MappableSignals for the keys.
+	MetricMapping istio.MetricMapping `yaml:"metric_mapping"`
 }
 
 type KubernetesConfig struct {
 	Namespace    string        `yaml:"namespace"`
 	LabelSelector string       `yaml:"label_selector"`
 	Timeout      time.Duration `yaml:"timeout"`
+	// PodSelection picks the replica scraped for each service: first,
+	// random, highest-traffic or round-robin
+	PodSelection string `yaml:"pod_selection"`
+	// ReplicaCheck scrapes every replica to flag one diverging from its
+	// siblings, at one extra scrape per replica
+	ReplicaCheck bool `yaml:"replica_check"`
+	// MaxPodsPerService caps the replicas the replica check scrapes,
+	// sampled evenly, with the service totals extrapolated; zero scrapes
+	// them all
+	MaxPodsPerService int `yaml:"max_pods_per_service"`
+	// CardinalityLimit warns when a scraped metric family has more
+	// series than this; zero disables the check
+	CardinalityLimit int `yaml:"cardinality_limit"`
+	// LatencyQuantiles are collected besides P50, P90, P95 and P99 into
+	// latency.quantiles, e.g. [p99.9]
+	LatencyQuantiles []string `yaml:"latency_quantiles"`
+	// MaxLatency drops a scrape's latency percentiles when one exceeds
+	// it, as a corrupted histogram would; zero disables the cap
+	MaxLatency time.Duration `yaml:"max_latency"`
 }
 
 type DetectionConfig struct {
@@ -25,37 +65,127 @@ type DetectionConfig struct {
 	LatencyThreshold      time.Duration `yaml:"latency_threshold"`
 	RetryThreshold        int64         `yaml:"retry_threshold"`
 	TimeoutThreshold      int64         `yaml:"timeout_threshold"`
-	WindowSize           int           `yaml:"window_size"`
+	// OutlierEjectionThreshold flags this many upstream hosts ejected by
+	// outlier detection, now or since the previous scan; zero disables it
+	OutlierEjectionThreshold int64 `yaml:"outlier_ejection_threshold"`
+	// ConnFailureSpikeFactor flags connection failures this many times
+	// their recent mean; zero disables it
+	ConnFailureSpikeFactor float64 `yaml:"conn_failure_spike_factor"`
+	// PayloadSizeFactor flags mean request or response sizes this many
+	// times above or below their recent mean; one or less disables it
+	PayloadSizeFactor float64 `yaml:"payload_size_factor"`
+	// BreakerRemainingFraction warns when a circuit breaker has less than
+	// this fraction of its threshold left; zero disables the warning
+	BreakerRemainingFraction float64 `yaml:"circuit_breaker_remaining_fraction"`
+	// Lookback is how many recent points the static detectors consider;
+	// the clustering feature window is clustering.window_size
+	Lookback             int           `yaml:"lookback"`
+	// ConsecutiveBreaches is how many of the latest points must breach the
+	// error rate or latency thresholds to raise an anomaly
+	ConsecutiveBreaches  int           `yaml:"consecutive_breaches"`
 	SensitivityLevel     float64       `yaml:"sensitivity_level"`
+	PerClusterThreshold  bool          `yaml:"per_cluster_threshold"`
+	ErrorRateSource      string        `yaml:"error_rate_source"`
+	MinRequestVolume     float64       `yaml:"min_request_volume"`
+	TailLatencyFactor    float64       `yaml:"tail_latency_factor"`
+	TailSpikeThreshold   float64       `yaml:"tail_spike_threshold"`
+	ThresholdFloor       float64       `yaml:"threshold_floor"`
+	// PercentChangeRules flag any stored metric moving more than threshold
+	// percent from the mean of its previous window points
+	PercentChangeRules []anomaly.PercentChangeRule `yaml:"percent_change_rules"`
+	// AlertRules raise an anomaly when a stored metric meets a condition,
+	// optionally for a sustained duration and on one service
+	AlertRules []anomaly.AlertRule `yaml:"alert_rules"`
+	// MinReplicas downgrades (or with LowReplicaAction suppress, drops)
+	// anomalies for services running fewer pods; zero disables it
+	MinReplicas      int    `yaml:"min_replicas"`
+	LowReplicaAction string `yaml:"low_replica_action"`
+	// LatencySLO flags P99 above each service's latency target instead of
+	// relying on one mesh-wide latency_threshold
+	LatencySLO bool `yaml:"latency_slo"`
+	// Services overrides detection settings per service, keyed by service,
+	// namespace/service or cluster/namespace/service
+	Services map[string]anomaly.ServiceOverride `yaml:"services"`
+}
+
+type StorageConfig struct {
+	// Compaction downsamples old points when the time series file is
+	// saved; an empty list keeps every raw point
+	Compaction []timeseries.CompactionTier `yaml:"compaction"`
+	// Labels names the collection labels kept on each stored point, e.g.
+	// app, version, namespace, cluster, workload or pod
+	Labels []string `yaml:"labels"`
+}
+
+type HistoryConfig struct {
+	// HalfLife is how quickly past anomalies fade from a service's recent
+	// trouble score
+	HalfLife time.Duration `yaml:"half_life"`
+}
+
+type NotifyConfig struct {
+	// WebhookURL receives anomaly notifications as Slack-compatible JSON;
+	// empty disables notifications
+	WebhookURL string `yaml:"webhook_url"`
+	// Mode is "anomaly" for a message per anomaly or "batch" for one
+	// digest per scan
+	Mode string `yaml:"mode"`
+	// Link is added to batch digests to point at the full details
+	Link string `yaml:"link"`
 }
 
 type ClusteringConfig struct {
 	K           int     `yaml:"k"`
 	MaxIter     int     `yaml:"max_iter"`
 	Tolerance   float64 `yaml:"tolerance"`
+	// WindowSize is how many consecutive points each feature is computed
+	// over, both when learning a baseline and when scoring against it
 	WindowSize  int     `yaml:"window_size"`
+	// Features names the registered ml features to cluster on; empty uses
+	// ml.DefaultFeatures
+	Features    []string `yaml:"features"`
+	// FeatureWeights scales each feature's share of the distance between
+	// points, in Features order; empty weighs them all equally
+	FeatureWeights []float64 `yaml:"feature_weights"`
 }
 
 type OutputConfig struct {
 	Format  string `yaml:"format"`
 	Verbose bool   `yaml:"verbose"`
+	// Columns picks and orders the table format's columns; empty keeps the
+	// full layout
+	Columns []string `yaml:"columns"`
 }
 
 func DefaultConfig() *Config {
 	return &Config{
 		Kubernetes: KubernetesConfig{
-			Namespace:     "",
-			LabelSelector: "app",
-			Timeout:       30 * time.Second,
+			Namespace:        "",
+			LabelSelector:    "app",
+			Timeout:          30 * time.Second,
+			PodSelection:     string(istio.PodSelectFirst),
+			CardinalityLimit: istio.DefaultCardinalityLimit,
+			MaxLatency:       istio.DefaultMaxLatency,
 		},
 		Detection: DetectionConfig{
-			TrafficSpikeThreshold: 2.0,
-			ErrorRateThreshold:    0.05,
-			LatencyThreshold:      1 * time.Second,
-			RetryThreshold:        100,
-			TimeoutThreshold:      10,
-			WindowSize:           10,
-			SensitivityLevel:     2.0,
+			TrafficSpikeThreshold:    2.0,
+			ErrorRateThreshold:       0.05,
+			LatencyThreshold:         1 * time.Second,
+			RetryThreshold:           100,
+			TimeoutThreshold:         10,
+			OutlierEjectionThreshold: 1,
+			ConnFailureSpikeFactor:   3.0,
+			PayloadSizeFactor:        3.0,
+			BreakerRemainingFraction: 0.2,
+			Lookback:                 anomaly.DefaultLookback,
+			ConsecutiveBreaches:      1,
+			SensitivityLevel:         2.0,
+			ErrorRateSource:          "effective",
+			MinRequestVolume:         20,
+			TailLatencyFactor:        10.0,
+			TailSpikeThreshold:       2.0,
+			ThresholdFloor:           0.01,
+			LowReplicaAction:         anomaly.LowReplicaDowngrade,
 		},
 		Clustering: ClusteringConfig{
 			K:          3,
@@ -67,19 +197,150 @@ func DefaultConfig() *Config {
 			Format:  "text",
 			Verbose: false,
 		},
+		Health: health.DefaultWeights(),
+		Storage: StorageConfig{
+			Compaction: timeseries.DefaultCompactionPolicy().Tiers,
+			Labels:     istio.DefaultStoredLabels(),
+		},
+		History: HistoryConfig{
+			HalfLife: anomaly.DefaultHalfLife,
+		},
+		Notify: NotifyConfig{
+			Mode: string(notify.PerAnomaly),
+		},
+		HTTP: istio.DefaultHTTPClientConfig(),
+	}
+}
+
+// Load overlays the settings read by v onto the defaults and validates the
+// result. Keys use the yaml names, e.g. detection.error_rate_threshold.
+func Load(v *viper.Viper) (*Config, error) {
+	c := DefaultConfig()
+	if v.IsSet("detection.window_size") {
+		return nil, fmt.Errorf("detection.window_size has been split: set clustering.window_size for the feature window and detection.lookback for the points static detectors consider")
+	}
+	if err := v.Unmarshal(c, func(dc *mapstructure.DecoderConfig) { dc.TagName = "yaml" }); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate reports settings that would otherwise only fail at scan time.
+func (c *Config) Validate() error {
+	if _, err := c.ToDescriptionTemplates(); err != nil {
+		return err
+	}
+	if err := ml.ValidateFeatures(c.Clustering.Features); err != nil {
+		return fmt.Errorf("invalid clustering features: %w", err)
+	}
+	if err := ml.ValidateFeatureWeights(c.Clustering.FeatureWeights, c.Clustering.Features); err != nil {
+		return fmt.Errorf("invalid clustering.feature_weights: %w", err)
+	}
+	if _, err := istio.ParsePodSelectionStrategy(c.Kubernetes.PodSelection); err != nil {
+		return err
 	}
+	if err := istio.ValidateMaxPodsPerService(c.Kubernetes.MaxPodsPerService); err != nil {
+		return err
+	}
+	if _, err := istio.ParseQuantiles(c.Kubernetes.LatencyQuantiles); err != nil {
+		return err
+	}
+	if c.Kubernetes.MaxLatency < 0 {
+		return fmt.Errorf("kubernetes.max_latency must not be negative, got %v", c.Kubernetes.MaxLatency)
+	}
+	for _, rule := range c.Detection.PercentChangeRules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, rule := range c.Detection.AlertRules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := anomaly.ValidateLowReplicaAction(c.Detection.LowReplicaAction); err != nil {
+		return err
+	}
+	if c.Clustering.WindowSize < 1 {
+		return fmt.Errorf("clustering.window_size must be at least 1, got %d", c.Clustering.WindowSize)
+	}
+	if c.Detection.Lookback < 0 {
+		return fmt.Errorf("detection.lookback must not be negative, got %d", c.Detection.Lookback)
+	}
+	if n := c.Detection.ConsecutiveBreaches; n < 0 || (c.Detection.Lookback > 0 && n > c.Detection.Lookback) {
+		return fmt.Errorf("detection.consecutive_breaches must be between 0 and detection.lookback (%d), got %d", c.Detection.Lookback, n)
+	}
+	if c.Detection.OutlierEjectionThreshold < 0 {
+		return fmt.Errorf("detection.outlier_ejection_threshold must not be negative, got %d", c.Detection.OutlierEjectionThreshold)
+	}
+	if f := c.Detection.BreakerRemainingFraction; f < 0 || f >= 1 {
+		return fmt.Errorf("circuit_breaker_remaining_fraction must be at least 0 and below 1, got %v", f)
+	}
+	if err := output.ValidateColumns(c.Output.Columns); err != nil {
+		return err
+	}
+	if _, err := notify.ParseMode(c.Notify.Mode); err != nil {
+		return err
+	}
+	if err := c.HTTP.Validate(); err != nil {
+		return err
+	}
+	if err := c.MetricMapping.Validate(); err != nil {
+		return err
+	}
+	for service, override := range c.Detection.Services {
+		if err := override.Validate(service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ToDescriptionTemplates parses the configured description templates over
+// the defaults.
+func (c *Config) ToDescriptionTemplates() (anomaly.DescriptionTemplates, error) {
+	return anomaly.ParseDescriptionTemplates(c.DescriptionTemplates)
 }
 
 func (c *Config) ToAnomalyDetectionConfig() anomaly.DetectionConfig {
 	return anomaly.DetectionConfig{
-		TrafficSpikeThreshold: c.Detection.TrafficSpikeThreshold,
-		ErrorRateThreshold:    c.Detection.ErrorRateThreshold,
-		LatencyThreshold:      c.Detection.LatencyThreshold,
-		RetryThreshold:        c.Detection.RetryThreshold,
-		TimeoutThreshold:      c.Detection.TimeoutThreshold,
-		WindowSize:           c.Detection.WindowSize,
-		SensitivityLevel:     c.Detection.SensitivityLevel,
+		TrafficSpikeThreshold:    c.Detection.TrafficSpikeThreshold,
+		ErrorRateThreshold:       c.Detection.ErrorRateThreshold,
+		LatencyThreshold:         c.Detection.LatencyThreshold,
+		RetryThreshold:           c.Detection.RetryThreshold,
+		TimeoutThreshold:         c.Detection.TimeoutThreshold,
+		OutlierEjectionThreshold: c.Detection.OutlierEjectionThreshold,
+		ConnFailureSpikeFactor:   c.Detection.ConnFailureSpikeFactor,
+		PayloadSizeFactor:        c.Detection.PayloadSizeFactor,
+		BreakerRemainingFraction: c.Detection.BreakerRemainingFraction,
+		FeatureWindow:            c.Clustering.WindowSize,
+		Lookback:                 c.Detection.Lookback,
+		ConsecutiveBreaches:      c.Detection.ConsecutiveBreaches,
+		SensitivityLevel:         c.Detection.SensitivityLevel,
+		PerClusterThreshold:      c.Detection.PerClusterThreshold,
+		ErrorRateSource:          c.Detection.ErrorRateSource,
+		MinRequestVolume:         c.Detection.MinRequestVolume,
+		TailLatencyFactor:        c.Detection.TailLatencyFactor,
+		TailSpikeThreshold:       c.Detection.TailSpikeThreshold,
+		ThresholdFloor:           c.Detection.ThresholdFloor,
+		PercentChangeRules:       c.Detection.PercentChangeRules,
+		AlertRules:               c.Detection.AlertRules,
+		MinReplicas:              c.Detection.MinReplicas,
+		LowReplicaAction:         c.Detection.LowReplicaAction,
+		LatencySLO:               c.Detection.LatencySLO,
+		ServiceOverrides:         c.Detection.Services,
+	}
+}
+
+// ToCompactionPolicy returns nil when compaction is disabled.
+func (c *Config) ToCompactionPolicy() *timeseries.CompactionPolicy {
+	if len(c.Storage.Compaction) == 0 {
+		return nil
 	}
+	return &timeseries.CompactionPolicy{Tiers: c.Storage.Compaction}
 }
 
 func (c *Config) ToMLConfig() ml.KMeansConfig {
@@ -87,5 +348,9 @@ func (c *Config) ToMLConfig() ml.KMeansConfig {
 		K:         c.Clustering.K,
 		MaxIter:   c.Clustering.MaxIter,
 		Tolerance: c.Clustering.Tolerance,
+		Features:  c.Clustering.Features,
+		// The detector scores against the engine's distance, so these
+		// apply to detection as well as clustering
+		FeatureWeights: c.Clustering.FeatureWeights,
 	}
 }
\ No newline at end of file
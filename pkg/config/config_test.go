@@ -0,0 +1,486 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+
+	"github.com/spf13/viper"
+)
+
+func loadYAML(t *testing.T, yaml string) (*Config, error) {
+	t.Helper()
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(yaml)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return Load(v)
+}
+
+func TestLoad_OverlaysDefaults(t *testing.T) {
+	c, err := loadYAML(t, `
+detection:
+  error_rate_threshold: 0.1
+description_templates:
+  error_rate_high: "{{.Service}} failing - see https://runbooks.example.com/errors"
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if c.Detection.ErrorRateThreshold != 0.1 {
+		t.Errorf("Expected error rate threshold 0.1, got %f", c.Detection.ErrorRateThreshold)
+	}
+	if c.Detection.TrafficSpikeThreshold != 2.0 {
+		t.Errorf("Expected default traffic spike threshold 2.0, got %f", c.Detection.TrafficSpikeThreshold)
+	}
+
+	templates, err := c.ToDescriptionTemplates()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got := templates.Describe(anomaly.Anomaly{Type: anomaly.ErrorRateHigh, ServiceName: "reviews"})
+	if got != "reviews failing - see https://runbooks.example.com/errors" {
+		t.Errorf("Expected the configured template to render, got %q", got)
+	}
+}
+
+func TestLoad_InvalidDescriptionTemplate(t *testing.T) {
+	_, err := loadYAML(t, `
+description_templates:
+  traffic_spike: "{{.Service"
+`)
+	if err == nil {
+		t.Error("Expected an error for an invalid description template")
+	}
+}
+
+func TestConfigureEnv_OverridesSettings(t *testing.T) {
+	t.Setenv("SMANALYZER_DETECTION_ERROR_RATE_THRESHOLD", "0.2")
+	t.Setenv("SMANALYZER_DETECTION_LATENCY_THRESHOLD", "250ms")
+	t.Setenv("SMANALYZER_HEALTH_WEIGHTS_ERRORS", "0.9")
+	t.Setenv("SMANALYZER_OUTPUT_FORMAT", "json")
+
+	v := viper.New()
+	ConfigureEnv(v)
+	c, err := Load(v)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if c.Detection.ErrorRateThreshold != 0.2 {
+		t.Errorf("Expected error rate threshold 0.2, got %f", c.Detection.ErrorRateThreshold)
+	}
+	if c.Detection.LatencyThreshold != 250*time.Millisecond {
+		t.Errorf("Expected latency threshold 250ms, got %v", c.Detection.LatencyThreshold)
+	}
+	if c.Health.Errors != 0.9 {
+		t.Errorf("Expected errors weight 0.9, got %f", c.Health.Errors)
+	}
+	if c.Output.Format != "json" {
+		t.Errorf("Expected output format json, got %s", c.Output.Format)
+	}
+	if c.Detection.TrafficSpikeThreshold != 2.0 {
+		t.Errorf("Expected unset settings to keep their defaults, got %f", c.Detection.TrafficSpikeThreshold)
+	}
+}
+
+func TestConfigureEnv_Lists(t *testing.T) {
+	t.Setenv("SMANALYZER_CLUSTERING_FEATURES", "mean,trend")
+	t.Setenv("SMANALYZER_CLUSTERING_FEATURE_WEIGHTS", "2,0.5")
+	t.Setenv("SMANALYZER_OUTPUT_COLUMNS", "service,severity")
+
+	v := viper.New()
+	ConfigureEnv(v)
+	c, err := Load(v)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(c.Clustering.Features, []string{"mean", "trend"}) {
+		t.Errorf("Expected features [mean trend], got %v", c.Clustering.Features)
+	}
+	if !reflect.DeepEqual(c.Clustering.FeatureWeights, []float64{2, 0.5}) {
+		t.Errorf("Expected feature weights [2 0.5], got %v", c.Clustering.FeatureWeights)
+	}
+	if !reflect.DeepEqual(c.Output.Columns, []string{"service", "severity"}) {
+		t.Errorf("Expected columns [service severity], got %v", c.Output.Columns)
+	}
+	if len(c.Storage.Labels) == 0 {
+		t.Error("Expected unset lists to keep their defaults")
+	}
+}
+
+func TestLoad_CompactionTiers(t *testing.T) {
+	c, err := loadYAML(t, `
+storage:
+  compaction:
+    - after: 1h
+      resolution: 1m
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	policy := c.ToCompactionPolicy()
+	if policy == nil || len(policy.Tiers) != 1 {
+		t.Fatalf("Expected 1 compaction tier, got %+v", policy)
+	}
+	if policy.Tiers[0].After != time.Hour || policy.Tiers[0].Resolution != time.Minute {
+		t.Errorf("Expected 1h/1m tier, got %+v", policy.Tiers[0])
+	}
+
+	disabled, _ := loadYAML(t, `
+storage:
+  compaction: []
+`)
+	if disabled.ToCompactionPolicy() != nil {
+		t.Error("Expected an empty tier list to disable compaction")
+	}
+}
+
+func TestLoad_ClusteringFeatures(t *testing.T) {
+	c, err := loadYAML(t, `
+clustering:
+  features: [mean, skewness]
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if features := c.ToMLConfig().Features; len(features) != 2 || features[1] != "skewness" {
+		t.Errorf("Expected [mean skewness], got %v", features)
+	}
+
+	if _, err := loadYAML(t, `
+clustering:
+  features: [median]
+`); err == nil {
+		t.Error("Expected an error for an unknown feature")
+	}
+}
+
+func TestLoad_AlertRules(t *testing.T) {
+	c, err := loadYAML(t, `
+detection:
+  alert_rules:
+    - {metric: error_rate, op: ">", value: 0.05, for: 2m, severity: high, service: checkout}
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rules := c.ToAnomalyDetectionConfig().AlertRules
+	if len(rules) != 1 || rules[0].Op != ">" || rules[0].Value != 0.05 || rules[0].For != 2*time.Minute || rules[0].Service != "checkout" {
+		t.Errorf("Expected the checkout error rate rule, got %+v", rules)
+	}
+
+	_, err = loadYAML(t, `
+detection:
+  alert_rules:
+    - {metric: error_rate, op: "~", value: 0.05}
+`)
+	if err == nil {
+		t.Error("Expected an error for an unknown op")
+	}
+}
+
+func TestLoad_ClusteringFeatureWeights(t *testing.T) {
+	c, err := loadYAML(t, `
+clustering:
+  features: [mean, trend, volatility]
+  feature_weights: [2, 2, 0.5]
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if weights := c.ToMLConfig().FeatureWeights; len(weights) != 3 || weights[2] != 0.5 {
+		t.Errorf("Expected [2 2 0.5], got %v", weights)
+	}
+
+	if _, err := loadYAML(t, `
+clustering:
+  feature_weights: [1, 1]
+`); err == nil {
+		t.Error("Expected an error for fewer weights than the default features")
+	}
+	if _, err := loadYAML(t, `
+clustering:
+  feature_weights: [1, -1, 1, 1]
+`); err == nil {
+		t.Error("Expected an error for a negative weight")
+	}
+}
+
+func TestLoad_DetectionWindows(t *testing.T) {
+	c, err := loadYAML(t, `
+clustering:
+  window_size: 5
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	detection := c.ToAnomalyDetectionConfig()
+	if detection.FeatureWindow != 5 || detection.Lookback != anomaly.DefaultLookback {
+		t.Errorf("Expected feature window 5 with the default lookback, got %d and %d", detection.FeatureWindow, detection.Lookback)
+	}
+
+	c, err = loadYAML(t, `
+detection:
+  lookback: 20
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	detection = c.ToAnomalyDetectionConfig()
+	if detection.FeatureWindow != 10 || detection.Lookback != 20 {
+		t.Errorf("Expected the default feature window with lookback 20, got %d and %d", detection.FeatureWindow, detection.Lookback)
+	}
+
+	if _, err := loadYAML(t, `
+detection:
+  window_size: 10
+`); err == nil || !strings.Contains(err.Error(), "clustering.window_size") {
+		t.Errorf("Expected the removed detection.window_size to point at its replacements, got %v", err)
+	}
+}
+
+func TestLoad_ConsecutiveBreaches(t *testing.T) {
+	c, err := loadYAML(t, `
+detection:
+  consecutive_breaches: 3
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := c.ToAnomalyDetectionConfig().ConsecutiveBreaches; n != 3 {
+		t.Errorf("Expected 3 consecutive breaches, got %d", n)
+	}
+
+	if _, err := loadYAML(t, `
+detection:
+  lookback: 5
+  consecutive_breaches: 6
+`); err == nil {
+		t.Error("Expected an error for more consecutive breaches than the lookback holds")
+	}
+}
+
+func TestLoad_OutlierEjectionThreshold(t *testing.T) {
+	c, err := loadYAML(t, `
+detection:
+  outlier_ejection_threshold: 0
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := c.ToAnomalyDetectionConfig().OutlierEjectionThreshold; n != 0 {
+		t.Errorf("Expected outlier ejection detection disabled, got threshold %d", n)
+	}
+	if n := DefaultConfig().ToAnomalyDetectionConfig().OutlierEjectionThreshold; n != 1 {
+		t.Errorf("Expected any ejection to be flagged by default, got threshold %d", n)
+	}
+
+	if _, err := loadYAML(t, `
+detection:
+  outlier_ejection_threshold: -1
+`); err == nil {
+		t.Error("Expected an error for a negative outlier ejection threshold")
+	}
+}
+
+func TestLoad_MaxLatency(t *testing.T) {
+	c, err := loadYAML(t, `
+kubernetes:
+  max_latency: 30s
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c.Kubernetes.MaxLatency != 30*time.Second {
+		t.Errorf("Expected a 30s latency cap, got %v", c.Kubernetes.MaxLatency)
+	}
+	if max := DefaultConfig().Kubernetes.MaxLatency; max != time.Hour {
+		t.Errorf("Expected a 1h cap by default, got %v", max)
+	}
+
+	if _, err := loadYAML(t, `
+kubernetes:
+  max_latency: -1s
+`); err == nil {
+		t.Error("Expected an error for a negative latency cap")
+	}
+}
+
+func TestLoad_HistoryHalfLife(t *testing.T) {
+	c, err := loadYAML(t, `
+history:
+  half_life: 6h
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c.History.HalfLife != 6*time.Hour {
+		t.Errorf("Expected half-life 6h, got %v", c.History.HalfLife)
+	}
+	if DefaultConfig().History.HalfLife != anomaly.DefaultHalfLife {
+		t.Errorf("Expected default half-life %v, got %v", anomaly.DefaultHalfLife, DefaultConfig().History.HalfLife)
+	}
+}
+
+func TestLoad_HTTPClient(t *testing.T) {
+	c, err := loadYAML(t, `
+http:
+  timeout: 3s
+  proxy_url: http://proxy.example:3128
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c.HTTP.Timeout != 3*time.Second {
+		t.Errorf("Expected HTTP timeout 3s, got %v", c.HTTP.Timeout)
+	}
+	if c.HTTP.ProxyURL != "http://proxy.example:3128" {
+		t.Errorf("Expected the configured proxy_url, got %q", c.HTTP.ProxyURL)
+	}
+}
+
+func TestLoad_InvalidPodSelection(t *testing.T) {
+	_, err := loadYAML(t, `
+kubernetes:
+  pod_selection: busiest
+`)
+	if err == nil || !strings.Contains(err.Error(), "busiest") {
+		t.Errorf("Expected an unsupported pod selection error, got %v", err)
+	}
+}
+
+func TestLoad_PercentChangeRules(t *testing.T) {
+	c, err := loadYAML(t, `
+detection:
+  percent_change_rules:
+    - metric: saturation_cpu
+      threshold: 50
+      window: 5
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rules := c.ToAnomalyDetectionConfig().PercentChangeRules
+	if len(rules) != 1 || rules[0].Metric != "saturation_cpu" || rules[0].Threshold != 50 || rules[0].Window != 5 {
+		t.Errorf("Expected the saturation_cpu rule, got %+v", rules)
+	}
+
+	_, err = loadYAML(t, `
+detection:
+  percent_change_rules:
+    - metric: saturation_cpu
+`)
+	if err == nil {
+		t.Error("Expected an error for a rule without a threshold")
+	}
+}
+
+func TestLoad_LowReplicaAction(t *testing.T) {
+	c, err := loadYAML(t, `
+detection:
+  min_replicas: 2
+  low_replica_action: suppress
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	detection := c.ToAnomalyDetectionConfig()
+	if detection.MinReplicas != 2 || detection.LowReplicaAction != "suppress" {
+		t.Errorf("Expected min replicas 2 with suppress, got %d with %q", detection.MinReplicas, detection.LowReplicaAction)
+	}
+
+	_, err = loadYAML(t, `
+detection:
+  low_replica_action: drop
+`)
+	if err == nil || !strings.Contains(err.Error(), "drop") {
+		t.Errorf("Expected an unknown low replica action error, got %v", err)
+	}
+}
+
+func TestLoad_ServiceLatencyTargets(t *testing.T) {
+	c, err := loadYAML(t, `
+detection:
+  latency_slo: true
+  services:
+    cache:
+      latency_target: 10ms
+    shop/reports:
+      latency_target: 2s
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	detection := c.ToAnomalyDetectionConfig()
+	if !detection.LatencySLO {
+		t.Error("Expected latency SLO evaluation enabled")
+	}
+	if detection.ServiceOverrides["cache"].LatencyTarget != 10*time.Millisecond {
+		t.Errorf("Expected a 10ms cache target, got %v", detection.ServiceOverrides["cache"].LatencyTarget)
+	}
+	if detection.ServiceOverrides["shop/reports"].LatencyTarget != 2*time.Second {
+		t.Errorf("Expected a 2s reports target, got %v", detection.ServiceOverrides["shop/reports"].LatencyTarget)
+	}
+
+	_, err = loadYAML(t, `
+detection:
+  services:
+    cache:
+      latency_target: -1s
+`)
+	if err == nil {
+		t.Error("Expected an error for a negative latency target")
+	}
+}
+
+func TestLoad_NotifyMode(t *testing.T) {
+	c, err := loadYAML(t, `
+notify:
+  webhook_url: https://hooks.example.com/smanalyzer
+  mode: batch
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c.Notify.Mode != "batch" || c.Notify.WebhookURL != "https://hooks.example.com/smanalyzer" {
+		t.Errorf("Expected a batch webhook notifier, got %+v", c.Notify)
+	}
+
+	_, err = loadYAML(t, `
+notify:
+  mode: digest
+`)
+	if err == nil || !strings.Contains(err.Error(), "digest") {
+		t.Errorf("Expected an unknown notify mode error, got %v", err)
+	}
+}
+
+func TestLoad_MetricMapping(t *testing.T) {
+	c, err := loadYAML(t, `
+metric_mapping:
+  requests: acme_http_requests
+  latency_p99: acme_latency_tail_ms
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c.MetricMapping["requests"] != "acme_http_requests" || c.MetricMapping["latency_p99"] != "acme_latency_tail_ms" {
+		t.Errorf("Expected the mapping loaded, got %v", c.MetricMapping)
+	}
+
+	_, err = loadYAML(t, `
+metric_mapping:
+  throughput: acme_http_requests
+`)
+	if err == nil {
+		t.Error("Expected an error for an unknown signal")
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix is prepended to every environment variable read by ConfigureEnv.
+const EnvPrefix = "SMANALYZER"
+
+// ConfigureEnv makes every setting overridable by an environment variable
+// named after its config key: the prefix, then the yaml path upper-cased
+// with "." replaced by "_". For example detection.error_rate_threshold is
+// read from SMANALYZER_DETECTION_ERROR_RATE_THRESHOLD. Lists of plain values
+// such as clustering.features are comma-separated. Map settings such as
+// description_templates and lists of rules can only be set in the config
+// file.
+func ConfigureEnv(v *viper.Viper) {
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	// AutomaticEnv only applies to keys viper already knows about, so bind
+	// each one explicitly for Unmarshal to see it
+	for _, key := range configKeys(reflect.TypeOf(Config{}), "") {
+		v.BindEnv(key)
+	}
+}
+
+// configKeys lists the dotted yaml paths of the leaf fields of t.
+func configKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		key := prefix + name
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			keys = append(keys, configKeys(field.Type, key+".")...)
+		case reflect.Slice:
+			// A string decodes into a list of plain values but not of
+			// structs
+			if field.Type.Elem().Kind() != reflect.Struct {
+				keys = append(keys, key)
+			}
+		case reflect.Map:
+			continue
+		default:
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
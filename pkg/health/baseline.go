@@ -0,0 +1,42 @@
+package health
+
+import (
+	"time"
+
+	"smanalyzer/pkg/telemetry"
+	"smanalyzer/pkg/timeseries"
+)
+
+// baselineWindow is how many stored scans a historical baseline averages,
+// the same history detection looks at.
+const baselineWindow = 50
+
+// HistoricalBaseline averages the scans stored for a service into a
+// Baseline. Call it before storing the current scan so the baseline doesn't
+// include it. ok is false when nothing has been stored for the service.
+func HistoricalBaseline(storage *timeseries.Storage, seriesKey string) (Baseline, bool) {
+	mean := func(metric string) (float64, bool) {
+		points := storage.GetLatestN(seriesKey, metric, baselineWindow)
+		if len(points) == 0 {
+			return 0, false
+		}
+		sum := 0.0
+		for _, p := range points {
+			sum += p.Value
+		}
+		return sum / float64(len(points)), true
+	}
+
+	rps, hasTraffic := mean(telemetry.TrafficRPS)
+	p99, hasLatency := mean(telemetry.LatencyP99)
+	errorRate, hasErrors := mean(telemetry.ErrorRate)
+	if !hasTraffic && !hasLatency && !hasErrors {
+		return Baseline{}, false
+	}
+
+	return Baseline{
+		LatencyP99:        time.Duration(p99 * float64(time.Millisecond)),
+		RequestsPerSecond: rps,
+		ErrorRate:         errorRate * 100,
+	}, true
+}
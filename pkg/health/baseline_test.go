@@ -0,0 +1,30 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/telemetry"
+	"smanalyzer/pkg/timeseries"
+)
+
+func TestHistoricalBaseline(t *testing.T) {
+	storage := timeseries.NewStorage()
+	if _, ok := HistoricalBaseline(storage, "shop/reviews"); ok {
+		t.Fatal("Expected no baseline without history")
+	}
+
+	for _, scan := range []struct{ rps, p99, errorRate float64 }{{10, 80, 0.01}, {14, 120, 0.03}} {
+		storage.Store("shop/reviews", telemetry.TrafficRPS, scan.rps, nil)
+		storage.Store("shop/reviews", telemetry.LatencyP99, scan.p99, nil)
+		storage.Store("shop/reviews", telemetry.ErrorRate, scan.errorRate, nil)
+	}
+
+	baseline, ok := HistoricalBaseline(storage, "shop/reviews")
+	if !ok {
+		t.Fatal("Expected a baseline from the stored scans")
+	}
+	if baseline.RequestsPerSecond != 12 || baseline.LatencyP99 != 100*time.Millisecond || baseline.ErrorRate != 2 {
+		t.Errorf("Expected 12 RPS, 100ms and 2%%, got %+v", baseline)
+	}
+}
@@ -0,0 +1,97 @@
+package health
+
+import (
+	"math"
+	"time"
+
+	"smanalyzer/pkg/istio"
+)
+
+// Weights controls how much each golden signal contributes to the score.
+// Weights are relative; they don't need to sum to 1.
+type Weights struct {
+	Latency    float64 `yaml:"latency"`
+	Errors     float64 `yaml:"errors"`
+	Traffic    float64 `yaml:"traffic"`
+	Saturation float64 `yaml:"saturation"`
+}
+
+// Baseline describes what normal looks like for a service. Zero fields fall
+// back to fixed targets.
+type Baseline struct {
+	LatencyP99        time.Duration
+	RequestsPerSecond float64
+	// ErrorRate in percent; the score rates errors against maxErrorRate
+	// rather than the baseline
+	ErrorRate float64
+}
+
+const (
+	// Error rate (percent) at which the error component is fully unhealthy
+	maxErrorRate = 10.0
+	// P99 used as the latency target when no baseline is known
+	defaultLatencyTarget = 1 * time.Second
+)
+
+func DefaultWeights() Weights {
+	return Weights{
+		Latency:    0.3,
+		Errors:     0.4,
+		Traffic:    0.15,
+		Saturation: 0.15,
+	}
+}
+
+// HealthScore combines the golden signals into a single 0-100 score where 100
+// is fully healthy. Each signal is turned into a penalty between 0 and 1 and
+// the score is the weighted average of the penalties subtracted from 100.
+func HealthScore(metrics *istio.ServiceMeshMetrics, baseline *Baseline, weights Weights) float64 {
+	if metrics == nil {
+		return 0
+	}
+	if baseline == nil {
+		baseline = &Baseline{}
+	}
+
+	totalWeight := weights.Latency + weights.Errors + weights.Traffic + weights.Saturation
+	if totalWeight <= 0 {
+		return 100
+	}
+
+	penalty := weights.Latency*latencyPenalty(metrics, baseline) +
+		weights.Errors*clamp(metrics.Errors.ErrorRate/maxErrorRate) +
+		weights.Traffic*trafficPenalty(metrics, baseline) +
+		weights.Saturation*clamp(math.Max(metrics.Saturation.CPUUsage, metrics.Saturation.MemoryUsage)/100)
+
+	return math.Max(0, math.Min(100, 100*(1-penalty/totalWeight)))
+}
+
+// latencyPenalty is 0 at or below target and reaches 1 at three times target.
+func latencyPenalty(metrics *istio.ServiceMeshMetrics, baseline *Baseline) float64 {
+	target := baseline.LatencyP99
+	if target <= 0 {
+		target = defaultLatencyTarget
+	}
+
+	ratio := float64(metrics.Latency.P99) / float64(target)
+	return clamp((ratio - 1) / 2)
+}
+
+// trafficPenalty is the relative deviation from the baseline request rate.
+// Without a baseline there's nothing to deviate from.
+func trafficPenalty(metrics *istio.ServiceMeshMetrics, baseline *Baseline) float64 {
+	if baseline.RequestsPerSecond <= 0 {
+		return 0
+	}
+	return clamp(math.Abs(metrics.Traffic.RequestsPerSecond-baseline.RequestsPerSecond) / baseline.RequestsPerSecond)
+}
+
+func clamp(v float64) float64 {
+	if math.IsNaN(v) || v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
@@ -0,0 +1,53 @@
+package health
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/istio"
+)
+
+func TestHealthScore_Healthy(t *testing.T) {
+	metrics := &istio.ServiceMeshMetrics{}
+	metrics.Latency.P99 = 100 * time.Millisecond
+	metrics.Traffic.RequestsPerSecond = 50
+
+	score := HealthScore(metrics, &Baseline{RequestsPerSecond: 50}, DefaultWeights())
+	if score != 100 {
+		t.Errorf("Expected score 100 for a healthy service, got %.2f", score)
+	}
+}
+
+func TestHealthScore_WeightSensitivity(t *testing.T) {
+	metrics := &istio.ServiceMeshMetrics{}
+	metrics.Errors.ErrorRate = 5.0
+
+	errorHeavy := HealthScore(metrics, nil, Weights{Errors: 0.9, Latency: 0.1})
+	errorLight := HealthScore(metrics, nil, Weights{Errors: 0.1, Latency: 0.9})
+
+	if errorHeavy >= errorLight {
+		t.Errorf("Expected error-weighted score to be lower, got %.2f >= %.2f", errorHeavy, errorLight)
+	}
+
+	if math.Abs(errorHeavy-55) > 0.001 {
+		t.Errorf("Expected error-weighted score 55, got %.2f", errorHeavy)
+	}
+}
+
+func TestHealthScore_Clamped(t *testing.T) {
+	metrics := &istio.ServiceMeshMetrics{}
+	metrics.Errors.ErrorRate = 250
+	metrics.Latency.P99 = 30 * time.Second
+	metrics.Traffic.RequestsPerSecond = 10000
+	metrics.Saturation.CPUUsage = 400
+
+	score := HealthScore(metrics, &Baseline{LatencyP99: time.Second, RequestsPerSecond: 10}, DefaultWeights())
+	if score != 0 {
+		t.Errorf("Expected score clamped to 0, got %.2f", score)
+	}
+
+	if score := HealthScore(&istio.ServiceMeshMetrics{}, nil, Weights{}); score != 100 {
+		t.Errorf("Expected score 100 with zero weights, got %.2f", score)
+	}
+}
@@ -0,0 +1,204 @@
+package health
+
+import (
+	"math"
+	"time"
+
+	"smanalyzer/pkg/telemetry"
+	"smanalyzer/pkg/timeseries"
+)
+
+// Verdict is the outcome of comparing a signal before and after a split.
+type Verdict string
+
+const (
+	Regression  Verdict = "regression"
+	Improvement Verdict = "improvement"
+	// Shifted is a significant change in a signal, such as traffic, that
+	// is neither better nor worse for going up
+	Shifted       Verdict = "shifted"
+	Unchanged     Verdict = "no significant change"
+	NotEnoughData Verdict = "not enough data"
+)
+
+// significanceLevel is the p-value below which a change is reported.
+const significanceLevel = 0.05
+
+// minWindowPoints is the fewest points each side of a split needs for a
+// variance, and so a test, to mean anything.
+const minWindowPoints = 3
+
+// windowSignals are the golden signals compared across a split, with
+// whether a rise is a regression (1), an improvement (-1) or neither (0).
+var windowSignals = []struct {
+	metric string
+	worse  int
+}{
+	{telemetry.ErrorRate, 1},
+	{telemetry.LatencyP50, 1},
+	{telemetry.LatencyP99, 1},
+	{telemetry.TrafficRPS, 0},
+}
+
+// WindowStats summarizes a signal's points on one side of a split.
+type WindowStats struct {
+	Points int     `json:"points"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+}
+
+// SignalComparison is one signal's before/after statistics and verdict.
+type SignalComparison struct {
+	Signal string      `json:"signal"`
+	Before WindowStats `json:"before"`
+	After  WindowStats `json:"after"`
+	// PValue is the two-sided p-value of Welch's t-test on the means
+	PValue  float64 `json:"p_value"`
+	Verdict Verdict `json:"verdict"`
+}
+
+// WindowComparison compares a service's golden signals in the window before
+// a split, typically a rollout, with the window after it.
+type WindowComparison struct {
+	Service string             `json:"service"`
+	Split   time.Time          `json:"split"`
+	Window  time.Duration      `json:"window"`
+	Signals []SignalComparison `json:"signals"`
+}
+
+// Regressed reports whether any signal regressed after the split.
+func (c WindowComparison) Regressed() bool {
+	for _, s := range c.Signals {
+		if s.Verdict == Regression {
+			return true
+		}
+	}
+	return false
+}
+
+// CompareWindow compares the points stored for a service in the window
+// before split with those in the window after it. Each window includes its
+// start, so a point at the split itself counts as after.
+func CompareWindow(storage *timeseries.Storage, seriesKey string, split time.Time, window time.Duration) WindowComparison {
+	comparison := WindowComparison{Service: seriesKey, Split: split, Window: window}
+	for _, signal := range windowSignals {
+		// GetTimeRange excludes both ends
+		before := storage.GetTimeRange(seriesKey, signal.metric, split.Add(-window-time.Nanosecond), split)
+		after := storage.GetTimeRange(seriesKey, signal.metric, split.Add(-time.Nanosecond), split.Add(window))
+		comparison.Signals = append(comparison.Signals, compareSignal(signal.metric, signal.worse, before, after))
+	}
+	return comparison
+}
+
+func compareSignal(metric string, worse int, before, after []timeseries.DataPoint) SignalComparison {
+	s := SignalComparison{Signal: metric, Before: windowStats(before), After: windowStats(after), PValue: 1}
+	if s.Before.Points < minWindowPoints || s.After.Points < minWindowPoints {
+		s.Verdict = NotEnoughData
+		return s
+	}
+
+	s.PValue = welchPValue(s.Before, s.After)
+	switch {
+	case s.PValue >= significanceLevel:
+		s.Verdict = Unchanged
+	case worse == 0:
+		s.Verdict = Shifted
+	case (s.After.Mean > s.Before.Mean) == (worse > 0):
+		s.Verdict = Regression
+	default:
+		s.Verdict = Improvement
+	}
+	return s
+}
+
+func windowStats(points []timeseries.DataPoint) WindowStats {
+	stats := WindowStats{Points: len(points)}
+	if len(points) == 0 {
+		return stats
+	}
+	for _, p := range points {
+		stats.Mean += p.Value
+	}
+	stats.Mean /= float64(len(points))
+	if len(points) < 2 {
+		return stats
+	}
+	variance := 0.0
+	for _, p := range points {
+		variance += (p.Value - stats.Mean) * (p.Value - stats.Mean)
+	}
+	stats.StdDev = math.Sqrt(variance / float64(len(points)-1))
+	return stats
+}
+
+// welchPValue is the two-sided p-value of Welch's t-test, which unlike
+// Student's doesn't assume a deploy left the variance alone.
+func welchPValue(a, b WindowStats) float64 {
+	va := a.StdDev * a.StdDev / float64(a.Points)
+	vb := b.StdDev * b.StdDev / float64(b.Points)
+	if va+vb == 0 {
+		// Two flat lines: either the same or certainly not
+		if a.Mean == b.Mean {
+			return 1
+		}
+		return 0
+	}
+
+	t := (b.Mean - a.Mean) / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/float64(a.Points-1) + vb*vb/float64(b.Points-1))
+	return incompleteBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// incompleteBeta is the regularized incomplete beta function I_x(a, b),
+// evaluated by its continued fraction (Numerical Recipes 6.4).
+func incompleteBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	// The fraction converges quickly only on one side of the mean
+	if x < (a+1)/(a+b+2) {
+		return front * betaFraction(a, b, x) / a
+	}
+	return 1 - front*betaFraction(b, a, 1-x)/b
+}
+
+func betaFraction(a, b, x float64) float64 {
+	const (
+		maxIterations = 200
+		epsilon       = 1e-12
+		tiny          = 1e-300
+	)
+	clamp := func(v float64) float64 {
+		if math.Abs(v) < tiny {
+			return tiny
+		}
+		return v
+	}
+
+	c, d := 1.0, 1/clamp(1-(a+b)*x/(a+1))
+	h := d
+	for m := 1; m <= maxIterations; m++ {
+		fm := float64(m)
+		even := fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm))
+		d = 1 / clamp(1+even*d)
+		c = clamp(1 + even/c)
+		h *= d * c
+
+		odd := -(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1))
+		d = 1 / clamp(1+odd*d)
+		c = clamp(1 + odd/c)
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
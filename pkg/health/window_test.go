@@ -0,0 +1,94 @@
+package health
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/telemetry"
+	"smanalyzer/pkg/timeseries"
+)
+
+func TestCompareWindow_ReportsRegression(t *testing.T) {
+	storage := timeseries.NewStorage()
+	split := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	jitter := []float64{0, 0.002, -0.001, 0.001, -0.002}
+	for i := -15; i < 15; i++ {
+		at := split.Add(time.Duration(i) * time.Minute)
+		j := jitter[(i+15)%len(jitter)]
+		errorRate, p99 := 0.01+j, 120.0+100*j
+		if i >= 0 {
+			// The deploy broke something: more errors, slower tail
+			errorRate, p99 = 0.06+j, 300.0+100*j
+		}
+		storage.StoreAt("shop/checkout", telemetry.ErrorRate, errorRate, at, nil)
+		storage.StoreAt("shop/checkout", telemetry.LatencyP99, p99, at, nil)
+		storage.StoreAt("shop/checkout", telemetry.LatencyP50, 40+100*j, at, nil)
+		storage.StoreAt("shop/checkout", telemetry.TrafficRPS, 10, at, nil)
+	}
+
+	comparison := CompareWindow(storage, "shop/checkout", split, 15*time.Minute)
+	if !comparison.Regressed() {
+		t.Fatalf("Expected a regression, got %+v", comparison.Signals)
+	}
+
+	verdicts := make(map[string]SignalComparison)
+	for _, s := range comparison.Signals {
+		verdicts[s.Signal] = s
+	}
+	errors := verdicts[telemetry.ErrorRate]
+	if errors.Verdict != Regression || errors.PValue >= significanceLevel {
+		t.Errorf("Expected a significant error rate regression, got %+v", errors)
+	}
+	if errors.Before.Points != 15 || errors.After.Points != 15 {
+		t.Errorf("Expected 15 points each side of the split, got %d and %d", errors.Before.Points, errors.After.Points)
+	}
+	if math.Abs(errors.Before.Mean-0.01) > 1e-9 || math.Abs(errors.After.Mean-0.06) > 1e-9 {
+		t.Errorf("Expected means of 0.01 and 0.06, got %v and %v", errors.Before.Mean, errors.After.Mean)
+	}
+	if verdicts[telemetry.LatencyP99].Verdict != Regression {
+		t.Errorf("Expected a P99 regression, got %+v", verdicts[telemetry.LatencyP99])
+	}
+	if verdicts[telemetry.LatencyP50].Verdict != Unchanged {
+		t.Errorf("Expected P50 unchanged, got %+v", verdicts[telemetry.LatencyP50])
+	}
+	if verdicts[telemetry.TrafficRPS].Verdict != Unchanged {
+		t.Errorf("Expected traffic unchanged, got %+v", verdicts[telemetry.TrafficRPS])
+	}
+}
+
+func TestCompareWindow_ImprovementAndTooFewPoints(t *testing.T) {
+	storage := timeseries.NewStorage()
+	split := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := -5; i < 5; i++ {
+		p99 := 500.0 + float64(i%2)*10
+		if i >= 0 {
+			p99 = 200 + float64(i%2)*10
+		}
+		storage.StoreAt("shop/checkout", telemetry.LatencyP99, p99, split.Add(time.Duration(i)*time.Minute), nil)
+	}
+	storage.StoreAt("shop/checkout", telemetry.ErrorRate, 0.01, split.Add(time.Minute), nil)
+
+	comparison := CompareWindow(storage, "shop/checkout", split, 15*time.Minute)
+	for _, s := range comparison.Signals {
+		want := NotEnoughData
+		if s.Signal == telemetry.LatencyP99 {
+			want = Improvement
+		}
+		if s.Verdict != want {
+			t.Errorf("Expected %s for %s, got %+v", want, s.Signal, s)
+		}
+	}
+}
+
+func TestWelchPValue(t *testing.T) {
+	// Two samples of 10 with means 1 apart and unit deviations: t = 2.236
+	// on 18 degrees of freedom
+	p := welchPValue(WindowStats{Points: 10, Mean: 0, StdDev: 1}, WindowStats{Points: 10, Mean: 1, StdDev: 1})
+	if math.Abs(p-0.0382) > 0.0005 {
+		t.Errorf("Expected p = 0.0382, got %.4f", p)
+	}
+	if p := welchPValue(WindowStats{Points: 5, Mean: 3}, WindowStats{Points: 5, Mean: 3}); p != 1 {
+		t.Errorf("Expected p = 1 for identical flat windows, got %v", p)
+	}
+}
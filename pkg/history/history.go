@@ -0,0 +1,82 @@
+package history
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+)
+
+// Store keeps every anomaly a scan detects so past incidents can be
+// queried. JSONLStore appends to a plain file; SQLiteStore indexes the
+// anomalies for filtering and aggregating large histories.
+type Store interface {
+	// Append records anomalies
+	Append(anomalies []anomaly.Anomaly) error
+	// Query returns the anomalies matching f, oldest first
+	Query(f Filter) ([]anomaly.Anomaly, error)
+	// CountByDay aggregates the anomalies matching f per UTC day, oldest
+	// first
+	CountByDay(f Filter) ([]DailyCount, error)
+	Close() error
+}
+
+// Filter selects anomalies from a Store. Zero fields match everything.
+type Filter struct {
+	Service     string
+	Namespace   string
+	Type        anomaly.AnomalyType
+	MinSeverity float64
+	// Since and Until bound the anomaly timestamps, inclusive
+	Since time.Time
+	Until time.Time
+}
+
+func (f Filter) matches(a anomaly.Anomaly) bool {
+	return (f.Service == "" || a.ServiceName == f.Service) &&
+		(f.Namespace == "" || a.Namespace == f.Namespace) &&
+		(f.Type == "" || a.Type == f.Type) &&
+		a.Severity >= f.MinSeverity &&
+		(f.Since.IsZero() || !a.Timestamp.Before(f.Since)) &&
+		(f.Until.IsZero() || !a.Timestamp.After(f.Until))
+}
+
+// DailyCount is the anomalies recorded on one UTC day.
+type DailyCount struct {
+	Day         time.Time `json:"day"`
+	Count       int       `json:"count"`
+	MaxSeverity float64   `json:"max_severity"`
+}
+
+// countByDay aggregates anomalies the way SQLiteStore's query does.
+func countByDay(anomalies []anomaly.Anomaly) []DailyCount {
+	days := make(map[time.Time]*DailyCount)
+	for _, a := range anomalies {
+		t := a.Timestamp.UTC()
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		if days[day] == nil {
+			days[day] = &DailyCount{Day: day}
+		}
+		days[day].Count++
+		days[day].MaxSeverity = max(days[day].MaxSeverity, a.Severity)
+	}
+
+	counts := make([]DailyCount, 0, len(days))
+	for _, c := range days {
+		counts = append(counts, *c)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Day.Before(counts[j].Day) })
+	return counts
+}
+
+// Open opens the store at path: SQLite for .db, .sqlite and .sqlite3
+// files, JSON lines otherwise.
+func Open(path string) (Store, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".db", ".sqlite", ".sqlite3":
+		return OpenSQLite(path)
+	}
+	return OpenJSONL(path), nil
+}
@@ -0,0 +1,120 @@
+package history
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+)
+
+// incidentHistory is a week of anomalies across two services.
+func incidentHistory() []anomaly.Anomaly {
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	at := func(day, hour int) time.Time {
+		return start.Add(time.Duration(day)*24*time.Hour + time.Duration(hour)*time.Hour)
+	}
+	return []anomaly.Anomaly{
+		{Type: anomaly.ErrorRateHigh, ServiceName: "checkout", Namespace: "shop", Severity: 1.6, Timestamp: at(0, 0)},
+		{Type: anomaly.LatencyAnomaly, ServiceName: "checkout", Namespace: "shop", Severity: 1.2, Timestamp: at(0, 2)},
+		{Type: anomaly.ErrorRateHigh, ServiceName: "checkout", Namespace: "shop", Severity: 3.4, Timestamp: at(0, 5)},
+		{Type: anomaly.ErrorRateHigh, ServiceName: "reviews", Namespace: "shop", Severity: 2.1, Timestamp: at(1, 0)},
+		{Type: anomaly.ErrorRateHigh, ServiceName: "checkout", Namespace: "shop", Severity: 2.2, Timestamp: at(3, 1)},
+		{Type: anomaly.ErrorRateHigh, ServiceName: "checkout", Namespace: "payments", Severity: 1.9, Timestamp: at(3, 2)},
+		{Type: anomaly.RetryStorm, ServiceName: "checkout", Namespace: "shop", Severity: 1.1, Timestamp: at(6, 0)},
+	}
+}
+
+func openStores(t *testing.T) map[string]Store {
+	dir := t.TempDir()
+	sqlite, err := Open(filepath.Join(dir, "anomalies.db"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+
+	jsonl, err := Open(filepath.Join(dir, "anomalies.jsonl"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := sqlite.(*SQLiteStore); !ok {
+		t.Fatalf("Expected an SQLite store for a .db file, got %T", sqlite)
+	}
+	if _, ok := jsonl.(*JSONLStore); !ok {
+		t.Fatalf("Expected a JSONL store for a .jsonl file, got %T", jsonl)
+	}
+
+	stores := map[string]Store{"sqlite": sqlite, "jsonl": jsonl}
+	for name, store := range stores {
+		// Appended over two scans
+		all := incidentHistory()
+		if err := store.Append(all[:3]); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if err := store.Append(all[3:]); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+	}
+	return stores
+}
+
+func TestStore_Query(t *testing.T) {
+	all := incidentHistory()
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected []anomaly.Anomaly
+	}{
+		{"everything", Filter{}, all},
+		{"service and namespace", Filter{Service: "checkout", Namespace: "shop"}, []anomaly.Anomaly{all[0], all[1], all[2], all[4], all[6]}},
+		{"type", Filter{Type: anomaly.ErrorRateHigh, Service: "checkout"}, []anomaly.Anomaly{all[0], all[2], all[4], all[5]}},
+		{"severity", Filter{MinSeverity: 2}, []anomaly.Anomaly{all[2], all[3], all[4]}},
+		{"time range", Filter{Since: all[3].Timestamp, Until: all[5].Timestamp}, []anomaly.Anomaly{all[3], all[4], all[5]}},
+		{"no match", Filter{Service: "cart"}, nil},
+	}
+
+	for name, store := range openStores(t) {
+		for _, tt := range tests {
+			got, err := store.Query(tt.filter)
+			if err != nil {
+				t.Fatalf("%s %s: unexpected error: %v", name, tt.name, err)
+			}
+			if len(got) != len(tt.expected) {
+				t.Errorf("%s %s: expected %d anomalies, got %d", name, tt.name, len(tt.expected), len(got))
+				continue
+			}
+			for i := range got {
+				if got[i].ServiceName != tt.expected[i].ServiceName || got[i].Type != tt.expected[i].Type ||
+					got[i].Severity != tt.expected[i].Severity || !got[i].Timestamp.Equal(tt.expected[i].Timestamp) {
+					t.Errorf("%s %s: expected %+v at %d, got %+v", name, tt.name, tt.expected[i], i, got[i])
+				}
+			}
+		}
+	}
+}
+
+func TestStore_CountByDay(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, 4+d, 0, 0, 0, 0, time.UTC) }
+	expected := []DailyCount{
+		{Day: day(0), Count: 2, MaxSeverity: 3.4},
+		{Day: day(3), Count: 2, MaxSeverity: 2.2},
+	}
+
+	for name, store := range openStores(t) {
+		counts, err := store.CountByDay(Filter{Service: "checkout", Type: anomaly.ErrorRateHigh})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if !reflect.DeepEqual(counts, expected) {
+			t.Errorf("%s: expected %+v, got %+v", name, expected, counts)
+		}
+	}
+}
+
+func TestJSONLStore_MissingFileIsEmpty(t *testing.T) {
+	store := OpenJSONL(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if anomalies, err := store.Query(Filter{}); err != nil || len(anomalies) != 0 {
+		t.Errorf("Expected an empty history, got %v (err %v)", anomalies, err)
+	}
+}
@@ -0,0 +1,89 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"smanalyzer/pkg/anomaly"
+)
+
+// JSONLStore appends anomalies to a file as one JSON object per line.
+// Queries read the whole file, which is fine for the history of a few
+// services; use SQLiteStore beyond that.
+type JSONLStore struct {
+	path string
+}
+
+func OpenJSONL(path string) *JSONLStore {
+	return &JSONLStore{path: path}
+}
+
+func (s *JSONLStore) Append(anomalies []anomaly.Anomaly) error {
+	if len(anomalies) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open anomaly history: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, a := range anomalies {
+		if err := encoder.Encode(a); err != nil {
+			return fmt.Errorf("failed to write anomaly history: %w", err)
+		}
+	}
+	return w.Flush()
+}
+
+func (s *JSONLStore) Query(filter Filter) ([]anomaly.Anomaly, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open anomaly history: %w", err)
+	}
+	defer f.Close()
+
+	var matched []anomaly.Anomaly
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var a anomaly.Anomaly
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			return nil, fmt.Errorf("failed to parse anomaly history line %d: %w", line, err)
+		}
+		if filter.matches(a) {
+			matched = append(matched, a)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read anomaly history: %w", err)
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].Timestamp.Before(matched[j].Timestamp) })
+	return matched, nil
+}
+
+func (s *JSONLStore) CountByDay(filter Filter) ([]DailyCount, error) {
+	anomalies, err := s.Query(filter)
+	if err != nil {
+		return nil, err
+	}
+	return countByDay(anomalies), nil
+}
+
+func (s *JSONLStore) Close() error {
+	return nil
+}
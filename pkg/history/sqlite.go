@@ -0,0 +1,162 @@
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS anomalies (
+	id        INTEGER PRIMARY KEY,
+	service   TEXT    NOT NULL,
+	namespace TEXT    NOT NULL,
+	cluster   TEXT    NOT NULL,
+	type      TEXT    NOT NULL,
+	severity  REAL    NOT NULL,
+	timestamp INTEGER NOT NULL,
+	data      TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS anomalies_service ON anomalies (service, namespace, timestamp);
+CREATE INDEX IF NOT EXISTS anomalies_type ON anomalies (type, timestamp);
+CREATE INDEX IF NOT EXISTS anomalies_timestamp ON anomalies (timestamp);
+`
+
+// nanosPerDay buckets the nanosecond timestamps into UTC days.
+const nanosPerDay = int64(24 * time.Hour)
+
+// SQLiteStore keeps anomalies in an SQLite database indexed by service,
+// type and time, so filtered queries and daily aggregates don't read the
+// whole history. Each row also holds the full anomaly as JSON.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open anomaly history: %w", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create anomaly history schema: %w", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Append(anomalies []anomaly.Anomaly) error {
+	if len(anomalies) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to write anomaly history: %w", err)
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare(`INSERT INTO anomalies (service, namespace, cluster, type, severity, timestamp, data) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to write anomaly history: %w", err)
+	}
+	defer insert.Close()
+
+	for _, a := range anomalies {
+		data, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		if _, err := insert.Exec(a.ServiceName, a.Namespace, a.Cluster, string(a.Type), a.Severity, a.Timestamp.UnixNano(), string(data)); err != nil {
+			return fmt.Errorf("failed to write anomaly history: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// where builds the WHERE clause for a filter.
+func (f Filter) where() (string, []any) {
+	var conditions []string
+	var args []any
+	add := func(condition string, arg any) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+
+	if f.Service != "" {
+		add("service = ?", f.Service)
+	}
+	if f.Namespace != "" {
+		add("namespace = ?", f.Namespace)
+	}
+	if f.Type != "" {
+		add("type = ?", string(f.Type))
+	}
+	if f.MinSeverity > 0 {
+		add("severity >= ?", f.MinSeverity)
+	}
+	if !f.Since.IsZero() {
+		add("timestamp >= ?", f.Since.UnixNano())
+	}
+	if !f.Until.IsZero() {
+		add("timestamp <= ?", f.Until.UnixNano())
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (s *SQLiteStore) Query(f Filter) ([]anomaly.Anomaly, error) {
+	where, args := f.where()
+	rows, err := s.db.Query(`SELECT data FROM anomalies`+where+` ORDER BY timestamp, id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query anomaly history: %w", err)
+	}
+	defer rows.Close()
+
+	var anomalies []anomaly.Anomaly
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to query anomaly history: %w", err)
+		}
+		var a anomaly.Anomaly
+		if err := json.Unmarshal([]byte(data), &a); err != nil {
+			return nil, fmt.Errorf("failed to parse stored anomaly: %w", err)
+		}
+		anomalies = append(anomalies, a)
+	}
+	return anomalies, rows.Err()
+}
+
+func (s *SQLiteStore) CountByDay(f Filter) ([]DailyCount, error) {
+	where, args := f.where()
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT timestamp / %d AS day, COUNT(*), MAX(severity) FROM anomalies%s GROUP BY day ORDER BY day`, nanosPerDay, where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query anomaly history: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []DailyCount
+	for rows.Next() {
+		var day int64
+		var c DailyCount
+		if err := rows.Scan(&day, &c.Count, &c.MaxSeverity); err != nil {
+			return nil, fmt.Errorf("failed to query anomaly history: %w", err)
+		}
+		c.Day = time.Unix(0, day*nanosPerDay).UTC()
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,183 @@
+package istio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"smanalyzer/pkg/progress"
+	"smanalyzer/pkg/telemetry"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ambientDataplaneLabel = "istio.io/dataplane-mode"
+	ambientDataplaneValue = "ambient"
+	ztunnelNamespace      = "istio-system"
+	ztunnelSelector       = "app=ztunnel"
+	ztunnelMetricsPort    = 15020
+)
+
+// ambientCollector handles Istio ambient mode, where workloads have no
+// sidecar and traffic is captured by the ztunnel on each node.
+type ambientCollector struct {
+	sd *ServiceDiscovery
+}
+
+func (c *ambientCollector) MeshedPods(ctx context.Context, pods []corev1.Pod) ([]corev1.Pod, error) {
+	ambientNamespaces, err := c.sd.ambientNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var meshed []corev1.Pod
+	for _, pod := range pods {
+		if isAmbientEnrolled(pod, ambientNamespaces) {
+			meshed = append(meshed, pod)
+		}
+	}
+	return meshed, nil
+}
+
+func (c *ambientCollector) Collect(ctx context.Context, pod corev1.Pod, metrics *ServiceMeshMetrics) error {
+	return c.sd.collectAmbientMetrics(ctx, pod, metrics)
+}
+
+func (c *ambientCollector) Method(pod corev1.Pod) string {
+	return fmt.Sprintf("GET :%d/metrics on the ztunnel of node %s through the API server proxy", ztunnelMetricsPort, pod.Spec.NodeName)
+}
+
+func isAmbientEnrolled(pod corev1.Pod, ambientNamespaces map[string]bool) bool {
+	if mode, exists := pod.Labels[ambientDataplaneLabel]; exists {
+		// Pods can opt out of a namespace-wide enrollment with dataplane-mode=none
+		return mode == ambientDataplaneValue
+	}
+	return ambientNamespaces[pod.Namespace]
+}
+
+// ambientNamespaces returns the namespaces labeled for ambient enrollment.
+func (sd *ServiceDiscovery) ambientNamespaces(ctx context.Context) (map[string]bool, error) {
+	namespaces, err := sd.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", ambientDataplaneLabel, ambientDataplaneValue),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ambient namespaces: %w", err)
+	}
+
+	enrolled := make(map[string]bool)
+	for _, ns := range namespaces.Items {
+		enrolled[ns.Name] = true
+	}
+	return enrolled, nil
+}
+
+// collectAmbientMetrics scrapes the ztunnel running on the workload's node and
+// maps its L4 telemetry for the service into the golden signals.
+func (sd *ServiceDiscovery) collectAmbientMetrics(ctx context.Context, pod corev1.Pod, metrics *ServiceMeshMetrics) error {
+	ztunnels, err := sd.clientset.CoreV1().Pods(ztunnelNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: ztunnelSelector,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list ztunnel pods: %w", err)
+	}
+
+	var ztunnel string
+	for _, z := range ztunnels.Items {
+		if z.Spec.NodeName == pod.Spec.NodeName && z.Status.Phase == corev1.PodRunning {
+			ztunnel = z.Name
+			break
+		}
+	}
+	if ztunnel == "" {
+		return fmt.Errorf("no ztunnel found on node %s", pod.Spec.NodeName)
+	}
+
+	output, err := sd.proxyGet(ctx, ztunnelNamespace, ztunnel, ztunnelMetricsPort, "metrics")
+	if err != nil {
+		return fmt.Errorf("failed to scrape ztunnel %s: %w", ztunnel, err)
+	}
+
+	return sd.parseZtunnelMetrics(output, metrics)
+}
+
+// parseZtunnelMetrics maps ztunnel's TCP metrics for the destination service:
+// connections stand in for requests, and connections closed with a response
+// flag other than "-" count as errors.
+func (sd *ServiceDiscovery) parseZtunnelMetrics(prometheusText string, metrics *ServiceMeshMetrics) error {
+	var opened, closed, failed float64
+	var received, sent float64
+	var rejected, serviceLines []string
+
+	for _, line := range strings.Split(prometheusText, "\n") {
+		sample, ok := parsePromLine(line)
+		if !ok || !isDestination(sample.Labels, metrics.Namespace, metrics.ServiceName) {
+			continue
+		}
+		serviceLines = append(serviceLines, line)
+		if !plausibleValue(sample.Value) {
+			rejected = append(rejected, line)
+			continue
+		}
+
+		switch strings.TrimSuffix(sample.Name, "_total") {
+		case "istio_tcp_connections_opened":
+			opened += sample.Value
+			if flags, exists := sample.Labels["response_flags"]; exists && flags != "-" && flags != "" {
+				failed += sample.Value
+			}
+		case "istio_tcp_connections_closed":
+			closed += sample.Value
+		case "istio_tcp_received_bytes":
+			received += sample.Value
+		case "istio_tcp_sent_bytes":
+			sent += sample.Value
+		}
+	}
+
+	warnRejected(rejected, metrics.ServiceName)
+
+	active := opened - closed
+	if active < 0 {
+		active = 0
+	}
+
+	// ztunnel is L4 only; latency needs a waypoint proxy
+	normalized := telemetry.Normalized{
+		Requests:           opened,
+		OtherErrors:        failed,
+		ConnectionFailures: failed,
+		InboundBytes:       received,
+		OutboundBytes:      sent,
+		ActiveConnections:  active,
+	}
+	// The ztunnel serves the whole node, so mapped metrics are read only
+	// from the series for this service
+	sd.metricMapping.apply(strings.Join(serviceLines, "\n"), &normalized)
+	zeroed := sd.sanitizeNormalized(&normalized, metrics.ServiceName)
+	metrics.ApplyNormalized(normalized)
+	sd.signals.clearUnselected(metrics)
+	zeroNonFiniteMetrics(metrics, zeroed)
+	sd.recordCardinality(prometheusText, metrics)
+	metrics.Traces = []TraceSpan{}
+	metrics.AccessLogs = []AccessLogEntry{}
+
+	progress.Printf("    📊 Ambient metrics collected: Connections=%d, Errors=%.2f%%\n",
+		metrics.Traffic.TotalRequests,
+		metrics.Errors.ErrorRate)
+
+	return nil
+}
+
+func isDestination(labels map[string]string, namespace, serviceName string) bool {
+	if ns, exists := labels["destination_workload_namespace"]; exists && ns != namespace {
+		return false
+	}
+	for _, key := range []string{"destination_canonical_service", "destination_app"} {
+		if labels[key] == serviceName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package istio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const ztunnelMetrics = `# TYPE istio_tcp_connections_opened counter
+istio_tcp_connections_opened_total{destination_canonical_service="reviews",destination_workload_namespace="shop",response_flags="-"} 95
+istio_tcp_connections_opened_total{destination_canonical_service="reviews",destination_workload_namespace="shop",response_flags="UF"} 5
+istio_tcp_connections_opened_total{destination_canonical_service="ratings",destination_workload_namespace="shop",response_flags="-"} 400
+istio_tcp_connections_closed_total{destination_canonical_service="reviews",destination_workload_namespace="shop",response_flags="-"} 90
+istio_tcp_received_bytes_total{destination_canonical_service="reviews",destination_workload_namespace="shop"} 2048
+istio_tcp_sent_bytes_total{destination_canonical_service="reviews",destination_workload_namespace="shop"} 4096
+`
+
+// rawResponse is a canned body from the fake clientset's pod proxy.
+type rawResponse string
+
+func (r rawResponse) DoRaw(ctx context.Context) ([]byte, error) {
+	return []byte(r), nil
+}
+
+func (r rawResponse) Stream(ctx context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(string(r))), nil
+}
+
+// serveProxy answers every pod proxy GET on clientset with body, recording
+// each as "namespace/pod:port/path".
+func serveProxy(clientset *fake.Clientset, body string, scraped *[]string) {
+	clientset.AddProxyReactor("pods", func(action k8stesting.Action) (bool, restclient.ResponseWrapper, error) {
+		get := action.(k8stesting.ProxyGetAction)
+		*scraped = append(*scraped, fmt.Sprintf("%s/%s:%s/%s", get.GetNamespace(), get.GetName(), get.GetPort(), get.GetPath()))
+		return true, rawResponse(body), nil
+	})
+}
+
+func newAmbientClientset() *fake.Clientset {
+	return fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "shop",
+			Labels: map[string]string{ambientDataplaneLabel: ambientDataplaneValue},
+		}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "legacy"}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews-1", Namespace: "shop", Labels: map[string]string{"app": "reviews"}},
+			Spec:       corev1.PodSpec{NodeName: "node-a"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "batch-1", Namespace: "shop", Labels: map[string]string{"app": "batch", ambientDataplaneLabel: "none"}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "cron-1", Namespace: "legacy", Labels: map[string]string{"app": "cron", ambientDataplaneLabel: ambientDataplaneValue}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "legacy", Labels: map[string]string{"app": "web"}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "ztunnel-a", Namespace: ztunnelNamespace, Labels: map[string]string{"app": "ztunnel"}},
+			Spec:       corev1.PodSpec{NodeName: "node-a"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.7"},
+		},
+	)
+}
+
+func TestServiceDiscovery_DiscoverServices_Ambient(t *testing.T) {
+	sd := NewServiceDiscovery(newAmbientClientset(), nil)
+	sd.SetMeshMode(MeshIstioAmbient)
+
+	services, err := sd.DiscoverServices(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	found := make(map[string]bool)
+	for _, service := range services {
+		found[service] = true
+	}
+
+	for _, expected := range []string{"reviews.shop", "cron.legacy"} {
+		if !found[expected] {
+			t.Errorf("Expected ambient service %s to be discovered, got %v", expected, services)
+		}
+	}
+	for _, unexpected := range []string{"batch.shop", "web.legacy", "ztunnel.istio-system"} {
+		if found[unexpected] {
+			t.Errorf("Expected %s not to be discovered in ambient mode", unexpected)
+		}
+	}
+}
+
+func TestServiceDiscovery_DiscoverServices_SidecarModeIgnoresAmbient(t *testing.T) {
+	sd := NewServiceDiscovery(newAmbientClientset(), nil)
+
+	services, err := sd.DiscoverServices(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(services) != 0 {
+		t.Errorf("Expected no sidecar services in an ambient cluster, got %v", services)
+	}
+}
+
+func TestServiceDiscovery_CollectMetrics_Ambient(t *testing.T) {
+	clientset := newAmbientClientset()
+	var scraped []string
+	serveProxy(clientset, ztunnelMetrics, &scraped)
+	sd := NewServiceDiscovery(clientset, nil)
+	sd.SetMeshMode(MeshIstioAmbient)
+
+	metrics, err := sd.CollectMetrics(context.Background(), "shop", "reviews")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(scraped) != 1 || scraped[0] != "istio-system/ztunnel-a:15020/metrics" {
+		t.Errorf("Expected the node-local ztunnel scraped through the API server proxy, got %v", scraped)
+	}
+
+	if metrics.Traffic.TotalRequests != 100 {
+		t.Errorf("Expected 100 connections, got %d", metrics.Traffic.TotalRequests)
+	}
+
+	if metrics.Errors.ErrorRate != 5.0 {
+		t.Errorf("Expected 5%% error rate, got %.2f", metrics.Errors.ErrorRate)
+	}
+
+	if metrics.Saturation.Connections != 10 {
+		t.Errorf("Expected 10 active connections, got %d", metrics.Saturation.Connections)
+	}
+
+	if metrics.Traffic.InboundBytes != 2048 || metrics.Traffic.OutboundBytes != 4096 {
+		t.Errorf("Expected 2048/4096 bytes, got %d/%d", metrics.Traffic.InboundBytes, metrics.Traffic.OutboundBytes)
+	}
+}
+
+func TestParsePromLine(t *testing.T) {
+	sample, ok := parsePromLine(`istio_requests_total{response_code="200",request_operation="GET /a b"} 42 1700000000`)
+	if !ok {
+		t.Fatal("Expected line to parse")
+	}
+
+	if sample.Name != "istio_requests_total" {
+		t.Errorf("Expected name istio_requests_total, got %s", sample.Name)
+	}
+
+	if sample.Labels["request_operation"] != "GET /a b" {
+		t.Errorf("Expected label with spaces to be preserved, got %q", sample.Labels["request_operation"])
+	}
+
+	if sample.Value != 42 {
+		t.Errorf("Expected value 42, got %f", sample.Value)
+	}
+
+	if _, ok := parsePromLine("# HELP istio_requests_total Total requests"); ok {
+		t.Error("Expected comment line to be skipped")
+	}
+}
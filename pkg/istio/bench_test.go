@@ -0,0 +1,53 @@
+package istio
+
+import (
+	"testing"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// Setting up the exec for one scrape, before any connection is dialed. The
+// dial and the command itself cost the same either way.
+
+func BenchmarkExecutor_Fresh(b *testing.B) {
+	restConfig := newTLSRestConfig(b)
+	u := execURL("reviews-1")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := remotecommand.NewSPDYExecutor(restConfig, "POST", u); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExecutor_SharedTLS(b *testing.B) {
+	sd := NewServiceDiscovery(nil, newTLSRestConfig(b))
+	u := execURL("reviews-1")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sd.newExecutor(u); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Inflating a busy sidecar's scrape, fetched gzipped to cut what crosses
+// the exec stream. The payload sizes are reported alongside.
+func BenchmarkInflate(b *testing.B) {
+	plain := busySidecarStats(100)
+	compressed := gzipped(b, plain)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := inflate(compressed); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(plain)), "plain-bytes")
+	b.ReportMetric(float64(len(compressed)), "gzip-bytes")
+}
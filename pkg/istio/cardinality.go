@@ -0,0 +1,100 @@
+package istio
+
+import (
+	"sort"
+	"strings"
+
+	"smanalyzer/pkg/progress"
+)
+
+// DefaultCardinalityLimit is the number of series a single metric family
+// may expose before the scrape is flagged.
+const DefaultCardinalityLimit = 1000
+
+// CardinalityWarning reports a metric family with more series than the
+// limit, usually because a per-request value such as a request ID leaked
+// into a label.
+type CardinalityWarning struct {
+	Metric string `json:"metric"`
+	Series int    `json:"series"`
+	// Label is the label with the most distinct values, the likely culprit
+	Label          string `json:"label"`
+	DistinctValues int    `json:"distinct_values"`
+}
+
+// checkCardinality counts the distinct label sets of each metric family in
+// a scrape and reports the families above limit, most series first.
+// Histogram buckets and summary quantiles are expected to multiply series,
+// so le and quantile are left out of the label sets and never blamed.
+func checkCardinality(prometheusText string, limit int) []CardinalityWarning {
+	if limit <= 0 {
+		return nil
+	}
+
+	series := make(map[string]map[string]struct{})
+	values := make(map[string]map[string]map[string]struct{})
+	for _, line := range strings.Split(prometheusText, "\n") {
+		sample, ok := parsePromLine(line)
+		if !ok {
+			continue
+		}
+
+		if values[sample.Name] == nil {
+			series[sample.Name] = make(map[string]struct{})
+			values[sample.Name] = make(map[string]map[string]struct{})
+		}
+		var labelSet []string
+		for label, value := range sample.Labels {
+			if label == "le" || label == "quantile" {
+				continue
+			}
+			labelSet = append(labelSet, label+"="+value)
+			if values[sample.Name][label] == nil {
+				values[sample.Name][label] = make(map[string]struct{})
+			}
+			values[sample.Name][label][value] = struct{}{}
+		}
+		sort.Strings(labelSet)
+		series[sample.Name][strings.Join(labelSet, ",")] = struct{}{}
+	}
+
+	var warnings []CardinalityWarning
+	for metric, labelSets := range series {
+		if len(labelSets) <= limit {
+			continue
+		}
+
+		warning := CardinalityWarning{Metric: metric, Series: len(labelSets)}
+		for label, distinct := range values[metric] {
+			if len(distinct) > warning.DistinctValues || (len(distinct) == warning.DistinctValues && label < warning.Label) {
+				warning.Label = label
+				warning.DistinctValues = len(distinct)
+			}
+		}
+		warnings = append(warnings, warning)
+	}
+
+	sort.Slice(warnings, func(i, j int) bool {
+		if warnings[i].Series != warnings[j].Series {
+			return warnings[i].Series > warnings[j].Series
+		}
+		return warnings[i].Metric < warnings[j].Metric
+	})
+	return warnings
+}
+
+// recordCardinality records the families of a scrape above the cardinality
+// limit in metrics.Cardinality, warning about each.
+func (sd *ServiceDiscovery) recordCardinality(prometheusText string, metrics *ServiceMeshMetrics) {
+	metrics.Cardinality = checkCardinality(prometheusText, sd.cardinalityLimit)
+	for _, warning := range metrics.Cardinality {
+		progress.Printf("    Warning: %s has %d series, most from label %q with %d distinct values; per-request values in labels overload Prometheus\n",
+			warning.Metric, warning.Series, warning.Label, warning.DistinctValues)
+	}
+}
+
+// SetCardinalityLimit flags metric families exposing more series than
+// limit in a scrape. Zero disables the check.
+func (sd *ServiceDiscovery) SetCardinalityLimit(limit int) {
+	sd.cardinalityLimit = limit
+}
@@ -0,0 +1,103 @@
+package istio
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// leakedRequestIDs renders istio_requests_total with a request ID leaked
+// into a label, so every request gets its own series.
+func leakedRequestIDs(requests int) string {
+	var b strings.Builder
+	for i := 0; i < requests; i++ {
+		code := "200"
+		if i%10 == 0 {
+			code = "503"
+		}
+		fmt.Fprintf(&b, "istio_requests_total{reporter=\"destination\",response_code=%q,x_request_id=\"req-%06d\"} 1\n", code, i)
+	}
+	return b.String()
+}
+
+func TestParsePrometheusMetrics_FlagsLeakedLabel(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	sd.SetCardinalityLimit(100)
+
+	metrics := &ServiceMeshMetrics{}
+	if err := sd.parsePrometheusMetrics(leakedRequestIDs(500)+sampleMetrics, metrics); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(metrics.Cardinality) != 1 {
+		t.Fatalf("Expected one high-cardinality family, got %+v", metrics.Cardinality)
+	}
+	warning := metrics.Cardinality[0]
+	if warning.Metric != "istio_requests_total" || warning.Series < 500 {
+		t.Errorf("Expected istio_requests_total with 500+ series, got %+v", warning)
+	}
+	if warning.Label != "x_request_id" || warning.DistinctValues != 500 {
+		t.Errorf("Expected x_request_id blamed with 500 values, got %q with %d", warning.Label, warning.DistinctValues)
+	}
+	if metrics.Traffic.TotalRequests < 500 {
+		t.Errorf("Expected the requests still counted, got %d", metrics.Traffic.TotalRequests)
+	}
+}
+
+func TestParseLinkerdMetrics_FlagsLeakedLabel(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	sd.SetCardinalityLimit(100)
+
+	var leaked strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&leaked, "request_total{direction=\"inbound\",path=\"/orders/%d\"} 1\n", i)
+	}
+	metrics := &ServiceMeshMetrics{}
+	if err := sd.parseLinkerdMetrics(leaked.String(), metrics); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(metrics.Cardinality) != 1 || metrics.Cardinality[0].Metric != "request_total" || metrics.Cardinality[0].Label != "path" {
+		t.Errorf("Expected request_total flagged with path blamed, got %+v", metrics.Cardinality)
+	}
+}
+
+func TestCheckCardinality_IgnoresBucketsAndQuantiles(t *testing.T) {
+	buckets := func(routes int) string {
+		var b strings.Builder
+		for i := 0; i < routes; i++ {
+			for _, le := range []string{"1", "5", "10", "50", "100", "+Inf"} {
+				fmt.Fprintf(&b, "request_duration_bucket{route=\"/%d\",le=%q} 1\n", i, le)
+			}
+			fmt.Fprintf(&b, "request_duration{route=\"/%d\",quantile=\"0.5\"} 1\n", i)
+			fmt.Fprintf(&b, "request_duration{route=\"/%d\",quantile=\"0.99\"} 1\n", i)
+		}
+		return b.String()
+	}
+
+	// 12 bucket samples, but only 2 series once le is set aside
+	if warnings := checkCardinality(buckets(2), 10); len(warnings) != 0 {
+		t.Errorf("Expected buckets and quantiles not counted as series, got %+v", warnings)
+	}
+
+	warnings := checkCardinality(buckets(20), 10)
+	if len(warnings) != 2 {
+		t.Fatalf("Expected the bucket and quantile families flagged, got %+v", warnings)
+	}
+	for _, warning := range warnings {
+		if warning.Series != 20 || warning.Label != "route" {
+			t.Errorf("Expected 20 series with route blamed rather than le or quantile, got %+v", warning)
+		}
+	}
+}
+
+func TestCheckCardinality_WithinLimit(t *testing.T) {
+	if warnings := checkCardinality(leakedRequestIDs(50), 100); len(warnings) != 0 {
+		t.Errorf("Expected no warnings within the limit, got %+v", warnings)
+	}
+	if warnings := checkCardinality(leakedRequestIDs(500), 0); len(warnings) != 0 {
+		t.Errorf("Expected a zero limit to disable the check, got %+v", warnings)
+	}
+}
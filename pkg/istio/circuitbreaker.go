@@ -0,0 +1,62 @@
+package istio
+
+import "strings"
+
+// breakerResources pairs the remaining-capacity gauge Envoy exposes for each
+// circuit breaker threshold with the gauge counting what's in use. Envoy
+// only reports remaining capacity for thresholds with track_remaining set.
+var breakerResources = map[string]string{
+	"envoy_cluster_circuit_breakers_default_remaining_cx":      "cx",
+	"envoy_cluster_upstream_cx_active":                         "cx",
+	"envoy_cluster_circuit_breakers_default_remaining_rq":      "rq",
+	"envoy_cluster_upstream_rq_active":                         "rq",
+	"envoy_cluster_circuit_breakers_default_remaining_pending": "pending",
+	"envoy_cluster_upstream_rq_pending_active":                 "pending",
+}
+
+// breakerThreshold is one circuit breaker threshold of an upstream cluster.
+type breakerThreshold struct {
+	remaining float64
+	active    float64
+	tracked   bool
+}
+
+// breakerCapacity collects the circuit breaker gauges of a scrape, keyed by
+// upstream cluster and resource.
+type breakerCapacity map[[2]string]*breakerThreshold
+
+func (b breakerCapacity) add(sample promSample) {
+	resource, ok := breakerResources[sample.Name]
+	if !ok {
+		return
+	}
+
+	key := [2]string{sample.Labels["cluster_name"], resource}
+	threshold := b[key]
+	if threshold == nil {
+		threshold = &breakerThreshold{}
+		b[key] = threshold
+	}
+	if strings.Contains(sample.Name, "_remaining_") {
+		threshold.remaining = sample.Value
+		threshold.tracked = true
+	} else {
+		threshold.active = sample.Value
+	}
+}
+
+// usage is the largest fraction of a threshold in use. The threshold
+// itself isn't exported, so it's taken as what's in use plus what remains.
+func (b breakerCapacity) usage() float64 {
+	var usage float64
+	for _, threshold := range b {
+		limit := threshold.active + threshold.remaining
+		if !threshold.tracked || limit <= 0 {
+			continue
+		}
+		if used := threshold.active / limit; used > usage {
+			usage = used
+		}
+	}
+	return usage
+}
@@ -0,0 +1,38 @@
+package istio
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// gzipMagic opens every gzip stream.
+const gzipMagic = "\x1f\x8b"
+
+// statsCommand fetches the sidecar's stats asking for them gzipped. curl
+// --compressed would inflate them inside the pod, before they cross the exec
+// stream; without it curl writes the compressed bytes as they came, and
+// they are inflated here instead.
+func statsCommand(statsURL string) []string {
+	return []string{"curl", "-s", "-H", "Accept-Encoding: gzip", statsURL}
+}
+
+// inflate decompresses a scrape that came back gzipped. A server that
+// ignored Accept-Encoding sent plain text, which is returned as is.
+func inflate(output string) (string, error) {
+	if !strings.HasPrefix(output, gzipMagic) {
+		return output, nil
+	}
+	reader, err := gzip.NewReader(strings.NewReader(output))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress metrics: %w", err)
+	}
+	defer reader.Close()
+
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress metrics: %w", err)
+	}
+	return string(text), nil
+}
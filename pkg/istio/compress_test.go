@@ -0,0 +1,115 @@
+package istio
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipped(t testing.TB, text string) string {
+	t.Helper()
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// busySidecarStats renders the request metrics of a sidecar called by many
+// workloads, each with its own labelled series per response code and
+// histogram bucket, as on a busy pod of a large mesh. Counts vary as real
+// ones do, since identical values would flatter the compression.
+func busySidecarStats(workloads int) string {
+	random := rand.New(rand.NewSource(1))
+	var b strings.Builder
+	for w := 0; w < workloads; w++ {
+		for _, code := range []string{"200", "404", "503"} {
+			labels := fmt.Sprintf(`reporter="destination",source_workload="frontend-%d",source_workload_namespace="shop",source_principal="spiffe://cluster.local/ns/shop/sa/frontend-%d",destination_workload="reviews-v1",destination_service="reviews.shop.svc.cluster.local",destination_version="v1",request_protocol="http",response_code=%q,response_flags="-",connection_security_policy="mutual_tls"`, w, w, code)
+			requests := random.Intn(1000000)
+			fmt.Fprintf(&b, "istio_requests_total{%s} %d\n", labels, requests)
+			bucket := 0
+			for _, le := range []string{"1", "5", "10", "25", "50", "100", "250", "500", "1000", "2500", "5000", "10000"} {
+				bucket += random.Intn(requests/12 + 1)
+				fmt.Fprintf(&b, "istio_request_duration_milliseconds_bucket{%s,le=%q} %d\n", labels, le, min(bucket, requests))
+			}
+			fmt.Fprintf(&b, "istio_request_duration_milliseconds_bucket{%s,le=\"+Inf\"} %d\n", labels, requests)
+			fmt.Fprintf(&b, "istio_request_duration_milliseconds_sum{%s} %d\n", labels, requests*random.Intn(200))
+			fmt.Fprintf(&b, "istio_request_duration_milliseconds_count{%s} %d\n", labels, requests)
+		}
+	}
+	return b.String()
+}
+
+func TestNewHTTPClient_DecompressesGzip(t *testing.T) {
+	var acceptEncoding []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = append(acceptEncoding, r.Header.Get("Accept-Encoding"))
+		if r.URL.Path == "/plain" || !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Write([]byte(sampleMetrics))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write([]byte(gzipped(t, sampleMetrics)))
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, path := range []string{"/metrics", "/plain"} {
+		body, err := fetch(client, server.URL+path)
+		if err != nil {
+			t.Fatalf("Unexpected error fetching %s: %v", path, err)
+		}
+		if body != sampleMetrics {
+			t.Errorf("Expected the metrics text from %s, got %q", path, body)
+		}
+	}
+	for _, header := range acceptEncoding {
+		if header != "gzip" {
+			t.Errorf("Expected every scrape to ask for gzip, got Accept-Encoding %q", header)
+		}
+	}
+}
+
+func TestCollectMetrics_InflatesGzippedExecOutput(t *testing.T) {
+	for _, compress := range []bool{true, false} {
+		execCalls := 0
+		sd := newTestDiscovery(&execCalls, newTestPod("shop", "reviews-1", "reviews"))
+		sd.podExec = func(ctx context.Context, namespace, podName, container string, command []string) (string, error) {
+			if !compress {
+				return sampleMetrics, nil
+			}
+			if !strings.Contains(strings.Join(command, " "), "-H Accept-Encoding: gzip") {
+				t.Errorf("Expected curl to ask for gzip, got %q", command)
+			}
+			return gzipped(t, sampleMetrics), nil
+		}
+
+		metrics, err := sd.CollectMetrics(context.Background(), "shop", "reviews")
+		if err != nil {
+			t.Fatalf("Unexpected error (gzip %v): %v", compress, err)
+		}
+		if metrics.Traffic.TotalRequests != 100 || metrics.Errors.Errors5xx != 10 {
+			t.Errorf("Expected 100 requests and 10 5xx (gzip %v), got %+v and %+v", compress, metrics.Traffic, metrics.Errors)
+		}
+	}
+}
+
+func TestInflate_CorruptGzip(t *testing.T) {
+	truncated := gzipped(t, sampleMetrics)[:12]
+	if _, err := inflate(truncated); err == nil {
+		t.Error("Expected an error for a truncated gzip stream")
+	}
+}
@@ -3,15 +3,25 @@ package istio
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
-	"net/http"
+	"maps"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"smanalyzer/pkg/clock"
+	"smanalyzer/pkg/progress"
+	"smanalyzer/pkg/telemetry"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -19,14 +29,76 @@ import (
 )
 
 type ServiceDiscovery struct {
-	clientset  *kubernetes.Clientset
+	clientset  kubernetes.Interface
 	restConfig *rest.Config
-	httpClient *http.Client
+	// dynamicClient looks up Istio policy resources; nil skips the lookup
+	dynamicClient dynamic.Interface
+
+	// podExec runs a command in a pod container and returns its stdout.
+	// It defaults to an SPDY exec against the API server.
+	podExec func(ctx context.Context, namespace, podName, container string, command []string) (string, error)
+
+	collector MeshCollector
+
+	// Which replica to scrape, with the state the strategies keep
+	podSelection   PodSelectionStrategy
+	rotation       map[string]int
+	podTraffic     map[string]float64
+	randIntn       func(n int) int
+	selectionMutex sync.Mutex
+	// replicaCheck also scrapes the replicas that weren't selected
+	replicaCheck bool
+	// maxPodsPerService caps the replicas the replica check scrapes
+	maxPodsPerService int
+	// metricMapping reads golden signals from custom metric names
+	metricMapping MetricMapping
+	// quantiles are the latency quantiles collected besides the standard ones
+	quantiles []Quantile
+	// cardinalityLimit flags metric families with more series than this
+	cardinalityLimit int
+	// namespaceSelector limits an all-namespaces scan to matching namespaces
+	namespaceSelector labels.Selector
+	// signals limits collection to some golden-signal families; nil
+	// collects all of them
+	signals SignalSet
+	// meshSettings is how the sidecars are scraped, from the mesh config
+	meshSettings MeshSettings
+	// podCounts is what the last discovery found, to explain an empty one
+	podCounts PodCounts
+	// maxLatency drops latency percentiles above it; zero disables the cap
+	maxLatency time.Duration
+	// ignoreControlPlane skips the istiod health check before discovery
+	ignoreControlPlane bool
+	// byRoute breaks requests down by route
+	byRoute bool
+
+	// TLS settings shared by every exec into a pod; see execTLSConfig
+	execTLS      *tls.Config
+	execTLSReady bool
+	execMutex    sync.Mutex
+
+	// Short-lived cache of collected metrics keyed by namespace/service
+	cacheTTL   time.Duration
+	cache      map[string]cachedMetrics
+	cacheMutex sync.Mutex
+
+	// clock stamps collected metrics and ages cache entries
+	clock clock.Clock
+}
+
+type cachedMetrics struct {
+	metrics     *ServiceMeshMetrics
+	collectedAt time.Time
 }
 
 type ServiceMeshMetrics struct {
 	ServiceName string `json:"service_name"`
 	Namespace   string `json:"namespace"`
+	// Cluster names the member cluster in a multi-cluster mesh
+	Cluster string `json:"cluster,omitempty"`
+
+	// Mesh-agnostic form the fields below are derived from
+	Normalized telemetry.Normalized `json:"normalized"`
 
 	// Four Golden Signals (Istio standard)
 	Latency    LatencyMetrics    `json:"latency"`    // Response time distribution
@@ -34,6 +106,39 @@ type ServiceMeshMetrics struct {
 	Errors     ErrorMetrics      `json:"errors"`     // Error rates by type
 	Saturation SaturationMetrics `json:"saturation"` // Resource utilization
 
+	// Replicas is how many running meshed pods back the service
+	Replicas int `json:"replicas,omitempty"`
+
+	// Policy is the declared DestinationRule and VirtualService settings,
+	// when a dynamic client is configured and they exist
+	Policy *TrafficPolicy `json:"policy,omitempty"`
+
+	// Versions breaks requests down by the destination_version label, so
+	// a misbehaving canary can be told apart from the stable release
+	Versions map[string]VersionTraffic `json:"versions,omitempty"`
+
+	// Edges breaks requests down by source workload and destination
+	// service, so a failing dependency can be named
+	Edges []EdgeTraffic `json:"edges,omitempty"`
+
+	// Routes breaks requests down by route when the route breakdown is
+	// on, so one failing route isn't hidden by the service's aggregate
+	Routes []RouteTraffic `json:"routes,omitempty"`
+
+	// Ejections lists the upstream clusters outlier detection has
+	// ejected hosts from
+	Ejections []OutlierEjections `json:"outlier_ejections,omitempty"`
+
+	// Pods holds each replica's signals when the replica check is on
+	Pods map[string]PodSignal `json:"pods,omitempty"`
+	// Aggregate sums the replicas' requests, extrapolated when only a
+	// sample of them was scraped
+	Aggregate *PodAggregate `json:"aggregate,omitempty"`
+
+	// Cardinality lists the metric families whose series count exceeded
+	// the cardinality limit in the last scrape
+	Cardinality []CardinalityWarning `json:"cardinality,omitempty"`
+
 	// Service mesh specific
 	CircuitBreakers int   `json:"circuit_breakers"`
 	RetryCount      int64 `json:"retry_count"`
@@ -53,6 +158,9 @@ type LatencyMetrics struct {
 	P95  time.Duration `json:"p95"`
 	P99  time.Duration `json:"p99"`
 	Mean time.Duration `json:"mean"`
+	// Quantiles holds the configured extra quantiles keyed by name, e.g.
+	// p99.9
+	Quantiles map[string]time.Duration `json:"quantiles,omitempty"`
 }
 
 type TrafficMetrics struct {
@@ -60,13 +168,115 @@ type TrafficMetrics struct {
 	TotalRequests     int64   `json:"total_requests"`
 	InboundBytes      int64   `json:"inbound_bytes"`
 	OutboundBytes     int64   `json:"outbound_bytes"`
+	// Mean body sizes per request, in bytes
+	MeanRequestBytes  float64 `json:"mean_request_bytes,omitempty"`
+	MeanResponseBytes float64 `json:"mean_response_bytes,omitempty"`
 }
 
 type ErrorMetrics struct {
+	// ErrorRate is the client-visible (effective) error rate, in percent
 	ErrorRate    float64 `json:"error_rate"`
 	Errors4xx    int64   `json:"errors_4xx"`
 	Errors5xx    int64   `json:"errors_5xx"`
 	ConnFailures int64   `json:"connection_failures"`
+
+	// UpstreamErrorRate also counts requests whose upstream failure was
+	// masked by a successful retry, in percent
+	UpstreamErrorRate float64 `json:"upstream_error_rate"`
+	RetriedSuccesses  int64   `json:"retried_successes"`
+}
+
+// SeriesKey names the time series the service's metrics are stored under,
+// keeping same-named services in other namespaces or clusters apart.
+func (m *ServiceMeshMetrics) SeriesKey() string {
+	key := m.ServiceName
+	if m.Namespace != "" {
+		key = m.Namespace + "/" + key
+	}
+	if m.Cluster != "" {
+		key = m.Cluster + "/" + key
+	}
+	return key
+}
+
+// VersionTraffic is the request outcome for one version of a service.
+type VersionTraffic struct {
+	Requests float64 `json:"requests"`
+	Errors   float64 `json:"errors"`
+}
+
+// Names of the series each version's cumulative counts are stored under,
+// at its VersionSeriesKey
+const (
+	VersionRequestsMetric = "version_requests"
+	VersionErrorsMetric   = "version_errors"
+)
+
+// VersionSeriesKey is the storage key of one version's counts, kept apart
+// from the service's own series.
+func (m *ServiceMeshMetrics) VersionSeriesKey(version string) string {
+	return m.SeriesKey() + " version:" + version
+}
+
+// VersionErrorRates returns the error rate fraction of each version that
+// served requests since the counts in previous, from the last scan. The
+// counts are cumulative, so a version without previous counts, or whose
+// counts went down with a restarted proxy, is rated over its lifetime.
+func (m *ServiceMeshMetrics) VersionErrorRates(previous map[string]VersionTraffic) map[string]float64 {
+	rates := make(map[string]float64, len(m.Versions))
+	for version, traffic := range m.Versions {
+		if last, ok := previous[version]; ok && traffic.Requests >= last.Requests && traffic.Errors >= last.Errors {
+			traffic.Requests -= last.Requests
+			traffic.Errors -= last.Errors
+		}
+		if traffic.Requests > 0 {
+			rates[version] = traffic.Errors / traffic.Requests
+		}
+	}
+	return rates
+}
+
+// addVersions sums the version breakdown of another replica into m's.
+func (m *ServiceMeshMetrics) addVersions(versions map[string]VersionTraffic) {
+	for version, traffic := range versions {
+		if m.Versions == nil {
+			m.Versions = make(map[string]VersionTraffic, len(versions))
+		}
+		sum := m.Versions[version]
+		sum.Requests += traffic.Requests
+		sum.Errors += traffic.Errors
+		m.Versions[version] = sum
+	}
+}
+
+// EdgeTraffic is the request outcome between a source workload and a
+// destination service, from istio_requests_total's labels.
+type EdgeTraffic struct {
+	Source      string  `json:"source"`
+	Destination string  `json:"destination"`
+	Requests    float64 `json:"requests"`
+	Errors      float64 `json:"errors"`
+}
+
+// ErrorRate is the fraction of the edge's requests that failed.
+func (e EdgeTraffic) ErrorRate() float64 {
+	if e.Requests <= 0 {
+		return 0
+	}
+	return e.Errors / e.Requests
+}
+
+// WorstEdge returns the edge with the highest error rate among those that
+// had errors.
+func (m *ServiceMeshMetrics) WorstEdge() (EdgeTraffic, bool) {
+	var worst EdgeTraffic
+	found := false
+	for _, edge := range m.Edges {
+		if edge.Errors > 0 && (!found || edge.ErrorRate() > worst.ErrorRate()) {
+			worst, found = edge, true
+		}
+	}
+	return worst, found
 }
 
 type SaturationMetrics struct {
@@ -100,66 +310,285 @@ type AccessLogEntry struct {
 	DestinationIP string        `json:"destination_ip"`
 }
 
-func NewServiceDiscovery(clientset *kubernetes.Clientset, restConfig *rest.Config) *ServiceDiscovery {
-	return &ServiceDiscovery{
-		clientset:  clientset,
-		restConfig: restConfig,
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+// ApplyNormalized records the collector's normalized signals and derives the
+// structured golden-signal fields from them.
+func (m *ServiceMeshMetrics) ApplyNormalized(n telemetry.Normalized) {
+	m.Normalized = n
+
+	m.Traffic = TrafficMetrics{
+		TotalRequests:     int64(n.Requests),
+		RequestsPerSecond: n.RequestsPerSecond(),
+		InboundBytes:      int64(n.InboundBytes),
+		OutboundBytes:     int64(n.OutboundBytes),
+		MeanRequestBytes:  n.RequestSizeMean,
+		MeanResponseBytes: n.ResponseSizeMean,
+	}
+
+	m.Latency = LatencyMetrics{
+		P50:  n.LatencyP50,
+		P90:  n.LatencyP90,
+		P95:  n.LatencyP95,
+		P99:  n.LatencyP99,
+		Mean: n.MeanLatency(),
+	}
+
+	m.Errors = ErrorMetrics{
+		ErrorRate:         n.ErrorRate() * 100,
+		Errors4xx:         int64(n.Errors4xx),
+		Errors5xx:         int64(n.Errors5xx),
+		ConnFailures:      int64(n.ConnectionFailures),
+		UpstreamErrorRate: n.UpstreamErrorRate() * 100,
+		RetriedSuccesses:  int64(n.MaskedFailures),
+	}
+
+	m.Saturation = SaturationMetrics{
+		CPUUsage:    n.CPUUsage,
+		MemoryUsage: n.MemoryUsage,
+		Connections: int64(n.ActiveConnections),
+		PendingReqs: int64(n.PendingRequests),
+	}
+
+	m.CircuitBreakers = int(n.CircuitBreakersOpen)
+	m.RetryCount = int64(n.Retries)
+	m.TimeoutCount = int64(n.Timeouts)
+}
+
+func NewServiceDiscovery(clientset kubernetes.Interface, restConfig *rest.Config) *ServiceDiscovery {
+	sd := &ServiceDiscovery{
+		clientset:    clientset,
+		restConfig:   restConfig,
+		cache:        make(map[string]cachedMetrics),
+		podSelection: PodSelectFirst,
+		rotation:     make(map[string]int),
+		podTraffic:   make(map[string]float64),
+		randIntn:     defaultRandIntn,
+
+		cardinalityLimit: DefaultCardinalityLimit,
+		maxLatency:       DefaultMaxLatency,
+		meshSettings:     DefaultMeshSettings(),
+		clock:            clock.Real{},
+	}
+	sd.podExec = sd.execInPod
+	sd.collector = &sidecarCollector{sd: sd}
+	return sd
+}
+
+// SetCacheTTL sets how long collected metrics are reused before a service is
+// scraped again. A zero TTL disables caching.
+func (sd *ServiceDiscovery) SetCacheTTL(ttl time.Duration) {
+	sd.cacheMutex.Lock()
+	defer sd.cacheMutex.Unlock()
+
+	sd.cacheTTL = ttl
+	if ttl <= 0 {
+		sd.cache = make(map[string]cachedMetrics)
 	}
 }
 
+// SetClock replaces the clock that timestamps collected metrics.
+func (sd *ServiceDiscovery) SetClock(c clock.Clock) {
+	sd.clock = c
+}
+
+// SetIgnoreControlPlane skips checking istiod and the ingress gateway
+// before discovery, for air-gapped or partly broken clusters where the
+// control plane is down or can't be read. Whatever sidecars answer are
+// still collected, but with istiod down their configuration may be stale.
+func (sd *ServiceDiscovery) SetIgnoreControlPlane(ignore bool) {
+	sd.ignoreControlPlane = ignore
+}
+
 func (sd *ServiceDiscovery) DiscoverServices(ctx context.Context, namespace string) ([]string, error) {
 	// First check Istio control plane health
-	if err := sd.checkControlPlaneHealth(ctx); err != nil {
-		fmt.Printf("Warning: Istio control plane issues detected: %v\n", err)
+	if !sd.ignoreControlPlane {
+		if err := sd.checkControlPlaneHealth(ctx); err != nil {
+			progress.Printf("Warning: Istio control plane issues detected: %v\n", err)
+		}
 	}
 
-	fmt.Printf("Debug: DiscoverServices called with namespace='%s'\n", namespace)
+	progress.Printf("Debug: DiscoverServices called with namespace='%s'\n", namespace)
 
 	// Get pods with Istio sidecars instead of services
-	listOptions := metav1.ListOptions{}
-	searchNamespace := namespace
+	searchNamespaces := []string{namespace}
 	if namespace == "" {
-		searchNamespace = metav1.NamespaceAll
+		searchNamespaces = []string{metav1.NamespaceAll}
+		if sd.namespaceSelector != nil {
+			selected, err := sd.selectNamespaces(ctx)
+			if err != nil {
+				return nil, err
+			}
+			searchNamespaces = selected
+		}
 	}
 
-	fmt.Printf("Debug: Searching in namespace='%s'\n", searchNamespace)
+	var allPods []corev1.Pod
+	for _, searchNamespace := range searchNamespaces {
+		progress.Printf("Debug: Searching in namespace='%s'\n", searchNamespace)
 
-	pods, err := sd.clientset.CoreV1().Pods(searchNamespace).List(ctx, listOptions)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list pods: %w", err)
-	}
+		pods, err := sd.clientset.CoreV1().Pods(searchNamespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods: %w", err)
+		}
 
-	fmt.Printf("Debug: Found %d total pods in namespace '%s'\n", len(pods.Items), searchNamespace)
+		progress.Printf("Debug: Found %d total pods in namespace '%s'\n", len(pods.Items), searchNamespace)
+		allPods = append(allPods, pods.Items...)
+	}
 
-	serviceSet := make(map[string]bool)
-	for _, pod := range pods.Items {
-		if hasIstioSidecar(pod.Labels, pod.Annotations) {
-			// Extract service name from app label or pod name
-			if serviceName := getServiceName(pod.Labels); serviceName != "" {
-				// Include namespace in service identifier for cross-namespace scanning
-				serviceKey := fmt.Sprintf("%s.%s", serviceName, pod.Namespace)
-				serviceSet[serviceKey] = true
-				fmt.Printf("Debug: Found Istio service: %s\n", serviceKey)
-			}
+	meshedPods, err := sd.collector.MeshedPods(ctx, allPods)
+	if err != nil {
+		return nil, err
+	}
+	sd.podCounts = PodCounts{Pods: len(allPods), Meshed: len(meshedPods)}
+
+	type serviceID struct{ namespace, name string }
+	serviceSet := make(map[string]serviceID)
+	for _, pod := range meshedPods {
+		// Extract service name from app label or pod name
+		if serviceName := getServiceName(pod.Labels); serviceName != "" {
+			// Include namespace in service identifier for cross-namespace scanning
+			serviceKey := fmt.Sprintf("%s.%s", serviceName, pod.Namespace)
+			serviceSet[serviceKey] = serviceID{namespace: pod.Namespace, name: serviceName}
+			progress.Printf("Debug: Found meshed service: %s\n", serviceKey)
 		}
 	}
 
+	// Sort by namespace then name so output is stable between runs
 	var serviceNames []string
 	for serviceKey := range serviceSet {
 		serviceNames = append(serviceNames, serviceKey)
 	}
+	sort.Slice(serviceNames, func(i, j int) bool {
+		a, b := serviceSet[serviceNames[i]], serviceSet[serviceNames[j]]
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		return a.name < b.name
+	})
 
 	return serviceNames, nil
 }
 
+// SetNamespaceSelector limits scans of all namespaces to the namespaces
+// whose labels match selector, e.g. "monitoring=enabled". An empty selector
+// scans every namespace.
+func (sd *ServiceDiscovery) SetNamespaceSelector(selector string) error {
+	if selector == "" {
+		sd.namespaceSelector = nil
+		return nil
+	}
+
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return fmt.Errorf("invalid namespace selector %q: %w", selector, err)
+	}
+	sd.namespaceSelector = parsed
+	return nil
+}
+
+// selectNamespaces lists the namespaces matching the namespace selector,
+// sorted by name.
+func (sd *ServiceDiscovery) selectNamespaces(ctx context.Context) ([]string, error) {
+	namespaces, err := sd.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: sd.namespaceSelector.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	var names []string
+	for _, ns := range namespaces.Items {
+		names = append(names, ns.Name)
+	}
+	sort.Strings(names)
+
+	progress.Printf("Debug: Namespace selector %q matched %d namespaces: %s\n", sd.namespaceSelector.String(), len(names), strings.Join(names, ", "))
+	return names, nil
+}
+
 func (sd *ServiceDiscovery) CollectMetrics(ctx context.Context, namespace, serviceName string) (*ServiceMeshMetrics, error) {
+	if cached, ok := sd.cachedMetrics(namespace, serviceName); ok {
+		return cached, nil
+	}
+
+	metrics, err := sd.collectServiceMetrics(ctx, namespace, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	sd.storeCachedMetrics(namespace, serviceName, metrics)
+	return metrics, nil
+}
+
+func (sd *ServiceDiscovery) cachedMetrics(namespace, serviceName string) (*ServiceMeshMetrics, bool) {
+	sd.cacheMutex.Lock()
+	defer sd.cacheMutex.Unlock()
+
+	if sd.cacheTTL <= 0 {
+		return nil, false
+	}
+
+	entry, exists := sd.cache[namespace+"/"+serviceName]
+	if !exists || sd.clock.Now().Sub(entry.collectedAt) > sd.cacheTTL {
+		return nil, false
+	}
+
+	// Hand out a copy so callers can't mutate the cached entry
+	return copyMetrics(entry.metrics), true
+}
+
+func (sd *ServiceDiscovery) storeCachedMetrics(namespace, serviceName string, metrics *ServiceMeshMetrics) {
+	sd.cacheMutex.Lock()
+	defer sd.cacheMutex.Unlock()
+
+	if sd.cacheTTL <= 0 {
+		return
+	}
+
+	sd.cache[namespace+"/"+serviceName] = cachedMetrics{
+		metrics:     copyMetrics(metrics),
+		collectedAt: sd.clock.Now(),
+	}
+}
+
+// copyMetrics returns a deep copy of metrics, so the maps and slices it holds
+// aren't shared with the original.
+func copyMetrics(metrics *ServiceMeshMetrics) *ServiceMeshMetrics {
+	copied := *metrics
+	copied.Latency.Quantiles = maps.Clone(metrics.Latency.Quantiles)
+	copied.Versions = maps.Clone(metrics.Versions)
+	copied.Edges = slices.Clone(metrics.Edges)
+	copied.Routes = slices.Clone(metrics.Routes)
+	copied.Ejections = slices.Clone(metrics.Ejections)
+	copied.Pods = maps.Clone(metrics.Pods)
+	copied.Cardinality = slices.Clone(metrics.Cardinality)
+	copied.AccessLogs = slices.Clone(metrics.AccessLogs)
+	copied.Labels = maps.Clone(metrics.Labels)
+
+	if metrics.Policy != nil {
+		policy := *metrics.Policy
+		policy.CircuitBreaker = maps.Clone(metrics.Policy.CircuitBreaker)
+		policy.Retries = maps.Clone(metrics.Policy.Retries)
+		copied.Policy = &policy
+	}
+	if metrics.Aggregate != nil {
+		aggregate := *metrics.Aggregate
+		copied.Aggregate = &aggregate
+	}
+	if metrics.Traces != nil {
+		copied.Traces = make([]TraceSpan, len(metrics.Traces))
+		for i, span := range metrics.Traces {
+			span.Tags = maps.Clone(span.Tags)
+			copied.Traces[i] = span
+		}
+	}
+	return &copied
+}
+
+func (sd *ServiceDiscovery) collectServiceMetrics(ctx context.Context, namespace, serviceName string) (*ServiceMeshMetrics, error) {
 	metrics := &ServiceMeshMetrics{
 		ServiceName: serviceName,
 		Namespace:   namespace,
-		Timestamp:   time.Now(),
+		Timestamp:   sd.clock.Now(),
 		Labels:      make(map[string]string),
 	}
 
@@ -172,15 +601,29 @@ func (sd *ServiceDiscovery) CollectMetrics(ctx context.Context, namespace, servi
 	if len(pods) == 0 {
 		return nil, fmt.Errorf("no pods found for service %s", serviceName)
 	}
-
-	// Collect metrics from the first available pod (could aggregate across all pods)
-	for _, pod := range pods {
-		fmt.Printf("  Attempting to collect metrics from pod %s\n", pod)
-		if err := sd.collectEnvoyMetrics(ctx, pod, metrics); err != nil {
-			fmt.Printf("  Failed to collect metrics from pod %s: %v\n", pod, err)
+	metrics.Replicas = len(pods)
+
+	// Collect metrics from the selected pod, falling back to the others in
+	// turn (could aggregate across all pods)
+	for _, pod := range sd.orderPods(namespace, serviceName, pods) {
+		progress.Printf("  Attempting to collect metrics from pod %s\n", pod.Name)
+		if err := sd.collector.Collect(ctx, pod, metrics); err != nil {
+			progress.Printf("  Failed to collect metrics from pod %s: %v\n", pod.Name, err)
 			continue // Try next pod if this one fails
 		}
-		fmt.Printf("  ✓ Successfully collected metrics from pod %s\n", pod)
+		progress.Printf("  ✓ Successfully collected metrics from pod %s\n", pod.Name)
+		metrics.Labels = workloadLabels(pod, serviceName)
+		sd.recordPodTraffic(pod, metrics.Normalized.Requests)
+		if sd.replicaCheck && len(pods) > 1 {
+			metrics.Pods = sd.collectPodSignals(ctx, pods, pod.Name, metrics)
+			metrics.Aggregate = aggregatePods(metrics.Pods, len(pods))
+		}
+
+		policy, err := sd.LookupTrafficPolicy(ctx, namespace, serviceName)
+		if err != nil {
+			progress.Printf("  Warning: %v\n", err)
+		}
+		metrics.Policy = policy
 		return metrics, nil
 	}
 
@@ -208,7 +651,7 @@ func hasIstioSidecar(labels, annotations map[string]string) bool {
 	return false
 }
 
-func (sd *ServiceDiscovery) getServicePods(ctx context.Context, namespace, serviceName string) ([]string, error) {
+func (sd *ServiceDiscovery) getServicePods(ctx context.Context, namespace, serviceName string) ([]corev1.Pod, error) {
 	listOptions := metav1.ListOptions{
 		LabelSelector: fmt.Sprintf("app=%s", serviceName),
 	}
@@ -218,40 +661,69 @@ func (sd *ServiceDiscovery) getServicePods(ctx context.Context, namespace, servi
 		return nil, fmt.Errorf("failed to list pods: %w", err)
 	}
 
-	var podNames []string
-	for _, pod := range pods.Items {
-		if hasIstioSidecar(pod.Labels, pod.Annotations) && pod.Status.Phase == "Running" {
-			podNames = append(podNames, pod.Name)
+	meshedPods, err := sd.collector.MeshedPods(ctx, pods.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	var runningPods []corev1.Pod
+	for _, pod := range meshedPods {
+		if pod.Status.Phase == "Running" {
+			runningPods = append(runningPods, pod)
 		}
 	}
-	return podNames, nil
+	return runningPods, nil
 }
 
-func (sd *ServiceDiscovery) collectEnvoyMetrics(ctx context.Context, podName string, metrics *ServiceMeshMetrics) error {
-	// Use kubectl exec to access Istio's Prometheus metrics endpoint
-	// This endpoint exposes Envoy metrics in Prometheus format on port 15020
+func (sd *ServiceDiscovery) collectEnvoyMetrics(ctx context.Context, pod corev1.Pod, metrics *ServiceMeshMetrics) error {
+	podName := pod.Name
+
+	// Fail clearly rather than with an opaque stream error when the
+	// sidecar isn't there to exec into
+	if err := requireContainer(pod, istioProxyContainer); err != nil {
+		return err
+	}
+
+	// Use kubectl exec to access Istio's Prometheus metrics endpoint,
+	// on the port the mesh config says the sidecar serves it
 
 	// Execute curl command to get Prometheus metrics from istio-proxy container
-	cmd := []string{"curl", "-s", "http://localhost:15020/stats/prometheus"}
+	cmd := statsCommand(sd.sidecarStatsURL())
+
+	metricsOutput, err := sd.podExec(ctx, metrics.Namespace, podName, istioProxyContainer, cmd)
+	if err != nil {
+		return err
+	}
+	if metricsOutput, err = inflate(metricsOutput); err != nil {
+		return fmt.Errorf("pod %s: %w", podName, err)
+	}
 
+	if len(metricsOutput) == 0 {
+		return fmt.Errorf("no metrics output received from pod %s", podName)
+	}
+
+	return sd.parsePrometheusMetrics(metricsOutput, metrics)
+}
+
+func (sd *ServiceDiscovery) execInPod(ctx context.Context, namespace, podName, container string, command []string) (string, error) {
 	// Create the exec request using the proper API
 	req := sd.clientset.CoreV1().RESTClient().Post().
 		Resource("pods").
 		Name(podName).
-		Namespace(metrics.Namespace).
+		Namespace(namespace).
 		SubResource("exec").
 		VersionedParams(&corev1.PodExecOptions{
-			Container: "istio-proxy",
-			Command:   cmd,
+			Container: container,
+			Command:   command,
 			Stdin:     false,
 			Stdout:    true,
 			Stderr:    true,
 			TTY:       false,
 		}, runtime.NewParameterCodec(scheme.Scheme))
 
-	exec, err := remotecommand.NewSPDYExecutor(sd.restConfig, "POST", req.URL())
+	exec, err := sd.newExecutor(req.URL())
 	if err != nil {
-		return fmt.Errorf("failed to create executor: %w", err)
+		return "", fmt.Errorf("failed to create executor: %w", err)
 	}
 
 	var stdout, stderr bytes.Buffer
@@ -261,28 +733,117 @@ func (sd *ServiceDiscovery) collectEnvoyMetrics(ctx context.Context, podName str
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to execute command: %w (stderr: %s)", err, stderr.String())
+		return "", fmt.Errorf("failed to execute command: %w (stderr: %s)", err, stderr.String())
 	}
 
 	if stderr.Len() > 0 {
-		return fmt.Errorf("command stderr: %s", stderr.String())
+		return "", fmt.Errorf("command stderr: %s", stderr.String())
 	}
 
-	metricsOutput := stdout.String()
-	if len(metricsOutput) == 0 {
-		return fmt.Errorf("no metrics output received from pod %s", podName)
+	return stdout.String(), nil
+}
+
+const istioProxyContainer = "istio-proxy"
+
+// recordVersionTraffic adds an istio_requests_total sample to the breakdown
+// by destination_version. Samples the proxy reported as a client are about
+// the services it calls, not its own versions, so they are skipped.
+func recordVersionTraffic(versions map[string]VersionTraffic, line string) {
+	sample, ok := parsePromLine(line)
+	if !ok || sample.Labels["reporter"] == "source" {
+		return
 	}
 
-	return sd.parsePrometheusMetrics(metricsOutput, metrics)
+	version := sample.Labels["destination_version"]
+	if version == "" || version == "unknown" {
+		return
+	}
+
+	traffic := versions[version]
+	traffic.Requests += sample.Value
+	if code := sample.Labels["response_code"]; strings.HasPrefix(code, "4") || strings.HasPrefix(code, "5") {
+		traffic.Errors += sample.Value
+	}
+	versions[version] = traffic
+}
+
+// recordEdgeTraffic adds an istio_requests_total sample to the breakdown by
+// source workload and destination service. Unlike the version breakdown it
+// keeps both reporters: a service's outbound calls are only reported by its
+// own sidecar as reporter="source". Samples without both labels are skipped.
+func recordEdgeTraffic(edges map[[2]string]EdgeTraffic, line string) {
+	sample, ok := parsePromLine(line)
+	if !ok {
+		return
+	}
+
+	source := sample.Labels["source_workload"]
+	destination := sample.Labels["destination_service_name"]
+	if destination == "" {
+		// destination_service is the FQDN, e.g. ratings.shop.svc.cluster.local
+		destination, _, _ = strings.Cut(sample.Labels["destination_service"], ".")
+	}
+	if source == "" || source == "unknown" || destination == "" || destination == "unknown" {
+		return
+	}
+
+	key := [2]string{source, destination}
+	edge := edges[key]
+	edge.Source, edge.Destination = source, destination
+	edge.Requests += sample.Value
+	if code := sample.Labels["response_code"]; strings.HasPrefix(code, "4") || strings.HasPrefix(code, "5") {
+		edge.Errors += sample.Value
+	}
+	edges[key] = edge
+}
+
+// sortedEdges lists the edges by source then destination.
+func sortedEdges(edges map[[2]string]EdgeTraffic) []EdgeTraffic {
+	var sorted []EdgeTraffic
+	for _, edge := range edges {
+		sorted = append(sorted, edge)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Source != sorted[j].Source {
+			return sorted[i].Source < sorted[j].Source
+		}
+		return sorted[i].Destination < sorted[j].Destination
+	})
+	return sorted
+}
+
+// requireContainer checks the pod spec for the named container, including
+// native sidecars declared as init containers.
+func requireContainer(pod corev1.Pod, name string) error {
+	var available []string
+	for _, containers := range [][]corev1.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+		for _, container := range containers {
+			if container.Name == name {
+				return nil
+			}
+			available = append(available, container.Name)
+		}
+	}
+	return fmt.Errorf("container %s not found in pod %s (available: %s)", name, pod.Name, strings.Join(available, ", "))
 }
 
 func (sd *ServiceDiscovery) parsePrometheusMetrics(prometheusText string, metrics *ServiceMeshMetrics) error {
 	lines := strings.Split(prometheusText, "\n")
 
 	var requestTotal, errors4xx, errors5xx float64
-	var p50, p90, p95, p99 float64
+	latency := newLatencyDistribution("istio_request_duration_milliseconds")
 	var inboundBytes, outboundBytes float64
+	var requestSizes, responseSizes float64
 	var connections, pendingReqs float64
+	var retries, retrySuccesses float64
+	var timeouts, circuitBreakers float64
+	var connFailures float64
+	breakers := make(breakerCapacity)
+	ejections := make(outlierEjections)
+	versions := make(map[string]VersionTraffic)
+	edges := make(map[[2]string]EdgeTraffic)
+	routes := make(map[string]RouteTraffic)
+	var rejected []string
 
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -290,17 +851,42 @@ func (sd *ServiceDiscovery) parsePrometheusMetrics(prometheusText string, metric
 			continue // Skip comments and empty lines
 		}
 
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
+		// Label values may contain spaces, e.g. request_operation="GET
+		// /cart", so the value is read after the label set
+		metricName, rest := line, ""
+		if end := strings.LastIndexByte(line, '}'); end >= 0 {
+			metricName, rest = line[:end+1], line[end+1:]
+		} else if space := strings.IndexAny(line, " \t"); space >= 0 {
+			metricName, rest = line[:space], line[space:]
+		}
+		parts := strings.Fields(rest)
+		if len(parts) < 1 {
 			continue
 		}
 
-		metricName := parts[0]
-		valueStr := parts[1]
+		valueStr := parts[0]
 		value, err := strconv.ParseFloat(valueStr, 64)
 		if err != nil {
 			continue
 		}
+		// Skipped rather than summed into the signals, where one bad sample
+		// would poison the stored series
+		if !plausibleValue(value) {
+			rejected = append(rejected, line)
+			continue
+		}
+
+		baseName := metricName
+		if brace := strings.IndexByte(baseName, '{'); brace >= 0 {
+			baseName = baseName[:brace]
+		}
+		// OpenMetrics adds a _created series holding each counter's and
+		// histogram's creation timestamp, which would otherwise be summed
+		// into the metric it belongs to. Families not being collected are
+		// skipped too.
+		if strings.HasSuffix(baseName, "_created") || !sd.signals.wants(baseName) {
+			continue
+		}
 
 		// Parse Istio/Envoy metrics
 		if strings.Contains(metricName, "istio_requests_total") {
@@ -312,18 +898,17 @@ func (sd *ServiceDiscovery) parsePrometheusMetrics(prometheusText string, metric
 			} else if strings.Contains(metricName, "response_code=\"5") {
 				errors5xx += value
 			}
+			recordVersionTraffic(versions, line)
+			recordEdgeTraffic(edges, line)
+			if sd.byRoute {
+				recordRouteTraffic(routes, line)
+			}
 		}
 
-		// Parse request duration percentiles
-		if strings.Contains(metricName, "istio_request_duration_milliseconds") {
-			if strings.Contains(metricName, "quantile=\"0.5\"") {
-				p50 = value
-			} else if strings.Contains(metricName, "quantile=\"0.9\"") {
-				p90 = value
-			} else if strings.Contains(metricName, "quantile=\"0.95\"") {
-				p95 = value
-			} else if strings.Contains(metricName, "quantile=\"0.99\"") {
-				p99 = value
+		// Parse request duration percentiles, from a summary or a histogram
+		if strings.HasPrefix(baseName, latency.name) {
+			if sample, ok := parsePromLine(line); ok {
+				latency.add(sample)
 			}
 		}
 
@@ -332,68 +917,126 @@ func (sd *ServiceDiscovery) parsePrometheusMetrics(prometheusText string, metric
 			connections = value
 		}
 
-		// Parse bytes transferred
-		if strings.Contains(metricName, "istio_request_bytes") {
+		// Parse retries; match the exact name so the _success and _overflow
+		// variants aren't folded into the retry count
+		switch baseName {
+		// Request and response sizes are histograms: the bytes transferred
+		// are the _sum, the requests measured the _count, and the buckets
+		// would only count them again
+		case "istio_request_bytes_sum", "istio_request_bytes":
 			inboundBytes += value
-		}
-		if strings.Contains(metricName, "istio_response_bytes") {
+		case "istio_request_bytes_count":
+			requestSizes += value
+		case "istio_response_bytes_sum", "istio_response_bytes":
 			outboundBytes += value
+		case "istio_response_bytes_count":
+			responseSizes += value
+		case "envoy_cluster_upstream_rq_retry":
+			retries += value
+		case "envoy_cluster_upstream_rq_retry_success":
+			retrySuccesses += value
+		case "envoy_cluster_upstream_cx_connect_fail":
+			// Summed over every upstream cluster the proxy connects to
+			connFailures += value
+		case "envoy_http_downstream_rq_active":
+			// In-flight requests, summed over the inbound and outbound
+			// connection managers
+			pendingReqs += value
 		}
 
 		// Parse circuit breaker metrics
-		if strings.Contains(metricName, "envoy_cluster_upstream_rq_retry") {
-			metrics.RetryCount = int64(value)
-		}
 		if strings.Contains(metricName, "envoy_cluster_upstream_rq_timeout") {
-			metrics.TimeoutCount = int64(value)
+			timeouts = value
 		}
 		if strings.Contains(metricName, "envoy_cluster_circuit_breakers") && strings.Contains(metricName, "cx_open") {
-			metrics.CircuitBreakers = int(value)
+			circuitBreakers = value
+		}
+		if _, ok := breakerResources[baseName]; ok {
+			if sample, ok := parsePromLine(line); ok {
+				breakers.add(sample)
+			}
+		}
+		if baseName == ejectionsActiveMetric || baseName == ejectionsTotalMetric {
+			if sample, ok := parsePromLine(line); ok {
+				ejections.add(sample)
+			}
 		}
 	}
+	ejectionsActive, ejectionsTotal := ejections.totals()
+	warnRejected(rejected, metrics.ServiceName)
 
-	// Populate structured metrics
-	totalRequests := requestTotal + errors4xx + errors5xx
-	metrics.Traffic = TrafficMetrics{
-		TotalRequests:     int64(totalRequests),
-		RequestsPerSecond: totalRequests / 60, // Approximate RPS over last minute
-		InboundBytes:      int64(inboundBytes),
-		OutboundBytes:     int64(outboundBytes),
+	// A corrupted histogram can report absurd percentiles
+	percentiles := []float64{latency.quantile(0.5), latency.quantile(0.9), latency.quantile(0.95), latency.quantile(0.99)}
+	for _, q := range sd.quantiles {
+		percentiles = append(percentiles, latency.quantile(q.Value))
 	}
-
-	metrics.Latency = LatencyMetrics{
-		P50:  time.Duration(p50) * time.Millisecond,
-		P90:  time.Duration(p90) * time.Millisecond,
-		P95:  time.Duration(p95) * time.Millisecond,
-		P99:  time.Duration(p99) * time.Millisecond,
-		Mean: time.Duration((p50+p90+p95+p99)/4) * time.Millisecond, // Approximate mean
+	latencyAbsent := false
+	if ms, bad := sd.implausibleLatency(percentiles...); bad {
+		progress.Printf("    Warning: dropped the latency percentiles of %s: %.0fms is negative or above the %v cap\n", metrics.ServiceName, ms, sd.maxLatency)
+		latency = newLatencyDistribution(latency.name)
+		latencyAbsent = true
 	}
 
-	errorRate := float64(0)
-	if totalRequests > 0 {
-		errorRate = ((errors4xx + errors5xx) / totalRequests) * 100
+	// istio_requests_total reports the outcome the client saw. Each
+	// successful retry hid one upstream failure from it.
+	normalized := telemetry.Normalized{
+		Requests:            requestTotal + errors4xx + errors5xx,
+		Errors4xx:           errors4xx,
+		Errors5xx:           errors5xx,
+		MaskedFailures:      retrySuccesses,
+		Retries:             retries,
+		Timeouts:            timeouts,
+		CircuitBreakersOpen: circuitBreakers,
+		CircuitBreakerUsage: breakers.usage(),
+		ConnectionFailures:  connFailures,
+		EjectionsActive:     ejectionsActive,
+		Ejections:           ejectionsTotal,
+		LatencyP50:          milliseconds(latency.quantile(0.5)),
+		LatencyP90:          milliseconds(latency.quantile(0.9)),
+		LatencyP95:          milliseconds(latency.quantile(0.95)),
+		LatencyP99:          milliseconds(latency.quantile(0.99)),
+		LatencyAbsent:       latencyAbsent,
+		InboundBytes:        inboundBytes,
+		OutboundBytes:       outboundBytes,
+		RequestSizeMean:     meanSize(inboundBytes, requestSizes),
+		ResponseSizeMean:    meanSize(outboundBytes, responseSizes),
+		RequestSizeCount:    requestSizes,
+		ResponseSizeCount:   responseSizes,
+		ActiveConnections:   connections,
+		PendingRequests:     pendingReqs,
 	}
-
-	metrics.Errors = ErrorMetrics{
-		ErrorRate: errorRate,
-		Errors4xx: int64(errors4xx),
-		Errors5xx: int64(errors5xx),
+	sd.metricMapping.apply(prometheusText, &normalized)
+	// Checked again here, since mapped latencies bypass the histogram
+	zeroed := sd.sanitizeNormalized(&normalized, metrics.ServiceName)
+	metrics.ApplyNormalized(normalized)
+	for _, q := range sd.quantiles {
+		if latencyAbsent {
+			break
+		}
+		if metrics.Latency.Quantiles == nil {
+			metrics.Latency.Quantiles = make(map[string]time.Duration, len(sd.quantiles))
+		}
+		metrics.Latency.Quantiles[q.Name] = milliseconds(latency.quantile(q.Value))
 	}
 
-	metrics.Saturation = SaturationMetrics{
-		Connections: int64(connections),
-		PendingReqs: int64(pendingReqs),
-		// CPU/Memory would need additional pod metrics
-		CPUUsage:    0,
-		MemoryUsage: 0,
+	if len(versions) > 0 {
+		metrics.Versions = versions
 	}
+	metrics.Edges = sortedEdges(edges)
+	metrics.Routes = sortedRoutes(routes)
+	metrics.Ejections = ejections.sorted()
+	sd.signals.clearUnselected(metrics)
+
+	zeroNonFiniteMetrics(metrics, zeroed)
+
+	sd.recordCardinality(prometheusText, metrics)
 
 	// Initialize observability arrays (real implementation would parse traces/logs)
 	metrics.Traces = []TraceSpan{}
 	metrics.AccessLogs = []AccessLogEntry{}
 
 	// Debug output showing metrics collected
-	fmt.Printf("    📊 Metrics collected: Requests=%d, RPS=%.1f, Errors=%.2f%%, P99=%v\n",
+	progress.Printf("    📊 Metrics collected: Requests=%d, RPS=%.1f, Errors=%.2f%%, P99=%v\n",
 		metrics.Traffic.TotalRequests,
 		metrics.Traffic.RequestsPerSecond,
 		metrics.Errors.ErrorRate,
@@ -402,6 +1045,15 @@ func (sd *ServiceDiscovery) parsePrometheusMetrics(prometheusText string, metric
 	return nil
 }
 
+// meanSize is the mean size per request of a size histogram, or zero when
+// it measured no requests.
+func meanSize(bytes, requests float64) float64 {
+	if requests <= 0 {
+		return 0
+	}
+	return bytes / requests
+}
+
 func getServiceName(labels map[string]string) string {
 	if labels == nil {
 		return ""
@@ -427,9 +1079,6 @@ func getServiceName(labels map[string]string) string {
 
 // Istio Control Plane Health Monitoring
 func (sd *ServiceDiscovery) checkControlPlaneHealth(ctx context.Context) error {
-	// Check for Istio system namespace
-	istioNamespace := "istio-system"
-
 	// Check Pilot (istiod) health
 	pilots, err := sd.clientset.AppsV1().Deployments(istioNamespace).Get(ctx, "istiod", metav1.GetOptions{})
 	if err != nil {
@@ -443,10 +1092,10 @@ func (sd *ServiceDiscovery) checkControlPlaneHealth(ctx context.Context) error {
 	// Check Istio ingress gateway
 	_, err = sd.clientset.AppsV1().Deployments(istioNamespace).Get(ctx, "istio-ingressgateway", metav1.GetOptions{})
 	if err != nil {
-		fmt.Printf("Warning: Istio ingress gateway not found: %v\n", err)
+		progress.Printf("Warning: Istio ingress gateway not found: %v\n", err)
 	}
 
-	fmt.Printf("✓ Istio control plane healthy (Pilot: %d replicas)\n", pilots.Status.ReadyReplicas)
+	progress.Printf("✓ Istio control plane healthy (Pilot: %d replicas)\n", pilots.Status.ReadyReplicas)
 	return nil
 }
 
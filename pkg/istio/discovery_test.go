@@ -0,0 +1,852 @@
+package istio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"smanalyzer/pkg/progress"
+	"smanalyzer/pkg/telemetry"
+)
+
+const sampleMetrics = `istio_requests_total{response_code="200"} 90
+istio_requests_total{response_code="503"} 10
+`
+
+func newTestPod(namespace, name, app string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      map[string]string{"app": app},
+			Annotations: map[string]string{"sidecar.istio.io/status": "injected"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: app}, {Name: "istio-proxy"}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func newTestDiscovery(execCalls *int, pods ...*corev1.Pod) *ServiceDiscovery {
+	clientset := fake.NewSimpleClientset()
+	for _, pod := range pods {
+		clientset.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	}
+
+	sd := NewServiceDiscovery(clientset, nil)
+	sd.podExec = func(ctx context.Context, namespace, podName, container string, command []string) (string, error) {
+		*execCalls++
+		return sampleMetrics, nil
+	}
+	return sd
+}
+
+func TestServiceDiscovery_DiscoverServices_SortedOrder(t *testing.T) {
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls,
+		newTestPod("shop", "reviews-1", "reviews"),
+		newTestPod("bookinfo", "ratings-1", "ratings"),
+		newTestPod("shop", "cart-1", "cart"),
+		newTestPod("bookinfo", "productpage-1", "productpage"),
+		newTestPod("shop", "reviews-2", "reviews"),
+	)
+
+	expected := []string{"productpage.bookinfo", "ratings.bookinfo", "cart.shop", "reviews.shop"}
+	for run := 0; run < 10; run++ {
+		services, err := sd.DiscoverServices(context.Background(), "")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(services, expected) {
+			t.Fatalf("Expected %v on run %d, got %v", expected, run, services)
+		}
+	}
+}
+
+func TestServiceDiscovery_NamespaceSelector(t *testing.T) {
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls,
+		newTestPod("shop", "reviews-1", "reviews"),
+		newTestPod("payments", "ledger-1", "ledger"),
+		newTestPod("sandbox", "scratch-1", "scratch"),
+	)
+	for name, labels := range map[string]map[string]string{
+		"shop":     {"monitoring": "enabled"},
+		"payments": {"monitoring": "enabled", "team": "billing"},
+		"sandbox":  {"monitoring": "disabled"},
+	} {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+		if _, err := sd.clientset.CoreV1().Namespaces().Create(context.Background(), ns, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if err := sd.SetNamespaceSelector("monitoring=enabled"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	metrics, err := CollectClusters(context.Background(), []Cluster{{Discovery: sd}}, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var scanned []string
+	for _, m := range metrics {
+		scanned = append(scanned, m.ServiceName+"."+m.Namespace)
+	}
+	if expected := []string{"ledger.payments", "reviews.shop"}; !reflect.DeepEqual(scanned, expected) {
+		t.Errorf("Expected only the labeled namespaces scanned %v, got %v", expected, scanned)
+	}
+	if execCalls != 2 {
+		t.Errorf("Expected the unlabeled namespace left unscraped, got %d scrapes", execCalls)
+	}
+
+	if err := sd.SetNamespaceSelector("monitoring in (enabled"); err == nil {
+		t.Error("Expected an invalid selector to be rejected")
+	}
+}
+
+func TestServiceDiscovery_CollectMetrics_CachedWithinTTL(t *testing.T) {
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls, newTestPod("shop", "reviews-1", "reviews"))
+	sd.SetCacheTTL(time.Minute)
+
+	first, err := sd.CollectMetrics(context.Background(), "shop", "reviews")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	second, err := sd.CollectMetrics(context.Background(), "shop", "reviews")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if execCalls != 1 {
+		t.Errorf("Expected 1 exec call within TTL, got %d", execCalls)
+	}
+
+	if second.Traffic.TotalRequests != first.Traffic.TotalRequests {
+		t.Errorf("Expected cached total requests %d, got %d", first.Traffic.TotalRequests, second.Traffic.TotalRequests)
+	}
+}
+
+func TestServiceDiscovery_CollectMetrics_CachedCopiesAreIndependent(t *testing.T) {
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls)
+	sd.SetCacheTTL(time.Minute)
+
+	collected := &ServiceMeshMetrics{
+		ServiceName: "reviews",
+		Namespace:   "shop",
+		Versions:    map[string]VersionTraffic{"v1": {Requests: 100}},
+		Edges:       []EdgeTraffic{{Source: "productpage", Destination: "reviews", Requests: 100}},
+		Traces:      []TraceSpan{{TraceID: "abc", Tags: map[string]string{"http.status_code": "200"}}},
+		Labels:      map[string]string{},
+	}
+	sd.storeCachedMetrics("shop", "reviews", collected)
+	// The collector's own value must not reach the cache either
+	collected.Edges[0].Requests = -1
+
+	for i := 0; i < 2; i++ {
+		metrics, err := sd.CollectMetrics(context.Background(), "shop", "reviews")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, ok := metrics.Labels[LabelCluster]; ok {
+			t.Errorf("Expected the cached labels unchanged, got %v", metrics.Labels)
+		}
+		if metrics.Versions["v1"].Requests != 100 || metrics.Edges[0].Requests != 100 {
+			t.Errorf("Expected the cached traffic unchanged, got %+v and %+v", metrics.Versions, metrics.Edges)
+		}
+		if metrics.Traces[0].Tags["http.status_code"] != "200" {
+			t.Errorf("Expected the cached span tags unchanged, got %v", metrics.Traces[0].Tags)
+		}
+
+		// As Collect does under --contexts, then some more
+		metrics.Labels[LabelCluster] = "east"
+		metrics.Versions["v1"] = VersionTraffic{Requests: -1}
+		metrics.Edges[0].Requests = -1
+		metrics.Traces[0].Tags["http.status_code"] = "503"
+	}
+
+	if execCalls != 0 {
+		t.Errorf("Expected every call served from the cache, got %d exec calls", execCalls)
+	}
+}
+
+func TestServiceDiscovery_CollectMetrics_CacheDisabled(t *testing.T) {
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls, newTestPod("shop", "reviews-1", "reviews"))
+
+	for i := 0; i < 2; i++ {
+		if _, err := sd.CollectMetrics(context.Background(), "shop", "reviews"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if execCalls != 2 {
+		t.Errorf("Expected 2 exec calls with caching disabled, got %d", execCalls)
+	}
+}
+
+func TestServiceDiscovery_CollectMetrics_CacheExpires(t *testing.T) {
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls, newTestPod("shop", "reviews-1", "reviews"))
+	sd.SetCacheTTL(time.Minute)
+
+	if _, err := sd.CollectMetrics(context.Background(), "shop", "reviews"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Age the entry past the TTL
+	entry := sd.cache["shop/reviews"]
+	entry.collectedAt = time.Now().Add(-2 * time.Minute)
+	sd.cache["shop/reviews"] = entry
+
+	if _, err := sd.CollectMetrics(context.Background(), "shop", "reviews"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if execCalls != 2 {
+		t.Errorf("Expected re-scrape after TTL expiry, got %d exec calls", execCalls)
+	}
+}
+
+func TestServiceDiscovery_CollectMetrics_MissingSidecarContainer(t *testing.T) {
+	pod := newTestPod("shop", "reviews-1", "reviews")
+	pod.Spec.Containers = []corev1.Container{{Name: "reviews"}, {Name: "log-shipper"}}
+
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls, pod)
+
+	err := sd.collectEnvoyMetrics(context.Background(), *pod, &ServiceMeshMetrics{Namespace: "shop"})
+	if err == nil {
+		t.Fatal("Expected an error for a pod without the istio-proxy container")
+	}
+
+	expected := "container istio-proxy not found in pod reviews-1 (available: reviews, log-shipper)"
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+	if execCalls != 0 {
+		t.Errorf("Expected no exec attempt, got %d", execCalls)
+	}
+}
+
+func TestServiceDiscovery_CollectMetrics_NativeSidecarContainer(t *testing.T) {
+	pod := newTestPod("shop", "reviews-1", "reviews")
+	pod.Spec.Containers = []corev1.Container{{Name: "reviews"}}
+	pod.Spec.InitContainers = []corev1.Container{{Name: "istio-proxy"}}
+
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls, pod)
+
+	if err := sd.collectEnvoyMetrics(context.Background(), *pod, &ServiceMeshMetrics{Namespace: "shop"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if execCalls != 1 {
+		t.Errorf("Expected 1 exec call, got %d", execCalls)
+	}
+}
+
+func TestParsePrometheusMetrics_VersionBreakdown(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	metrics := &ServiceMeshMetrics{ServiceName: "reviews", Namespace: "shop"}
+
+	text := `istio_requests_total{reporter="destination",destination_version="v1",response_code="200"} 999
+istio_requests_total{reporter="destination",destination_version="v1",response_code="503"} 1
+istio_requests_total{reporter="destination",destination_version="v2",response_code="200"} 88
+istio_requests_total{reporter="destination",destination_version="v2",response_code="500"} 12
+istio_requests_total{reporter="source",destination_version="v9",response_code="500"} 50
+`
+	if err := sd.parsePrometheusMetrics(text, metrics); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rates := metrics.VersionErrorRates(nil)
+	if len(rates) != 2 {
+		t.Fatalf("Expected rates for v1 and v2 only, got %v", rates)
+	}
+	if math.Abs(rates["v1"]-0.001) > 1e-9 {
+		t.Errorf("Expected v1 error rate 0.001, got %f", rates["v1"])
+	}
+	if math.Abs(rates["v2"]-0.12) > 1e-9 {
+		t.Errorf("Expected v2 error rate 0.12, got %f", rates["v2"])
+	}
+
+	// Since the last scan v2 served 100 requests without failing, and v1's
+	// counts went down with a restarted proxy
+	rates = metrics.VersionErrorRates(map[string]VersionTraffic{"v1": {Requests: 2000, Errors: 1}, "v2": {Requests: 0, Errors: 12}})
+	if rates["v2"] != 0 || math.Abs(rates["v1"]-0.001) > 1e-9 {
+		t.Errorf("Expected v2 rated over its new requests and v1 over its lifetime, got %v", rates)
+	}
+
+	unversioned := &ServiceMeshMetrics{}
+	if err := sd.parsePrometheusMetrics(sampleMetrics, unversioned); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if unversioned.Versions != nil {
+		t.Errorf("Expected no version breakdown without version labels, got %v", unversioned.Versions)
+	}
+}
+
+func TestParsePrometheusMetrics_UpstreamIdentity(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	metrics := &ServiceMeshMetrics{ServiceName: "reviews", Namespace: "shop"}
+
+	text := `istio_requests_total{reporter="destination",source_workload="productpage-v1",destination_service="reviews.shop.svc.cluster.local",destination_service_name="reviews",response_code="200"} 300
+istio_requests_total{reporter="source",source_workload="reviews-v2",destination_service="ratings.shop.svc.cluster.local",response_code="200"} 80
+istio_requests_total{reporter="source",source_workload="reviews-v2",destination_service="ratings.shop.svc.cluster.local",response_code="503"} 20
+istio_requests_total{reporter="destination",source_workload="unknown",destination_service_name="reviews",response_code="200"} 10
+`
+	if err := sd.parsePrometheusMetrics(text, metrics); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []EdgeTraffic{
+		{Source: "productpage-v1", Destination: "reviews", Requests: 300},
+		{Source: "reviews-v2", Destination: "ratings", Requests: 100, Errors: 20},
+	}
+	if !reflect.DeepEqual(metrics.Edges, expected) {
+		t.Errorf("Expected edges %+v, got %+v", expected, metrics.Edges)
+	}
+
+	worst, ok := metrics.WorstEdge()
+	if !ok || worst.Source != "reviews-v2" || worst.Destination != "ratings" || worst.ErrorRate() != 0.2 {
+		t.Errorf("Expected reviews-v2 → ratings at 20%%, got %+v (%v)", worst, ok)
+	}
+
+	unlabelled := &ServiceMeshMetrics{}
+	if err := sd.parsePrometheusMetrics(sampleMetrics, unlabelled); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := unlabelled.WorstEdge(); ok {
+		t.Errorf("Expected no upstream edge without workload labels, got %+v", unlabelled.Edges)
+	}
+}
+
+func TestParsePrometheusMetrics_EffectiveVsUpstreamErrorRate(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+
+	// Many upstream failures, nearly all recovered by retries
+	recovered := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(`istio_requests_total{response_code="200"} 990
+istio_requests_total{response_code="503"} 10
+envoy_cluster_upstream_rq_retry{cluster_name="outbound|9080||ratings"} 210
+envoy_cluster_upstream_rq_retry_success{cluster_name="outbound|9080||ratings"} 200
+`, recovered)
+
+	// The same number of failures, none recovered
+	unrecovered := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(`istio_requests_total{response_code="200"} 790
+istio_requests_total{response_code="503"} 210
+`, unrecovered)
+
+	if recovered.Errors.ErrorRate != 1.0 {
+		t.Errorf("Expected 1%% effective error rate, got %.2f", recovered.Errors.ErrorRate)
+	}
+	if recovered.Errors.UpstreamErrorRate != 21.0 {
+		t.Errorf("Expected 21%% upstream error rate, got %.2f", recovered.Errors.UpstreamErrorRate)
+	}
+	if recovered.RetryCount != 210 || recovered.Errors.RetriedSuccesses != 200 {
+		t.Errorf("Expected 210 retries and 200 retried successes, got %d/%d", recovered.RetryCount, recovered.Errors.RetriedSuccesses)
+	}
+
+	if unrecovered.Errors.ErrorRate != 21.0 || unrecovered.Errors.UpstreamErrorRate != 21.0 {
+		t.Errorf("Expected 21%% effective and upstream error rate, got %.2f/%.2f",
+			unrecovered.Errors.ErrorRate, unrecovered.Errors.UpstreamErrorRate)
+	}
+}
+
+func TestServiceMeshMetrics_SeriesKey(t *testing.T) {
+	tests := []struct {
+		metrics ServiceMeshMetrics
+		want    string
+	}{
+		{ServiceMeshMetrics{ServiceName: "reviews"}, "reviews"},
+		{ServiceMeshMetrics{ServiceName: "reviews", Namespace: "default"}, "default/reviews"},
+		{ServiceMeshMetrics{ServiceName: "reviews", Namespace: "staging", Cluster: "east"}, "east/staging/reviews"},
+	}
+	for _, tt := range tests {
+		if got := tt.metrics.SeriesKey(); got != tt.want {
+			t.Errorf("Expected series key %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestServiceDiscovery_CollectMetrics_CountsReplicas(t *testing.T) {
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls,
+		newTestPod("shop", "reviews-1", "reviews"),
+		newTestPod("shop", "reviews-2", "reviews"),
+		newTestPod("shop", "reviews-canary-1", "reviews-canary"))
+
+	for service, expected := range map[string]int{"reviews": 2, "reviews-canary": 1} {
+		metrics, err := sd.CollectMetrics(context.Background(), "shop", service)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if metrics.Replicas != expected {
+			t.Errorf("Expected %d replicas for %s, got %d", expected, service, metrics.Replicas)
+		}
+	}
+}
+
+func TestParsePrometheusMetrics_PayloadSizes(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+
+	// 100 requests of about 500 bytes, answered with 2KiB, then the same
+	// endpoint answering with 64KiB
+	sizes := func(responseBytes int) string {
+		return fmt.Sprintf(`istio_requests_total{response_code="200"} 100
+istio_request_bytes_bucket{le="1000"} 100
+istio_request_bytes_bucket{le="+Inf"} 100
+istio_request_bytes_sum 50000
+istio_request_bytes_count 100
+istio_response_bytes_bucket{le="1000"} 0
+istio_response_bytes_bucket{le="100000"} 100
+istio_response_bytes_bucket{le="+Inf"} 100
+istio_response_bytes_sum %d
+istio_response_bytes_count 100
+`, responseBytes*100)
+	}
+
+	before := &ServiceMeshMetrics{}
+	if err := sd.parsePrometheusMetrics(sizes(2048), before); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if before.Traffic.MeanRequestBytes != 500 || before.Traffic.MeanResponseBytes != 2048 {
+		t.Errorf("Expected 500 and 2048 bytes per request, got %+v", before.Traffic)
+	}
+	if before.Traffic.InboundBytes != 50000 || before.Traffic.OutboundBytes != 204800 {
+		t.Errorf("Expected the histogram sums as bytes transferred, not the buckets, got %+v", before.Traffic)
+	}
+
+	after := &ServiceMeshMetrics{}
+	if err := sd.parsePrometheusMetrics(sizes(65536), after); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	series := after.Normalized.Series()
+	if series[telemetry.ResponseSize] != 65536 || series[telemetry.RequestSize] != 500 {
+		t.Errorf("Expected size series of 500 and 65536 bytes, got %v and %v", series[telemetry.RequestSize], series[telemetry.ResponseSize])
+	}
+}
+
+func TestParsePrometheusMetrics_ActiveRequests(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+
+	metrics := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(`envoy_http_downstream_cx_active{http_conn_manager_prefix="inbound_0.0.0.0_9080"} 4
+envoy_http_downstream_rq_active{http_conn_manager_prefix="inbound_0.0.0.0_9080"} 12
+envoy_http_downstream_rq_active{http_conn_manager_prefix="outbound_0.0.0.0_9080"} 3
+envoy_http_downstream_rq_total{http_conn_manager_prefix="inbound_0.0.0.0_9080"} 5000
+`, metrics)
+
+	if metrics.Saturation.PendingReqs != 15 {
+		t.Errorf("Expected 15 in-flight requests, got %d", metrics.Saturation.PendingReqs)
+	}
+	if metrics.Normalized.PendingRequests != 15 {
+		t.Errorf("Expected 15 normalized pending requests, got %f", metrics.Normalized.PendingRequests)
+	}
+	if metrics.Saturation.Connections != 4 {
+		t.Errorf("Expected 4 active connections, got %d", metrics.Saturation.Connections)
+	}
+}
+
+func TestParsePrometheusMetrics_ConnectionFailures(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+
+	metrics := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(`envoy_cluster_upstream_cx_connect_fail{cluster_name="outbound|9080||ratings"} 5
+envoy_cluster_upstream_cx_connect_fail{cluster_name="outbound|3306||mysql"} 2
+envoy_cluster_upstream_cx_connect_timeout{cluster_name="outbound|9080||ratings"} 9
+`, metrics)
+
+	if metrics.Errors.ConnFailures != 7 {
+		t.Errorf("Expected 7 connection failures, got %d", metrics.Errors.ConnFailures)
+	}
+	if metrics.Normalized.Series()[telemetry.ConnFailures] != 7 {
+		t.Errorf("Expected the connection_failures series to be 7, got %f", metrics.Normalized.Series()[telemetry.ConnFailures])
+	}
+}
+
+func TestParsePrometheusMetrics_CircuitBreakerUsage(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+
+	// ratings has 15 of 100 pending requests left; mysql is far from its
+	// limits and details doesn't track remaining capacity
+	metrics := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(`envoy_cluster_circuit_breakers_default_remaining_cx{cluster_name="outbound|9080||ratings"} 900
+envoy_cluster_upstream_cx_active{cluster_name="outbound|9080||ratings"} 100
+envoy_cluster_circuit_breakers_default_remaining_pending{cluster_name="outbound|9080||ratings"} 15
+envoy_cluster_upstream_rq_pending_active{cluster_name="outbound|9080||ratings"} 85
+envoy_cluster_circuit_breakers_default_remaining_rq{cluster_name="outbound|3306||mysql"} 1000
+envoy_cluster_upstream_rq_active{cluster_name="outbound|3306||mysql"} 24
+envoy_cluster_upstream_rq_active{cluster_name="outbound|9080||details"} 500
+envoy_cluster_circuit_breakers_default_cx_open{cluster_name="outbound|9080||ratings"} 0
+`, metrics)
+
+	if usage := metrics.Normalized.CircuitBreakerUsage; math.Abs(usage-0.85) > 1e-9 {
+		t.Errorf("Expected 85%% of the pending threshold in use, got %v", usage)
+	}
+	if metrics.CircuitBreakers != 0 {
+		t.Errorf("Expected no open breakers, got %d", metrics.CircuitBreakers)
+	}
+
+	tripped := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(`envoy_cluster_circuit_breakers_default_remaining_cx{cluster_name="outbound|9080||ratings"} 0
+envoy_cluster_upstream_cx_active{cluster_name="outbound|9080||ratings"} 1024
+envoy_cluster_circuit_breakers_default_cx_open{cluster_name="outbound|9080||ratings"} 1
+`, tripped)
+	if tripped.Normalized.CircuitBreakerUsage != 1 || tripped.CircuitBreakers != 1 {
+		t.Errorf("Expected an exhausted, open breaker, got usage %v and %d open", tripped.Normalized.CircuitBreakerUsage, tripped.CircuitBreakers)
+	}
+}
+
+func TestServiceDiscovery_IgnoreControlPlane(t *testing.T) {
+	var out bytes.Buffer
+	progress.SetOutput(&out)
+	defer progress.SetOutput(os.Stdout)
+
+	// No istiod in istio-system, as in a cluster whose control plane is down
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls, newTestPod("shop", "reviews-1", "reviews"))
+	sd.SetIgnoreControlPlane(true)
+
+	services, err := sd.DiscoverServices(context.Background(), "shop")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(services, []string{"reviews.shop"}) {
+		t.Errorf("Expected reviews to be discovered, got %v", services)
+	}
+	if _, err := sd.CollectMetrics(context.Background(), "shop", "reviews"); err != nil || execCalls != 1 {
+		t.Errorf("Expected the responding sidecar to be scraped, got %v after %d execs", err, execCalls)
+	}
+
+	for _, action := range sd.clientset.(*fake.Clientset).Actions() {
+		if action.GetResource().Resource == "deployments" {
+			t.Errorf("Expected the control plane left unchecked, got a %s of %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+	if strings.Contains(out.String(), "control plane") {
+		t.Errorf("Expected no control plane warning, got:\n%s", out.String())
+	}
+
+	sd.SetIgnoreControlPlane(false)
+	out.Reset()
+	if _, err := sd.DiscoverServices(context.Background(), "shop"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Warning: Istio control plane issues detected") {
+		t.Errorf("Expected the missing istiod to be reported by default, got:\n%s", out.String())
+	}
+}
+
+func TestParsePrometheusMetrics_Routes(t *testing.T) {
+	const multiRoute = `istio_requests_total{reporter="destination",request_operation="GET /cart",response_code="200"} 900
+istio_requests_total{reporter="destination",request_operation="GET /cart",response_code="503"} 1
+istio_requests_total{reporter="destination",request_operation="POST /checkout",response_code="200"} 92
+istio_requests_total{reporter="destination",request_operation="POST /checkout",response_code="500"} 8
+istio_requests_total{reporter="destination",request_operation="unknown",response_code="200"} 50
+istio_requests_total{reporter="source",request_operation="GET /payments",response_code="503"} 40
+`
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+
+	aggregate := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(multiRoute, aggregate)
+	if aggregate.Routes != nil {
+		t.Errorf("Expected no route breakdown unless enabled, got %+v", aggregate.Routes)
+	}
+
+	sd.SetRouteBreakdown(true)
+	metrics := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(multiRoute, metrics)
+
+	expected := []RouteTraffic{
+		{Route: "GET /cart", Requests: 901, Errors: 1},
+		{Route: "POST /checkout", Requests: 100, Errors: 8},
+	}
+	if !reflect.DeepEqual(metrics.Routes, expected) {
+		t.Errorf("Expected routes %+v, got %+v", expected, metrics.Routes)
+	}
+	worst, ok := metrics.WorstRoute()
+	if !ok || worst.Route != "POST /checkout" || worst.ErrorRate() != 0.08 {
+		t.Errorf("Expected POST /checkout failing at 8%%, got %+v", worst)
+	}
+	if rate := metrics.Normalized.ErrorRate(); rate > 0.05 {
+		t.Errorf("Expected the service aggregate to hide the failing route, got %.1f%%", rate*100)
+	}
+	if key := metrics.RouteSeriesKey("POST /checkout"); key == metrics.SeriesKey() {
+		t.Errorf("Expected the route's series apart from the service's, got %q", key)
+	}
+}
+
+func TestParsePrometheusMetrics_OutlierEjections(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+
+	// ratings has two hosts ejected now; details ejected one earlier that
+	// has since returned, and mysql never ejected any
+	metrics := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(`# TYPE envoy_cluster_outlier_detection_ejections_active gauge
+envoy_cluster_outlier_detection_ejections_active{cluster_name="outbound|9080||ratings"} 2
+envoy_cluster_outlier_detection_ejections_active{cluster_name="outbound|9080||details"} 0
+envoy_cluster_outlier_detection_ejections_active{cluster_name="outbound|3306||mysql"} 0
+# TYPE envoy_cluster_outlier_detection_ejections_total counter
+envoy_cluster_outlier_detection_ejections_total{cluster_name="outbound|9080||ratings"} 5
+envoy_cluster_outlier_detection_ejections_total{cluster_name="outbound|9080||details"} 1
+envoy_cluster_outlier_detection_ejections_total{cluster_name="outbound|3306||mysql"} 0
+`, metrics)
+
+	series := metrics.Normalized.Series()
+	if series[telemetry.EjectionsActive] != 2 || series[telemetry.Ejections] != 6 {
+		t.Errorf("Expected 2 hosts ejected now and 6 ejections, got %v and %v", series[telemetry.EjectionsActive], series[telemetry.Ejections])
+	}
+	expected := []OutlierEjections{
+		{Cluster: "outbound|9080||details", Active: 0, Total: 1},
+		{Cluster: "outbound|9080||ratings", Active: 2, Total: 5},
+	}
+	if !reflect.DeepEqual(metrics.Ejections, expected) {
+		t.Errorf("Expected ejections %+v, got %+v", expected, metrics.Ejections)
+	}
+	if most, ok := metrics.MostEjected(); !ok || most.Cluster != "outbound|9080||ratings" {
+		t.Errorf("Expected ratings to be the most ejected cluster, got %+v", most)
+	}
+
+	sd.SetSignals(SignalSet{SignalLatency: true})
+	latencyOnly := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(`envoy_cluster_outlier_detection_ejections_active{cluster_name="outbound|9080||ratings"} 2
+`, latencyOnly)
+	if latencyOnly.Ejections != nil || latencyOnly.Normalized.EjectionsActive != 0 {
+		t.Errorf("Expected ejections skipped without the errors signal, got %+v", latencyOnly.Ejections)
+	}
+}
+
+func TestServiceDiscovery_CollectMetrics_ReplicaCheck(t *testing.T) {
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls,
+		newTestPod("shop", "reviews-a", "reviews"),
+		newTestPod("shop", "reviews-b", "reviews"),
+		newTestPod("shop", "reviews-c", "reviews"),
+		newTestPod("shop", "reviews-d", "reviews"))
+	sd.podExec = func(ctx context.Context, namespace, podName, container string, command []string) (string, error) {
+		execCalls++
+		if podName == "reviews-c" {
+			return `istio_requests_total{destination_version="v2",response_code="200"} 70
+istio_requests_total{destination_version="v2",response_code="503"} 30
+`, nil
+		}
+		return `istio_requests_total{destination_version="v1",response_code="200"} 100
+`, nil
+	}
+	sd.SetReplicaCheck(true)
+
+	metrics, err := sd.CollectMetrics(context.Background(), "shop", "reviews")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if execCalls != 4 {
+		t.Errorf("Expected every replica scraped once, got %d exec calls", execCalls)
+	}
+	rates := metrics.PodErrorRates()
+	if len(rates) != 4 {
+		t.Fatalf("Expected signals for 4 replicas, got %v", rates)
+	}
+	if math.Abs(rates["reviews-c"]-0.30) > 1e-9 || rates["reviews-a"] != 0 {
+		t.Errorf("Expected reviews-c at 30%% and reviews-a clean, got %v", rates)
+	}
+	if metrics.Errors.ErrorRate != 0 {
+		t.Errorf("Expected the service aggregate to come from the selected pod, got %.2f%%", metrics.Errors.ErrorRate)
+	}
+	// Each replica reports its own version, summed over all of them
+	if v1, v2 := metrics.Versions["v1"], metrics.Versions["v2"]; v1.Requests != 300 || v2.Requests != 100 || v2.Errors != 30 {
+		t.Errorf("Expected the versions summed over the replicas, got %+v", metrics.Versions)
+	}
+}
+
+func TestServiceDiscovery_CollectMetrics_MaxPodsPerService(t *testing.T) {
+	var pods []*corev1.Pod
+	for i := 0; i < 10; i++ {
+		pods = append(pods, newTestPod("shop", fmt.Sprintf("reviews-%d", i), "reviews"))
+	}
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls, pods...)
+	sd.podExec = func(ctx context.Context, namespace, podName, container string, command []string) (string, error) {
+		execCalls++
+		return `istio_requests_total{response_code="200"} 90
+istio_requests_total{response_code="503"} 10
+`, nil
+	}
+	sd.SetReplicaCheck(true)
+	sd.SetMaxPodsPerService(3)
+
+	metrics, err := sd.CollectMetrics(context.Background(), "shop", "reviews")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if execCalls != 3 || len(metrics.Pods) != 3 {
+		t.Fatalf("Expected 3 of 10 replicas scraped, got %d exec calls and %v", execCalls, metrics.Pods)
+	}
+	// The selected pod, then others spread over the remaining nine
+	for _, name := range []string{"reviews-0", "reviews-1", "reviews-5"} {
+		if _, ok := metrics.Pods[name]; !ok {
+			t.Errorf("Expected %s in the sample, got %v", name, metrics.Pods)
+		}
+	}
+
+	expected := PodAggregate{Sampled: 3, Replicas: 10, Requests: 1000, Errors: 100, Extrapolated: true}
+	if metrics.Aggregate == nil || math.Abs(metrics.Aggregate.Requests-expected.Requests) > 1e-9 ||
+		math.Abs(metrics.Aggregate.Errors-expected.Errors) > 1e-9 ||
+		metrics.Aggregate.Sampled != expected.Sampled || metrics.Aggregate.Replicas != expected.Replicas || !metrics.Aggregate.Extrapolated {
+		t.Errorf("Expected aggregate %+v, got %+v", expected, metrics.Aggregate)
+	}
+	if math.Abs(metrics.Aggregate.ErrorRate()-0.1) > 1e-9 {
+		t.Errorf("Expected an aggregate error rate of 0.1, got %f", metrics.Aggregate.ErrorRate())
+	}
+}
+
+func TestServiceDiscovery_CollectMetrics_ReplicaCheckOff(t *testing.T) {
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls,
+		newTestPod("shop", "reviews-a", "reviews"),
+		newTestPod("shop", "reviews-b", "reviews"))
+
+	metrics, err := sd.CollectMetrics(context.Background(), "shop", "reviews")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if execCalls != 1 || metrics.Pods != nil {
+		t.Errorf("Expected only the selected pod scraped, got %d exec calls and %v", execCalls, metrics.Pods)
+	}
+}
+
+const summaryLatency = `istio_requests_total{response_code="200"} 1000
+istio_request_duration_milliseconds{quantile="0.5"} 20
+istio_request_duration_milliseconds{quantile="0.9"} 50
+istio_request_duration_milliseconds{quantile="0.95"} 100
+istio_request_duration_milliseconds{quantile="0.99"} 250
+istio_request_duration_milliseconds_sum 41000
+istio_request_duration_milliseconds_count 1000
+`
+
+// The same 1000 requests as summaryLatency in OpenMetrics, as a histogram
+// split across two source workloads, with _created timestamps
+const histogramLatency = `# TYPE istio_requests counter
+istio_requests_total{response_code="200"} 1000
+istio_requests_created{response_code="200"} 1.7e+09
+# TYPE istio_request_duration_milliseconds histogram
+istio_request_duration_milliseconds_bucket{source_workload="productpage",le="10"} 125
+istio_request_duration_milliseconds_bucket{source_workload="productpage",le="20"} 250
+istio_request_duration_milliseconds_bucket{source_workload="productpage",le="50"} 450
+istio_request_duration_milliseconds_bucket{source_workload="productpage",le="100"} 475
+istio_request_duration_milliseconds_bucket{source_workload="productpage",le="250"} 495
+istio_request_duration_milliseconds_bucket{source_workload="productpage",le="+Inf"} 500
+istio_request_duration_milliseconds_bucket{source_workload="gateway",le="10"} 125
+istio_request_duration_milliseconds_bucket{source_workload="gateway",le="20"} 250
+istio_request_duration_milliseconds_bucket{source_workload="gateway",le="50"} 450
+istio_request_duration_milliseconds_bucket{source_workload="gateway",le="100"} 475
+istio_request_duration_milliseconds_bucket{source_workload="gateway",le="250"} 495
+istio_request_duration_milliseconds_bucket{source_workload="gateway",le="+Inf"} 500
+istio_request_duration_milliseconds_sum 41000
+istio_request_duration_milliseconds_count 1000
+istio_request_duration_milliseconds_created 1.7e+09
+# EOF
+`
+
+func TestParsePrometheusMetrics_SummaryAndHistogramLatencyAgree(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+
+	fromSummary := &ServiceMeshMetrics{}
+	if err := sd.parsePrometheusMetrics(summaryLatency, fromSummary); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fromHistogram := &ServiceMeshMetrics{}
+	if err := sd.parsePrometheusMetrics(histogramLatency, fromHistogram); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := LatencyMetrics{P50: 20 * time.Millisecond, P90: 50 * time.Millisecond, P95: 100 * time.Millisecond, P99: 250 * time.Millisecond}
+	for name, got := range map[string]LatencyMetrics{"summary": fromSummary.Latency, "histogram": fromHistogram.Latency} {
+		if got.P50 != expected.P50 || got.P90 != expected.P90 || got.P95 != expected.P95 || got.P99 != expected.P99 {
+			t.Errorf("Expected %s percentiles %v/%v/%v/%v, got %v/%v/%v/%v", name,
+				expected.P50, expected.P90, expected.P95, expected.P99, got.P50, got.P90, got.P95, got.P99)
+		}
+	}
+
+	if fromHistogram.Traffic.TotalRequests != 1000 {
+		t.Errorf("Expected _created timestamps left out of the request count, got %d", fromHistogram.Traffic.TotalRequests)
+	}
+}
+
+func TestParsePrometheusMetrics_CustomQuantiles(t *testing.T) {
+	quantiles, err := ParseQuantiles([]string{"p75", "P99.9"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	sd.SetLatencyQuantiles(quantiles)
+
+	fromSummary := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(summaryLatency+`istio_request_duration_milliseconds{quantile="0.75"} 35
+istio_request_duration_milliseconds{quantile="0.999"} 800
+`, fromSummary)
+	expected := map[string]time.Duration{"p75": 35 * time.Millisecond, "p99.9": 800 * time.Millisecond}
+	if !reflect.DeepEqual(fromSummary.Latency.Quantiles, expected) {
+		t.Errorf("Expected summary quantiles %v, got %v", expected, fromSummary.Latency.Quantiles)
+	}
+	if fromSummary.Latency.P99 != 250*time.Millisecond {
+		t.Errorf("Expected the standard P99 kept alongside, got %v", fromSummary.Latency.P99)
+	}
+
+	// The 750th of 1000 requests falls 5/8 of the way through the 20-50ms
+	// bucket; the 999th in the 250ms-+Inf bucket, so at its lower bound
+	fromHistogram := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(histogramLatency, fromHistogram)
+	expected = map[string]time.Duration{"p75": 38750 * time.Microsecond, "p99.9": 250 * time.Millisecond}
+	if !reflect.DeepEqual(fromHistogram.Latency.Quantiles, expected) {
+		t.Errorf("Expected histogram quantiles %v, got %v", expected, fromHistogram.Latency.Quantiles)
+	}
+
+	plain := &ServiceMeshMetrics{}
+	NewServiceDiscovery(fake.NewSimpleClientset(), nil).parsePrometheusMetrics(summaryLatency, plain)
+	if plain.Latency.Quantiles != nil {
+		t.Errorf("Expected no quantiles map unless configured, got %v", plain.Latency.Quantiles)
+	}
+}
+
+func TestParseQuantiles_Invalid(t *testing.T) {
+	for _, name := range []string{"99.9", "p0", "p100", "pxx", "median"} {
+		if _, err := ParseQuantiles([]string{name}); err == nil {
+			t.Errorf("Expected an error for %q", name)
+		}
+	}
+}
+
+func TestParsePrometheusMetrics_SummaryPreferredOverHistogram(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+
+	metrics := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(`istio_request_duration_milliseconds{quantile="0.99"} 300
+istio_request_duration_milliseconds_bucket{le="100"} 10
+istio_request_duration_milliseconds_bucket{le="+Inf"} 10
+`, metrics)
+
+	if metrics.Latency.P99 != 300*time.Millisecond {
+		t.Errorf("Expected the summary's P99 of 300ms, got %v", metrics.Latency.P99)
+	}
+	if metrics.Latency.P50 != 50*time.Millisecond {
+		t.Errorf("Expected P50 estimated from the histogram, got %v", metrics.Latency.P50)
+	}
+}
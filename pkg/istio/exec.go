@@ -0,0 +1,68 @@
+package istio
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// execPingPeriod keeps an exec stream alive while a slow sidecar answers,
+// as remotecommand.NewSPDYExecutor does.
+const execPingPeriod = 5 * time.Second
+
+// execTLSConfig returns the TLS settings every exec into a pod dials the
+// API server with. remotecommand.NewSPDYExecutor derives them from the
+// rest.Config on every call, parsing the CA bundle and client certificate
+// each time; they don't change between scrapes, so they're derived once.
+// Certificate files named in the kubeconfig are read then, unless the
+// config asks for them to be reloaded.
+func (sd *ServiceDiscovery) execTLSConfig() (*tls.Config, error) {
+	sd.execMutex.Lock()
+	defer sd.execMutex.Unlock()
+
+	if !sd.execTLSReady {
+		tlsConfig, err := rest.TLSConfigFor(sd.restConfig)
+		if err != nil {
+			return nil, err
+		}
+		sd.execTLS, sd.execTLSReady = tlsConfig, true
+	}
+	return sd.execTLS, nil
+}
+
+// newExecutor returns an executor for one exec request. The upgraded
+// connection can't be shared: the round tripper keeps the connection it
+// dialed, and the API server runs one command per connection. So each exec
+// still gets a round tripper of its own, built on the shared TLS settings.
+// Nothing is kept per pod, so a recreated pod is simply exec'd into by the
+// URL of the pod that now holds the name.
+func (sd *ServiceDiscovery) newExecutor(execURL *url.URL) (remotecommand.Executor, error) {
+	tlsConfig, err := sd.execTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if sd.restConfig.Proxy != nil {
+		proxy = sd.restConfig.Proxy
+	}
+	upgrader, err := spdy.NewRoundTripperWithConfig(spdy.RoundTripperConfig{
+		TLS:        tlsConfig,
+		Proxier:    proxy,
+		PingPeriod: execPingPeriod,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Adds the credentials; an exec plugin's token is cached by client-go
+	wrapper, err := rest.HTTPWrappersForConfig(sd.restConfig, upgrader)
+	if err != nil {
+		return nil, err
+	}
+	return remotecommand.NewSPDYExecutorForTransports(wrapper, upgrader, "POST", execURL)
+}
@@ -0,0 +1,100 @@
+package istio
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// newTLSRestConfig returns a rest.Config with a CA bundle and client
+// certificate, like a kubeconfig for a real cluster.
+func newTLSRestConfig(t testing.TB) *rest.Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "smanalyzer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return &rest.Config{
+		Host: "https://kubernetes.example:6443",
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData:   certPEM,
+			CertData: certPEM,
+			KeyData:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		},
+	}
+}
+
+func execURL(pod string) *url.URL {
+	return &url.URL{Scheme: "https", Host: "kubernetes.example:6443", Path: "/api/v1/namespaces/shop/pods/" + pod + "/exec"}
+}
+
+func TestServiceDiscovery_ExecTLSConfigShared(t *testing.T) {
+	sd := NewServiceDiscovery(nil, newTLSRestConfig(t))
+
+	first, err := sd.execTLSConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if first == nil || first.RootCAs == nil {
+		t.Fatalf("Expected the CA bundle loaded, got %+v", first)
+	}
+	if second, _ := sd.execTLSConfig(); second != first {
+		t.Error("Expected every exec to share the TLS settings")
+	}
+
+	// The pod a scrape execs into comes from its own URL, so a recreated
+	// pod never gets the executor of the one it replaced
+	before, err := sd.newExecutor(execURL("reviews-1"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	after, err := sd.newExecutor(execURL("reviews-2"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if before == after {
+		t.Error("Expected a new executor per exec")
+	}
+}
+
+func TestServiceDiscovery_ExecTLSConfigPlainHTTP(t *testing.T) {
+	sd := NewServiceDiscovery(nil, &rest.Config{Host: "http://localhost:8080"})
+
+	tlsConfig, err := sd.execTLSConfig()
+	if err != nil || tlsConfig != nil {
+		t.Errorf("Expected no TLS settings for a plain HTTP API server, got %+v and %v", tlsConfig, err)
+	}
+	if _, err := sd.newExecutor(execURL("reviews-1")); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package istio
+
+import "sort"
+
+// Dependencies maps each collected service to the services it calls, read
+// from the request edges in the metrics. Services are keyed "cluster/service"
+// when a cluster is set, like anomaly.RecentTroubleScores: edges name their
+// destination without a namespace. A calling workload is matched to its
+// service through the workload label, so calls from workloads that weren't
+// collected are left out.
+func Dependencies(metrics []*ServiceMeshMetrics) map[string][]string {
+	services := make(map[[2]string]string)
+	for _, m := range metrics {
+		services[[2]string{m.Cluster, m.ServiceName}] = m.ServiceName
+		if workload := m.Labels[LabelWorkload]; workload != "" {
+			services[[2]string{m.Cluster, workload}] = m.ServiceName
+		}
+	}
+
+	calls := make(map[string]map[string]bool)
+	for _, m := range metrics {
+		for _, edge := range m.Edges {
+			caller, ok := services[[2]string{m.Cluster, edge.Source}]
+			if !ok || caller == edge.Destination {
+				continue
+			}
+			from, to := clusterKey(m.Cluster, caller), clusterKey(m.Cluster, edge.Destination)
+			if calls[from] == nil {
+				calls[from] = make(map[string]bool)
+			}
+			calls[from][to] = true
+		}
+	}
+
+	dependencies := make(map[string][]string, len(calls))
+	for from, to := range calls {
+		for callee := range to {
+			dependencies[from] = append(dependencies[from], callee)
+		}
+		sort.Strings(dependencies[from])
+	}
+	return dependencies
+}
+
+func clusterKey(cluster, service string) string {
+	if cluster == "" {
+		return service
+	}
+	return cluster + "/" + service
+}
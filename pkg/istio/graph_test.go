@@ -0,0 +1,44 @@
+package istio
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDependencies(t *testing.T) {
+	metrics := []*ServiceMeshMetrics{
+		{
+			ServiceName: "productpage",
+			Labels:      map[string]string{LabelWorkload: "productpage-v1"},
+			Edges: []EdgeTraffic{
+				{Source: "productpage-v1", Destination: "reviews"},
+				{Source: "productpage-v1", Destination: "details"},
+				// Inbound from an ingress that wasn't collected
+				{Source: "istio-ingressgateway", Destination: "productpage"},
+			},
+		},
+		{
+			ServiceName: "reviews",
+			Labels:      map[string]string{LabelWorkload: "reviews-v2"},
+			Edges: []EdgeTraffic{
+				{Source: "productpage-v1", Destination: "reviews"},
+				{Source: "reviews-v2", Destination: "ratings"},
+			},
+		},
+		{
+			ServiceName: "reviews",
+			Cluster:     "west",
+			Labels:      map[string]string{LabelWorkload: "reviews-v2"},
+			Edges:       []EdgeTraffic{{Source: "reviews-v2", Destination: "ratings"}},
+		},
+	}
+
+	expected := map[string][]string{
+		"productpage":  {"details", "reviews"},
+		"reviews":      {"ratings"},
+		"west/reviews": {"west/ratings"},
+	}
+	if got := Dependencies(metrics); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
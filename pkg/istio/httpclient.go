@@ -0,0 +1,72 @@
+package istio
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// DefaultHTTPTimeout bounds every outbound HTTP call when no timeout is
+// configured.
+const DefaultHTTPTimeout = 10 * time.Second
+
+// HTTPClientConfig configures the HTTP clients for outbound calls such as
+// notifier webhooks and OTLP exports. Proxy admin endpoints are scraped
+// through the API server instead, with the kubeconfig's credentials.
+type HTTPClientConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+
+	// ProxyURL sends every request through this proxy instead of the one
+	// named by HTTP_PROXY/HTTPS_PROXY. NO_PROXY is honored either way.
+	ProxyURL string `yaml:"proxy_url"`
+}
+
+func DefaultHTTPClientConfig() HTTPClientConfig {
+	return HTTPClientConfig{Timeout: DefaultHTTPTimeout}
+}
+
+// Validate reports settings NewHTTPClient would reject.
+func (cfg HTTPClientConfig) Validate() error {
+	_, err := proxyFunc(cfg.ProxyURL)
+	return err
+}
+
+// NewHTTPClient builds an HTTP client with the configured timeout and
+// proxy. A zero timeout falls back to DefaultHTTPTimeout.
+func NewHTTPClient(cfg HTTPClientConfig) (*http.Client, error) {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+
+	proxy, err := proxyFunc(cfg.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// proxyFunc picks the proxy for each request from HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY, with proxyURL, when set, replacing the first two.
+func proxyFunc(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	if u, err := url.Parse(proxyURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy_url %q: expected a URL such as http://proxy.example.com:3128", proxyURL)
+	}
+
+	config := httpproxy.FromEnvironment()
+	config.HTTPProxy = proxyURL
+	config.HTTPSProxy = proxyURL
+	proxy := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
@@ -0,0 +1,110 @@
+package istio
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient_Timeout(t *testing.T) {
+	client, err := NewHTTPClient(HTTPClientConfig{Timeout: 3 * time.Second})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if client.Timeout != 3*time.Second {
+		t.Errorf("Expected timeout 3s, got %v", client.Timeout)
+	}
+
+	client, err = NewHTTPClient(HTTPClientConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if client.Timeout != DefaultHTTPTimeout {
+		t.Errorf("Expected default timeout %v, got %v", DefaultHTTPTimeout, client.Timeout)
+	}
+}
+
+// fetch GETs url with client and returns the body of a 200 response.
+func fetch(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestNewHTTPClient_AppliesTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := NewHTTPClient(HTTPClientConfig{Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := fetch(client, server.URL); err == nil {
+		t.Fatal("Expected the request to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the configured timeout to apply, took %v", elapsed)
+	}
+}
+
+func TestNewHTTPClient_ProxyURL(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A proxy is sent the absolute URL of the target
+		proxied = r.URL.String()
+		w.Write([]byte("ok"))
+	}))
+	defer proxy.Close()
+
+	t.Setenv("NO_PROXY", "internal.example")
+	client, err := NewHTTPClient(HTTPClientConfig{ProxyURL: proxy.URL})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	resp, err := client.Get("http://jaeger.example:16686/api/traces")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if proxied != "http://jaeger.example:16686/api/traces" {
+		t.Errorf("Expected the request to go through the configured proxy, got %q", proxied)
+	}
+
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Expected an *http.Transport, got %T", client.Transport)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://internal.example/metrics", nil)
+	if u, err := transport.Proxy(req); err != nil || u != nil {
+		t.Errorf("Expected NO_PROXY hosts to bypass the proxy, got %v, %v", u, err)
+	}
+}
+
+func TestNewHTTPClient_InvalidProxyURL(t *testing.T) {
+	for _, proxyURL := range []string{"proxy.example:3128", "://bad"} {
+		if _, err := NewHTTPClient(HTTPClientConfig{ProxyURL: proxyURL}); err == nil {
+			t.Errorf("Expected an error for proxy_url %q", proxyURL)
+		}
+		if err := (HTTPClientConfig{ProxyURL: proxyURL}).Validate(); err == nil {
+			t.Errorf("Expected Validate to reject proxy_url %q", proxyURL)
+		}
+	}
+}
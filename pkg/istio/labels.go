@@ -0,0 +1,74 @@
+package istio
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Labels recorded on collected metrics, describing the workload the
+// scraped pod belongs to
+const (
+	LabelApp       = "app"
+	LabelVersion   = "version"
+	LabelNamespace = "namespace"
+	LabelCluster   = "cluster"
+	LabelWorkload  = "workload"
+	LabelPod       = "pod"
+)
+
+// DefaultStoredLabels are the labels kept on each stored point unless the
+// config picks others. The pod is left out so round-robin scraping doesn't
+// scatter a series across replicas.
+func DefaultStoredLabels() []string {
+	return []string{LabelApp, LabelVersion, LabelNamespace, LabelCluster}
+}
+
+// workloadLabels describes the pod metrics were collected from.
+func workloadLabels(pod corev1.Pod, serviceName string) map[string]string {
+	labels := map[string]string{
+		LabelApp:       serviceName,
+		LabelNamespace: pod.Namespace,
+		LabelPod:       pod.Name,
+	}
+	for _, key := range []string{"version", "app.kubernetes.io/version", "service.istio.io/canonical-revision"} {
+		if version := pod.Labels[key]; version != "" {
+			labels[LabelVersion] = version
+			break
+		}
+	}
+	if workload := podWorkload(pod); workload != "" {
+		labels[LabelWorkload] = workload
+	}
+	return labels
+}
+
+// podWorkload names the controller behind a pod: the Deployment for a pod
+// of one of its ReplicaSets, otherwise the owner itself.
+func podWorkload(pod corev1.Pod) string {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Controller == nil || !*owner.Controller {
+			continue
+		}
+		if hash := pod.Labels["pod-template-hash"]; owner.Kind == "ReplicaSet" && hash != "" {
+			return strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+		return owner.Name
+	}
+	return ""
+}
+
+// StoredLabels returns the metrics' labels named in keys, to store with
+// each point, or nil when none of them is set.
+func (m *ServiceMeshMetrics) StoredLabels(keys []string) map[string]string {
+	var stored map[string]string
+	for _, key := range keys {
+		if value, ok := m.Labels[key]; ok && value != "" {
+			if stored == nil {
+				stored = make(map[string]string)
+			}
+			stored[key] = value
+		}
+	}
+	return stored
+}
@@ -0,0 +1,162 @@
+package istio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"smanalyzer/pkg/progress"
+	"smanalyzer/pkg/telemetry"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	linkerdProxyVersionAnnotation = "linkerd.io/proxy-version"
+	linkerdProxyContainer         = "linkerd-proxy"
+	linkerdAdminPort              = 4191
+)
+
+// linkerdCollector handles Linkerd, whose proxy exposes Prometheus metrics on
+// its admin port. The proxy image has no shell, so it is scraped over HTTP
+// through the API server's pod proxy rather than via exec.
+type linkerdCollector struct {
+	sd *ServiceDiscovery
+}
+
+func (c *linkerdCollector) MeshedPods(ctx context.Context, pods []corev1.Pod) ([]corev1.Pod, error) {
+	var meshed []corev1.Pod
+	for _, pod := range pods {
+		if hasLinkerdProxy(pod.Annotations) {
+			meshed = append(meshed, pod)
+		}
+	}
+	return meshed, nil
+}
+
+func (c *linkerdCollector) Collect(ctx context.Context, pod corev1.Pod, metrics *ServiceMeshMetrics) error {
+	// Scrapes go through the API server proxy by pod name, so the pod only
+	// needs to be running, not to have reported an IP
+	if pod.Status.Phase != corev1.PodRunning {
+		return fmt.Errorf("pod %s is %s, not running", pod.Name, pod.Status.Phase)
+	}
+
+	output, err := c.sd.proxyGet(ctx, pod.Namespace, pod.Name, linkerdAdminPort, "metrics")
+	if err != nil {
+		return fmt.Errorf("failed to scrape %s on pod %s: %w", linkerdProxyContainer, pod.Name, err)
+	}
+
+	return c.sd.parseLinkerdMetrics(output, metrics)
+}
+
+func (c *linkerdCollector) Method(pod corev1.Pod) string {
+	return fmt.Sprintf("GET :%d/metrics through the API server proxy", linkerdAdminPort)
+}
+
+func hasLinkerdProxy(annotations map[string]string) bool {
+	if version, exists := annotations[linkerdProxyVersionAnnotation]; exists && version != "" {
+		return true
+	}
+	for key := range annotations {
+		if strings.HasPrefix(key, "linkerd.io/proxy-") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLinkerdMetrics maps linkerd-proxy's inbound metrics into the golden
+// signals. Only direction="inbound" is counted so the figures describe
+// traffic served by this workload, matching Istio's destination reporting.
+func (sd *ServiceDiscovery) parseLinkerdMetrics(prometheusText string, metrics *ServiceMeshMetrics) error {
+	var requests, errors4xx, errors5xx, failures float64
+	var readBytes, writeBytes, connections float64
+	var latencyBuckets []histogramBucket
+	var rejected []string
+
+	for _, line := range strings.Split(prometheusText, "\n") {
+		sample, ok := parsePromLine(line)
+		if !ok || sample.Labels["direction"] != "inbound" {
+			continue
+		}
+		if !plausibleValue(sample.Value) {
+			rejected = append(rejected, line)
+			continue
+		}
+
+		switch sample.Name {
+		case "request_total":
+			requests += sample.Value
+		case "response_total":
+			code := sample.Labels["status_code"]
+			if strings.HasPrefix(code, "4") {
+				errors4xx += sample.Value
+			} else if strings.HasPrefix(code, "5") {
+				errors5xx += sample.Value
+			} else if sample.Labels["classification"] == "failure" {
+				// e.g. gRPC failures carried on a 200
+				failures += sample.Value
+			}
+		case "response_latency_ms_bucket":
+			if bound, ok := parseBucketBound(sample.Labels["le"]); ok {
+				latencyBuckets = mergeBucket(latencyBuckets, bound, sample.Value)
+			}
+		case "tcp_open_connections":
+			connections += sample.Value
+		case "tcp_read_bytes_total":
+			readBytes += sample.Value
+		case "tcp_write_bytes_total":
+			writeBytes += sample.Value
+		}
+	}
+
+	warnRejected(rejected, metrics.ServiceName)
+
+	quantile := func(q float64) time.Duration {
+		return time.Duration(histogramQuantile(q, latencyBuckets) * float64(time.Millisecond))
+	}
+
+	normalized := telemetry.Normalized{
+		Requests:          requests,
+		Errors4xx:         errors4xx,
+		Errors5xx:         errors5xx,
+		OtherErrors:       failures,
+		LatencyP50:        quantile(0.5),
+		LatencyP90:        quantile(0.9),
+		LatencyP95:        quantile(0.95),
+		LatencyP99:        quantile(0.99),
+		InboundBytes:      readBytes,
+		OutboundBytes:     writeBytes,
+		ActiveConnections: connections,
+	}
+	sd.metricMapping.apply(prometheusText, &normalized)
+	zeroed := sd.sanitizeNormalized(&normalized, metrics.ServiceName)
+	metrics.ApplyNormalized(normalized)
+	sd.signals.clearUnselected(metrics)
+	zeroNonFiniteMetrics(metrics, zeroed)
+	sd.recordCardinality(prometheusText, metrics)
+
+	metrics.Traces = []TraceSpan{}
+	metrics.AccessLogs = []AccessLogEntry{}
+
+	progress.Printf("    📊 Metrics collected: Requests=%d, RPS=%.1f, Errors=%.2f%%, P99=%v\n",
+		metrics.Traffic.TotalRequests,
+		metrics.Traffic.RequestsPerSecond,
+		metrics.Errors.ErrorRate,
+		metrics.Latency.P99)
+
+	return nil
+}
+
+// mergeBucket adds a bucket count, summing series that share an upper bound
+// (e.g. the same histogram split by route labels).
+func mergeBucket(buckets []histogramBucket, bound, count float64) []histogramBucket {
+	for i := range buckets {
+		if buckets[i].UpperBound == bound {
+			buckets[i].Count += count
+			return buckets
+		}
+	}
+	return append(buckets, histogramBucket{UpperBound: bound, Count: count})
+}
@@ -0,0 +1,187 @@
+package istio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const linkerdMetrics = `# HELP request_total Total count of HTTP requests.
+request_total{direction="inbound",target_addr="10.0.0.5:9080"} 200
+request_total{direction="outbound",target_addr="10.0.0.9:9080"} 999
+response_total{direction="inbound",status_code="200",classification="success"} 180
+response_total{direction="inbound",status_code="404",classification="success"} 4
+response_total{direction="inbound",status_code="503",classification="failure"} 16
+response_latency_ms_bucket{direction="inbound",le="10"} 100
+response_latency_ms_bucket{direction="inbound",le="50"} 180
+response_latency_ms_bucket{direction="inbound",le="100"} 196
+response_latency_ms_bucket{direction="inbound",le="500"} 200
+response_latency_ms_bucket{direction="inbound",le="+Inf"} 200
+tcp_open_connections{direction="inbound"} 12
+tcp_read_bytes_total{direction="inbound"} 1024
+tcp_write_bytes_total{direction="inbound"} 8192
+`
+
+func newLinkerdClientset() *fake.Clientset {
+	return fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "reviews-1",
+				Namespace:   "shop",
+				Labels:      map[string]string{"app": "reviews"},
+				Annotations: map[string]string{linkerdProxyVersionAnnotation: "stable-2.14.0"},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.5"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "ratings-1",
+				Namespace:   "shop",
+				Labels:      map[string]string{"app": "ratings"},
+				Annotations: map[string]string{"sidecar.istio.io/status": "injected"},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.6"},
+		},
+	)
+}
+
+func TestServiceDiscovery_DiscoverServices_Linkerd(t *testing.T) {
+	sd := NewServiceDiscovery(newLinkerdClientset(), nil)
+	sd.SetMeshMode(MeshLinkerd)
+
+	services, err := sd.DiscoverServices(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(services) != 1 || services[0] != "reviews.shop" {
+		t.Errorf("Expected only reviews.shop to be discovered, got %v", services)
+	}
+}
+
+func TestServiceDiscovery_CollectMetrics_Linkerd(t *testing.T) {
+	clientset := newLinkerdClientset()
+	var scraped []string
+	serveProxy(clientset, linkerdMetrics, &scraped)
+	sd := NewServiceDiscovery(clientset, nil)
+	sd.SetMeshMode(MeshLinkerd)
+
+	metrics, err := sd.CollectMetrics(context.Background(), "shop", "reviews")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(scraped) != 1 || scraped[0] != "shop/reviews-1:4191/metrics" {
+		t.Errorf("Expected the proxy admin port scraped through the API server proxy, got %v", scraped)
+	}
+
+	if metrics.Traffic.TotalRequests != 200 {
+		t.Errorf("Expected 200 inbound requests, got %d", metrics.Traffic.TotalRequests)
+	}
+
+	if metrics.Errors.Errors4xx != 4 || metrics.Errors.Errors5xx != 16 {
+		t.Errorf("Expected 4/16 errors, got %d/%d", metrics.Errors.Errors4xx, metrics.Errors.Errors5xx)
+	}
+
+	if metrics.Errors.ErrorRate != 10.0 {
+		t.Errorf("Expected 10%% error rate, got %.2f", metrics.Errors.ErrorRate)
+	}
+
+	if metrics.Latency.P50 != 10*time.Millisecond {
+		t.Errorf("Expected P50 10ms, got %v", metrics.Latency.P50)
+	}
+
+	if metrics.Latency.P99 < 100*time.Millisecond || metrics.Latency.P99 > 500*time.Millisecond {
+		t.Errorf("Expected P99 between 100ms and 500ms, got %v", metrics.Latency.P99)
+	}
+
+	if metrics.Saturation.Connections != 12 {
+		t.Errorf("Expected 12 open connections, got %d", metrics.Saturation.Connections)
+	}
+}
+
+func TestLinkerdCollector_Collect_RunningPodsOnly(t *testing.T) {
+	clientset := newLinkerdClientset()
+	var scraped []string
+	serveProxy(clientset, linkerdMetrics, &scraped)
+	collector := &linkerdCollector{sd: NewServiceDiscovery(clientset, nil)}
+
+	// Scraped through the API server proxy, so a missing pod IP doesn't matter
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "reviews-1", Namespace: "shop"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	metrics := &ServiceMeshMetrics{ServiceName: "reviews", Namespace: "shop"}
+	if err := collector.Collect(context.Background(), pod, metrics); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(scraped) != 1 || metrics.Traffic.TotalRequests != 200 {
+		t.Errorf("Expected the running pod scraped, got %v and %d requests", scraped, metrics.Traffic.TotalRequests)
+	}
+
+	pod.Status.Phase = corev1.PodPending
+	if err := collector.Collect(context.Background(), pod, metrics); err == nil {
+		t.Error("Expected an error for a pending pod")
+	}
+	if len(scraped) != 1 {
+		t.Errorf("Expected the pending pod left unscraped, got %v", scraped)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	buckets := []histogramBucket{
+		{UpperBound: 100, Count: 100},
+		{UpperBound: 10, Count: 50},
+	}
+
+	if q := histogramQuantile(0.5, buckets); q != 10 {
+		t.Errorf("Expected median 10, got %f", q)
+	}
+
+	if q := histogramQuantile(0.75, buckets); q != 55 {
+		t.Errorf("Expected P75 55, got %f", q)
+	}
+}
+
+func TestNormalized_IstioAndLinkerdComparable(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+
+	// The same 200 requests, 4 client errors and 16 server errors as
+	// linkerdMetrics, as Istio would report them
+	fromIstio := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(`istio_requests_total{response_code="200"} 180
+istio_requests_total{response_code="404"} 4
+istio_requests_total{response_code="503"} 16
+istio_request_duration_milliseconds{quantile="0.99"} 300
+envoy_http_downstream_cx_active 12
+istio_request_bytes 1024
+istio_response_bytes 8192
+`, fromIstio)
+
+	fromLinkerd := &ServiceMeshMetrics{}
+	sd.parseLinkerdMetrics(linkerdMetrics, fromLinkerd)
+
+	istioSeries := fromIstio.Normalized.Series()
+	linkerdSeries := fromLinkerd.Normalized.Series()
+	for _, metric := range []string{"request_count", "traffic_rps", "error_rate", "upstream_error_rate", "latency_p99"} {
+		if istioSeries[metric] != linkerdSeries[metric] {
+			t.Errorf("Expected %s to match across meshes, got istio=%v linkerd=%v",
+				metric, istioSeries[metric], linkerdSeries[metric])
+		}
+	}
+
+	if fromIstio.Normalized.Errors4xx != fromLinkerd.Normalized.Errors4xx ||
+		fromIstio.Normalized.Errors5xx != fromLinkerd.Normalized.Errors5xx {
+		t.Errorf("Expected matching error breakdown, got istio=%v/%v linkerd=%v/%v",
+			fromIstio.Normalized.Errors4xx, fromIstio.Normalized.Errors5xx,
+			fromLinkerd.Normalized.Errors4xx, fromLinkerd.Normalized.Errors5xx)
+	}
+	if fromIstio.Saturation.Connections != fromLinkerd.Saturation.Connections {
+		t.Errorf("Expected matching connections, got istio=%d linkerd=%d",
+			fromIstio.Saturation.Connections, fromLinkerd.Saturation.Connections)
+	}
+}
@@ -0,0 +1,108 @@
+package istio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"smanalyzer/pkg/progress"
+)
+
+type MeshMode string
+
+const (
+	// MeshIstio discovers workloads with an injected Envoy sidecar
+	MeshIstio MeshMode = "istio"
+	// MeshIstioAmbient discovers workloads enrolled in ambient mode and
+	// collects from the node-level ztunnel instead of a per-pod sidecar
+	MeshIstioAmbient MeshMode = "istio-ambient"
+	// MeshLinkerd discovers workloads with an injected linkerd-proxy
+	MeshLinkerd MeshMode = "linkerd"
+)
+
+// MeshCollector knows how one service mesh marks its workloads and where
+// their proxy metrics are exposed.
+type MeshCollector interface {
+	// MeshedPods filters pods down to the ones participating in the mesh
+	MeshedPods(ctx context.Context, pods []corev1.Pod) ([]corev1.Pod, error)
+	// Collect scrapes the proxy serving the pod and fills in metrics
+	Collect(ctx context.Context, pod corev1.Pod, metrics *ServiceMeshMetrics) error
+	// Method describes how Collect would scrape the pod, for dry runs
+	Method(pod corev1.Pod) string
+}
+
+// ParseMeshMode validates a --mesh value.
+func ParseMeshMode(mode string) (MeshMode, error) {
+	switch MeshMode(mode) {
+	case MeshIstio, MeshIstioAmbient, MeshLinkerd:
+		return MeshMode(mode), nil
+	}
+	return "", fmt.Errorf("unsupported mesh %q (expected %s, %s or %s)", mode, MeshIstio, MeshIstioAmbient, MeshLinkerd)
+}
+
+// SetMeshMode selects the collector used to discover and scrape workloads.
+func (sd *ServiceDiscovery) SetMeshMode(mode MeshMode) {
+	switch mode {
+	case MeshIstioAmbient:
+		sd.collector = &ambientCollector{sd: sd}
+	case MeshLinkerd:
+		sd.collector = &linkerdCollector{sd: sd}
+	default:
+		sd.collector = &sidecarCollector{sd: sd}
+	}
+}
+
+// SetCollector installs a custom mesh collector.
+func (sd *ServiceDiscovery) SetCollector(collector MeshCollector) {
+	sd.collector = collector
+}
+
+// sidecarCollector handles Istio's classic data plane with an Envoy sidecar
+// injected into every workload pod.
+type sidecarCollector struct {
+	sd *ServiceDiscovery
+}
+
+func (c *sidecarCollector) MeshedPods(ctx context.Context, pods []corev1.Pod) ([]corev1.Pod, error) {
+	// Pick up telemetry changes made since the last discovery
+	settings, err := c.sd.detectMeshConfig(ctx)
+	if err != nil {
+		progress.Printf("Warning: using default collection settings: %v\n", err)
+	} else if !settings.PrometheusMetrics {
+		progress.Printf("Warning: the mesh config's default metrics providers don't include Prometheus; Istio's standard metrics will read zero\n")
+	}
+	if settings.Version != "" {
+		progress.Printf("Debug: Istio %s, scraping sidecar stats on port %d\n", settings.Version, settings.StatsPort)
+	}
+	c.sd.meshSettings = settings
+
+	var meshed []corev1.Pod
+	for _, pod := range pods {
+		if hasIstioSidecar(pod.Labels, pod.Annotations) {
+			meshed = append(meshed, pod)
+		}
+	}
+	return meshed, nil
+}
+
+func (c *sidecarCollector) Collect(ctx context.Context, pod corev1.Pod, metrics *ServiceMeshMetrics) error {
+	return c.sd.collectEnvoyMetrics(ctx, pod, metrics)
+}
+
+func (c *sidecarCollector) Method(pod corev1.Pod) string {
+	return fmt.Sprintf("exec curl %s in %s", c.sd.sidecarStatsURL(), istioProxyContainer)
+}
+
+// proxyGet fetches path from a pod's port through the API server's pod
+// proxy, with the kubeconfig's credentials, so pods are reached wherever
+// the API server can reach them rather than only from inside the cluster
+// network.
+func (sd *ServiceDiscovery) proxyGet(ctx context.Context, namespace, podName string, port int, path string) (string, error) {
+	body, err := sd.clientset.CoreV1().Pods(namespace).ProxyGet("http", podName, strconv.Itoa(port), path, nil).DoRaw(ctx)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
@@ -0,0 +1,108 @@
+package istio
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	istioNamespace = "istio-system"
+	// meshConfigMap holds the MeshConfig istiod distributes to the proxies
+	meshConfigMap = "istio"
+
+	// statusPort is where the sidecar agent serves Envoy's stats merged
+	// with the application's, the default since Istio 1.6
+	statusPort = 15020
+	// envoyPrometheusPort is where Envoy serves its own stats when
+	// Prometheus merging is turned off
+	envoyPrometheusPort = 15090
+)
+
+// MeshSettings are the collection settings derived from the installed
+// mesh config.
+type MeshSettings struct {
+	// Version of the installed Istio, when the ConfigMap is labeled with it
+	Version string
+	// StatsPort is the sidecar port stats are scraped from
+	StatsPort int
+	// PrometheusMetrics is false when the mesh's default metrics providers
+	// leave out Prometheus, so the istio_* standard metrics aren't emitted
+	// and every signal would read zero
+	PrometheusMetrics bool
+}
+
+// DefaultMeshSettings are used when the mesh config can't be read.
+func DefaultMeshSettings() MeshSettings {
+	return MeshSettings{StatsPort: statusPort, PrometheusMetrics: true}
+}
+
+// meshConfig is the part of Istio's MeshConfig that affects collection.
+type meshConfig struct {
+	EnablePrometheusMerge *bool `json:"enablePrometheusMerge"`
+	DefaultConfig         struct {
+		StatusPort int `json:"statusPort"`
+	} `json:"defaultConfig"`
+	DefaultProviders struct {
+		Metrics []string `json:"metrics"`
+	} `json:"defaultProviders"`
+	ExtensionProviders []struct {
+		Name       string    `json:"name"`
+		Prometheus *struct{} `json:"prometheus"`
+	} `json:"extensionProviders"`
+}
+
+// detectMeshConfig reads the istio ConfigMap to learn how the installed
+// control plane configures telemetry. When it can't be read or parsed the
+// defaults are returned along with the error.
+func (sd *ServiceDiscovery) detectMeshConfig(ctx context.Context) (MeshSettings, error) {
+	settings := DefaultMeshSettings()
+
+	configMap, err := sd.clientset.CoreV1().ConfigMaps(istioNamespace).Get(ctx, meshConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return settings, fmt.Errorf("failed to read mesh config: %w", err)
+	}
+
+	for _, label := range []string{"operator.istio.io/version", "app.kubernetes.io/version"} {
+		if version := configMap.Labels[label]; version != "" {
+			settings.Version = version
+			break
+		}
+	}
+
+	var mesh meshConfig
+	if err := yaml.Unmarshal([]byte(configMap.Data["mesh"]), &mesh); err != nil {
+		return settings, fmt.Errorf("failed to parse mesh config: %w", err)
+	}
+
+	if mesh.EnablePrometheusMerge != nil && !*mesh.EnablePrometheusMerge {
+		settings.StatsPort = envoyPrometheusPort
+	} else if mesh.DefaultConfig.StatusPort > 0 {
+		settings.StatsPort = mesh.DefaultConfig.StatusPort
+	}
+
+	if providers := mesh.DefaultProviders.Metrics; len(providers) > 0 {
+		prometheusProviders := map[string]bool{"prometheus": true}
+		for _, provider := range mesh.ExtensionProviders {
+			if provider.Prometheus != nil {
+				prometheusProviders[provider.Name] = true
+			}
+		}
+
+		settings.PrometheusMetrics = false
+		for _, provider := range providers {
+			if prometheusProviders[provider] {
+				settings.PrometheusMetrics = true
+			}
+		}
+	}
+
+	return settings, nil
+}
+
+// statsURL is where the sidecar's Prometheus stats are scraped from.
+func (s MeshSettings) statsURL() string {
+	return fmt.Sprintf("http://localhost:%d/stats/prometheus", s.StatsPort)
+}
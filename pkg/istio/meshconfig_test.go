@@ -0,0 +1,109 @@
+package istio
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func meshConfigMapWith(mesh string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      meshConfigMap,
+			Namespace: istioNamespace,
+			Labels:    map[string]string{"operator.istio.io/version": "1.22.3"},
+		},
+		Data: map[string]string{"mesh": mesh},
+	}
+}
+
+func TestDetectMeshConfig_DerivesSettings(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(meshConfigMapWith(`
+enablePrometheusMerge: false
+defaultConfig:
+  discoveryAddress: istiod.istio-system.svc:15012
+defaultProviders:
+  metrics:
+  - otel
+extensionProviders:
+- name: otel
+  opentelemetry:
+    service: otel-collector.observability.svc.cluster.local
+    port: 4317
+`)), nil)
+
+	settings, err := sd.detectMeshConfig(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := MeshSettings{Version: "1.22.3", StatsPort: envoyPrometheusPort, PrometheusMetrics: false}
+	if settings != expected {
+		t.Errorf("Expected %+v, got %+v", expected, settings)
+	}
+}
+
+func TestDetectMeshConfig_CustomStatusPortAndPrometheusProvider(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(meshConfigMapWith(`
+defaultConfig:
+  statusPort: 15021
+defaultProviders:
+  metrics:
+  - otel
+  - custom-prom
+extensionProviders:
+- name: custom-prom
+  prometheus: {}
+`)), nil)
+
+	settings, err := sd.detectMeshConfig(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if settings.StatsPort != 15021 || !settings.PrometheusMetrics {
+		t.Errorf("Expected port 15021 with Prometheus metrics, got %+v", settings)
+	}
+	if url := settings.statsURL(); url != "http://localhost:15021/stats/prometheus" {
+		t.Errorf("Expected the custom status port in the scrape URL, got %s", url)
+	}
+}
+
+func TestDetectMeshConfig_FallsBackToDefaults(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+
+	settings, err := sd.detectMeshConfig(context.Background())
+	if err == nil {
+		t.Error("Expected an error without the istio ConfigMap")
+	}
+	if settings != DefaultMeshSettings() {
+		t.Errorf("Expected the default settings, got %+v", settings)
+	}
+
+	sd = NewServiceDiscovery(fake.NewSimpleClientset(meshConfigMapWith("defaultConfig: [")), nil)
+	if settings, err := sd.detectMeshConfig(context.Background()); err == nil || settings.StatsPort != statusPort {
+		t.Errorf("Expected defaults and an error for an unparseable mesh config, got %+v, %v", settings, err)
+	}
+}
+
+func TestCollectMetrics_ScrapesPortFromMeshConfig(t *testing.T) {
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls, newTestPod("shop", "reviews-1", "reviews"))
+	sd.clientset.CoreV1().ConfigMaps(istioNamespace).Create(context.Background(),
+		meshConfigMapWith("enablePrometheusMerge: false\n"), metav1.CreateOptions{})
+
+	var scraped []string
+	exec := sd.podExec
+	sd.podExec = func(ctx context.Context, namespace, podName, container string, command []string) (string, error) {
+		scraped = command
+		return exec(ctx, namespace, podName, container, command)
+	}
+
+	if _, err := CollectClusters(context.Background(), []Cluster{{Discovery: sd}}, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(scraped) == 0 || scraped[len(scraped)-1] != "http://localhost:15090/stats/prometheus" {
+		t.Errorf("Expected Envoy's own stats port scraped, got %v", scraped)
+	}
+}
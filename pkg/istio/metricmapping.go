@@ -0,0 +1,118 @@
+package istio
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"smanalyzer/pkg/telemetry"
+)
+
+// MetricMapping names the Prometheus metric each golden signal is read
+// from, keyed by signal (see MappableSignals), for proxies that expose
+// their golden signals under custom names. Counters and gauges are summed
+// across series; latencies are in milliseconds and the highest series is
+// taken. Mapped signals replace the collector's built-in parsing when the
+// metric appears in a scrape.
+type MetricMapping map[string]string
+
+// mappedSignal records a metric's value into its normalized field.
+type mappedSignal struct {
+	set     func(n *telemetry.Normalized, value float64)
+	latency bool
+}
+
+func milliseconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Millisecond))
+}
+
+var mappedSignals = map[string]mappedSignal{
+	"requests":            {set: func(n *telemetry.Normalized, v float64) { n.Requests = v }},
+	"errors_4xx":          {set: func(n *telemetry.Normalized, v float64) { n.Errors4xx = v }},
+	"errors_5xx":          {set: func(n *telemetry.Normalized, v float64) { n.Errors5xx = v }},
+	"other_errors":        {set: func(n *telemetry.Normalized, v float64) { n.OtherErrors = v }},
+	"retries":             {set: func(n *telemetry.Normalized, v float64) { n.Retries = v }},
+	"timeouts":            {set: func(n *telemetry.Normalized, v float64) { n.Timeouts = v }},
+	"circuit_breakers":    {set: func(n *telemetry.Normalized, v float64) { n.CircuitBreakersOpen = v }},
+	"connection_failures": {set: func(n *telemetry.Normalized, v float64) { n.ConnectionFailures = v }},
+	"inbound_bytes":       {set: func(n *telemetry.Normalized, v float64) { n.InboundBytes = v }},
+	"outbound_bytes":      {set: func(n *telemetry.Normalized, v float64) { n.OutboundBytes = v }},
+	"active_connections":  {set: func(n *telemetry.Normalized, v float64) { n.ActiveConnections = v }},
+	"pending_requests":    {set: func(n *telemetry.Normalized, v float64) { n.PendingRequests = v }},
+	"cpu_usage":           {set: func(n *telemetry.Normalized, v float64) { n.CPUUsage = v }},
+	"memory_usage":        {set: func(n *telemetry.Normalized, v float64) { n.MemoryUsage = v }},
+	"latency_p50":         {set: func(n *telemetry.Normalized, v float64) { n.LatencyP50 = milliseconds(v) }, latency: true},
+	"latency_p90":         {set: func(n *telemetry.Normalized, v float64) { n.LatencyP90 = milliseconds(v) }, latency: true},
+	"latency_p95":         {set: func(n *telemetry.Normalized, v float64) { n.LatencyP95 = milliseconds(v) }, latency: true},
+	"latency_p99":         {set: func(n *telemetry.Normalized, v float64) { n.LatencyP99 = milliseconds(v) }, latency: true},
+}
+
+// MappableSignals lists the signal names a MetricMapping accepts.
+func MappableSignals() []string {
+	var names []string
+	for name := range mappedSignals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate rejects unknown signals and empty metric names.
+func (m MetricMapping) Validate() error {
+	for signal, metric := range m {
+		if _, known := mappedSignals[signal]; !known {
+			return fmt.Errorf("unknown signal %q in metric mapping (expected one of %s)", signal, strings.Join(MappableSignals(), ", "))
+		}
+		if metric == "" {
+			return fmt.Errorf("no metric name mapped to signal %q", signal)
+		}
+	}
+	return nil
+}
+
+// apply reads the mapped metrics from a scrape into n, leaving signals
+// whose metric isn't present untouched.
+func (m MetricMapping) apply(prometheusText string, n *telemetry.Normalized) {
+	if len(m) == 0 {
+		return
+	}
+
+	signalsByMetric := make(map[string][]string)
+	for signal, metric := range m {
+		signalsByMetric[metric] = append(signalsByMetric[metric], signal)
+	}
+
+	values := make(map[string]float64)
+	for _, line := range strings.Split(prometheusText, "\n") {
+		sample, ok := parsePromLine(line)
+		if !ok || !plausibleValue(sample.Value) {
+			continue
+		}
+		for _, signal := range signalsByMetric[sample.Name] {
+			current, seen := values[signal]
+			switch {
+			case !seen:
+				values[signal] = sample.Value
+			case mappedSignals[signal].latency:
+				values[signal] = max(current, sample.Value)
+			default:
+				values[signal] = current + sample.Value
+			}
+		}
+	}
+
+	for signal, value := range values {
+		mappedSignals[signal].set(n, value)
+		if mappedSignals[signal].latency {
+			// A mapped percentile stands in for a dropped histogram's
+			n.LatencyAbsent = false
+		}
+	}
+}
+
+// SetMetricMapping reads golden signals from custom metric names in
+// addition to the mesh's standard ones.
+func (sd *ServiceDiscovery) SetMetricMapping(mapping MetricMapping) {
+	sd.metricMapping = mapping
+}
@@ -0,0 +1,120 @@
+package istio
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParsePrometheusMetrics_CustomMapping(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	sd.SetMetricMapping(MetricMapping{
+		"requests":            "acme_http_requests",
+		"errors_4xx":          "acme_http_client_errors",
+		"errors_5xx":          "acme_http_server_errors",
+		"latency_p50":         "acme_latency_median_ms",
+		"latency_p99":         "acme_latency_tail_ms",
+		"retries":             "acme_retries",
+		"timeouts":            "acme_timeouts",
+		"circuit_breakers":    "acme_breakers_open",
+		"connection_failures": "acme_connect_failures",
+		"active_connections":  "acme_connections",
+		"pending_requests":    "acme_inflight",
+		"cpu_usage":           "acme_cpu_percent",
+	})
+
+	metrics := &ServiceMeshMetrics{}
+	err := sd.parsePrometheusMetrics(`# HELP acme_http_requests Requests served
+acme_http_requests{route="/api"} 700
+acme_http_requests{route="/health"} 300
+acme_http_client_errors 20
+acme_http_server_errors{route="/api"} 30
+acme_latency_median_ms{route="/api"} 12
+acme_latency_tail_ms{route="/api"} 250.5
+acme_latency_tail_ms{route="/health"} 3
+acme_retries 40
+acme_timeouts 5
+acme_breakers_open 1
+acme_connect_failures 7
+acme_connections 9
+acme_inflight 4
+acme_cpu_percent 63
+`, metrics)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if metrics.Traffic.TotalRequests != 1000 {
+		t.Errorf("Expected requests summed across routes to 1000, got %d", metrics.Traffic.TotalRequests)
+	}
+	if metrics.Errors.Errors4xx != 20 || metrics.Errors.Errors5xx != 30 || metrics.Errors.ErrorRate != 5.0 {
+		t.Errorf("Expected 20 4xx, 30 5xx and a 5%% error rate, got %d/%d/%.2f",
+			metrics.Errors.Errors4xx, metrics.Errors.Errors5xx, metrics.Errors.ErrorRate)
+	}
+	if metrics.Latency.P50 != 12*time.Millisecond {
+		t.Errorf("Expected P50 12ms, got %v", metrics.Latency.P50)
+	}
+	if metrics.Latency.P99 != 250500*time.Microsecond {
+		t.Errorf("Expected the slowest route's P99 of 250.5ms, got %v", metrics.Latency.P99)
+	}
+	if metrics.RetryCount != 40 || metrics.TimeoutCount != 5 || metrics.CircuitBreakers != 1 || metrics.Errors.ConnFailures != 7 {
+		t.Errorf("Expected resilience counters 40/5/1/7, got %d/%d/%d/%d",
+			metrics.RetryCount, metrics.TimeoutCount, metrics.CircuitBreakers, metrics.Errors.ConnFailures)
+	}
+	if metrics.Saturation.Connections != 9 || metrics.Saturation.PendingReqs != 4 || metrics.Saturation.CPUUsage != 63 {
+		t.Errorf("Expected saturation 9/4/63, got %+v", metrics.Saturation)
+	}
+}
+
+func TestParsePrometheusMetrics_MappingKeepsUnmappedSignals(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	sd.SetMetricMapping(MetricMapping{"latency_p99": "acme_latency_tail_ms"})
+
+	metrics := &ServiceMeshMetrics{}
+	sd.parsePrometheusMetrics(`istio_requests_total{response_code="200"} 90
+istio_requests_total{response_code="503"} 10
+istio_request_duration_milliseconds{quantile="0.99"} 80
+`, metrics)
+
+	if metrics.Traffic.TotalRequests != 100 || metrics.Errors.ErrorRate != 10.0 {
+		t.Errorf("Expected Istio's request counts kept, got %d requests at %.2f%%", metrics.Traffic.TotalRequests, metrics.Errors.ErrorRate)
+	}
+	if metrics.Latency.P99 != 80*time.Millisecond {
+		t.Errorf("Expected Istio's P99 kept when the mapped metric is absent, got %v", metrics.Latency.P99)
+	}
+}
+
+func TestParseMeshMetrics_CustomMappingForEveryMesh(t *testing.T) {
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	sd.SetMetricMapping(MetricMapping{"latency_p99": "acme_latency_tail_ms"})
+
+	linkerd := &ServiceMeshMetrics{ServiceName: "reviews", Namespace: "shop"}
+	sd.parseLinkerdMetrics(linkerdMetrics+"acme_latency_tail_ms 750\n", linkerd)
+	if linkerd.Latency.P99 != 750*time.Millisecond || linkerd.Traffic.TotalRequests != 200 {
+		t.Errorf("Expected the mapped P99 alongside Linkerd's requests, got %v and %d", linkerd.Latency.P99, linkerd.Traffic.TotalRequests)
+	}
+
+	// The ztunnel serves every workload on the node
+	const ztunnelLatency = `acme_latency_tail_ms{destination_canonical_service="reviews",destination_workload_namespace="shop"} 300
+acme_latency_tail_ms{destination_canonical_service="ratings",destination_workload_namespace="shop"} 900
+`
+	ambient := &ServiceMeshMetrics{ServiceName: "reviews", Namespace: "shop"}
+	sd.parseZtunnelMetrics(ztunnelMetrics+ztunnelLatency, ambient)
+	if ambient.Latency.P99 != 300*time.Millisecond || ambient.Traffic.TotalRequests != 100 {
+		t.Errorf("Expected only the reviews series mapped, got %v and %d", ambient.Latency.P99, ambient.Traffic.TotalRequests)
+	}
+}
+
+func TestMetricMapping_Validate(t *testing.T) {
+	if err := (MetricMapping{"requests": "acme_http_requests"}).Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := (MetricMapping{"throughput": "acme_http_requests"}).Validate(); err == nil || !strings.Contains(err.Error(), "throughput") {
+		t.Errorf("Expected an unknown signal error, got %v", err)
+	}
+	if err := (MetricMapping{"requests": ""}).Validate(); err == nil {
+		t.Error("Expected an error for an empty metric name")
+	}
+}
@@ -0,0 +1,197 @@
+package istio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"smanalyzer/pkg/profile"
+	"smanalyzer/pkg/progress"
+)
+
+// Discoverer finds the meshed services in a cluster and collects their
+// metrics. ServiceDiscovery implements it against a live cluster.
+type Discoverer interface {
+	// DiscoverServices returns the meshed services as "name.namespace" keys
+	DiscoverServices(ctx context.Context, namespace string) ([]string, error)
+	CollectMetrics(ctx context.Context, namespace, serviceName string) (*ServiceMeshMetrics, error)
+}
+
+var _ Discoverer = (*ServiceDiscovery)(nil)
+
+// Cluster is one member of a multi-cluster mesh with its own discovery.
+type Cluster struct {
+	Name      string
+	Discovery Discoverer
+}
+
+// ErrNoServices is returned when discovery finds no meshed services in any
+// cluster.
+var ErrNoServices = errors.New("no meshed services discovered")
+
+// PodCounts is how many pods a discovery listed and how many of them were
+// part of the mesh.
+type PodCounts struct {
+	Pods   int
+	Meshed int
+}
+
+// podCounter is implemented by discoverers that can say what their last
+// discovery found, so an empty result can be explained.
+type podCounter interface {
+	PodCounts() PodCounts
+}
+
+// PodCounts reports the pods seen by the last DiscoverServices call.
+func (sd *ServiceDiscovery) PodCounts() PodCounts {
+	return sd.podCounts
+}
+
+// NoServicesError is ErrNoServices with the pods discovery saw, telling an
+// empty namespace apart from pods without sidecars. Known is false when no
+// discoverer could count its pods.
+type NoServicesError struct {
+	PodCounts
+	Known bool
+}
+
+func (e *NoServicesError) Error() string {
+	return ErrNoServices.Error()
+}
+
+func (e *NoServicesError) Is(target error) bool {
+	return target == ErrNoServices
+}
+
+// CollectClusters discovers and collects every meshed service in each
+// cluster, tagging the metrics with the cluster name. A cluster that can't
+// be reached is reported and skipped so the others still produce results.
+func CollectClusters(ctx context.Context, clusters []Cluster, namespace string) ([]*ServiceMeshMetrics, error) {
+	return CollectSampled(ctx, clusters, namespace, nil)
+}
+
+// discoveredService is a service found in one cluster, not yet collected.
+type discoveredService struct {
+	cluster   Cluster
+	name      string
+	namespace string
+}
+
+func (s discoveredService) seriesKey() string {
+	m := ServiceMeshMetrics{ServiceName: s.name, Namespace: s.namespace, Cluster: s.cluster.Name}
+	return m.SeriesKey()
+}
+
+// CollectSampled is CollectClusters collecting only the services the
+// sampler picks, across all clusters. A nil sampler collects everything.
+func CollectSampled(ctx context.Context, clusters []Cluster, namespace string, sampler *ServiceSampler) ([]*ServiceMeshMetrics, error) {
+	collection, err := Collect(ctx, clusters, namespace, sampler)
+	return collection.Metrics, err
+}
+
+// Collection is what a scan collected.
+type Collection struct {
+	Metrics []*ServiceMeshMetrics
+	// Failed counts the services discovered but not collected
+	Failed int
+}
+
+// Collect is CollectSampled also counting the services whose collection
+// failed.
+func Collect(ctx context.Context, clusters []Cluster, namespace string, sampler *ServiceSampler) (Collection, error) {
+	found, err := discoverSampled(ctx, clusters, namespace, sampler)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	var collection Collection
+	for _, service := range found {
+		progress.Printf("Debug: Collecting metrics for service %s in namespace %s\n", service.name, service.namespace)
+		done := profile.Track(ctx, "collection")
+		doneService := profile.Track(ctx, "collection "+service.namespace+"/"+service.name)
+		metrics, err := service.cluster.Discovery.CollectMetrics(ctx, service.namespace, service.name)
+		doneService()
+		done()
+		if err != nil {
+			progress.Printf("Warning: failed to collect metrics for %s: %v\n", service.name, err)
+			collection.Failed++
+			continue
+		}
+
+		metrics.Cluster = service.cluster.Name
+		if metrics.Cluster != "" {
+			if metrics.Labels == nil {
+				metrics.Labels = make(map[string]string)
+			}
+			metrics.Labels[LabelCluster] = metrics.Cluster
+		}
+		collection.Metrics = append(collection.Metrics, metrics)
+	}
+
+	return collection, nil
+}
+
+// discoverSampled discovers the services in each cluster, skipping clusters
+// that can't be reached, and keeps the ones the sampler picks. When no
+// cluster can be reached, the discovery errors are returned rather than
+// ErrNoServices, so a broken connection isn't mistaken for an empty mesh.
+func discoverSampled(ctx context.Context, clusters []Cluster, namespace string, sampler *ServiceSampler) ([]discoveredService, error) {
+	var found []discoveredService
+	var failures []error
+	discovered := 0
+	empty := &NoServicesError{}
+
+	for _, cluster := range clusters {
+		done := profile.Track(ctx, "discovery")
+		services, err := cluster.Discovery.DiscoverServices(ctx, namespace)
+		done()
+		if err != nil {
+			progress.Printf("Warning: failed to discover services%s: %v\n", clusterSuffix(cluster.Name), err)
+			failures = append(failures, fmt.Errorf("failed to discover services%s: %w", clusterSuffix(cluster.Name), err))
+			continue
+		}
+
+		progress.Printf("✓ Found %d services with Istio sidecars%s\n", len(services), clusterSuffix(cluster.Name))
+		discovered += len(services)
+		if counter, ok := cluster.Discovery.(podCounter); ok {
+			counts := counter.PodCounts()
+			empty.Pods += counts.Pods
+			empty.Meshed += counts.Meshed
+			empty.Known = true
+		}
+
+		for _, serviceKey := range services {
+			serviceName, serviceNamespace, ok := strings.Cut(serviceKey, ".")
+			if !ok {
+				progress.Printf("Warning: invalid service key format: %s\n", serviceKey)
+				continue
+			}
+			found = append(found, discoveredService{cluster: cluster, name: serviceName, namespace: serviceNamespace})
+		}
+	}
+
+	if len(failures) > 0 && len(failures) == len(clusters) {
+		return nil, errors.Join(failures...)
+	}
+	if discovered == 0 {
+		return nil, empty
+	}
+
+	if sampler != nil {
+		total := len(found)
+		found = sampler.sample(found)
+		if len(found) < total {
+			progress.Printf("Sampling %d of %d services this scan\n", len(found), total)
+		}
+	}
+
+	return found, nil
+}
+
+func clusterSuffix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf(" in cluster %s", name)
+}
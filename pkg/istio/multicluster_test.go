@@ -0,0 +1,344 @@
+package istio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"smanalyzer/pkg/clock"
+	"smanalyzer/pkg/profile"
+	"smanalyzer/pkg/progress"
+)
+
+func TestCollectClusters_TagsEachCluster(t *testing.T) {
+	eastCalls, westCalls := 0, 0
+	clusters := []Cluster{
+		{Name: "east", Discovery: newTestDiscovery(&eastCalls,
+			newTestPod("shop", "reviews-1", "reviews"),
+			newTestPod("shop", "ratings-1", "ratings"),
+		)},
+		{Name: "west", Discovery: newTestDiscovery(&westCalls,
+			newTestPod("shop", "reviews-1", "reviews"),
+		)},
+	}
+
+	metrics, err := CollectClusters(context.Background(), clusters, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(metrics) != 3 {
+		t.Fatalf("Expected 3 services across clusters, got %d", len(metrics))
+	}
+
+	perCluster := map[string][]string{}
+	for _, m := range metrics {
+		perCluster[m.Cluster] = append(perCluster[m.Cluster], m.ServiceName+"."+m.Namespace)
+	}
+	if len(perCluster["east"]) != 2 || len(perCluster["west"]) != 1 {
+		t.Errorf("Expected 2 east and 1 west services, got %v", perCluster)
+	}
+	if west := perCluster["west"]; len(west) == 1 && west[0] != "reviews.shop" {
+		t.Errorf("Expected reviews.shop in west, got %s", west[0])
+	}
+
+	if eastCalls != 2 || westCalls != 1 {
+		t.Errorf("Expected each cluster to be scraped through its own client, got east=%d west=%d", eastCalls, westCalls)
+	}
+}
+
+func TestCollectClusters_Quiet(t *testing.T) {
+	var out bytes.Buffer
+	progress.SetOutput(&out)
+	progress.SetQuiet(true)
+	defer progress.SetOutput(os.Stdout)
+	defer progress.SetQuiet(false)
+
+	execCalls := 0
+	clusters := []Cluster{{Discovery: newTestDiscovery(&execCalls, newTestPod("shop", "reviews-1", "reviews"))}}
+
+	if metrics, _ := CollectClusters(context.Background(), clusters, ""); len(metrics) != 1 {
+		t.Fatalf("Expected 1 service, got %d", len(metrics))
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no progress output in quiet mode, got %q", out.String())
+	}
+}
+
+func TestCollectClusters_NoServices(t *testing.T) {
+	execCalls := 0
+	clusters := []Cluster{{Discovery: newTestDiscovery(&execCalls)}}
+
+	if _, err := CollectClusters(context.Background(), clusters, "shop"); !errors.Is(err, ErrNoServices) {
+		t.Errorf("Expected ErrNoServices, got %v", err)
+	}
+}
+
+// unreachableDiscoverer is a cluster whose API server can't be reached.
+type unreachableDiscoverer struct{}
+
+func (unreachableDiscoverer) DiscoverServices(ctx context.Context, namespace string) ([]string, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (unreachableDiscoverer) CollectMetrics(ctx context.Context, namespace, serviceName string) (*ServiceMeshMetrics, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestCollectClusters_UnreachableIsNotEmpty(t *testing.T) {
+	progress.SetOutput(&bytes.Buffer{})
+	defer progress.SetOutput(os.Stdout)
+
+	clusters := []Cluster{{Name: "east", Discovery: unreachableDiscoverer{}}, {Name: "west", Discovery: unreachableDiscoverer{}}}
+	_, err := CollectClusters(context.Background(), clusters, "shop")
+	if err == nil || errors.Is(err, ErrNoServices) {
+		t.Fatalf("Expected the discovery error rather than ErrNoServices, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "connection refused") || !strings.Contains(err.Error(), "west") {
+		t.Errorf("Expected each cluster's failure in the error, got %v", err)
+	}
+
+	// One reachable cluster without services is an empty mesh
+	execCalls := 0
+	clusters[1].Discovery = newTestDiscovery(&execCalls)
+	if _, err := CollectClusters(context.Background(), clusters, "shop"); !errors.Is(err, ErrNoServices) {
+		t.Errorf("Expected ErrNoServices with one cluster reachable, got %v", err)
+	}
+}
+
+func TestCollectClusters_NoServicesCountsPods(t *testing.T) {
+	unmeshed := newTestPod("shop", "reviews-1", "reviews")
+	unmeshed.Annotations = nil
+	execCalls := 0
+	clusters := []Cluster{
+		{Name: "east", Discovery: newTestDiscovery(&execCalls, unmeshed)},
+		{Name: "west", Discovery: newTestDiscovery(&execCalls)},
+	}
+
+	_, err := CollectClusters(context.Background(), clusters, "shop")
+	var empty *NoServicesError
+	if !errors.As(err, &empty) {
+		t.Fatalf("Expected a NoServicesError, got %v", err)
+	}
+	if !empty.Known || empty.Pods != 1 || empty.Meshed != 0 {
+		t.Errorf("Expected 1 pod without a sidecar, got %+v", empty)
+	}
+}
+
+func TestCollectClusters_ProfileRecordsPhases(t *testing.T) {
+	recorder := profile.NewRecorder()
+	ctx := profile.WithRecorder(context.Background(), recorder)
+
+	execCalls := 0
+	clusters := []Cluster{{Discovery: newTestDiscovery(&execCalls,
+		newTestPod("shop", "reviews-1", "reviews"),
+		newTestPod("shop", "ratings-1", "ratings"))}}
+
+	if _, err := CollectClusters(ctx, clusters, ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for name, count := range map[string]int{"discovery": 1, "collection": 2, "collection shop/reviews": 1} {
+		phase, ok := recorder.Phase(name)
+		if !ok || phase.Count != count || phase.Duration <= 0 {
+			t.Errorf("Expected %s timed %d times, got %+v", name, count, phase)
+		}
+	}
+}
+
+func TestCollectSampled_RotatesThroughAllServices(t *testing.T) {
+	var pods []*corev1.Pod
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("svc-%d", i)
+		pods = append(pods, newTestPod("shop", name+"-1", name))
+	}
+	execCalls := 0
+	clusters := []Cluster{{Discovery: newTestDiscovery(&execCalls, pods...)}}
+
+	sampler, err := NewServiceSampler(0.3)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	simulated := clock.NewSimulated(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	sampler.SetClock(simulated)
+
+	covered := map[string]int{}
+	for interval := 0; interval < 4; interval++ {
+		metrics, err := CollectSampled(context.Background(), clusters, "", sampler)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if interval < 3 && len(metrics) != 3 {
+			t.Errorf("Expected 3 services in interval %d, got %d", interval, len(metrics))
+		}
+		for _, m := range metrics {
+			covered[m.ServiceName]++
+		}
+		simulated.Advance(time.Minute)
+	}
+
+	if len(covered) != 10 {
+		t.Errorf("Expected every service sampled within 4 intervals, got %v", covered)
+	}
+	for name, count := range covered {
+		if count > 2 {
+			t.Errorf("Expected %s sampled at most twice in 4 intervals, got %d", name, count)
+		}
+	}
+}
+
+func TestCollectSampled_SeededServicesWaitTheirTurn(t *testing.T) {
+	execCalls := 0
+	clusters := []Cluster{{Discovery: newTestDiscovery(&execCalls,
+		newTestPod("shop", "reviews-1", "reviews"),
+		newTestPod("shop", "ratings-1", "ratings"),
+	)}}
+
+	sampler, err := NewServiceSampler(0.5)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sampler.Seed("shop/ratings", time.Now().Add(-time.Minute))
+
+	metrics, err := CollectSampled(context.Background(), clusters, "", sampler)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(metrics) != 1 || metrics[0].ServiceName != "reviews" {
+		t.Errorf("Expected the never-sampled reviews first, got %v", metrics)
+	}
+}
+
+func TestServiceSampler_Exclude(t *testing.T) {
+	execCalls := 0
+	clusters := []Cluster{{Discovery: newTestDiscovery(&execCalls,
+		newTestPod("shop", "reviews-1", "reviews"),
+		newTestPod("shop", "ratings-1", "ratings"),
+		newTestPod("shop", "legacy-1", "legacy"),
+	)}}
+
+	sampler, err := NewServiceSampler(1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sampler.Exclude("shop/legacy")
+
+	metrics, err := CollectSampled(context.Background(), clusters, "", sampler)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(metrics) != 2 || execCalls != 2 {
+		t.Fatalf("Expected reviews and ratings collected, got %d services in %d execs", len(metrics), execCalls)
+	}
+	for _, m := range metrics {
+		if m.ServiceName == "legacy" {
+			t.Error("Expected the excluded legacy service left out")
+		}
+	}
+}
+
+func TestNewServiceSampler_RejectsOutOfRange(t *testing.T) {
+	for _, rate := range []float64{0, -0.5, 1.5} {
+		if _, err := NewServiceSampler(rate); err == nil {
+			t.Errorf("Expected error for rate %v", rate)
+		}
+	}
+}
+
+func TestCollectClusters_AllNamespacesTargetsEachServiceNamespace(t *testing.T) {
+	execCalls := 0
+	clusters := []Cluster{{Discovery: newTestDiscovery(&execCalls,
+		newTestPod("shop", "reviews-1", "reviews"),
+		newTestPod("payments", "ledger-1", "ledger"),
+	)}}
+
+	metrics, err := CollectClusters(context.Background(), clusters, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(metrics) != 2 {
+		t.Fatalf("Expected 2 services across namespaces, got %d", len(metrics))
+	}
+
+	namespaces := map[string]string{}
+	for _, m := range metrics {
+		namespaces[m.ServiceName] = m.Namespace
+	}
+	if namespaces["reviews"] != "shop" || namespaces["ledger"] != "payments" {
+		t.Errorf("Expected reviews in shop and ledger in payments, got %v", namespaces)
+	}
+	if execCalls != 2 {
+		t.Errorf("Expected each service scraped in its own namespace, got %d scrapes", execCalls)
+	}
+}
+
+func TestCollectClusters_WorkloadLabels(t *testing.T) {
+	calls := 0
+	pod := newTestPod("shop", "reviews-v2-7d4f9-abcde", "reviews")
+	pod.Labels["version"] = "v2"
+	pod.Labels["pod-template-hash"] = "7d4f9"
+	controller := true
+	pod.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "reviews-v2-7d4f9", Controller: &controller}}
+	clusters := []Cluster{{Name: "east", Discovery: newTestDiscovery(&calls, pod)}}
+
+	metrics, err := CollectClusters(context.Background(), clusters, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(metrics) != 1 {
+		t.Fatalf("Expected 1 service, got %d", len(metrics))
+	}
+
+	labels := metrics[0].Labels
+	if labels[LabelWorkload] != "reviews-v2" || labels[LabelPod] != pod.Name {
+		t.Errorf("Expected workload reviews-v2 on pod %s, got %v", pod.Name, labels)
+	}
+
+	stored := metrics[0].StoredLabels(DefaultStoredLabels())
+	expected := map[string]string{"app": "reviews", "version": "v2", "namespace": "shop", "cluster": "east"}
+	if !reflect.DeepEqual(stored, expected) {
+		t.Errorf("Expected stored labels %v, got %v", expected, stored)
+	}
+}
+
+func TestPlanClusters_ListsPodsWithoutScraping(t *testing.T) {
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls,
+		newTestPod("shop", "reviews-1", "reviews"),
+		newTestPod("shop", "reviews-2", "reviews"),
+		newTestPod("shop", "ratings-1", "ratings"),
+	)
+
+	plan, err := PlanClusters(context.Background(), []Cluster{{Name: "east", Discovery: sd}}, "", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if execCalls != 0 {
+		t.Errorf("Expected no pod to be scraped, got %d exec calls", execCalls)
+	}
+	if len(plan) != 2 || plan[0].Name != "ratings" || plan[1].Name != "reviews" || plan[1].Cluster != "east" {
+		t.Fatalf("Expected ratings and reviews in east, got %+v", plan)
+	}
+
+	reviews := plan[1].Pods
+	if len(reviews) != 2 || reviews[0].Fallback || !reviews[1].Fallback {
+		t.Errorf("Expected the second reviews pod as a fallback, got %+v", reviews)
+	}
+	if want := "exec curl http://localhost:15020/stats/prometheus in istio-proxy"; reviews[0].Method != want {
+		t.Errorf("Expected method %q, got %q", want, reviews[0].Method)
+	}
+
+	sd.SetReplicaCheck(true)
+	plan, _ = PlanClusters(context.Background(), []Cluster{{Discovery: sd}}, "", nil)
+	if pods := plan[1].Pods; len(pods) != 2 || pods[1].Fallback {
+		t.Errorf("Expected every replica scraped with the replica check, got %+v", pods)
+	}
+}
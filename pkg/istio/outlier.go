@@ -0,0 +1,73 @@
+package istio
+
+import "sort"
+
+// Envoy's outlier detection stats for each upstream cluster: the hosts
+// ejected from its load balancing pool now, and every ejection so far.
+const (
+	ejectionsActiveMetric = "envoy_cluster_outlier_detection_ejections_active"
+	ejectionsTotalMetric  = "envoy_cluster_outlier_detection_ejections_total"
+)
+
+// OutlierEjections is the outlier detection state of one upstream cluster,
+// e.g. outbound|9080||ratings.shop.svc.cluster.local.
+type OutlierEjections struct {
+	Cluster string  `json:"cluster"`
+	Active  float64 `json:"active"`
+	Total   float64 `json:"total"`
+}
+
+// outlierEjections collects the ejection stats of a scrape, keyed by
+// upstream cluster.
+type outlierEjections map[string]*OutlierEjections
+
+func (o outlierEjections) add(sample promSample) {
+	name := sample.Labels["cluster_name"]
+	ejections := o[name]
+	if ejections == nil {
+		ejections = &OutlierEjections{Cluster: name}
+		o[name] = ejections
+	}
+	switch sample.Name {
+	case ejectionsActiveMetric:
+		ejections.Active = sample.Value
+	case ejectionsTotalMetric:
+		ejections.Total = sample.Value
+	}
+}
+
+// totals sums the active and total ejections over every upstream cluster.
+func (o outlierEjections) totals() (active, total float64) {
+	for _, ejections := range o {
+		active += ejections.Active
+		total += ejections.Total
+	}
+	return active, total
+}
+
+// sorted lists the upstream clusters that have ejected a host, by name.
+// Envoy reports every cluster the proxy knows of, mostly at zero.
+func (o outlierEjections) sorted() []OutlierEjections {
+	var sorted []OutlierEjections
+	for _, ejections := range o {
+		if ejections.Active > 0 || ejections.Total > 0 {
+			sorted = append(sorted, *ejections)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Cluster < sorted[j].Cluster })
+	return sorted
+}
+
+// MostEjected returns the upstream cluster with the most hosts ejected
+// now, or failing that the most ejections so far.
+func (m *ServiceMeshMetrics) MostEjected() (OutlierEjections, bool) {
+	var most OutlierEjections
+	found := false
+	for _, ejections := range m.Ejections {
+		if !found || ejections.Active > most.Active ||
+			(ejections.Active == most.Active && ejections.Total > most.Total) {
+			most, found = ejections, true
+		}
+	}
+	return most, found
+}
@@ -0,0 +1,85 @@
+package istio
+
+import (
+	"context"
+
+	"smanalyzer/pkg/progress"
+)
+
+// ScrapeTarget is a pod collection would scrape, and how.
+type ScrapeTarget struct {
+	Pod    string `json:"pod"`
+	Method string `json:"method"`
+	// Fallback pods are only scraped if the ones before them fail
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// PlannedService is a discovered service with the pods its collection
+// would try, in order.
+type PlannedService struct {
+	Cluster   string         `json:"cluster,omitempty"`
+	Namespace string         `json:"namespace"`
+	Name      string         `json:"service"`
+	Pods      []ScrapeTarget `json:"pods"`
+}
+
+// Planner is implemented by discoverers that can list the pods they would
+// scrape for a service without scraping them.
+type Planner interface {
+	PlanCollection(ctx context.Context, namespace, serviceName string) ([]ScrapeTarget, error)
+}
+
+var _ Planner = (*ServiceDiscovery)(nil)
+
+// PlanCollection lists the running meshed pods of the service in the order
+// CollectMetrics would try them. Only the first that answers is scraped,
+// unless the replica check scrapes each of them.
+func (sd *ServiceDiscovery) PlanCollection(ctx context.Context, namespace, serviceName string) ([]ScrapeTarget, error) {
+	pods, err := sd.getServicePods(ctx, namespace, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, nil
+	}
+
+	ordered := sd.orderPods(namespace, serviceName, pods)
+	replicas := sd.replicaCheck && len(ordered) > 1
+	if replicas {
+		ordered = samplePods(ordered, ordered[0].Name, sd.maxPodsPerService)
+	}
+
+	targets := make([]ScrapeTarget, len(ordered))
+	for i, pod := range ordered {
+		targets[i] = ScrapeTarget{
+			Pod:      pod.Name,
+			Method:   sd.collector.Method(pod),
+			Fallback: i > 0 && !replicas,
+		}
+	}
+	return targets, nil
+}
+
+// PlanClusters discovers services like CollectSampled but, instead of
+// collecting them, lists the pods each would be collected from. Services
+// of a discoverer that isn't a Planner are listed without pods.
+func PlanClusters(ctx context.Context, clusters []Cluster, namespace string, sampler *ServiceSampler) ([]PlannedService, error) {
+	found, err := discoverSampled(ctx, clusters, namespace, sampler)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := make([]PlannedService, 0, len(found))
+	for _, service := range found {
+		planned := PlannedService{Cluster: service.cluster.Name, Namespace: service.namespace, Name: service.name}
+		if planner, ok := service.cluster.Discovery.(Planner); ok {
+			pods, err := planner.PlanCollection(ctx, service.namespace, service.name)
+			if err != nil {
+				progress.Printf("Warning: failed to list pods for %s: %v\n", service.name, err)
+			}
+			planned.Pods = pods
+		}
+		plan = append(plan, planned)
+	}
+	return plan, nil
+}
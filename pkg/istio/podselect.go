@@ -0,0 +1,154 @@
+package istio
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodSelectionStrategy picks which replica of a service is scraped.
+type PodSelectionStrategy string
+
+const (
+	// PodSelectFirst scrapes the first listed pod
+	PodSelectFirst PodSelectionStrategy = "first"
+	// PodSelectRandom scrapes a random pod each time
+	PodSelectRandom PodSelectionStrategy = "random"
+	// PodSelectHighestTraffic scrapes the pod that reported the most
+	// requests on earlier scrapes. Pods not scraped yet are tried first so
+	// every replica gets measured once.
+	PodSelectHighestTraffic PodSelectionStrategy = "highest-traffic"
+	// PodSelectRoundRobin moves to the next pod on each scrape so every
+	// replica is sampled over successive scans
+	PodSelectRoundRobin PodSelectionStrategy = "round-robin"
+)
+
+// ParsePodSelectionStrategy validates a pod selection setting. An empty
+// value selects PodSelectFirst.
+func ParsePodSelectionStrategy(strategy string) (PodSelectionStrategy, error) {
+	switch PodSelectionStrategy(strategy) {
+	case "":
+		return PodSelectFirst, nil
+	case PodSelectFirst, PodSelectRandom, PodSelectHighestTraffic, PodSelectRoundRobin:
+		return PodSelectionStrategy(strategy), nil
+	}
+	return "", fmt.Errorf("unsupported pod selection %q (expected %s, %s, %s or %s)",
+		strategy, PodSelectFirst, PodSelectRandom, PodSelectHighestTraffic, PodSelectRoundRobin)
+}
+
+// SetPodSelection sets how the pod to scrape is chosen among a service's
+// replicas.
+func (sd *ServiceDiscovery) SetPodSelection(strategy PodSelectionStrategy) {
+	sd.selectionMutex.Lock()
+	defer sd.selectionMutex.Unlock()
+	sd.podSelection = strategy
+}
+
+// orderPods returns the pods in the order they should be tried: the
+// selected pod first, then the others as fallbacks.
+func (sd *ServiceDiscovery) orderPods(namespace, serviceName string, pods []corev1.Pod) []corev1.Pod {
+	sd.selectionMutex.Lock()
+	defer sd.selectionMutex.Unlock()
+
+	if len(pods) < 2 {
+		return pods
+	}
+
+	start := 0
+	switch sd.podSelection {
+	case PodSelectRandom:
+		start = sd.randIntn(len(pods))
+	case PodSelectRoundRobin:
+		key := namespace + "/" + serviceName
+		start = sd.rotation[key] % len(pods)
+		sd.rotation[key]++
+	case PodSelectHighestTraffic:
+		ordered := append([]corev1.Pod(nil), pods...)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			trafficI, seenI := sd.podTraffic[podKey(ordered[i])]
+			trafficJ, seenJ := sd.podTraffic[podKey(ordered[j])]
+			if seenI != seenJ {
+				return !seenI
+			}
+			return trafficI > trafficJ
+		})
+		return ordered
+	}
+
+	return append(append([]corev1.Pod(nil), pods[start:]...), pods[:start]...)
+}
+
+// recordPodTraffic remembers how many requests a pod reported for the
+// highest-traffic strategy.
+func (sd *ServiceDiscovery) recordPodTraffic(pod corev1.Pod, requests float64) {
+	sd.selectionMutex.Lock()
+	defer sd.selectionMutex.Unlock()
+	sd.podTraffic[podKey(pod)] = requests
+}
+
+// PodSelectionState is what the round-robin and highest-traffic strategies
+// remember between scrapes: the next position of each "namespace/service"
+// and the requests each "namespace/pod" last reported.
+type PodSelectionState struct {
+	Rotation   map[string]int
+	PodTraffic map[string]float64
+}
+
+// PodSelectionKeeper is a discovery whose pod selection can be saved and
+// restored, so the strategies carry on where the previous run stopped.
+type PodSelectionKeeper interface {
+	PodSelectionState() PodSelectionState
+	RestorePodSelection(state PodSelectionState)
+}
+
+var _ PodSelectionKeeper = (*ServiceDiscovery)(nil)
+
+// PodSelectionState returns a copy of the state the configured strategy
+// uses; the other strategies don't need any.
+func (sd *ServiceDiscovery) PodSelectionState() PodSelectionState {
+	sd.selectionMutex.Lock()
+	defer sd.selectionMutex.Unlock()
+
+	var state PodSelectionState
+	switch sd.podSelection {
+	case PodSelectRoundRobin:
+		state.Rotation = make(map[string]int, len(sd.rotation))
+		for key, next := range sd.rotation {
+			state.Rotation[key] = next
+		}
+	case PodSelectHighestTraffic:
+		state.PodTraffic = make(map[string]float64, len(sd.podTraffic))
+		for key, requests := range sd.podTraffic {
+			state.PodTraffic[key] = requests
+		}
+	}
+	return state
+}
+
+// RestorePodSelection seeds the strategies with state saved by an earlier
+// run. Entries already recorded by this run are kept.
+func (sd *ServiceDiscovery) RestorePodSelection(state PodSelectionState) {
+	sd.selectionMutex.Lock()
+	defer sd.selectionMutex.Unlock()
+
+	for key, next := range state.Rotation {
+		if _, ok := sd.rotation[key]; !ok {
+			sd.rotation[key] = next
+		}
+	}
+	for key, requests := range state.PodTraffic {
+		if _, ok := sd.podTraffic[key]; !ok {
+			sd.podTraffic[key] = requests
+		}
+	}
+}
+
+func podKey(pod corev1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+func defaultRandIntn(n int) int {
+	return rand.Intn(n)
+}
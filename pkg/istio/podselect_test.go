@@ -0,0 +1,134 @@
+package istio
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// newSelectionDiscovery serves three reviews replicas whose proxies report
+// the given request counts, and records which pod each scrape hit.
+func newSelectionDiscovery(strategy PodSelectionStrategy, requests map[string]int, scraped *[]string) *ServiceDiscovery {
+	execCalls := 0
+	sd := newTestDiscovery(&execCalls,
+		newTestPod("shop", "reviews-a", "reviews"),
+		newTestPod("shop", "reviews-b", "reviews"),
+		newTestPod("shop", "reviews-c", "reviews"),
+	)
+	sd.SetPodSelection(strategy)
+	sd.podExec = func(ctx context.Context, namespace, podName, container string, command []string) (string, error) {
+		*scraped = append(*scraped, podName)
+		return fmt.Sprintf("istio_requests_total{response_code=\"200\"} %d\n", requests[podName]), nil
+	}
+	return sd
+}
+
+func scrapeTimes(t *testing.T, sd *ServiceDiscovery, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := sd.CollectMetrics(context.Background(), "shop", "reviews"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestPodSelection_First(t *testing.T) {
+	var scraped []string
+	scrapeTimes(t, newSelectionDiscovery(PodSelectFirst, nil, &scraped), 3)
+
+	expected := []string{"reviews-a", "reviews-a", "reviews-a"}
+	if !reflect.DeepEqual(scraped, expected) {
+		t.Errorf("Expected %v, got %v", expected, scraped)
+	}
+}
+
+func TestPodSelection_RoundRobin(t *testing.T) {
+	var scraped []string
+	scrapeTimes(t, newSelectionDiscovery(PodSelectRoundRobin, nil, &scraped), 4)
+
+	expected := []string{"reviews-a", "reviews-b", "reviews-c", "reviews-a"}
+	if !reflect.DeepEqual(scraped, expected) {
+		t.Errorf("Expected %v, got %v", expected, scraped)
+	}
+}
+
+func TestPodSelection_Random(t *testing.T) {
+	var scraped []string
+	sd := newSelectionDiscovery(PodSelectRandom, nil, &scraped)
+	picks := []int{2, 0, 1}
+	sd.randIntn = func(n int) int {
+		pick := picks[0]
+		picks = picks[1:]
+		return pick
+	}
+	scrapeTimes(t, sd, 3)
+
+	expected := []string{"reviews-c", "reviews-a", "reviews-b"}
+	if !reflect.DeepEqual(scraped, expected) {
+		t.Errorf("Expected %v, got %v", expected, scraped)
+	}
+}
+
+func TestPodSelection_HighestTraffic(t *testing.T) {
+	var scraped []string
+	requests := map[string]int{"reviews-a": 10, "reviews-b": 500, "reviews-c": 40}
+	scrapeTimes(t, newSelectionDiscovery(PodSelectHighestTraffic, requests, &scraped), 5)
+
+	// Each replica is measured once, then the busiest one is kept
+	expected := []string{"reviews-a", "reviews-b", "reviews-c", "reviews-b", "reviews-b"}
+	if !reflect.DeepEqual(scraped, expected) {
+		t.Errorf("Expected %v, got %v", expected, scraped)
+	}
+}
+
+func TestPodSelection_RestoredAcrossRuns(t *testing.T) {
+	requests := map[string]int{"reviews-a": 10, "reviews-b": 500, "reviews-c": 40}
+	for _, c := range []struct {
+		strategy PodSelectionStrategy
+		scrapes  int
+		next     string
+	}{
+		{PodSelectRoundRobin, 2, "reviews-c"},
+		{PodSelectHighestTraffic, 3, "reviews-b"},
+	} {
+		var previous []string
+		earlier := newSelectionDiscovery(c.strategy, requests, &previous)
+		scrapeTimes(t, earlier, c.scrapes)
+
+		var scraped []string
+		sd := newSelectionDiscovery(c.strategy, requests, &scraped)
+		sd.RestorePodSelection(earlier.PodSelectionState())
+		scrapeTimes(t, sd, 1)
+		if !reflect.DeepEqual(scraped, []string{c.next}) {
+			t.Errorf("%s: expected the next run to start at %s, got %v", c.strategy, c.next, scraped)
+		}
+	}
+}
+
+func TestPodSelection_FallsBackToNextPod(t *testing.T) {
+	var scraped []string
+	sd := newSelectionDiscovery(PodSelectRoundRobin, nil, &scraped)
+	sd.podExec = func(ctx context.Context, namespace, podName, container string, command []string) (string, error) {
+		scraped = append(scraped, podName)
+		if podName == "reviews-a" {
+			return "", fmt.Errorf("exec failed")
+		}
+		return sampleMetrics, nil
+	}
+	scrapeTimes(t, sd, 1)
+
+	expected := []string{"reviews-a", "reviews-b"}
+	if !reflect.DeepEqual(scraped, expected) {
+		t.Errorf("Expected %v, got %v", expected, scraped)
+	}
+}
+
+func TestParsePodSelectionStrategy(t *testing.T) {
+	if strategy, err := ParsePodSelectionStrategy(""); err != nil || strategy != PodSelectFirst {
+		t.Errorf("Expected empty to default to first, got %q (%v)", strategy, err)
+	}
+	if _, err := ParsePodSelectionStrategy("busiest"); err == nil {
+		t.Error("Expected an error for an unknown strategy")
+	}
+}
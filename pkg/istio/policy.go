@@ -0,0 +1,161 @@
+package istio
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	DestinationRuleGVR = schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1beta1", Resource: "destinationrules"}
+	VirtualServiceGVR  = schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1beta1", Resource: "virtualservices"}
+)
+
+// TrafficPolicy is the declared Istio configuration for a service, so an
+// anomaly can be compared against the policy it stems from.
+type TrafficPolicy struct {
+	// DestinationRule names the rule whose outlier detection and
+	// connection pool limits are in CircuitBreaker
+	DestinationRule string            `json:"destination_rule,omitempty"`
+	CircuitBreaker  map[string]string `json:"circuit_breaker,omitempty"`
+	// VirtualService names the route whose retry and timeout policy is in
+	// Retries
+	VirtualService string            `json:"virtual_service,omitempty"`
+	Retries        map[string]string `json:"retries,omitempty"`
+}
+
+// SetDynamicClient enables looking up each service's DestinationRule and
+// VirtualService while collecting.
+func (sd *ServiceDiscovery) SetDynamicClient(client dynamic.Interface) {
+	sd.dynamicClient = client
+}
+
+// LookupTrafficPolicy finds the DestinationRule and VirtualService that
+// apply to the service. It returns nil when neither exists, including when
+// the Istio CRDs aren't installed.
+func (sd *ServiceDiscovery) LookupTrafficPolicy(ctx context.Context, namespace, serviceName string) (*TrafficPolicy, error) {
+	if sd.dynamicClient == nil {
+		return nil, nil
+	}
+
+	hosts := serviceHosts(namespace, serviceName)
+	policy := &TrafficPolicy{}
+
+	rule, err := sd.findIstioResource(ctx, DestinationRuleGVR, namespace, hosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up DestinationRule: %w", err)
+	}
+	if rule != nil {
+		policy.DestinationRule = rule.GetName()
+		policy.CircuitBreaker = destinationRuleSettings(rule)
+	}
+
+	route, err := sd.findIstioResource(ctx, VirtualServiceGVR, namespace, hosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up VirtualService: %w", err)
+	}
+	if route != nil {
+		policy.VirtualService = route.GetName()
+		policy.Retries = virtualServiceSettings(route)
+	}
+
+	if rule == nil && route == nil {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// serviceHosts lists the names a service can be addressed by in Istio
+// host fields.
+func serviceHosts(namespace, serviceName string) map[string]bool {
+	return map[string]bool{
+		serviceName:                                          true,
+		serviceName + "." + namespace:                        true,
+		serviceName + "." + namespace + ".svc":               true,
+		serviceName + "." + namespace + ".svc.cluster.local": true,
+	}
+}
+
+// findIstioResource returns the first resource, by name, whose host (for a
+// DestinationRule) or hosts (for a VirtualService) match the service.
+func (sd *ServiceDiscovery) findIstioResource(ctx context.Context, gvr schema.GroupVersionResource, namespace string, hosts map[string]bool) (*unstructured.Unstructured, error) {
+	list, err := sd.dynamicClient.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	items := list.Items
+	sort.Slice(items, func(i, j int) bool { return items[i].GetName() < items[j].GetName() })
+
+	for i := range items {
+		if host, found, _ := unstructured.NestedString(items[i].Object, "spec", "host"); found && hosts[host] {
+			return &items[i], nil
+		}
+		if routeHosts, found, _ := unstructured.NestedStringSlice(items[i].Object, "spec", "hosts"); found {
+			for _, host := range routeHosts {
+				if hosts[host] {
+					return &items[i], nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// destinationRuleSettings flattens outlier detection and connection pool
+// limits, e.g. consecutive5xxErrors=5 or http1MaxPendingRequests=100.
+func destinationRuleSettings(rule *unstructured.Unstructured) map[string]string {
+	settings := make(map[string]string)
+	for _, path := range [][]string{
+		{"spec", "trafficPolicy", "outlierDetection"},
+		{"spec", "trafficPolicy", "connectionPool", "tcp"},
+		{"spec", "trafficPolicy", "connectionPool", "http"},
+	} {
+		addScalarFields(settings, rule.Object, path...)
+	}
+	return settings
+}
+
+// virtualServiceSettings takes the retry policy and timeout of the first
+// HTTP route, e.g. attempts=3 or timeout=2s.
+func virtualServiceSettings(route *unstructured.Unstructured) map[string]string {
+	settings := make(map[string]string)
+
+	routes, found, _ := unstructured.NestedSlice(route.Object, "spec", "http")
+	if !found || len(routes) == 0 {
+		return settings
+	}
+	first, ok := routes[0].(map[string]interface{})
+	if !ok {
+		return settings
+	}
+
+	addScalarFields(settings, first, "retries")
+	if timeout, found, _ := unstructured.NestedString(first, "timeout"); found {
+		settings["timeout"] = timeout
+	}
+	return settings
+}
+
+func addScalarFields(settings map[string]string, object map[string]interface{}, path ...string) {
+	fields, found, _ := unstructured.NestedMap(object, path...)
+	if !found {
+		return
+	}
+	for key, value := range fields {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			continue
+		}
+		settings[key] = fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,103 @@
+package istio
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newIstioResource(kind, namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "networking.istio.io/v1beta1",
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"spec":       spec,
+	}}
+}
+
+func newPolicyClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			DestinationRuleGVR: "DestinationRuleList",
+			VirtualServiceGVR:  "VirtualServiceList",
+		}, objects...)
+}
+
+func TestServiceDiscovery_LookupTrafficPolicy(t *testing.T) {
+	client := newPolicyClient(
+		newIstioResource("DestinationRule", "shop", "reviews-dr", map[string]interface{}{
+			"host": "reviews.shop.svc.cluster.local",
+			"trafficPolicy": map[string]interface{}{
+				"outlierDetection": map[string]interface{}{
+					"consecutive5xxErrors": int64(5),
+					"interval":             "10s",
+				},
+				"connectionPool": map[string]interface{}{
+					"http": map[string]interface{}{"http1MaxPendingRequests": int64(100)},
+				},
+			},
+		}),
+		newIstioResource("VirtualService", "shop", "reviews-route", map[string]interface{}{
+			"hosts": []interface{}{"reviews"},
+			"http": []interface{}{map[string]interface{}{
+				"timeout": "2s",
+				"retries": map[string]interface{}{"attempts": int64(3), "perTryTimeout": "500ms"},
+			}},
+		}),
+		// Another service's rule must not be picked up
+		newIstioResource("DestinationRule", "shop", "ratings-dr", map[string]interface{}{
+			"host": "ratings",
+		}),
+	)
+
+	sd := NewServiceDiscovery(nil, nil)
+	sd.SetDynamicClient(client)
+
+	policy, err := sd.LookupTrafficPolicy(context.Background(), "shop", "reviews")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if policy == nil {
+		t.Fatal("Expected a traffic policy")
+	}
+
+	if policy.DestinationRule != "reviews-dr" {
+		t.Errorf("Expected DestinationRule reviews-dr, got %q", policy.DestinationRule)
+	}
+	for key, expected := range map[string]string{"consecutive5xxErrors": "5", "interval": "10s", "http1MaxPendingRequests": "100"} {
+		if policy.CircuitBreaker[key] != expected {
+			t.Errorf("Expected %s=%s, got %q", key, expected, policy.CircuitBreaker[key])
+		}
+	}
+
+	if policy.VirtualService != "reviews-route" {
+		t.Errorf("Expected VirtualService reviews-route, got %q", policy.VirtualService)
+	}
+	for key, expected := range map[string]string{"attempts": "3", "perTryTimeout": "500ms", "timeout": "2s"} {
+		if policy.Retries[key] != expected {
+			t.Errorf("Expected %s=%s, got %q", key, expected, policy.Retries[key])
+		}
+	}
+}
+
+func TestServiceDiscovery_LookupTrafficPolicy_NoResources(t *testing.T) {
+	sd := NewServiceDiscovery(nil, nil)
+
+	policy, err := sd.LookupTrafficPolicy(context.Background(), "shop", "reviews")
+	if err != nil || policy != nil {
+		t.Errorf("Expected no lookup without a dynamic client, got %+v (%v)", policy, err)
+	}
+
+	sd.SetDynamicClient(newPolicyClient())
+	policy, err = sd.LookupTrafficPolicy(context.Background(), "shop", "reviews")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if policy != nil {
+		t.Errorf("Expected no policy without matching resources, got %+v", policy)
+	}
+}
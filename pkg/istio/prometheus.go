@@ -0,0 +1,195 @@
+package istio
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// promSample is a single sample line from the Prometheus text exposition format.
+type promSample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// parsePromLine parses a line such as `name{a="b",c="d"} 12 1700000000`.
+// Comments, blank lines, and malformed samples return false.
+func parsePromLine(line string) (promSample, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return promSample{}, false
+	}
+
+	sample := promSample{Labels: make(map[string]string)}
+	rest := line
+
+	if brace := strings.IndexByte(line, '{'); brace >= 0 {
+		sample.Name = line[:brace]
+		end, ok := parsePromLabels(line[brace+1:], sample.Labels)
+		if !ok {
+			return promSample{}, false
+		}
+		rest = line[brace+1+end:]
+	} else {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return promSample{}, false
+		}
+		sample.Name = fields[0]
+		rest = strings.TrimPrefix(line, fields[0])
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return promSample{}, false
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return promSample{}, false
+	}
+	sample.Value = value
+
+	return sample, true
+}
+
+// parsePromLabels reads label pairs up to the closing brace and returns the
+// offset just past it.
+func parsePromLabels(s string, labels map[string]string) (int, bool) {
+	i := 0
+	for i < len(s) {
+		for i < len(s) && (s[i] == ',' || s[i] == ' ') {
+			i++
+		}
+		if i < len(s) && s[i] == '}' {
+			return i + 1, true
+		}
+
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 || i+eq+1 >= len(s) || s[i+eq+1] != '"' {
+			return 0, false
+		}
+		key := strings.TrimSpace(s[i : i+eq])
+		i += eq + 2
+
+		var value strings.Builder
+		for i < len(s) && s[i] != '"' {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+			} else {
+				value.WriteByte(s[i])
+			}
+			i++
+		}
+		if i >= len(s) {
+			return 0, false
+		}
+		i++ // closing quote
+
+		labels[key] = value.String()
+	}
+	return 0, false
+}
+
+// histogramBucket is a cumulative bucket of a Prometheus histogram.
+type histogramBucket struct {
+	UpperBound float64
+	Count      float64
+}
+
+// histogramQuantile estimates the q-quantile from cumulative buckets the same
+// way Prometheus' histogram_quantile does: find the bucket containing the
+// rank and interpolate linearly within it.
+func histogramQuantile(q float64, buckets []histogramBucket) float64 {
+	if len(buckets) == 0 {
+		return 0
+	}
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].UpperBound < buckets[j].UpperBound })
+
+	total := buckets[len(buckets)-1].Count
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * total
+	lowerBound, lowerCount := 0.0, 0.0
+	for _, b := range buckets {
+		if b.Count >= rank {
+			if math.IsInf(b.UpperBound, 1) {
+				// The quantile falls in the overflow bucket; the best estimate
+				// is the highest finite bound
+				return lowerBound
+			}
+			if b.Count == lowerCount {
+				return b.UpperBound
+			}
+			return lowerBound + (b.UpperBound-lowerBound)*(rank-lowerCount)/(b.Count-lowerCount)
+		}
+		lowerBound, lowerCount = b.UpperBound, b.Count
+	}
+
+	return lowerBound
+}
+
+// parseBucketBound parses an `le` label value, including "+Inf".
+func parseBucketBound(le string) (float64, bool) {
+	bound, err := strconv.ParseFloat(le, 64)
+	if err != nil {
+		return 0, false
+	}
+	return bound, true
+}
+
+// latencyDistribution collects a latency metric that may be exposed as a
+// summary (series labeled quantile) or as a histogram (name_bucket series
+// labeled le), depending on how the proxy's telemetry is configured.
+type latencyDistribution struct {
+	name      string
+	quantiles map[float64]float64
+	buckets   []histogramBucket
+}
+
+func newLatencyDistribution(name string) *latencyDistribution {
+	return &latencyDistribution{name: name, quantiles: make(map[float64]float64)}
+}
+
+// add records sample if it belongs to the distribution. The _sum and _count
+// series (and OpenMetrics' _created) carry no quantile information and are
+// ignored. Summary series split by other labels keep the highest value.
+func (d *latencyDistribution) add(sample promSample) {
+	switch sample.Name {
+	case d.name:
+		if q, err := strconv.ParseFloat(sample.Labels["quantile"], 64); err == nil && !math.IsNaN(sample.Value) {
+			d.quantiles[q] = max(d.quantiles[q], sample.Value)
+		}
+	case d.name + "_bucket":
+		if bound, ok := parseBucketBound(sample.Labels["le"]); ok {
+			d.buckets = mergeBucket(d.buckets, bound, sample.Value)
+		}
+	}
+}
+
+// quantile returns the q-quantile, preferring the summary's precomputed
+// value and estimating it from the histogram buckets otherwise.
+func (d *latencyDistribution) quantile(q float64) float64 {
+	if value, ok := d.quantiles[q]; ok {
+		return value
+	}
+	// A configured quantile such as 99.9/100 may not be exactly the
+	// summary's 0.999
+	for summary, value := range d.quantiles {
+		if math.Abs(summary-q) < 1e-9 {
+			return value
+		}
+	}
+	return histogramQuantile(q, d.buckets)
+}
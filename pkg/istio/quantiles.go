@@ -0,0 +1,39 @@
+package istio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Quantile is a latency quantile collected on top of P50, P90, P95 and P99,
+// such as P99.9 for services with tight tail latency targets.
+type Quantile struct {
+	// Name keys the quantile in LatencyMetrics.Quantiles, e.g. p99.9
+	Name string
+	// Value is the quantile as a fraction, e.g. 0.999
+	Value float64
+}
+
+// ParseQuantiles parses percentile names such as p99.9 or p75.
+func ParseQuantiles(names []string) ([]Quantile, error) {
+	quantiles := make([]Quantile, 0, len(names))
+	for _, name := range names {
+		percent, err := strconv.ParseFloat(strings.TrimPrefix(strings.ToLower(name), "p"), 64)
+		if err != nil || !strings.HasPrefix(strings.ToLower(name), "p") || percent <= 0 || percent >= 100 {
+			return nil, fmt.Errorf("invalid latency quantile %q: expected a percentile such as p99.9", name)
+		}
+		quantiles = append(quantiles, Quantile{
+			Name:  "p" + strconv.FormatFloat(percent, 'f', -1, 64),
+			Value: percent / 100,
+		})
+	}
+	return quantiles, nil
+}
+
+// SetLatencyQuantiles records the given quantiles in each service's
+// LatencyMetrics.Quantiles, read from the summary when the proxy exports
+// one and estimated from the histogram otherwise.
+func (sd *ServiceDiscovery) SetLatencyQuantiles(quantiles []Quantile) {
+	sd.quantiles = quantiles
+}
@@ -0,0 +1,167 @@
+package istio
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"smanalyzer/pkg/progress"
+	"smanalyzer/pkg/telemetry"
+)
+
+// PodSignal is one replica's own golden signals, scraped separately from
+// the service so a single bad pod isn't averaged away.
+type PodSignal struct {
+	Requests   float64 `json:"requests"`
+	ErrorRate  float64 `json:"error_rate"`
+	LatencyP99 float64 `json:"latency_p99_ms"`
+}
+
+func podSignal(n telemetry.Normalized) PodSignal {
+	return PodSignal{
+		Requests:   n.Requests,
+		ErrorRate:  n.ErrorRate(),
+		LatencyP99: float64(n.LatencyP99.Milliseconds()),
+	}
+}
+
+// PodAggregate is the service's request totals summed over the replicas
+// the replica check scraped. When max pods per service left some replicas
+// unscraped, the totals are extrapolated from the sample, scaled by
+// replicas/sampled. That assumes the unscraped pods serve like the average
+// sampled one, so a single hot or failing replica outside the sample is
+// missed.
+type PodAggregate struct {
+	Sampled      int     `json:"sampled"`
+	Replicas     int     `json:"replicas"`
+	Requests     float64 `json:"requests"`
+	Errors       float64 `json:"errors"`
+	Extrapolated bool    `json:"extrapolated,omitempty"`
+}
+
+// ErrorRate is the fraction of the aggregated requests that failed.
+func (a PodAggregate) ErrorRate() float64 {
+	if a.Requests <= 0 {
+		return 0
+	}
+	return a.Errors / a.Requests
+}
+
+// aggregatePods sums the scraped replicas' requests and errors, scaling
+// them up to all replicas when only a sample was scraped.
+func aggregatePods(signals map[string]PodSignal, replicas int) *PodAggregate {
+	aggregate := &PodAggregate{Sampled: len(signals), Replicas: replicas}
+	for _, signal := range signals {
+		aggregate.Requests += signal.Requests
+		aggregate.Errors += signal.Requests * signal.ErrorRate
+	}
+	if aggregate.Sampled > 0 && aggregate.Sampled < replicas {
+		scale := float64(replicas) / float64(aggregate.Sampled)
+		aggregate.Requests *= scale
+		aggregate.Errors *= scale
+		aggregate.Extrapolated = true
+	}
+	return aggregate
+}
+
+// SetMaxPodsPerService caps how many replicas the replica check scrapes
+// per service; zero scrapes them all.
+func (sd *ServiceDiscovery) SetMaxPodsPerService(max int) {
+	sd.maxPodsPerService = max
+}
+
+// ValidateMaxPodsPerService rejects a negative cap.
+func ValidateMaxPodsPerService(max int) error {
+	if max < 0 {
+		return fmt.Errorf("max pods per service must not be negative, got %d", max)
+	}
+	return nil
+}
+
+// samplePods returns at most limit pods: the collected one plus others
+// spread evenly over the rest, so a capped check still covers the whole
+// replica list rather than its first few entries.
+func samplePods(pods []corev1.Pod, collected string, limit int) []corev1.Pod {
+	if limit <= 0 || len(pods) <= limit {
+		return pods
+	}
+
+	var sampled, others []corev1.Pod
+	for _, pod := range pods {
+		if pod.Name == collected {
+			sampled = append(sampled, pod)
+		} else {
+			others = append(others, pod)
+		}
+	}
+	want := limit - len(sampled)
+	for i := 0; i < want; i++ {
+		sampled = append(sampled, others[i*len(others)/want])
+	}
+	return sampled
+}
+
+// SetReplicaCheck scrapes every replica of a service, not just the
+// selected one, recording each pod's signals in ServiceMeshMetrics.Pods.
+// It costs one extra scrape per replica, up to SetMaxPodsPerService.
+func (sd *ServiceDiscovery) SetReplicaCheck(enabled bool) {
+	sd.replicaCheck = enabled
+}
+
+// collectPodSignals gathers the signals of each pod, or of an even sample
+// of them when the service has more than max pods per service. The
+// collected pod was already scraped into metrics; the others are scraped
+// now, and skipped with a warning if that fails. Their version breakdowns
+// are added to the collected pod's.
+func (sd *ServiceDiscovery) collectPodSignals(ctx context.Context, pods []corev1.Pod, collected string, metrics *ServiceMeshMetrics) map[string]PodSignal {
+	sampled := samplePods(pods, collected, sd.maxPodsPerService)
+	if len(sampled) < len(pods) {
+		progress.Printf("  Sampling %d of %d replicas; service totals are extrapolated\n", len(sampled), len(pods))
+	}
+
+	signals := map[string]PodSignal{collected: podSignal(metrics.Normalized)}
+	for _, pod := range sampled {
+		if pod.Name == collected {
+			continue
+		}
+		scratch := &ServiceMeshMetrics{
+			ServiceName: metrics.ServiceName,
+			Namespace:   metrics.Namespace,
+			Labels:      make(map[string]string),
+		}
+		if err := sd.collector.Collect(ctx, pod, scratch); err != nil {
+			progress.Printf("  Warning: failed to collect replica %s: %v\n", pod.Name, err)
+			continue
+		}
+		sd.recordPodTraffic(pod, scratch.Normalized.Requests)
+		signals[pod.Name] = podSignal(scratch.Normalized)
+		// Each sidecar reports only its own version as the destination
+		metrics.addVersions(scratch.Versions)
+	}
+	return signals
+}
+
+// PodErrorRates returns each scraped replica's error rate as a fraction,
+// skipping replicas that served no requests.
+func (m *ServiceMeshMetrics) PodErrorRates() map[string]float64 {
+	rates := make(map[string]float64, len(m.Pods))
+	for pod, signal := range m.Pods {
+		if signal.Requests > 0 {
+			rates[pod] = signal.ErrorRate
+		}
+	}
+	return rates
+}
+
+// PodLatencies returns each scraped replica's P99 latency in milliseconds,
+// skipping replicas that served no requests.
+func (m *ServiceMeshMetrics) PodLatencies() map[string]float64 {
+	latencies := make(map[string]float64, len(m.Pods))
+	for pod, signal := range m.Pods {
+		if signal.Requests > 0 {
+			latencies[pod] = signal.LatencyP99
+		}
+	}
+	return latencies
+}
@@ -0,0 +1,91 @@
+package istio
+
+import (
+	"sort"
+	"strings"
+)
+
+// routeLabels are the istio_requests_total labels naming the route of a
+// request, most specific first. Neither is a default Istio label; they're
+// added through the Telemetry API or request classification.
+var routeLabels = []string{"request_operation", "route_name"}
+
+// RouteTraffic is the request outcome for one route of a service, e.g.
+// "POST /checkout".
+type RouteTraffic struct {
+	Route    string  `json:"route"`
+	Requests float64 `json:"requests"`
+	Errors   float64 `json:"errors"`
+}
+
+// ErrorRate is the fraction of the route's requests that failed.
+func (r RouteTraffic) ErrorRate() float64 {
+	if r.Requests <= 0 {
+		return 0
+	}
+	return r.Errors / r.Requests
+}
+
+// SetRouteBreakdown breaks each service's requests down by route. Every
+// route becomes series of its own, so it's off by default.
+func (sd *ServiceDiscovery) SetRouteBreakdown(enabled bool) {
+	sd.byRoute = enabled
+}
+
+// RouteSeriesKey is the storage key of one route's series, kept apart from
+// the service's own.
+func (m *ServiceMeshMetrics) RouteSeriesKey(route string) string {
+	return m.SeriesKey() + " " + route
+}
+
+// WorstRoute returns the route with the highest error rate among those
+// that had errors.
+func (m *ServiceMeshMetrics) WorstRoute() (RouteTraffic, bool) {
+	var worst RouteTraffic
+	found := false
+	for _, route := range m.Routes {
+		if route.Errors > 0 && (!found || route.ErrorRate() > worst.ErrorRate()) {
+			worst, found = route, true
+		}
+	}
+	return worst, found
+}
+
+// recordRouteTraffic adds an istio_requests_total sample to the breakdown
+// by route. Like the version breakdown it counts the destination's view of
+// its inbound requests; samples without a route are skipped.
+func recordRouteTraffic(routes map[string]RouteTraffic, line string) {
+	sample, ok := parsePromLine(line)
+	if !ok || sample.Labels["reporter"] == "source" {
+		return
+	}
+
+	var name string
+	for _, label := range routeLabels {
+		if value := sample.Labels[label]; value != "" && value != "unknown" {
+			name = value
+			break
+		}
+	}
+	if name == "" {
+		return
+	}
+
+	route := routes[name]
+	route.Route = name
+	route.Requests += sample.Value
+	if code := sample.Labels["response_code"]; strings.HasPrefix(code, "4") || strings.HasPrefix(code, "5") {
+		route.Errors += sample.Value
+	}
+	routes[name] = route
+}
+
+// sortedRoutes lists the routes by name.
+func sortedRoutes(routes map[string]RouteTraffic) []RouteTraffic {
+	var sorted []RouteTraffic
+	for _, route := range routes {
+		sorted = append(sorted, route)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Route < sorted[j].Route })
+	return sorted
+}
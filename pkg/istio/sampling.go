@@ -0,0 +1,96 @@
+package istio
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"smanalyzer/pkg/clock"
+)
+
+// ServiceSampler limits each scan to a share of the discovered services so
+// huge meshes aren't scraped in full every interval. It picks the services
+// sampled least recently, so at rate r every service is covered within
+// ceil(1/r) scans.
+type ServiceSampler struct {
+	rate        float64
+	lastSampled map[string]time.Time
+	excluded    map[string]bool
+	clock       clock.Clock
+	mutex       sync.Mutex
+}
+
+// NewServiceSampler samples the given fraction of services per scan, in
+// (0, 1]. A rate of 1 samples every service.
+func NewServiceSampler(rate float64) (*ServiceSampler, error) {
+	if rate <= 0 || rate > 1 {
+		return nil, fmt.Errorf("sample rate must be in (0, 1], got %v", rate)
+	}
+	return &ServiceSampler{
+		rate:        rate,
+		lastSampled: make(map[string]time.Time),
+		excluded:    make(map[string]bool),
+		clock:       clock.Real{},
+	}, nil
+}
+
+// SetClock replaces the clock that records when services were sampled.
+func (s *ServiceSampler) SetClock(c clock.Clock) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.clock = c
+}
+
+// Seed records when a service, by series key, was last sampled, e.g. from
+// its stored series, so rotation carries over between runs.
+func (s *ServiceSampler) Seed(seriesKey string, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if at.After(s.lastSampled[seriesKey]) {
+		s.lastSampled[seriesKey] = at
+	}
+}
+
+// Exclude leaves a service, by series key, out of every scan, e.g. one known
+// to be idle. The share sampled is taken of the remaining services.
+func (s *ServiceSampler) Exclude(seriesKey string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.excluded[seriesKey] = true
+}
+
+// sample picks this scan's share of the services that aren't excluded,
+// never-sampled first and then oldest first, and marks them sampled.
+func (s *ServiceSampler) sample(services []discoveredService) []discoveredService {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var ordered []discoveredService
+	for _, service := range services {
+		if !s.excluded[service.seriesKey()] {
+			ordered = append(ordered, service)
+		}
+	}
+
+	count := int(math.Ceil(s.rate * float64(len(ordered))))
+	if count >= len(ordered) {
+		count = len(ordered)
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := s.lastSampled[ordered[i].seriesKey()], s.lastSampled[ordered[j].seriesKey()]
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return ordered[i].seriesKey() < ordered[j].seriesKey()
+	})
+
+	now := s.clock.Now()
+	picked := ordered[:count]
+	for _, service := range picked {
+		s.lastSampled[service.seriesKey()] = now
+	}
+	return picked
+}
@@ -0,0 +1,135 @@
+package istio
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"smanalyzer/pkg/progress"
+	"smanalyzer/pkg/telemetry"
+)
+
+// DefaultMaxLatency is the highest request latency taken at face value.
+// Long-lived streams can legitimately report minutes, but an hour-long P99
+// is far more likely a corrupted histogram.
+const DefaultMaxLatency = time.Hour
+
+// SetMaxLatency drops a scrape's latency percentiles when any of them
+// exceeds max, so a corrupted histogram can't poison the stored series.
+// Zero disables the cap.
+func (sd *ServiceDiscovery) SetMaxLatency(max time.Duration) {
+	sd.maxLatency = max
+}
+
+// plausibleValue reports whether a sample can be one of the counters or
+// gauges collected, none of which go negative. A negative value (e.g. a
+// duration measured across a clock step), NaN or Inf is a corrupt sample.
+func plausibleValue(value float64) bool {
+	return value >= 0 && !math.IsInf(value, 0)
+}
+
+// implausibleLatency returns the first latency, in milliseconds, that is
+// negative, not a number or above the cap.
+func (sd *ServiceDiscovery) implausibleLatency(latencies ...float64) (float64, bool) {
+	for _, ms := range latencies {
+		if !plausibleValue(ms) {
+			return ms, true
+		}
+		if sd.maxLatency > 0 && ms > float64(sd.maxLatency.Milliseconds()) {
+			return ms, true
+		}
+	}
+	return 0, false
+}
+
+// clearLatency drops every latency percentile of a scrape and marks them
+// absent, so they aren't stored as zero.
+func clearLatency(n *telemetry.Normalized) {
+	n.LatencyP50, n.LatencyP90, n.LatencyP95, n.LatencyP99 = 0, 0, 0, 0
+	n.LatencyAbsent = true
+}
+
+// warnRejected reports the samples a parser skipped as implausible.
+func warnRejected(rejected []string, serviceName string) {
+	if len(rejected) > 0 {
+		progress.Printf("    Warning: skipped %d negative, NaN or infinite samples from %s, e.g. %s\n", len(rejected), serviceName, rejected[0])
+	}
+}
+
+// sanitizeNormalized drops the latency percentiles of a scrape when any of
+// them is implausible and zeroes its NaN or infinite fields, returning the
+// paths of the fields zeroed. It runs before the scrape is applied, since
+// converting an Inf to an integer count is undefined.
+func (sd *ServiceDiscovery) sanitizeNormalized(n *telemetry.Normalized, serviceName string) []string {
+	if ms, bad := sd.implausibleLatency(float64(n.LatencyP50.Milliseconds()), float64(n.LatencyP90.Milliseconds()),
+		float64(n.LatencyP95.Milliseconds()), float64(n.LatencyP99.Milliseconds())); bad {
+		progress.Printf("    Warning: dropped the latency percentiles of %s: %.0fms is negative or above the %v cap\n", serviceName, ms, sd.maxLatency)
+		clearLatency(n)
+	}
+	return zeroNonFinite(n, "Normalized")
+}
+
+// zeroNonFiniteMetrics zeroes the NaN or infinite fields of metrics once it
+// is filled in, and warns about them along with the ones sanitizeNormalized
+// zeroed.
+func zeroNonFiniteMetrics(metrics *ServiceMeshMetrics, zeroed []string) {
+	if zeroed = append(zeroed, zeroNonFinite(metrics, "")...); len(zeroed) > 0 {
+		progress.Printf("    Warning: zeroed %d NaN or infinite fields of %s: %s\n", len(zeroed), metrics.ServiceName, strings.Join(zeroed, ", "))
+	}
+}
+
+// zeroNonFinite replaces every NaN or infinite float reachable from v, a
+// pointer, with zero and returns the paths, under name, of the fields it
+// zeroed. Samples are checked as they are parsed, but sums of huge counters
+// overflow to Inf and ratios of them can be NaN, which detection and
+// clustering can't recover from.
+func zeroNonFinite(v any, name string) []string {
+	var zeroed []string
+	zeroNonFiniteValue(reflect.ValueOf(v), name, &zeroed)
+	return zeroed
+}
+
+func zeroNonFiniteValue(v reflect.Value, path string, zeroed *[]string) {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); (math.IsNaN(f) || math.IsInf(f, 0)) && v.CanSet() {
+			v.SetFloat(0)
+			*zeroed = append(*zeroed, path)
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			zeroNonFiniteValue(v.Elem(), path, zeroed)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if field := v.Type().Field(i); field.IsExported() {
+				zeroNonFiniteValue(v.Field(i), joinPath(path, field.Name), zeroed)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			zeroNonFiniteValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), zeroed)
+		}
+	case reflect.Map:
+		// Map values can't be set in place, so each is copied and put back
+		iter := v.MapRange()
+		for iter.Next() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(iter.Value())
+			before := len(*zeroed)
+			zeroNonFiniteValue(value, fmt.Sprintf("%s[%v]", path, iter.Key()), zeroed)
+			if len(*zeroed) > before {
+				v.SetMapIndex(iter.Key(), value)
+			}
+		}
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,213 @@
+package istio
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"smanalyzer/pkg/progress"
+	"smanalyzer/pkg/telemetry"
+	"smanalyzer/pkg/timeseries"
+)
+
+func TestParsePrometheusMetrics_SkipsImplausibleSamples(t *testing.T) {
+	const corrupt = `istio_requests_total{response_code="200"} 100
+istio_requests_total{response_code="503"} -40
+envoy_cluster_upstream_rq_retry{cluster_name="outbound|9080||ratings"} NaN
+envoy_cluster_upstream_rq_timeout{cluster_name="outbound|9080||ratings"} +Inf
+envoy_http_downstream_rq_active{envoy_http_conn_manager_prefix="inbound"} -Inf
+envoy_http_downstream_rq_active{envoy_http_conn_manager_prefix="outbound"} 3
+`
+	var out bytes.Buffer
+	progress.SetOutput(&out)
+	defer progress.SetOutput(os.Stdout)
+
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	metrics := &ServiceMeshMetrics{ServiceName: "reviews"}
+	sd.parsePrometheusMetrics(corrupt, metrics)
+
+	n := metrics.Normalized
+	if n.Requests != 100 || n.Errors5xx != 0 || n.Retries != 0 || n.Timeouts != 0 || n.PendingRequests != 3 {
+		t.Errorf("Expected only the valid samples counted, got %+v", n)
+	}
+	if !strings.Contains(out.String(), "skipped 4 negative, NaN or infinite samples from reviews") {
+		t.Errorf("Expected the skipped samples logged, got %q", out.String())
+	}
+
+	storage := timeseries.NewStorage()
+	for metric, value := range n.Series() {
+		storage.Store("shop/reviews", metric, value, nil)
+	}
+	for metric := range n.Series() {
+		for _, point := range storage.GetLatestN("shop/reviews", metric, 1) {
+			if point.Value < 0 || math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+				t.Errorf("Expected no implausible value stored, got %s=%v", metric, point.Value)
+			}
+		}
+	}
+}
+
+func TestParsePrometheusMetrics_LatencyCap(t *testing.T) {
+	// Half the requests under 10ms and the rest in a bucket bounded at
+	// 31 years, as a corrupted histogram might report
+	const corrupt = `istio_request_duration_milliseconds_bucket{le="10"} 50
+istio_request_duration_milliseconds_bucket{le="1e+12"} 100
+istio_request_duration_milliseconds_bucket{le="+Inf"} 100
+`
+	var out bytes.Buffer
+	progress.SetOutput(&out)
+	defer progress.SetOutput(os.Stdout)
+
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	metrics := &ServiceMeshMetrics{ServiceName: "reviews"}
+	sd.parsePrometheusMetrics(corrupt, metrics)
+
+	if n := metrics.Normalized; n.LatencyP50 != 0 || n.LatencyP99 != 0 {
+		t.Errorf("Expected the absurd percentiles dropped, got P50 %v and P99 %v", n.LatencyP50, n.LatencyP99)
+	}
+	if !strings.Contains(out.String(), "dropped the latency percentiles of reviews") {
+		t.Errorf("Expected the dropped percentiles logged, got %q", out.String())
+	}
+	// Stored as zero they would drag the latency baselines down
+	if _, stored := metrics.Normalized.Series()[telemetry.LatencyP99]; stored {
+		t.Error("Expected the dropped percentiles left out of the stored series")
+	}
+
+	sd.SetMaxLatency(0)
+	uncapped := &ServiceMeshMetrics{ServiceName: "reviews"}
+	sd.parsePrometheusMetrics(corrupt, uncapped)
+	if uncapped.Normalized.LatencyP50 != 10*time.Millisecond || uncapped.Normalized.LatencyP99 < time.Hour {
+		t.Errorf("Expected the percentiles kept with the cap disabled, got P50 %v and P99 %v", uncapped.Normalized.LatencyP50, uncapped.Normalized.LatencyP99)
+	}
+}
+
+func TestParsePrometheusMetrics_ZeroesOverflowedFields(t *testing.T) {
+	// Each sample is finite, but their sums overflow to +Inf and the mean
+	// request size is then Inf/2
+	const overflowing = `istio_requests_total{response_code="200",destination_version="v1"} 1.5e308
+istio_requests_total{response_code="200",destination_version="v1"} 1.5e308
+istio_request_bytes_sum{response_code="200"} 1.7e308
+istio_request_bytes_sum{response_code="500"} 1.7e308
+istio_request_bytes_count{response_code="200"} 2
+envoy_http_downstream_rq_active{envoy_http_conn_manager_prefix="outbound"} 3
+`
+	var out bytes.Buffer
+	progress.SetOutput(&out)
+	defer progress.SetOutput(os.Stdout)
+
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	metrics := &ServiceMeshMetrics{ServiceName: "reviews"}
+	sd.parsePrometheusMetrics(overflowing, metrics)
+
+	n := metrics.Normalized
+	if n.Requests != 0 || n.InboundBytes != 0 || n.RequestSizeMean != 0 || n.PendingRequests != 3 {
+		t.Errorf("Expected the overflowed fields zeroed and the rest kept, got %+v", n)
+	}
+	if metrics.Traffic.TotalRequests != 0 || metrics.Traffic.RequestsPerSecond != 0 || metrics.Traffic.MeanRequestBytes != 0 {
+		t.Errorf("Expected the derived traffic zeroed too, got %+v", metrics.Traffic)
+	}
+	if v1 := metrics.Versions["v1"]; v1.Requests != 0 {
+		t.Errorf("Expected the v1 breakdown zeroed, got %+v", v1)
+	}
+	for metric, value := range n.Series() {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			t.Errorf("Expected every series finite, got %s=%v", metric, value)
+		}
+	}
+	for _, field := range []string{"Normalized.Requests", "Normalized.InboundBytes", "Versions[v1].Requests"} {
+		if !strings.Contains(out.String(), field) {
+			t.Errorf("Expected %s named in the warning, got %q", field, out.String())
+		}
+	}
+}
+
+func TestParseLinkerdMetrics_Sanitized(t *testing.T) {
+	const corrupt = `request_total{direction="inbound"} 200
+response_total{direction="inbound",status_code="503",classification="failure"} -16
+tcp_open_connections{direction="inbound"} NaN
+tcp_read_bytes_total{direction="inbound"} 1.7e308
+tcp_read_bytes_total{direction="inbound",peer="src"} 1.7e308
+response_latency_ms_bucket{direction="inbound",le="10"} 100
+response_latency_ms_bucket{direction="inbound",le="1e+12"} 200
+response_latency_ms_bucket{direction="inbound",le="+Inf"} 200
+`
+	var out bytes.Buffer
+	progress.SetOutput(&out)
+	defer progress.SetOutput(os.Stdout)
+
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	metrics := &ServiceMeshMetrics{ServiceName: "reviews"}
+	sd.parseLinkerdMetrics(corrupt, metrics)
+
+	n := metrics.Normalized
+	if n.Requests != 200 || n.Errors5xx != 0 || n.ActiveConnections != 0 || n.InboundBytes != 0 {
+		t.Errorf("Expected the implausible samples skipped and the overflow zeroed, got %+v", n)
+	}
+	if !n.LatencyAbsent || n.LatencyP99 != 0 {
+		t.Errorf("Expected the absurd percentiles dropped, got P99 %v", n.LatencyP99)
+	}
+	for _, warning := range []string{"skipped 2 negative, NaN or infinite samples from reviews", "dropped the latency percentiles of reviews", "Normalized.InboundBytes"} {
+		if !strings.Contains(out.String(), warning) {
+			t.Errorf("Expected %q in the warnings, got %q", warning, out.String())
+		}
+	}
+}
+
+func TestParseZtunnelMetrics_Sanitized(t *testing.T) {
+	const corrupt = `istio_tcp_connections_opened_total{destination_canonical_service="reviews",response_flags="-"} 100
+istio_tcp_connections_opened_total{destination_canonical_service="reviews",response_flags="UF"} -5
+istio_tcp_connections_closed_total{destination_canonical_service="reviews",response_flags="-"} +Inf
+istio_tcp_sent_bytes_total{destination_canonical_service="reviews",source_workload="a"} 1.7e308
+istio_tcp_sent_bytes_total{destination_canonical_service="reviews",source_workload="b"} 1.7e308
+`
+	var out bytes.Buffer
+	progress.SetOutput(&out)
+	defer progress.SetOutput(os.Stdout)
+
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	metrics := &ServiceMeshMetrics{ServiceName: "reviews"}
+	sd.parseZtunnelMetrics(corrupt, metrics)
+
+	n := metrics.Normalized
+	if n.Requests != 100 || n.ConnectionFailures != 0 || n.ActiveConnections != 100 || n.OutboundBytes != 0 {
+		t.Errorf("Expected the implausible samples skipped and the overflow zeroed, got %+v", n)
+	}
+	if metrics.Traffic.OutboundBytes != 0 {
+		t.Errorf("Expected the derived traffic zeroed too, got %+v", metrics.Traffic)
+	}
+	for _, warning := range []string{"skipped 2 negative, NaN or infinite samples from reviews", "Normalized.OutboundBytes"} {
+		if !strings.Contains(out.String(), warning) {
+			t.Errorf("Expected %q in the warnings, got %q", warning, out.String())
+		}
+	}
+}
+
+func TestZeroNonFinite(t *testing.T) {
+	type sample struct {
+		Value  float64
+		Ratios []float64
+		ByPod  map[string]PodSignal
+		Next   *sample
+		hidden float64
+	}
+	s := &sample{
+		Value:  math.NaN(),
+		Ratios: []float64{0.5, math.Inf(-1)},
+		ByPod:  map[string]PodSignal{"a": {ErrorRate: math.Inf(1)}},
+		Next:   &sample{Value: 2},
+		hidden: math.NaN(),
+	}
+
+	zeroed := zeroNonFinite(s, "")
+	if s.Value != 0 || s.Ratios[0] != 0.5 || s.Ratios[1] != 0 || s.ByPod["a"].ErrorRate != 0 || s.Next.Value != 2 {
+		t.Errorf("Expected only the NaN and infinite values zeroed, got %+v", s)
+	}
+	if got := strings.Join(zeroed, ","); got != "Value,Ratios[1],ByPod[a].ErrorRate" {
+		t.Errorf("Expected the zeroed paths listed, got %q", got)
+	}
+}
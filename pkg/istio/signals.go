@@ -0,0 +1,162 @@
+package istio
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Signal is a golden-signal family that can be collected on its own.
+type Signal string
+
+const (
+	SignalErrors     Signal = "errors"
+	SignalLatency    Signal = "latency"
+	SignalTraffic    Signal = "traffic"
+	SignalSaturation Signal = "saturation"
+)
+
+// signalMetrics lists the metrics each family is read from. They're matched
+// as substrings, which covers both the Prometheus names in a scrape and the
+// Envoy stat names the sidecar's ?filter= regex is applied to, e.g.
+// cluster.outbound|9080||ratings.upstream_rq_retry.
+var signalMetrics = map[Signal][]string{
+	// The error rate is a fraction of all requests, so the request counter
+	// belongs to both errors and traffic
+	SignalErrors: {
+		"istio_requests_total",
+		"upstream_rq_retry",
+		"upstream_rq_timeout",
+		"upstream_cx_connect_fail",
+		"circuit_breakers",
+		// What's in use of each circuit breaker threshold
+		"upstream_cx_active",
+		"upstream_rq_active",
+		"upstream_rq_pending_active",
+		// Hosts ejected from upstream load balancing pools
+		"outlier_detection_ejections",
+	},
+	SignalLatency: {"istio_request_duration_milliseconds"},
+	SignalTraffic: {
+		"istio_requests_total",
+		"istio_request_bytes",
+		"istio_response_bytes",
+	},
+	SignalSaturation: {
+		"downstream_cx_active",
+		"downstream_rq_active",
+	},
+}
+
+// SignalSet selects the golden-signal families to collect. A nil set
+// collects all of them.
+type SignalSet map[Signal]bool
+
+// Signals lists the families a SignalSet accepts.
+func Signals() []string {
+	return []string{string(SignalErrors), string(SignalLatency), string(SignalTraffic), string(SignalSaturation)}
+}
+
+// ParseSignals validates a list of signal families. An empty list selects
+// all of them.
+func ParseSignals(names []string) (SignalSet, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	set := make(SignalSet)
+	for _, name := range names {
+		signal := Signal(strings.ToLower(strings.TrimSpace(name)))
+		if _, known := signalMetrics[signal]; !known {
+			return nil, fmt.Errorf("unknown signal %q (expected %s)", name, strings.Join(Signals(), ", "))
+		}
+		set[signal] = true
+	}
+	return set, nil
+}
+
+// collects reports whether the family is selected.
+func (s SignalSet) collects(signal Signal) bool {
+	return s == nil || s[signal]
+}
+
+// wants reports whether a metric feeds one of the selected families, so the
+// parser can skip the rest of a scrape.
+func (s SignalSet) wants(metric string) bool {
+	if s == nil {
+		return true
+	}
+	for signal := range s {
+		for _, name := range signalMetrics[signal] {
+			if strings.Contains(metric, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// statsFilter is the regex passed to the sidecar's /stats/prometheus so it
+// only renders the selected families, or "" to fetch everything.
+func (s SignalSet) statsFilter() string {
+	if s == nil {
+		return ""
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for signal := range s {
+		for _, name := range signalMetrics[signal] {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, regexp.QuoteMeta(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+// clearUnselected zeroes the families that weren't collected, so they read
+// as absent rather than as whatever a partial scrape happened to imply.
+func (s SignalSet) clearUnselected(metrics *ServiceMeshMetrics) {
+	if !s.collects(SignalErrors) {
+		metrics.Errors = ErrorMetrics{}
+		metrics.RetryCount = 0
+		metrics.TimeoutCount = 0
+		metrics.CircuitBreakers = 0
+		metrics.Versions = nil
+		metrics.Edges = nil
+		metrics.Routes = nil
+		metrics.Ejections = nil
+	}
+	if !s.collects(SignalLatency) {
+		metrics.Latency = LatencyMetrics{}
+	}
+	if !s.collects(SignalTraffic) {
+		metrics.Traffic = TrafficMetrics{}
+	}
+	if !s.collects(SignalSaturation) {
+		metrics.Saturation = SaturationMetrics{}
+	}
+}
+
+// SetSignals limits collection to the given golden-signal families. The
+// sidecar is asked for just their metrics, which keeps scrapes of large
+// meshes small when only one signal matters. Other families read zero.
+func (sd *ServiceDiscovery) SetSignals(signals SignalSet) {
+	sd.signals = signals
+}
+
+// sidecarStatsURL is the sidecar's stats URL, filtered to the selected
+// families. A metric mapping may read from any metric, so it disables the
+// filter.
+func (sd *ServiceDiscovery) sidecarStatsURL() string {
+	statsURL := sd.meshSettings.statsURL()
+	if filter := sd.signals.statsFilter(); filter != "" && len(sd.metricMapping) == 0 {
+		statsURL += "?filter=" + url.QueryEscape(filter)
+	}
+	return statsURL
+}
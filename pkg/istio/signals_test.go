@@ -0,0 +1,118 @@
+package istio
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const goldenSignalMetrics = `istio_requests_total{response_code="200"} 90
+istio_requests_total{response_code="503"} 10
+istio_request_duration_milliseconds{quantile="0.5"} 20
+istio_request_duration_milliseconds{quantile="0.99"} 250
+istio_request_bytes_sum 4096
+istio_response_bytes_sum 8192
+envoy_cluster_upstream_rq_retry{cluster_name="outbound|9080||ratings"} 4
+envoy_http_downstream_cx_active 12
+envoy_http_downstream_rq_active 3
+`
+
+func TestParsePrometheusMetrics_SignalSubset(t *testing.T) {
+	tests := []struct {
+		signals    []string
+		errors     bool
+		latency    bool
+		traffic    bool
+		saturation bool
+	}{
+		{nil, true, true, true, true},
+		{[]string{"errors"}, true, false, false, false},
+		{[]string{"latency"}, false, true, false, false},
+		{[]string{"traffic", "saturation"}, false, false, true, true},
+	}
+
+	for _, tt := range tests {
+		signals, err := ParseSignals(tt.signals)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.signals, err)
+		}
+		sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+		sd.SetSignals(signals)
+
+		metrics := &ServiceMeshMetrics{}
+		if err := sd.parsePrometheusMetrics(goldenSignalMetrics, metrics); err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.signals, err)
+		}
+
+		if got := metrics.Errors.ErrorRate > 0 && metrics.RetryCount == 4; got != tt.errors {
+			t.Errorf("%v: expected errors populated %v, got %+v (retries %d)", tt.signals, tt.errors, metrics.Errors, metrics.RetryCount)
+		}
+		if got := metrics.Latency.P99 > 0; got != tt.latency {
+			t.Errorf("%v: expected latency populated %v, got %+v", tt.signals, tt.latency, metrics.Latency)
+		}
+		if got := metrics.Traffic.TotalRequests == 100 && metrics.Traffic.InboundBytes > 0; got != tt.traffic {
+			t.Errorf("%v: expected traffic populated %v, got %+v", tt.signals, tt.traffic, metrics.Traffic)
+		}
+		if got := metrics.Saturation.Connections == 12 && metrics.Saturation.PendingReqs == 3; got != tt.saturation {
+			t.Errorf("%v: expected saturation populated %v, got %+v", tt.signals, tt.saturation, metrics.Saturation)
+		}
+	}
+}
+
+func TestParseMeshMetrics_SignalSubsetForEveryMesh(t *testing.T) {
+	signals, err := ParseSignals([]string{"errors"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	sd.SetSignals(signals)
+
+	linkerd := &ServiceMeshMetrics{ServiceName: "reviews", Namespace: "shop"}
+	sd.parseLinkerdMetrics(linkerdMetrics, linkerd)
+	ambient := &ServiceMeshMetrics{ServiceName: "reviews", Namespace: "shop"}
+	sd.parseZtunnelMetrics(ztunnelMetrics, ambient)
+
+	for mesh, metrics := range map[string]*ServiceMeshMetrics{"linkerd": linkerd, "ambient": ambient} {
+		if metrics.Errors.ErrorRate == 0 {
+			t.Errorf("%s: expected errors populated, got %+v", mesh, metrics.Errors)
+		}
+		if metrics.Latency.P99 != 0 || metrics.Traffic.TotalRequests != 0 || metrics.Saturation.Connections != 0 {
+			t.Errorf("%s: expected the unselected families cleared, got %+v, %+v and %+v", mesh, metrics.Latency, metrics.Traffic, metrics.Saturation)
+		}
+	}
+}
+
+func TestParseSignals_Unknown(t *testing.T) {
+	if _, err := ParseSignals([]string{"errors", "throughput"}); err == nil {
+		t.Error("Expected an error for an unknown signal")
+	}
+}
+
+func TestCollectEnvoyMetrics_FiltersStats(t *testing.T) {
+	pod := newTestPod("shop", "reviews-1", "reviews")
+	sd := NewServiceDiscovery(fake.NewSimpleClientset(), nil)
+	var scraped string
+	sd.podExec = func(ctx context.Context, namespace, podName, container string, command []string) (string, error) {
+		scraped = command[len(command)-1]
+		return sampleMetrics, nil
+	}
+
+	if err := sd.collectEnvoyMetrics(context.Background(), *pod, &ServiceMeshMetrics{Namespace: "shop"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(scraped, "filter=") {
+		t.Errorf("Expected an unfiltered scrape by default, got %s", scraped)
+	}
+
+	signals, _ := ParseSignals([]string{"latency"})
+	sd.SetSignals(signals)
+	if err := sd.collectEnvoyMetrics(context.Background(), *pod, &ServiceMeshMetrics{Namespace: "shop"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "http://localhost:15020/stats/prometheus?filter=istio_request_duration_milliseconds"
+	if scraped != expected {
+		t.Errorf("Expected %s, got %s", expected, scraped)
+	}
+}
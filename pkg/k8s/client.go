@@ -4,20 +4,29 @@ import (
 	"context"
 	"fmt"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 type Client struct {
-	Clientset  *kubernetes.Clientset
+	Clientset *kubernetes.Clientset
+	// Dynamic reaches custom resources such as Istio's DestinationRules
+	Dynamic    dynamic.Interface
 	RestConfig *rest.Config
 }
 
 func NewClient() (*Client, error) {
+	return NewClientForContext("")
+}
+
+// NewClientForContext connects using the named kubeconfig context, or the
+// current context when name is empty.
+func NewClientForContext(name string) (*Client, error) {
 	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 		clientcmd.NewDefaultClientConfigLoadingRules(),
-		&clientcmd.ConfigOverrides{},
+		&clientcmd.ConfigOverrides{CurrentContext: name},
 	).ClientConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
@@ -28,8 +37,14 @@ func NewClient() (*Client, error) {
 		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
 	}
 
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
 	return &Client{
 		Clientset:  clientset,
+		Dynamic:    dynamicClient,
 		RestConfig: config,
 	}, nil
 }
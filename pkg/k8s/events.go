@@ -0,0 +1,159 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/clock"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	eventComponent = "smanalyzer"
+
+	// DefaultEventInterval is the minimum time between events for the same
+	// object and anomaly type.
+	DefaultEventInterval = 5 * time.Minute
+)
+
+// EventPublisher records anomalies as Kubernetes Events on the Deployment
+// owning the service, or the Service itself when there is no Deployment of
+// the same name.
+type EventPublisher struct {
+	clientset kubernetes.Interface
+	interval  time.Duration
+	clock     clock.Clock
+
+	mutex    sync.Mutex
+	lastSent map[string]time.Time
+	sequence uint64
+}
+
+func NewEventPublisher(clientset kubernetes.Interface) *EventPublisher {
+	return &EventPublisher{
+		clientset: clientset,
+		interval:  DefaultEventInterval,
+		clock:     clock.Real{},
+		lastSent:  make(map[string]time.Time),
+	}
+}
+
+// SetInterval changes the minimum time between events for the same object
+// and anomaly type.
+func (p *EventPublisher) SetInterval(interval time.Duration) {
+	p.interval = interval
+}
+
+// SetClock replaces the clock events are timestamped and rate limited by.
+func (p *EventPublisher) SetClock(c clock.Clock) {
+	p.clock = c
+}
+
+// Publish creates a Warning event for the anomaly unless one with the same
+// reason was sent for the object within the interval. It reports whether an
+// event was created.
+func (p *EventPublisher) Publish(ctx context.Context, a anomaly.Anomaly) (bool, error) {
+	if a.Namespace == "" {
+		return false, fmt.Errorf("anomaly for %s has no namespace", a.ServiceName)
+	}
+
+	target, err := p.involvedObject(ctx, a.Namespace, a.ServiceName)
+	if err != nil {
+		return false, err
+	}
+
+	reason := eventReason(a.Type)
+	sentAt := p.clock.Now()
+	sequence, ok := p.allow(target, reason, sentAt)
+	if !ok {
+		return false, nil
+	}
+
+	now := metav1.NewTime(sentAt)
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// Named like client-go's event recorder, with a sequence number
+			// so events sent in the same instant don't collide
+			Name:      fmt.Sprintf("%s.%x.%d", target.Name, sentAt.UnixNano(), sequence),
+			Namespace: a.Namespace,
+		},
+		InvolvedObject: target,
+		Reason:         reason,
+		Message:        fmt.Sprintf("%s (severity %.2f)", a.Description, a.Severity),
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: eventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	if _, err := p.clientset.CoreV1().Events(a.Namespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		return false, fmt.Errorf("failed to create event for %s/%s: %w", target.Kind, target.Name, err)
+	}
+	return true, nil
+}
+
+func (p *EventPublisher) involvedObject(ctx context.Context, namespace, name string) (corev1.ObjectReference, error) {
+	deployment, err := p.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return corev1.ObjectReference{
+			Kind:       "Deployment",
+			APIVersion: "apps/v1",
+			Namespace:  namespace,
+			Name:       deployment.Name,
+			UID:        deployment.UID,
+		}, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return corev1.ObjectReference{}, fmt.Errorf("failed to get deployment %s/%s: %w", namespace, name, err)
+	}
+
+	service, err := p.clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return corev1.ObjectReference{}, fmt.Errorf("no deployment or service %s/%s: %w", namespace, name, err)
+	}
+	return corev1.ObjectReference{
+		Kind:       "Service",
+		APIVersion: "v1",
+		Namespace:  namespace,
+		Name:       service.Name,
+		UID:        service.UID,
+	}, nil
+}
+
+// allow applies the rate limit and, when the event may be sent, returns a
+// sequence number unique to this publisher.
+func (p *EventPublisher) allow(target corev1.ObjectReference, reason string, now time.Time) (uint64, bool) {
+	key := target.Kind + "/" + target.Namespace + "/" + target.Name + "/" + reason
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if last, exists := p.lastSent[key]; exists && now.Sub(last) < p.interval {
+		return 0, false
+	}
+	p.lastSent[key] = now
+	p.sequence++
+	return p.sequence, true
+}
+
+// eventReason converts an anomaly type such as "traffic_spike" into the
+// UpperCamelCase form Kubernetes uses for reasons ("TrafficSpike").
+func eventReason(t anomaly.AnomalyType) string {
+	var b strings.Builder
+	for _, word := range strings.Split(string(t), "_") {
+		if word == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
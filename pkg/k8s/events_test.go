@@ -0,0 +1,129 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/clock"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func listEvents(t *testing.T, clientset *fake.Clientset, namespace string) []corev1.Event {
+	t.Helper()
+	events, err := clientset.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return events.Items
+}
+
+func TestEventPublisher_Publish_Deployment(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: "shop", UID: "deploy-uid"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: "shop"}},
+	)
+	publisher := NewEventPublisher(clientset)
+
+	sent, err := publisher.Publish(context.Background(), anomaly.Anomaly{
+		Type:        anomaly.ErrorRateHigh,
+		ServiceName: "reviews",
+		Namespace:   "shop",
+		Severity:    4.2,
+		Description: "High error rate: 21.00%",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !sent {
+		t.Fatal("Expected an event to be sent")
+	}
+
+	events := listEvents(t, clientset, "shop")
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+
+	event := events[0]
+	if event.InvolvedObject.Kind != "Deployment" || event.InvolvedObject.Name != "reviews" || event.InvolvedObject.UID != "deploy-uid" {
+		t.Errorf("Expected involved object Deployment/reviews, got %+v", event.InvolvedObject)
+	}
+	if event.Reason != "ErrorRateHigh" {
+		t.Errorf("Expected reason ErrorRateHigh, got %s", event.Reason)
+	}
+	if event.Type != corev1.EventTypeWarning {
+		t.Errorf("Expected Warning event, got %s", event.Type)
+	}
+	if event.Message != "High error rate: 21.00% (severity 4.20)" {
+		t.Errorf("Expected description and severity in message, got %q", event.Message)
+	}
+}
+
+func TestEventPublisher_Publish_FallsBackToService(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "ratings", Namespace: "shop"}},
+	)
+	publisher := NewEventPublisher(clientset)
+
+	if _, err := publisher.Publish(context.Background(), anomaly.Anomaly{
+		Type:        anomaly.TrafficSpike,
+		ServiceName: "ratings",
+		Namespace:   "shop",
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	events := listEvents(t, clientset, "shop")
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	if events[0].InvolvedObject.Kind != "Service" || events[0].InvolvedObject.Name != "ratings" {
+		t.Errorf("Expected involved object Service/ratings, got %+v", events[0].InvolvedObject)
+	}
+	if events[0].Reason != "TrafficSpike" {
+		t.Errorf("Expected reason TrafficSpike, got %s", events[0].Reason)
+	}
+}
+
+func TestEventPublisher_Publish_RateLimited(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: "shop"}},
+	)
+	publisher := NewEventPublisher(clientset)
+	simulated := clock.NewSimulated(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	publisher.SetClock(simulated)
+
+	spike := anomaly.Anomaly{Type: anomaly.TrafficSpike, ServiceName: "reviews", Namespace: "shop"}
+	errors := anomaly.Anomaly{Type: anomaly.ErrorRateHigh, ServiceName: "reviews", Namespace: "shop"}
+
+	publisher.Publish(context.Background(), spike)
+	if sent, _ := publisher.Publish(context.Background(), spike); sent {
+		t.Error("Expected a repeat within the interval to be suppressed")
+	}
+	if sent, _ := publisher.Publish(context.Background(), errors); !sent {
+		t.Error("Expected a different reason to be sent")
+	}
+
+	simulated.Advance(DefaultEventInterval)
+	if sent, _ := publisher.Publish(context.Background(), spike); !sent {
+		t.Error("Expected the event to be sent again after the interval")
+	}
+
+	if n := len(listEvents(t, clientset, "shop")); n != 3 {
+		t.Errorf("Expected 3 events, got %d", n)
+	}
+}
+
+func TestEventPublisher_Publish_UnknownObject(t *testing.T) {
+	publisher := NewEventPublisher(fake.NewSimpleClientset())
+
+	_, err := publisher.Publish(context.Background(), anomaly.Anomaly{Type: anomaly.TrafficSpike, ServiceName: "missing", Namespace: "shop"})
+	if err == nil {
+		t.Error("Expected an error when neither a deployment nor a service exists")
+	}
+}
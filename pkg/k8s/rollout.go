@@ -0,0 +1,65 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// revisionAnnotation is the rollout revision the deployment controller
+// stamps on a Deployment and each of its ReplicaSets.
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// RolloutFinder looks up when the Deployment behind a service last rolled
+// out, to split before/after comparisons on.
+type RolloutFinder struct {
+	clientset kubernetes.Interface
+}
+
+func NewRolloutFinder(clientset kubernetes.Interface) *RolloutFinder {
+	return &RolloutFinder{clientset: clientset}
+}
+
+// LastRollout returns when the Deployment named after the service started
+// its current rollout: the creation of the ReplicaSet of its current
+// revision, or of its newest ReplicaSet when none carries the revision. A
+// rollback re-uses an old ReplicaSet, so it dates from that revision's
+// first rollout instead.
+func (r *RolloutFinder) LastRollout(ctx context.Context, namespace, name string) (time.Time, error) {
+	deployment, err := r.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get deployment %s/%s: %w", namespace, name, err)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("deployment %s/%s has an invalid selector: %w", namespace, name, err)
+	}
+	replicaSets, err := r.clientset.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to list replicasets of deployment %s/%s: %w", namespace, name, err)
+	}
+
+	revision := deployment.Annotations[revisionAnnotation]
+	var newest *appsv1.ReplicaSet
+	for i := range replicaSets.Items {
+		rs := &replicaSets.Items[i]
+		if !metav1.IsControlledBy(rs, deployment) {
+			continue
+		}
+		if revision != "" && rs.Annotations[revisionAnnotation] == revision {
+			return rs.CreationTimestamp.Time, nil
+		}
+		if newest == nil || newest.CreationTimestamp.Before(&rs.CreationTimestamp) {
+			newest = rs
+		}
+	}
+	if newest == nil {
+		return time.Time{}, fmt.Errorf("deployment %s/%s has no replicasets", namespace, name)
+	}
+	return newest.CreationTimestamp.Time, nil
+}
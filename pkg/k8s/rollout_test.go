@@ -0,0 +1,58 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newReplicaSet(deployment *appsv1.Deployment, name, revision string, created time.Time) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         deployment.Namespace,
+		Labels:            deployment.Spec.Selector.MatchLabels,
+		Annotations:       map[string]string{revisionAnnotation: revision},
+		CreationTimestamp: metav1.NewTime(created),
+		OwnerReferences:   []metav1.OwnerReference{*metav1.NewControllerRef(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+	}}
+}
+
+func TestRolloutFinder_LastRollout(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "checkout",
+			Namespace:   "shop",
+			UID:         "deploy-uid",
+			Annotations: map[string]string{revisionAnnotation: "2"},
+		},
+		Spec: appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "checkout"}}},
+	}
+	first := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	second := first.Add(3 * time.Hour)
+	// A ReplicaSet of another owner, newer than either
+	stray := newReplicaSet(deployment, "checkout-manual", "9", second.Add(time.Hour))
+	stray.OwnerReferences = nil
+
+	clientset := fake.NewSimpleClientset(
+		deployment,
+		newReplicaSet(deployment, "checkout-7d4b9", "1", first),
+		newReplicaSet(deployment, "checkout-5f6c8", "2", second),
+		stray,
+	)
+
+	rolledOut, err := NewRolloutFinder(clientset).LastRollout(context.Background(), "shop", "checkout")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !rolledOut.Equal(second) {
+		t.Errorf("Expected the revision 2 rollout at %v, got %v", second, rolledOut)
+	}
+
+	if _, err := NewRolloutFinder(clientset).LastRollout(context.Background(), "shop", "ratings"); err == nil {
+		t.Error("Expected an error for a service without a deployment")
+	}
+}
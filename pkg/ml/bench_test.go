@@ -0,0 +1,73 @@
+package ml
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+// benchmarkPoints is a long, noisy daily-cycle history like a busy
+// service's request counts.
+func benchmarkPoints(n int) []timeseries.DataPoint {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := make([]timeseries.DataPoint, n)
+	for i := range points {
+		cycle := math.Sin(float64(i) * 2 * math.Pi / 1440)
+		noise := math.Sin(float64(i)*7.3) * 5
+		points[i] = timeseries.DataPoint{
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			Value:     100 + 40*cycle + noise,
+		}
+	}
+	return points
+}
+
+func BenchmarkExtractFeatures_10k(b *testing.B) {
+	points := benchmarkPoints(10000)
+	engine := NewClusteringEngine(KMeansConfig{K: 3, MaxIter: 100, Tolerance: 0.01})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine.ExtractFeatures(points, 10)
+	}
+}
+
+func BenchmarkKMeans_10k(b *testing.B) {
+	engine := NewClusteringEngine(KMeansConfig{K: 3, MaxIter: 100, Tolerance: 0.01})
+	features := engine.ExtractFeatures(benchmarkPoints(10000), 10)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine.KMeans(features)
+	}
+}
+
+func TestExtractFeatures_AllocationsIndependentOfLength(t *testing.T) {
+	engine := NewClusteringEngine(KMeansConfig{K: 3, MaxIter: 100, Tolerance: 0.01})
+	points := benchmarkPoints(10000)
+
+	allocs := testing.AllocsPerRun(10, func() {
+		engine.ExtractFeatures(points, 10)
+	})
+	if allocs > 2 {
+		t.Errorf("Expected at most 2 allocations for 10k points, got %v", allocs)
+	}
+}
+
+func TestExtractFeatures_RowsDoNotShareCapacity(t *testing.T) {
+	engine := NewClusteringEngine(KMeansConfig{K: 3, MaxIter: 100, Tolerance: 0.01})
+	features := engine.ExtractFeatures(benchmarkPoints(20), 5)
+	if len(features) < 2 {
+		t.Fatalf("Expected several windows, got %d", len(features))
+	}
+
+	next := features[1].Features[0]
+	_ = append(features[0].Features, -1)
+	if features[1].Features[0] != next {
+		t.Errorf("Expected appending to one row to leave the next intact, got %v", features[1].Features[0])
+	}
+}
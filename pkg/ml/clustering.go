@@ -17,10 +17,13 @@ type Cluster struct {
 }
 
 type KMeansConfig struct {
-	K            int
-	MaxIter      int
-	Tolerance    float64
-	Features     []string
+	K         int
+	MaxIter   int
+	Tolerance float64
+	Features  []string
+	// FeatureWeights scales each feature's contribution to the distance
+	// between points, in Features order; empty weighs every feature 1
+	FeatureWeights []float64
 }
 
 type ClusteringEngine struct {
@@ -31,28 +34,57 @@ func NewClusteringEngine(config KMeansConfig) *ClusteringEngine {
 	return &ClusteringEngine{config: config}
 }
 
+// ExtractFeatures computes the configured features (DefaultFeatures when
+// none are configured) over each sliding window. Names without a
+// registered feature are skipped; see ValidateFeatures.
+//
+// Cost is O(n·w·f) for n points, window w and f features, with two
+// allocations regardless of n: every window's features share one backing
+// array.
 func (ce *ClusteringEngine) ExtractFeatures(points []timeseries.DataPoint, windowSize int) []ClusterPoint {
-	var features []ClusterPoint
+	count := len(points) - windowSize
+	if count <= 0 {
+		return nil
+	}
+	
+	names := ce.config.Features
+	if len(names) == 0 {
+		names = DefaultFeatures
+	}
+	var extractors []FeatureFunc
+	for _, name := range names {
+		if fn, exists := LookupFeature(name); exists {
+			extractors = append(extractors, fn)
+		}
+	}
+	
+	features := make([]ClusterPoint, 0, count)
+	values := make([]float64, count*len(extractors))
 	
 	for i := windowSize; i < len(points); i++ {
 		window := points[i-windowSize : i]
 		
-		feature := ClusterPoint{
-			Features: make([]float64, 0),
-			Original: &points[i],
-		}
+		// Cap the row so appending to it can't overwrite the next window
+		row := values[:len(extractors):len(extractors)]
+		values = values[len(extractors):]
 		
-		feature.Features = append(feature.Features, ce.calculateMean(window))
-		feature.Features = append(feature.Features, ce.calculateStdDev(window))
-		feature.Features = append(feature.Features, ce.calculateTrend(window))
-		feature.Features = append(feature.Features, ce.calculateVolatility(window))
+		for j, extract := range extractors {
+			row[j] = extract(window)
+		}
 		
-		features = append(features, feature)
+		features = append(features, ClusterPoint{
+			Features: row,
+			Original: &points[i],
+		})
 	}
 	
 	return features
 }
 
+// KMeans clusters the points into K groups. Each iteration is O(n·K·d) for
+// n points of d features and reuses the clusters' point slices, so
+// allocations don't grow with the iteration count. It stops early once the
+// centroids move less than the tolerance.
 func (ce *ClusteringEngine) KMeans(points []ClusterPoint) []Cluster {
 	if len(points) < ce.config.K {
 		return nil
@@ -111,6 +143,11 @@ func (ce *ClusteringEngine) calculateTrend(points []timeseries.DataPoint) float6
 	first := points[0].Value
 	last := points[len(points)-1].Value
 	
+	// Relative change is undefined from zero
+	if first == 0 {
+		return 0
+	}
+	
 	return (last - first) / first
 }
 
@@ -119,29 +156,35 @@ func (ce *ClusteringEngine) calculateVolatility(points []timeseries.DataPoint) f
 		return 0
 	}
 	
-	changes := make([]float64, len(points)-1)
-	for i := 1; i < len(points); i++ {
-		if points[i-1].Value != 0 {
-			changes[i-1] = (points[i].Value - points[i-1].Value) / points[i-1].Value
-		}
-	}
+	// Two passes over the relative changes instead of collecting them, as
+	// this runs once per window
+	n := float64(len(points) - 1)
 	
 	mean := 0.0
-	for _, change := range changes {
-		mean += change
+	for i := 1; i < len(points); i++ {
+		mean += relativeChange(points[i-1].Value, points[i].Value)
 	}
-	mean /= float64(len(changes))
+	mean /= n
 	
 	variance := 0.0
-	for _, change := range changes {
-		diff := change - mean
+	for i := 1; i < len(points); i++ {
+		diff := relativeChange(points[i-1].Value, points[i].Value) - mean
 		variance += diff * diff
 	}
-	variance /= float64(len(changes))
+	variance /= n
 	
 	return math.Sqrt(variance)
 }
 
+// relativeChange is the change from prev to next relative to prev, or 0
+// when prev is 0.
+func relativeChange(prev, next float64) float64 {
+	if prev == 0 {
+		return 0
+	}
+	return (next - prev) / prev
+}
+
 func (ce *ClusteringEngine) initializeClusters(points []ClusterPoint) []Cluster {
 	clusters := make([]Cluster, ce.config.K)
 	
@@ -164,8 +207,9 @@ func (ce *ClusteringEngine) assignPointsToClusters(points []ClusterPoint, cluste
 		minDist := math.Inf(1)
 		clusterIdx := 0
 		
+		// Squared distance picks the same nearest centroid without a sqrt
 		for i, cluster := range clusters {
-			dist := ce.euclideanDistance(point.Features, cluster.Centroid)
+			dist := ce.squaredDistance(point.Features, cluster.Centroid)
 			if dist < minDist {
 				minDist = dist
 				clusterIdx = i
@@ -182,23 +226,45 @@ func (ce *ClusteringEngine) updateCentroids(clusters []Cluster) {
 			continue
 		}
 		
-		for j := range clusters[i].Centroid {
-			sum := 0.0
-			for _, point := range clusters[i].Points {
-				sum += point.Features[j]
+		// Sum row by row so each point's features are read once
+		centroid := clusters[i].Centroid
+		for j := range centroid {
+			centroid[j] = 0
+		}
+		for _, point := range clusters[i].Points {
+			for j := range centroid {
+				centroid[j] += point.Features[j]
 			}
-			clusters[i].Centroid[j] = sum / float64(len(clusters[i].Points))
+		}
+		for j := range centroid {
+			centroid[j] /= float64(len(clusters[i].Points))
 		}
 	}
 }
 
+// Distance is the weighted euclidean distance between two feature vectors.
+// The detector scores points against a baseline with it too, so learning
+// and detection weigh the features alike.
+func (ce *ClusteringEngine) Distance(a, b []float64) float64 {
+	return ce.euclideanDistance(a, b)
+}
+
 func (ce *ClusteringEngine) euclideanDistance(a, b []float64) float64 {
+	return math.Sqrt(ce.squaredDistance(a, b))
+}
+
+func (ce *ClusteringEngine) squaredDistance(a, b []float64) float64 {
+	weights := ce.config.FeatureWeights
 	sum := 0.0
 	for i := range a {
 		diff := a[i] - b[i]
-		sum += diff * diff
+		if i < len(weights) {
+			sum += weights[i] * diff * diff
+		} else {
+			sum += diff * diff
+		}
 	}
-	return math.Sqrt(sum)
+	return sum
 }
 
 func (ce *ClusteringEngine) copyCentroids(clusters []Cluster) [][]float64 {
@@ -196,6 +196,33 @@ func TestClusteringEngine_EuclideanDistance(t *testing.T) {
 	}
 }
 
+func TestClusteringEngine_FeatureWeightsPickOutlier(t *testing.T) {
+	centroid := []float64{0, 0}
+	// Off the baseline in mean and in volatility respectively
+	meanShift := []float64{3, 0}
+	volatile := []float64{0, 4}
+
+	outlier := func(engine *ClusteringEngine) string {
+		if engine.Distance(meanShift, centroid) > engine.Distance(volatile, centroid) {
+			return "mean shift"
+		}
+		return "volatile"
+	}
+
+	uniform := NewClusteringEngine(KMeansConfig{Features: []string{"mean", "volatility"}})
+	if got := outlier(uniform); got != "volatile" {
+		t.Errorf("Expected the volatile point to be the outlier with uniform weights, got the %s point", got)
+	}
+
+	weighted := NewClusteringEngine(KMeansConfig{Features: []string{"mean", "volatility"}, FeatureWeights: []float64{4, 0.25}})
+	if got := outlier(weighted); got != "mean shift" {
+		t.Errorf("Expected the mean shift to be the outlier when the mean weighs more, got the %s point", got)
+	}
+	if distance := weighted.Distance(meanShift, centroid); math.Abs(distance-6) > 0.001 {
+		t.Errorf("Expected weighted distance 6, got %.3f", distance)
+	}
+}
+
 func TestClusteringEngine_HasConverged(t *testing.T) {
 	config := KMeansConfig{Tolerance: 0.1}
 	engine := NewClusteringEngine(config)
@@ -222,4 +249,18 @@ func TestClusteringEngine_HasConverged(t *testing.T) {
 	if !converged {
 		t.Error("Expected converged with changes < tolerance")
 	}
-}
\ No newline at end of file
+}
+
+func TestClusteringEngine_CalculateTrend_FromZero(t *testing.T) {
+	engine := &ClusteringEngine{}
+	
+	points := []timeseries.DataPoint{
+		{Timestamp: time.Now(), Value: 0.0},
+		{Timestamp: time.Now(), Value: 5.0},
+	}
+	
+	trend := engine.calculateTrend(points)
+	if math.IsNaN(trend) || math.IsInf(trend, 0) {
+		t.Errorf("Expected a finite trend from a zero start, got %v", trend)
+	}
+}
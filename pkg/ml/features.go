@@ -0,0 +1,164 @@
+package ml
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+// FeatureFunc computes one feature from a window of points.
+type FeatureFunc func(window []timeseries.DataPoint) float64
+
+// DefaultFeatures are extracted when KMeansConfig.Features is empty.
+var DefaultFeatures = []string{"mean", "stddev", "trend", "volatility"}
+
+var (
+	featuresMutex sync.RWMutex
+	features      = map[string]FeatureFunc{}
+)
+
+func init() {
+	var ce ClusteringEngine
+	RegisterFeature("mean", ce.calculateMean)
+	RegisterFeature("stddev", ce.calculateStdDev)
+	RegisterFeature("trend", ce.calculateTrend)
+	RegisterFeature("volatility", ce.calculateVolatility)
+	RegisterFeature("skewness", skewness)
+	RegisterFeature("kurtosis", kurtosis)
+	RegisterFeature("range", valueRange)
+	RegisterFeature("autocorrelation_lag1", autocorrelationLag1)
+}
+
+// RegisterFeature makes a feature available to KMeansConfig.Features under
+// name, replacing any feature already registered with it.
+func RegisterFeature(name string, fn FeatureFunc) {
+	featuresMutex.Lock()
+	defer featuresMutex.Unlock()
+	features[name] = fn
+}
+
+// LookupFeature returns the feature registered under name.
+func LookupFeature(name string) (FeatureFunc, bool) {
+	featuresMutex.RLock()
+	defer featuresMutex.RUnlock()
+	fn, exists := features[name]
+	return fn, exists
+}
+
+// RegisteredFeatures returns the names of every registered feature, sorted.
+func RegisteredFeatures() []string {
+	featuresMutex.RLock()
+	defer featuresMutex.RUnlock()
+
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateFeatures reports the first name with no registered feature.
+func ValidateFeatures(names []string) error {
+	for _, name := range names {
+		if _, exists := LookupFeature(name); !exists {
+			return fmt.Errorf("unknown feature %q (available: %v)", name, RegisteredFeatures())
+		}
+	}
+	return nil
+}
+
+// ValidateFeatureWeights reports weights that don't pair up with the
+// features (DefaultFeatures when none are named) or are negative.
+func ValidateFeatureWeights(weights []float64, names []string) error {
+	if len(weights) == 0 {
+		return nil
+	}
+	if len(names) == 0 {
+		names = DefaultFeatures
+	}
+	if len(weights) != len(names) {
+		return fmt.Errorf("%d feature weights for %d features %v", len(weights), len(names), names)
+	}
+	for i, weight := range weights {
+		if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return fmt.Errorf("weight %v for feature %q must be a non-negative number", weight, names[i])
+		}
+	}
+	return nil
+}
+
+// moments returns the mean and the second, third and fourth central moments.
+func moments(window []timeseries.DataPoint) (mean, m2, m3, m4 float64) {
+	n := float64(len(window))
+	for _, p := range window {
+		mean += p.Value
+	}
+	mean /= n
+
+	for _, p := range window {
+		d := p.Value - mean
+		m2 += d * d
+		m3 += d * d * d
+		m4 += d * d * d * d
+	}
+	return mean, m2 / n, m3 / n, m4 / n
+}
+
+// skewness is the population skewness; zero for a constant window.
+func skewness(window []timeseries.DataPoint) float64 {
+	if len(window) < 3 {
+		return 0
+	}
+	_, m2, m3, _ := moments(window)
+	if m2 == 0 {
+		return 0
+	}
+	return m3 / math.Pow(m2, 1.5)
+}
+
+// kurtosis is the excess kurtosis (0 for a normal distribution); zero for a
+// constant window.
+func kurtosis(window []timeseries.DataPoint) float64 {
+	if len(window) < 4 {
+		return 0
+	}
+	_, m2, _, m4 := moments(window)
+	if m2 == 0 {
+		return 0
+	}
+	return m4/(m2*m2) - 3
+}
+
+func valueRange(window []timeseries.DataPoint) float64 {
+	if len(window) == 0 {
+		return 0
+	}
+	min, max := window[0].Value, window[0].Value
+	for _, p := range window[1:] {
+		min = math.Min(min, p.Value)
+		max = math.Max(max, p.Value)
+	}
+	return max - min
+}
+
+// autocorrelationLag1 measures how much each point predicts the next, from
+// -1 (alternating) to 1 (smooth); zero for a constant window.
+func autocorrelationLag1(window []timeseries.DataPoint) float64 {
+	if len(window) < 3 {
+		return 0
+	}
+	mean, m2, _, _ := moments(window)
+	if m2 == 0 {
+		return 0
+	}
+
+	sum := 0.0
+	for i := 1; i < len(window); i++ {
+		sum += (window[i].Value - mean) * (window[i-1].Value - mean)
+	}
+	return sum / (m2 * float64(len(window)))
+}
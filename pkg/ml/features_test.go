@@ -0,0 +1,76 @@
+package ml
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/timeseries"
+)
+
+func valuePoints(values ...float64) []timeseries.DataPoint {
+	points := make([]timeseries.DataPoint, len(values))
+	for i, v := range values {
+		points[i] = timeseries.DataPoint{Timestamp: time.Now(), Value: v}
+	}
+	return points
+}
+
+func TestExtractFeatures_CustomFeature(t *testing.T) {
+	RegisterFeature("test_last", func(window []timeseries.DataPoint) float64 {
+		return window[len(window)-1].Value
+	})
+
+	engine := NewClusteringEngine(KMeansConfig{Features: []string{"mean", "test_last"}})
+	features := engine.ExtractFeatures(valuePoints(1, 2, 3, 4, 5), 3)
+
+	if len(features) != 2 {
+		t.Fatalf("Expected 2 feature vectors, got %d", len(features))
+	}
+	if got := features[0].Features; len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Errorf("Expected [2 3] for the first window, got %v", got)
+	}
+	if got := features[1].Features; got[0] != 3 || got[1] != 4 {
+		t.Errorf("Expected [3 4] for the second window, got %v", got)
+	}
+}
+
+func TestExtractFeatures_DefaultFeatures(t *testing.T) {
+	engine := NewClusteringEngine(KMeansConfig{})
+	features := engine.ExtractFeatures(valuePoints(1, 2, 3, 4), 3)
+
+	if len(features) != 1 || len(features[0].Features) != len(DefaultFeatures) {
+		t.Fatalf("Expected one vector of %d default features, got %v", len(DefaultFeatures), features)
+	}
+}
+
+func TestValidateFeatures(t *testing.T) {
+	if err := ValidateFeatures([]string{"mean", "skewness", "kurtosis", "range", "autocorrelation_lag1"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := ValidateFeatures([]string{"median"}); err == nil {
+		t.Error("Expected an error for an unregistered feature")
+	}
+}
+
+func TestBuiltinFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		fn       FeatureFunc
+		values   []float64
+		expected float64
+	}{
+		{"range", valueRange, []float64{3, 9, 1, 4}, 8},
+		{"skewness symmetric", skewness, []float64{1, 2, 3, 4, 5}, 0},
+		{"skewness right tail", skewness, []float64{1, 1, 1, 1, 10}, 1.5},
+		{"kurtosis uniform", kurtosis, []float64{1, 2, 3, 4, 5}, -1.3},
+		{"autocorrelation alternating", autocorrelationLag1, []float64{1, -1, 1, -1, 1, -1}, -5.0 / 6},
+		{"constant", skewness, []float64{2, 2, 2}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.fn(valuePoints(tt.values...)); math.Abs(got-tt.expected) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"smanalyzer/pkg/anomaly"
+)
+
+// Mode selects whether anomalies are notified one by one or as a digest.
+type Mode string
+
+const (
+	// PerAnomaly sends a message for every anomaly as it is detected.
+	PerAnomaly Mode = "anomaly"
+	// PerBatch collects a scan's anomalies and sends one summary on Flush.
+	PerBatch Mode = "batch"
+)
+
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case "":
+		return PerAnomaly, nil
+	case PerAnomaly, PerBatch:
+		return Mode(mode), nil
+	}
+	return "", fmt.Errorf("unsupported notify mode %q (expected %s or %s)", mode, PerAnomaly, PerBatch)
+}
+
+// Message is a single notification.
+type Message struct {
+	Title string
+	Text  string
+}
+
+// Sender delivers messages to a notification channel.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// WebhookSender posts messages as Slack-compatible JSON ({"text": ...}).
+type WebhookSender struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookSender(url string, client *http.Client) *WebhookSender {
+	return &WebhookSender{url: url, client: client}
+}
+
+func (s *WebhookSender) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(map[string]string{"text": msg.Title + "\n" + msg.Text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Notifier turns anomalies into messages for a Sender, either one per
+// anomaly or one digest per scan (or monitor interval).
+type Notifier struct {
+	sender       Sender
+	mode         Mode
+	link         string
+	descriptions anomaly.DescriptionTemplates
+	pending      []anomaly.Anomaly
+}
+
+func NewNotifier(sender Sender, mode Mode) *Notifier {
+	descriptions, _ := anomaly.ParseDescriptionTemplates(nil)
+	return &Notifier{
+		sender:       sender,
+		mode:         mode,
+		descriptions: descriptions,
+	}
+}
+
+// SetLink adds a reference to digests where the full details can be found,
+// e.g. a dashboard or the API server's anomaly endpoint.
+func (n *Notifier) SetLink(link string) {
+	n.link = link
+}
+
+// SetDescriptionTemplates overrides how anomaly descriptions are worded.
+func (n *Notifier) SetDescriptionTemplates(templates anomaly.DescriptionTemplates) {
+	n.descriptions = templates
+}
+
+// Add sends the anomaly right away in per-anomaly mode, or queues it for
+// the next Flush in batch mode.
+func (n *Notifier) Add(ctx context.Context, a anomaly.Anomaly) error {
+	if n.mode == PerBatch {
+		n.pending = append(n.pending, a)
+		return nil
+	}
+	return n.sender.Send(ctx, n.anomalyMessage(a))
+}
+
+// Flush sends the queued anomalies as one digest. Nothing is sent when the
+// batch is empty.
+func (n *Notifier) Flush(ctx context.Context) error {
+	if len(n.pending) == 0 {
+		return nil
+	}
+	msg := n.digest(n.pending)
+	n.pending = nil
+	return n.sender.Send(ctx, msg)
+}
+
+func (n *Notifier) anomalyMessage(a anomaly.Anomaly) Message {
+	return Message{
+		Title: fmt.Sprintf("[%s] %s on %s", anomaly.SeverityText(a.Severity), a.Type, serviceRef(a)),
+		Text:  fmt.Sprintf("%s (severity %.2f)", n.descriptions.Describe(a), a.Severity),
+	}
+}
+
+// digest summarizes anomalies with counts by severity and, per service,
+// the anomaly types seen, worst service first.
+func (n *Notifier) digest(anomalies []anomaly.Anomaly) Message {
+	severities := make(map[string]int)
+	services := make(map[string][]anomaly.Anomaly)
+	for _, a := range anomalies {
+		severities[anomaly.SeverityText(a.Severity)]++
+		ref := serviceRef(a)
+		services[ref] = append(services[ref], a)
+	}
+
+	var text strings.Builder
+	var counts []string
+	for _, level := range anomaly.SeverityLevels {
+		if count := severities[level]; count > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", count, level))
+		}
+	}
+	fmt.Fprintf(&text, "By severity: %s\n", strings.Join(counts, ", "))
+
+	refs := make([]string, 0, len(services))
+	for ref := range services {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		a, b := maxSeverity(services[refs[i]]), maxSeverity(services[refs[j]])
+		if a != b {
+			return a > b
+		}
+		return refs[i] < refs[j]
+	})
+	for _, ref := range refs {
+		var types []string
+		for _, a := range services[ref] {
+			types = append(types, string(a.Type))
+		}
+		fmt.Fprintf(&text, "- %s: %d (%s)\n", ref, len(services[ref]), strings.Join(types, ", "))
+	}
+
+	if n.link != "" {
+		fmt.Fprintf(&text, "Details: %s\n", n.link)
+	}
+
+	return Message{
+		Title: fmt.Sprintf("%d anomalies across %d services", len(anomalies), len(services)),
+		Text:  text.String(),
+	}
+}
+
+func serviceRef(a anomaly.Anomaly) string {
+	ref := a.ServiceName + "." + a.Namespace
+	if a.Cluster != "" {
+		ref = a.Cluster + "/" + ref
+	}
+	return ref
+}
+
+func maxSeverity(anomalies []anomaly.Anomaly) float64 {
+	highest := 0.0
+	for _, a := range anomalies {
+		if a.Severity > highest {
+			highest = a.Severity
+		}
+	}
+	return highest
+}
@@ -0,0 +1,139 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"smanalyzer/pkg/anomaly"
+)
+
+// recordingSender keeps every message instead of delivering it.
+type recordingSender struct {
+	messages []Message
+}
+
+func (r *recordingSender) Send(ctx context.Context, msg Message) error {
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+func scanAnomalies() []anomaly.Anomaly {
+	return []anomaly.Anomaly{
+		{Type: anomaly.ErrorRateHigh, ServiceName: "reviews", Namespace: "shop", Severity: 3.5, Metrics: map[string]float64{"error_rate": 0.21}},
+		{Type: anomaly.LatencyAnomaly, ServiceName: "reviews", Namespace: "shop", Severity: 2.1, Description: "P99 latency 1.2s"},
+		{Type: anomaly.TrafficSpike, ServiceName: "ratings", Namespace: "shop", Severity: 1.2, Description: "Traffic spike"},
+	}
+}
+
+func notifyAll(t *testing.T, notifier *Notifier, anomalies []anomaly.Anomaly) {
+	t.Helper()
+	ctx := context.Background()
+	for _, a := range anomalies {
+		if err := notifier.Add(ctx, a); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := notifier.Flush(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestNotifier_PerAnomalySendsEach(t *testing.T) {
+	sender := &recordingSender{}
+	notifyAll(t, NewNotifier(sender, PerAnomaly), scanAnomalies())
+
+	if len(sender.messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(sender.messages))
+	}
+	first := sender.messages[0]
+	if first.Title != "[CRITICAL] error_rate_high on reviews.shop" {
+		t.Errorf("Unexpected title %q", first.Title)
+	}
+	if first.Text != "High error rate: 21.00% (severity 3.50)" {
+		t.Errorf("Unexpected text %q", first.Text)
+	}
+}
+
+func TestNotifier_BatchSendsOneDigest(t *testing.T) {
+	sender := &recordingSender{}
+	notifier := NewNotifier(sender, PerBatch)
+	notifier.SetLink("http://smanalyzer.monitoring:8080/anomalies")
+	notifyAll(t, notifier, scanAnomalies())
+
+	if len(sender.messages) != 1 {
+		t.Fatalf("Expected 1 digest, got %d", len(sender.messages))
+	}
+	digest := sender.messages[0]
+	if digest.Title != "3 anomalies across 2 services" {
+		t.Errorf("Unexpected title %q", digest.Title)
+	}
+	for _, expected := range []string{
+		"By severity: 1 CRITICAL, 1 HIGH, 1 LOW",
+		"- reviews.shop: 2 (error_rate_high, latency_anomaly)\n- ratings.shop: 1 (traffic_spike)",
+		"Details: http://smanalyzer.monitoring:8080/anomalies",
+	} {
+		if !strings.Contains(digest.Text, expected) {
+			t.Errorf("Expected digest to contain %q, got:\n%s", expected, digest.Text)
+		}
+	}
+}
+
+func TestNotifier_BatchFlushesPerInterval(t *testing.T) {
+	sender := &recordingSender{}
+	notifier := NewNotifier(sender, PerBatch)
+
+	notifyAll(t, notifier, scanAnomalies()[:1])
+	notifyAll(t, notifier, nil)
+	notifyAll(t, notifier, scanAnomalies()[1:])
+
+	if len(sender.messages) != 2 {
+		t.Fatalf("Expected one digest per non-empty interval, got %d", len(sender.messages))
+	}
+	if sender.messages[1].Title != "2 anomalies across 2 services" {
+		t.Errorf("Expected the second digest to hold only its interval, got %q", sender.messages[1].Title)
+	}
+}
+
+func TestWebhookSender_PostsText(t *testing.T) {
+	var payload map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected a JSON body, got %q", r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer server.Close()
+
+	sender := NewWebhookSender(server.URL, server.Client())
+	if err := sender.Send(context.Background(), Message{Title: "title", Text: "body"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if payload["text"] != "title\nbody" {
+		t.Errorf("Expected the title and text posted, got %q", payload["text"])
+	}
+}
+
+func TestWebhookSender_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := NewWebhookSender(server.URL, server.Client()).Send(context.Background(), Message{})
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected a 403 error, got %v", err)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	if mode, err := ParseMode(""); err != nil || mode != PerAnomaly {
+		t.Errorf("Expected the per-anomaly default, got %q, %v", mode, err)
+	}
+	if _, err := ParseMode("digest"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,213 @@
+// Package otlp pushes each scan's per-service health metrics and anomaly
+// counts to an OpenTelemetry collector over OTLP/HTTP, for environments
+// that collect metrics by push rather than by scraping.
+package otlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/health"
+	"smanalyzer/pkg/istio"
+)
+
+// Exported metric names.
+const (
+	HealthMetric       = "smanalyzer.service.health"
+	ErrorRateMetric    = "smanalyzer.service.error_rate"
+	LatencyP99Metric   = "smanalyzer.service.latency.p99"
+	AnomalyCountMetric = "smanalyzer.anomalies"
+
+	// AnomalyTypeKey and AnomalySeverityKey are the attributes anomaly
+	// counts are broken down by
+	AnomalyTypeKey     = attribute.Key("smanalyzer.anomaly.type")
+	AnomalySeverityKey = attribute.Key("smanalyzer.anomaly.severity")
+)
+
+// defaultPath is where collectors receive OTLP/HTTP metrics.
+const defaultPath = "/v1/metrics"
+
+var scope = instrumentation.Scope{Name: "smanalyzer"}
+
+// Exporter sends a scan's results to an OTLP endpoint, one resource per
+// service.
+type Exporter struct {
+	exporter sdkmetric.Exporter
+	weights  health.Weights
+}
+
+// NewExporter exports to endpoint, an http or https URL such as
+// http://otel-collector:4318, through client. The path defaults to
+// /v1/metrics.
+func NewExporter(ctx context.Context, endpoint string, client *http.Client) (*Exporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: expected an http or https URL such as http://otel-collector:4318", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = defaultPath
+	}
+
+	exporter, err := otlpmetrichttp.New(ctx,
+		otlpmetrichttp.WithEndpointURL(u.String()),
+		otlpmetrichttp.WithHTTPClient(client),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	return &Exporter{exporter: exporter, weights: health.DefaultWeights()}, nil
+}
+
+// SetHealthWeights overrides the signal weights used for the health score.
+func (e *Exporter) SetHealthWeights(weights health.Weights) {
+	e.weights = weights
+}
+
+// Export pushes a gauge of each service's health score, error rate (in
+// percent) and P99 latency (in seconds), and the number of anomalies
+// detected for it since start by type and severity. Services are mapped to
+// resource attributes: service.name, service.namespace and
+// k8s.namespace.name from the namespace, and k8s.cluster.name.
+func (e *Exporter) Export(ctx context.Context, start time.Time, metrics []*istio.ServiceMeshMetrics, anomalies []anomaly.Anomaly) error {
+	now := time.Now()
+	services := make(map[serviceKey]*metricdata.ScopeMetrics)
+	var keys []serviceKey
+	scopeFor := func(key serviceKey) *metricdata.ScopeMetrics {
+		sm, ok := services[key]
+		if !ok {
+			sm = &metricdata.ScopeMetrics{Scope: scope}
+			services[key] = sm
+			keys = append(keys, key)
+		}
+		return sm
+	}
+
+	for _, m := range metrics {
+		at := m.Timestamp
+		if at.IsZero() {
+			at = now
+		}
+		sm := scopeFor(serviceKey{m.Cluster, m.Namespace, m.ServiceName})
+		sm.Metrics = append(sm.Metrics,
+			gauge(HealthMetric, "Health score from 0 to 100, where 100 is fully healthy", "1", at, health.HealthScore(m, nil, e.weights)),
+			gauge(ErrorRateMetric, "Percentage of requests failing", "%", at, m.Errors.ErrorRate),
+			gauge(LatencyP99Metric, "99th percentile request latency", "s", at, m.Latency.P99.Seconds()),
+		)
+	}
+
+	counts := make(map[serviceKey]map[[2]string]int64)
+	for _, a := range anomalies {
+		key := serviceKey{a.Cluster, a.Namespace, a.ServiceName}
+		if counts[key] == nil {
+			counts[key] = make(map[[2]string]int64)
+		}
+		counts[key][[2]string{string(a.Type), anomaly.SeverityText(a.Severity)}]++
+	}
+	for key, byType := range counts {
+		sm := scopeFor(key)
+		sm.Metrics = append(sm.Metrics, anomalyCounts(byType, start, now))
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	var errs []error
+	for _, key := range keys {
+		rm := &metricdata.ResourceMetrics{
+			Resource:     key.resource(),
+			ScopeMetrics: []metricdata.ScopeMetrics{*services[key]},
+		}
+		if err := e.exporter.Export(ctx, rm); err != nil {
+			errs = append(errs, fmt.Errorf("failed to export metrics for %s: %w", key.ServiceName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown flushes and closes the underlying exporter.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	return e.exporter.Shutdown(ctx)
+}
+
+type serviceKey struct {
+	Cluster, Namespace, ServiceName string
+}
+
+func (k serviceKey) less(o serviceKey) bool {
+	if k.Cluster != o.Cluster {
+		return k.Cluster < o.Cluster
+	}
+	if k.Namespace != o.Namespace {
+		return k.Namespace < o.Namespace
+	}
+	return k.ServiceName < o.ServiceName
+}
+
+func (k serviceKey) resource() *resource.Resource {
+	attrs := []attribute.KeyValue{semconv.ServiceName(k.ServiceName)}
+	if k.Namespace != "" {
+		attrs = append(attrs, semconv.ServiceNamespace(k.Namespace), semconv.K8SNamespaceName(k.Namespace))
+	}
+	if k.Cluster != "" {
+		attrs = append(attrs, semconv.K8SClusterName(k.Cluster))
+	}
+	return resource.NewWithAttributes(semconv.SchemaURL, attrs...)
+}
+
+func gauge(name, description, unit string, at time.Time, value float64) metricdata.Metrics {
+	return metricdata.Metrics{
+		Name:        name,
+		Description: description,
+		Unit:        unit,
+		Data: metricdata.Gauge[float64]{
+			DataPoints: []metricdata.DataPoint[float64]{{Time: at, Value: value}},
+		},
+	}
+}
+
+// anomalyCounts is a delta counter, so each scan's anomalies add to what
+// the backend has seen rather than restarting a cumulative count.
+func anomalyCounts(byType map[[2]string]int64, start, end time.Time) metricdata.Metrics {
+	keys := make([][2]string, 0, len(byType))
+	for typeSeverity := range byType {
+		keys = append(keys, typeSeverity)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	points := make([]metricdata.DataPoint[int64], len(keys))
+	for i, typeSeverity := range keys {
+		points[i] = metricdata.DataPoint[int64]{
+			Attributes: attribute.NewSet(AnomalyTypeKey.String(typeSeverity[0]), AnomalySeverityKey.String(typeSeverity[1])),
+			StartTime:  start,
+			Time:       end,
+			Value:      byType[typeSeverity],
+		}
+	}
+	return metricdata.Metrics{
+		Name:        AnomalyCountMetric,
+		Description: "Anomalies detected by type and severity",
+		Unit:        "{anomaly}",
+		Data: metricdata.Sum[int64]{
+			DataPoints:  points,
+			Temporality: metricdata.DeltaTemporality,
+			IsMonotonic: true,
+		},
+	}
+}
@@ -0,0 +1,179 @@
+package otlp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/istio"
+)
+
+// receiver is an in-process OTLP/HTTP metrics endpoint keeping every
+// request it is sent.
+type receiver struct {
+	mu       sync.Mutex
+	paths    []string
+	requests []*colmetricpb.ExportMetricsServiceRequest
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	export := &colmetricpb.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(body, export); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	r.paths = append(r.paths, req.URL.Path)
+	r.requests = append(r.requests, export)
+	r.mu.Unlock()
+
+	resp, _ := proto.Marshal(&colmetricpb.ExportMetricsServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+// exported maps each exported resource's service.name to its attributes
+// and metrics.
+func (r *receiver) exported() map[string]exportedService {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	services := make(map[string]exportedService)
+	for _, req := range r.requests {
+		for _, rm := range req.ResourceMetrics {
+			svc := exportedService{attributes: make(map[string]string), metrics: make(map[string]*metricpb.Metric)}
+			for _, kv := range rm.Resource.Attributes {
+				svc.attributes[kv.Key] = kv.Value.GetStringValue()
+			}
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					svc.metrics[m.Name] = m
+				}
+			}
+			services[svc.attributes["service.name"]] = svc
+		}
+	}
+	return services
+}
+
+type exportedService struct {
+	attributes map[string]string
+	metrics    map[string]*metricpb.Metric
+}
+
+func (s exportedService) gauge(t *testing.T, name string) float64 {
+	t.Helper()
+	m, ok := s.metrics[name]
+	if !ok || len(m.GetGauge().GetDataPoints()) != 1 {
+		t.Fatalf("Expected one %s data point, got %v", name, m)
+	}
+	return m.GetGauge().DataPoints[0].GetAsDouble()
+}
+
+func newTestExporter(t *testing.T) (*Exporter, *receiver) {
+	t.Helper()
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	t.Cleanup(server.Close)
+
+	exporter, err := NewExporter(context.Background(), server.URL, server.Client())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { exporter.Shutdown(context.Background()) })
+	return exporter, recv
+}
+
+func TestExporter_Export(t *testing.T) {
+	exporter, recv := newTestExporter(t)
+
+	metrics := []*istio.ServiceMeshMetrics{
+		{ServiceName: "reviews", Namespace: "shop", Cluster: "east", Timestamp: time.Now(),
+			Latency: istio.LatencyMetrics{P99: 1200 * time.Millisecond},
+			Errors:  istio.ErrorMetrics{ErrorRate: 12.5}},
+		{ServiceName: "ratings", Namespace: "shop", Timestamp: time.Now(),
+			Latency: istio.LatencyMetrics{P99: 80 * time.Millisecond}},
+	}
+	anomalies := []anomaly.Anomaly{
+		{Type: anomaly.ErrorRateHigh, ServiceName: "reviews", Namespace: "shop", Cluster: "east", Severity: 3.5},
+		{Type: anomaly.ErrorRateHigh, ServiceName: "reviews", Namespace: "shop", Cluster: "east", Severity: 3.1},
+		{Type: anomaly.LatencyAnomaly, ServiceName: "reviews", Namespace: "shop", Cluster: "east", Severity: 2.1},
+	}
+
+	if err := exporter.Export(context.Background(), time.Now().Add(-time.Minute), metrics, anomalies); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if recv.paths[0] != defaultPath {
+		t.Errorf("Expected metrics posted to %s, got %s", defaultPath, recv.paths[0])
+	}
+
+	services := recv.exported()
+	reviews, ratings := services["reviews"], services["ratings"]
+	if reviews.attributes["service.namespace"] != "shop" || reviews.attributes["k8s.namespace.name"] != "shop" || reviews.attributes["k8s.cluster.name"] != "east" {
+		t.Errorf("Expected namespace and cluster resource attributes, got %v", reviews.attributes)
+	}
+	if _, ok := ratings.attributes["k8s.cluster.name"]; ok {
+		t.Errorf("Expected no cluster attribute without a cluster, got %v", ratings.attributes)
+	}
+
+	if v := reviews.gauge(t, ErrorRateMetric); v != 12.5 {
+		t.Errorf("Expected error rate 12.5, got %v", v)
+	}
+	if v := reviews.gauge(t, LatencyP99Metric); v != 1.2 {
+		t.Errorf("Expected P99 of 1.2s, got %v", v)
+	}
+	if v := ratings.gauge(t, HealthMetric); v != 100 {
+		t.Errorf("Expected a healthy score for ratings, got %v", v)
+	}
+
+	counts := reviews.metrics[AnomalyCountMetric].GetSum()
+	if counts.GetAggregationTemporality() != metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA || !counts.GetIsMonotonic() {
+		t.Errorf("Expected a monotonic delta counter, got %v", counts)
+	}
+	got := make(map[[2]string]int64)
+	for _, dp := range counts.GetDataPoints() {
+		var key [2]string
+		for _, kv := range dp.Attributes {
+			switch kv.Key {
+			case string(AnomalyTypeKey):
+				key[0] = kv.Value.GetStringValue()
+			case string(AnomalySeverityKey):
+				key[1] = kv.Value.GetStringValue()
+			}
+		}
+		got[key] = dp.GetAsInt()
+	}
+	want := map[[2]string]int64{
+		{string(anomaly.ErrorRateHigh), "CRITICAL"}: 2,
+		{string(anomaly.LatencyAnomaly), "HIGH"}:    1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected anomaly counts %v, got %v", want, got)
+	}
+	if _, ok := ratings.metrics[AnomalyCountMetric]; ok {
+		t.Error("Expected no anomaly counts for a service without anomalies")
+	}
+}
+
+func TestNewExporter_InvalidEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"otel-collector:4318", "grpc://otel-collector:4317", "http://"} {
+		if _, err := NewExporter(context.Background(), endpoint, http.DefaultClient); err == nil {
+			t.Errorf("Expected an error for endpoint %q", endpoint)
+		}
+	}
+}
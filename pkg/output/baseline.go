@@ -0,0 +1,81 @@
+package output
+
+import (
+	"fmt"
+
+	"smanalyzer/pkg/health"
+	"smanalyzer/pkg/istio"
+)
+
+// baselineWidth is the room a metrics table column gains for a deviation
+// such as " (+120%)".
+const baselineWidth = 9
+
+// SetBaselines annotates the metrics display with each service's deviation
+// from its baseline, keyed by series key. Services without a baseline are
+// shown as is.
+func (f *Formatter) SetBaselines(baselines map[string]health.Baseline) {
+	f.baselines = baselines
+}
+
+func (f *Formatter) baseline(m *istio.ServiceMeshMetrics) (health.Baseline, bool) {
+	baseline, ok := f.baselines[m.SeriesKey()]
+	return baseline, ok
+}
+
+// healthScore scores m against its baseline, when it has one.
+func (f *Formatter) healthScore(m *istio.ServiceMeshMetrics) float64 {
+	baseline, ok := f.baseline(m)
+	if !ok {
+		return health.HealthScore(m, nil, f.healthWeights)
+	}
+	return health.HealthScore(m, &baseline, f.healthWeights)
+}
+
+// compared appends a deviation to a metrics table cell for services with a
+// baseline.
+func (f *Formatter) compared(m *istio.ServiceMeshMetrics, cell string, change func(health.Baseline) string) string {
+	baseline, ok := f.baseline(m)
+	if !ok {
+		return cell
+	}
+	if c := change(baseline); c != "" {
+		return fmt.Sprintf("%s (%s)", cell, c)
+	}
+	return cell
+}
+
+// metricTableColumns is the selected metrics table columns, widened for the
+// deviations when comparing against baselines.
+func (f *Formatter) metricTableColumns() []column {
+	selected := selectColumns(metricColumns, f.columns)
+	if len(f.baselines) == 0 {
+		return selected
+	}
+
+	columns := make([]column, len(selected))
+	copy(columns, selected)
+	for i, c := range columns {
+		switch c.name {
+		case "rps", "error_rate", "p99":
+			columns[i].width += baselineWidth
+		}
+	}
+	return columns
+}
+
+// percentChange formats current relative to baseline, e.g. "+40%". It is
+// empty when there's no baseline value to compare with.
+func percentChange(current, baseline float64) string {
+	if baseline <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%+.0f%%", (current-baseline)/baseline*100)
+}
+
+// pointChange formats the difference between two percentages in
+// percentage points, e.g. "+1.50pp", since a relative change of a rate
+// that is usually near zero says little.
+func pointChange(current, baseline float64) string {
+	return fmt.Sprintf("%+.2fpp", current-baseline)
+}
@@ -0,0 +1,174 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/health"
+	"smanalyzer/pkg/istio"
+)
+
+// column is one table column: its --columns name, header, padded width,
+// and the length cells are truncated to (0 leaves them whole).
+type column struct {
+	name     string
+	header   string
+	width    int
+	truncate int
+}
+
+var anomalyColumns = []column{
+	{"service", "SERVICE", 15, 15},
+	{"namespace", "NAMESPACE", 11, 11},
+	{"type", "TYPE", 16, 16},
+	{"severity", "SEVERITY", 8, 0},
+	{"trend", "TREND", 5, 0},
+	{"description", "DESCRIPTION", 40, 40},
+}
+
+var metricColumns = []column{
+	{"service", "SERVICE", 20, 19},
+	{"namespace", "NAMESPACE", 10, 9},
+	{"health", "HEALTH", 6, 0},
+	{"rps", "RPS", 8, 0},
+	{"error_rate", "ERR%", 8, 0},
+	{"p99", "P99_LAT", 10, 0},
+	{"circuit_breakers", "CIRCUIT", 8, 0},
+	{"retries", "RETRIES", 8, 0},
+	{"timeouts", "TIMEOUTS", 8, 0},
+}
+
+func (f *Formatter) anomalyCell(name string, a anomaly.Anomaly) string {
+	switch name {
+	case "service":
+		return a.ServiceName
+	case "namespace":
+		return a.Namespace
+	case "type":
+		return string(a.Type)
+	case "severity":
+		return f.getSeverityText(a.Severity)
+	case "trend":
+		// A first occurrence has no history to trend against
+		if a.FirstSeen {
+			return "NEW"
+		}
+		return a.Trend.Arrow()
+	case "description":
+		return f.descriptions.Describe(a)
+	}
+	return ""
+}
+
+func (f *Formatter) metricCell(name string, m *istio.ServiceMeshMetrics) string {
+	switch name {
+	case "service":
+		return m.ServiceName
+	case "namespace":
+		return m.Namespace
+	case "health":
+		return fmt.Sprintf("%.0f", f.healthScore(m))
+	case "rps":
+		return f.compared(m, fmt.Sprintf("%.1f", m.Traffic.RequestsPerSecond), func(b health.Baseline) string {
+			return percentChange(m.Traffic.RequestsPerSecond, b.RequestsPerSecond)
+		})
+	case "error_rate":
+		return f.compared(m, fmt.Sprintf("%.2f", m.Errors.ErrorRate), func(b health.Baseline) string {
+			return pointChange(m.Errors.ErrorRate, b.ErrorRate)
+		})
+	case "p99":
+		return f.compared(m, m.Latency.P99.String(), func(b health.Baseline) string {
+			return percentChange(float64(m.Latency.P99), float64(b.LatencyP99))
+		})
+	case "circuit_breakers":
+		return fmt.Sprintf("%d", m.CircuitBreakers)
+	case "retries":
+		return fmt.Sprintf("%d", m.RetryCount)
+	case "timeouts":
+		return fmt.Sprintf("%d", m.TimeoutCount)
+	}
+	return ""
+}
+
+// ValidateColumns reports --columns names that neither table has.
+func ValidateColumns(names []string) error {
+	known := make(map[string]bool)
+	for _, c := range append(append([]column(nil), anomalyColumns...), metricColumns...) {
+		known[c.name] = true
+	}
+
+	for _, name := range names {
+		if !known[name] {
+			valid := make([]string, 0, len(known))
+			for k := range known {
+				valid = append(valid, k)
+			}
+			sort.Strings(valid)
+			return fmt.Errorf("unknown column %q (expected one of %s)", name, strings.Join(valid, ", "))
+		}
+	}
+	return nil
+}
+
+// selectColumns returns the named columns in the order given. Names the
+// table doesn't have are skipped, so one list can serve both tables; if
+// none apply the table keeps its full layout.
+func selectColumns(all []column, names []string) []column {
+	var selected []column
+	for _, name := range names {
+		for _, c := range all {
+			if c.name == name {
+				selected = append(selected, c)
+				break
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return all
+	}
+	return selected
+}
+
+// renderTable writes a header, an underline and a line per row of cells,
+// with columns separated by sep. When padLast is false the last column
+// isn't padded, so there is no trailing whitespace.
+func (f *Formatter) renderTable(columns []column, rows [][]string, sep string, padLast bool) string {
+	var b strings.Builder
+
+	line := func(cells []string) {
+		for i, c := range columns {
+			if i == len(columns)-1 && !padLast {
+				b.WriteString(cells[i])
+				break
+			}
+			fmt.Fprintf(&b, "%-*s", c.width, cells[i])
+			if i < len(columns)-1 {
+				b.WriteString(sep)
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	headers := make([]string, len(columns))
+	rules := make([]string, len(columns))
+	for i, c := range columns {
+		headers[i] = c.header
+		rules[i] = strings.Repeat("-", len(c.header))
+	}
+	line(headers)
+	line(rules)
+
+	for _, row := range rows {
+		cells := make([]string, len(columns))
+		for i, c := range columns {
+			cells[i] = row[i]
+			if c.truncate > 0 {
+				cells[i] = f.truncate(cells[i], c.truncate)
+			}
+		}
+		line(cells)
+	}
+	return b.String()
+}
@@ -3,9 +3,15 @@ package output
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/clock"
+	"smanalyzer/pkg/health"
 	"smanalyzer/pkg/istio"
 )
 
@@ -18,14 +24,50 @@ const (
 )
 
 type Formatter struct {
-	format Format
+	format        Format
+	healthWeights health.Weights
+	descriptions  anomaly.DescriptionTemplates
+	columns       []string
+	clock         clock.Clock
+	// baselines, keyed by series key, that metrics are compared against
+	baselines map[string]health.Baseline
 }
 
 func NewFormatter(format string) *Formatter {
-	return &Formatter{format: Format(format)}
+	descriptions, _ := anomaly.ParseDescriptionTemplates(nil)
+	return &Formatter{
+		format:        Format(format),
+		healthWeights: health.DefaultWeights(),
+		descriptions:  descriptions,
+		clock:         clock.Real{},
+	}
+}
+
+// SetClock replaces the clock used for the time shown above metrics.
+func (f *Formatter) SetClock(c clock.Clock) {
+	f.clock = c
+}
+
+// SetHealthWeights overrides the signal weights used for the health score column.
+func (f *Formatter) SetHealthWeights(weights health.Weights) {
+	f.healthWeights = weights
+}
+
+// SetDescriptionTemplates overrides how anomaly descriptions are worded.
+func (f *Formatter) SetDescriptionTemplates(templates anomaly.DescriptionTemplates) {
+	f.descriptions = templates
+}
+
+// SetColumns limits the table format to the named columns, in that order.
+// Names a table doesn't have are ignored; see ValidateColumns.
+func (f *Formatter) SetColumns(columns []string) {
+	f.columns = columns
 }
 
+// FormatAnomalies renders anomalies in the formatter's format, most severe
+// first; see sortAnomalies.
 func (f *Formatter) FormatAnomalies(anomalies []anomaly.Anomaly) string {
+	anomalies = sortAnomalies(anomalies)
 	switch f.format {
 	case JSON:
 		return f.formatJSON(anomalies)
@@ -36,6 +78,33 @@ func (f *Formatter) FormatAnomalies(anomalies []anomaly.Anomaly) string {
 	}
 }
 
+// sortAnomalies returns a copy of anomalies ordered by severity, highest
+// first, then service, namespace, cluster, type and time, so identical scans
+// print identically whatever order detection produced them in.
+func sortAnomalies(anomalies []anomaly.Anomaly) []anomaly.Anomaly {
+	sorted := append([]anomaly.Anomaly(nil), anomalies...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Severity != b.Severity {
+			return a.Severity > b.Severity
+		}
+		if a.ServiceName != b.ServiceName {
+			return a.ServiceName < b.ServiceName
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Timestamp.Before(b.Timestamp)
+	})
+	return sorted
+}
+
 func (f *Formatter) formatText(anomalies []anomaly.Anomaly) string {
 	if len(anomalies) == 0 {
 		return "No anomalies detected.\n"
@@ -46,15 +115,28 @@ func (f *Formatter) formatText(anomalies []anomaly.Anomaly) string {
 
 	for i, anom := range anomalies {
 		severity := f.getSeverityText(anom.Severity)
-		output.WriteString(fmt.Sprintf("%d. %s [%s]\n", i+1, anom.Description, severity))
+		marker := ""
+		if anom.FirstSeen {
+			marker = newMarker + " "
+		}
+		output.WriteString(fmt.Sprintf("%d. %s%s [%s]\n", i+1, marker, f.descriptions.Describe(anom), severity))
 		output.WriteString(fmt.Sprintf("   Service: %s.%s\n", anom.ServiceName, anom.Namespace))
+		if anom.Cluster != "" {
+			output.WriteString(fmt.Sprintf("   Cluster: %s\n", anom.Cluster))
+		}
 		output.WriteString(fmt.Sprintf("   Type: %s\n", anom.Type))
+		if source, destination := anom.Labels[anomaly.EdgeSourceLabel], anom.Labels[anomaly.EdgeDestinationLabel]; source != "" && destination != "" {
+			output.WriteString(fmt.Sprintf("   Upstream: %s → %s\n", source, destination))
+		}
+		if anom.Trend != "" {
+			output.WriteString(fmt.Sprintf("   Trend: %s %s\n", anom.Trend.Arrow(), anom.Trend))
+		}
 		output.WriteString(fmt.Sprintf("   Time: %s\n", anom.Timestamp.Format(time.RFC3339)))
 		
 		if len(anom.Metrics) > 0 {
 			output.WriteString("   Metrics:\n")
-			for key, value := range anom.Metrics {
-				output.WriteString(fmt.Sprintf("     %s: %.2f\n", key, value))
+			for _, key := range sortedKeys(anom.Metrics) {
+				output.WriteString(fmt.Sprintf("     %s: %.2f\n", key, anom.Metrics[key]))
 			}
 		}
 		output.WriteString("\n")
@@ -63,43 +145,78 @@ func (f *Formatter) formatText(anomalies []anomaly.Anomaly) string {
 	return output.String()
 }
 
+// formatQuantiles renders latency quantiles from the lowest up, e.g.
+// "P99.9=1.2s P99.99=3s".
+func formatQuantiles(quantiles map[string]time.Duration) string {
+	names := make([]string, 0, len(quantiles))
+	for name := range quantiles {
+		names = append(names, name)
+	}
+	percent := func(name string) float64 {
+		p, _ := strconv.ParseFloat(strings.TrimPrefix(name, "p"), 64)
+		return p
+	}
+	sort.Slice(names, func(i, j int) bool { return percent(names[i]) < percent(names[j]) })
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%v", strings.ToUpper(name), quantiles[name])
+	}
+	return strings.Join(parts, " ")
+}
+
+// sortedKeys returns the keys of m in order, so maps print the same way on
+// every run.
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (f *Formatter) formatTable(anomalies []anomaly.Anomaly) string {
 	if len(anomalies) == 0 {
 		return "No anomalies detected.\n"
 	}
 
-	var output strings.Builder
-	
-	output.WriteString("SERVICE          NAMESPACE    TYPE              SEVERITY  DESCRIPTION\n")
-	output.WriteString("-------          ---------    ----              --------  -----------\n")
-
-	for _, anom := range anomalies {
-		service := f.truncate(anom.ServiceName, 15)
-		namespace := f.truncate(anom.Namespace, 11)
-		anomType := f.truncate(string(anom.Type), 16)
-		severity := f.getSeverityText(anom.Severity)
-		description := f.truncate(anom.Description, 40)
-
-		output.WriteString(fmt.Sprintf("%-15s  %-11s  %-16s  %-8s  %s\n", 
-			service, namespace, anomType, severity, description))
+	columns := selectColumns(anomalyColumns, f.columns)
+	rows := make([][]string, len(anomalies))
+	for i, anom := range anomalies {
+		for _, c := range columns {
+			rows[i] = append(rows[i], f.anomalyCell(c.name, anom))
+		}
 	}
 
-	return output.String()
+	return f.renderTable(columns, rows, "  ", false)
 }
 
 func (f *Formatter) formatJSON(anomalies []anomaly.Anomaly) string {
-	return fmt.Sprintf("%+v\n", anomalies)
+	data, err := json.MarshalIndent(f.describe(anomalies), "", "  ")
+	if err != nil {
+		return fmt.Sprintf("failed to marshal anomalies: %v\n", err)
+	}
+	return string(data) + "\n"
 }
 
-func (f *Formatter) getSeverityText(severity float64) string {
-	if severity >= 3.0 {
-		return "CRITICAL"
-	} else if severity >= 2.0 {
-		return "HIGH"
-	} else if severity >= 1.5 {
-		return "MEDIUM"
+// describe returns copies of anomalies with their descriptions filled in.
+func (f *Formatter) describe(anomalies []anomaly.Anomaly) []anomaly.Anomaly {
+	// An empty scan still marshals as [] rather than null
+	described := make([]anomaly.Anomaly, 0, len(anomalies))
+	for _, anom := range anomalies {
+		anom.Description = f.descriptions.Describe(anom)
+		described = append(described, anom)
 	}
-	return "LOW"
+	return described
+}
+
+// newMarker flags an anomaly type appearing for a service for the first
+// time, so it stands out from ongoing ones.
+const newMarker = "[NEW]"
+
+func (f *Formatter) getSeverityText(severity float64) string {
+	return anomaly.SeverityText(severity)
 }
 
 func (f *Formatter) truncate(s string, maxLen int) string {
@@ -110,77 +227,97 @@ func (f *Formatter) truncate(s string, maxLen int) string {
 }
 
 func (f *Formatter) DisplayMetrics(metrics []*istio.ServiceMeshMetrics) error {
+	return f.WriteMetrics(os.Stdout, metrics)
+}
+
+// WriteMetrics renders the collected metrics to w in the formatter's format.
+func (f *Formatter) WriteMetrics(w io.Writer, metrics []*istio.ServiceMeshMetrics) error {
 	switch f.format {
 	case JSON:
-		return f.displayMetricsJSON(metrics)
+		return f.writeMetricsJSON(w, metrics)
 	case Table:
-		return f.displayMetricsTable(metrics)
+		return f.writeMetricsTable(w, metrics)
 	default:
-		return f.displayMetricsText(metrics)
+		return f.writeMetricsText(w, metrics)
 	}
 }
 
-func (f *Formatter) displayMetricsText(metrics []*istio.ServiceMeshMetrics) error {
+func (f *Formatter) writeMetricsText(w io.Writer, metrics []*istio.ServiceMeshMetrics) error {
 	if len(metrics) == 0 {
-		fmt.Printf("[%s] No services found\n", time.Now().Format("15:04:05"))
+		fmt.Fprintf(w, "[%s] No services found\n", f.clock.Now().Format("15:04:05"))
 		return nil
 	}
 
-	fmt.Printf("[%s] Service Mesh Metrics:\n\n", time.Now().Format("15:04:05"))
-	
+	fmt.Fprintf(w, "[%s] Service Mesh Metrics:\n\n", f.clock.Now().Format("15:04:05"))
+
 	for _, m := range metrics {
-		fmt.Printf("Service: %s.%s\n", m.ServiceName, m.Namespace)
-		fmt.Printf("  Traffic: %d requests (%5.1f RPS)\n", m.Traffic.TotalRequests, m.Traffic.RequestsPerSecond)
-		fmt.Printf("  Latency: P50=%v P99=%v\n", m.Latency.P50, m.Latency.P99)
-		fmt.Printf("  Errors: %.2f%% (%d/4xx, %d/5xx)\n", m.Errors.ErrorRate, m.Errors.Errors4xx, m.Errors.Errors5xx)
-		fmt.Printf("  Saturation: CPU=%.1f%% Memory=%.1f%% Connections=%d\n", m.Saturation.CPUUsage, m.Saturation.MemoryUsage, m.Saturation.Connections)
-		fmt.Printf("  Circuit Breakers: %d, Retries: %d, Timeouts: %d\n", m.CircuitBreakers, m.RetryCount, m.TimeoutCount)
+		baseline, compared := f.baseline(m)
+		vs := func(change string) string {
+			if !compared || change == "" {
+				return ""
+			}
+			return fmt.Sprintf(" (%s vs baseline)", change)
+		}
+
+		fmt.Fprintf(w, "Service: %s.%s\n", m.ServiceName, m.Namespace)
+		if m.Cluster != "" {
+			fmt.Fprintf(w, "  Cluster: %s\n", m.Cluster)
+		}
+		fmt.Fprintf(w, "  Health: %.0f/100\n", f.healthScore(m))
+		fmt.Fprintf(w, "  Traffic: %d requests (%5.1f RPS)%s\n", m.Traffic.TotalRequests, m.Traffic.RequestsPerSecond,
+			vs(percentChange(m.Traffic.RequestsPerSecond, baseline.RequestsPerSecond)))
+		fmt.Fprintf(w, "  Latency: P50=%v P99=%v%s\n", m.Latency.P50, m.Latency.P99,
+			vs(percentChange(float64(m.Latency.P99), float64(baseline.LatencyP99))))
+		if len(m.Latency.Quantiles) > 0 {
+			fmt.Fprintf(w, "  Quantiles: %s\n", formatQuantiles(m.Latency.Quantiles))
+		}
+		fmt.Fprintf(w, "  Errors: %.2f%%%s (%d/4xx, %d/5xx)\n", m.Errors.ErrorRate,
+			vs(pointChange(m.Errors.ErrorRate, baseline.ErrorRate)), m.Errors.Errors4xx, m.Errors.Errors5xx)
+		fmt.Fprintf(w, "  Saturation: CPU=%.1f%% Memory=%.1f%% Connections=%d In-flight=%d\n", m.Saturation.CPUUsage, m.Saturation.MemoryUsage, m.Saturation.Connections, m.Saturation.PendingReqs)
+		fmt.Fprintf(w, "  Circuit Breakers: %d, Retries: %d, Timeouts: %d\n", m.CircuitBreakers, m.RetryCount, m.TimeoutCount)
 		if len(m.Traces) > 0 {
-			fmt.Printf("  Traces: %d spans collected\n", len(m.Traces))
+			fmt.Fprintf(w, "  Traces: %d spans collected\n", len(m.Traces))
 		}
 		if len(m.AccessLogs) > 0 {
-			fmt.Printf("  Access Logs: %d entries\n", len(m.AccessLogs))
+			fmt.Fprintf(w, "  Access Logs: %d entries\n", len(m.AccessLogs))
 		}
-		fmt.Println()
+		fmt.Fprintln(w)
 	}
-	
+
 	return nil
 }
 
-func (f *Formatter) displayMetricsTable(metrics []*istio.ServiceMeshMetrics) error {
+func (f *Formatter) writeMetricsTable(w io.Writer, metrics []*istio.ServiceMeshMetrics) error {
 	if len(metrics) == 0 {
-		fmt.Printf("[%s] No services found\n", time.Now().Format("15:04:05"))
+		fmt.Fprintf(w, "[%s] No services found\n", f.clock.Now().Format("15:04:05"))
 		return nil
 	}
 
-	fmt.Printf("[%s] Service Mesh Metrics:\n\n", time.Now().Format("15:04:05"))
-	fmt.Printf("%-20s %-10s %-8s %-8s %-10s %-8s %-8s %-8s\n", 
-		"SERVICE", "NAMESPACE", "RPS", "ERR%", "P99_LAT", "CIRCUIT", "RETRIES", "TIMEOUTS")
-	fmt.Printf("%-20s %-10s %-8s %-8s %-10s %-8s %-8s %-8s\n", 
-		"-------", "---------", "----", "----", "-------", "-------", "-------", "--------")
-	
-	for _, m := range metrics {
-		service := f.truncate(m.ServiceName, 19)
-		namespace := f.truncate(m.Namespace, 9)
-		
-		fmt.Printf("%-20s %-10s %-8.1f %-8.2f %-10v %-8d %-8d %-8d\n",
-			service, namespace, m.Traffic.RequestsPerSecond, m.Errors.ErrorRate, 
-			m.Latency.P99, m.CircuitBreakers, m.RetryCount, m.TimeoutCount)
-	}
-	fmt.Println()
-	
+	fmt.Fprintf(w, "[%s] Service Mesh Metrics:\n\n", f.clock.Now().Format("15:04:05"))
+	fmt.Fprint(w, f.formatMetricsTable(metrics))
+	fmt.Fprintln(w)
+
 	return nil
 }
 
-func (f *Formatter) displayMetricsJSON(metrics []*istio.ServiceMeshMetrics) error {
+func (f *Formatter) formatMetricsTable(metrics []*istio.ServiceMeshMetrics) string {
+	columns := f.metricTableColumns()
+	rows := make([][]string, len(metrics))
+	for i, m := range metrics {
+		for _, c := range columns {
+			rows[i] = append(rows[i], f.metricCell(c.name, m))
+		}
+	}
+
+	return f.renderTable(columns, rows, " ", true)
+}
+
+func (f *Formatter) writeMetricsJSON(w io.Writer, metrics []*istio.ServiceMeshMetrics) error {
 	data, err := json.MarshalIndent(metrics, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal metrics: %w", err)
 	}
-	
-	fmt.Printf("[%s] Service Mesh Metrics (JSON):\n", time.Now().Format("15:04:05"))
-	fmt.Println(string(data))
-	fmt.Println()
-	
+
+	fmt.Fprintln(w, string(data))
 	return nil
-}
\ No newline at end of file
+}
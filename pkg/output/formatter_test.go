@@ -0,0 +1,246 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/health"
+	"smanalyzer/pkg/istio"
+)
+
+func tableAnomalies() []anomaly.Anomaly {
+	return []anomaly.Anomaly{{
+		Type:        anomaly.ErrorRateHigh,
+		ServiceName: "reviews",
+		Namespace:   "shop",
+		Severity:    2.5,
+		Description: "High error rate: 12.00%",
+		Trend:       anomaly.TrendWorsening,
+	}}
+}
+
+func tableMetrics() []*istio.ServiceMeshMetrics {
+	return []*istio.ServiceMeshMetrics{{
+		ServiceName: "reviews",
+		Namespace:   "shop",
+		Traffic:     istio.TrafficMetrics{RequestsPerSecond: 12.5},
+		Errors:      istio.ErrorMetrics{ErrorRate: 3.2},
+		Latency:     istio.LatencyMetrics{P99: 250 * time.Millisecond},
+		RetryCount:  4,
+	}}
+}
+
+func TestFormatTable_DefaultLayout(t *testing.T) {
+	f := NewFormatter("table")
+	a := tableAnomalies()[0]
+
+	expected := "SERVICE          NAMESPACE    TYPE              SEVERITY  TREND  DESCRIPTION\n" +
+		"-------          ---------    ----              --------  -----  -----------\n" +
+		fmt.Sprintf("%-15s  %-11s  %-16s  %-8s  %-5s  %s\n", "reviews", "shop", "error_rate_high", "HIGH", a.Trend.Arrow(), f.descriptions.Describe(a))
+	if got := f.FormatAnomalies(tableAnomalies()); got != expected {
+		t.Errorf("Expected the default layout:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestFormatTable_SelectedColumnsInOrder(t *testing.T) {
+	f := NewFormatter("table")
+	f.SetColumns([]string{"severity", "service"})
+
+	lines := strings.Split(strings.TrimRight(f.FormatAnomalies(tableAnomalies()), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected header, rule and one row, got %q", lines)
+	}
+	if fields := strings.Fields(lines[0]); strings.Join(fields, ",") != "SEVERITY,SERVICE" {
+		t.Errorf("Expected SEVERITY then SERVICE, got %v", fields)
+	}
+	if fields := strings.Fields(lines[2]); strings.Join(fields, ",") != "HIGH,reviews" {
+		t.Errorf("Expected HIGH then reviews, got %v", fields)
+	}
+}
+
+func TestFormatMetricsTable_SelectedColumnsInOrder(t *testing.T) {
+	f := NewFormatter("table")
+	f.SetColumns([]string{"service", "error_rate", "p99"})
+
+	lines := strings.Split(strings.TrimRight(f.formatMetricsTable(tableMetrics()), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected header, rule and one row, got %q", lines)
+	}
+	if fields := strings.Fields(lines[0]); strings.Join(fields, ",") != "SERVICE,ERR%,P99_LAT" {
+		t.Errorf("Expected SERVICE, ERR%% and P99_LAT only, got %v", fields)
+	}
+	if fields := strings.Fields(lines[2]); strings.Join(fields, ",") != "reviews,3.20,250ms" {
+		t.Errorf("Expected reviews, 3.20 and 250ms only, got %v", fields)
+	}
+
+	// The anomaly table has no error_rate or p99 column, so it keeps what applies
+	header := strings.Fields(strings.SplitN(f.FormatAnomalies(tableAnomalies()), "\n", 2)[0])
+	if strings.Join(header, ",") != "SERVICE" {
+		t.Errorf("Expected only SERVICE in the anomaly table, got %v", header)
+	}
+}
+
+func TestValidateColumns(t *testing.T) {
+	if err := ValidateColumns([]string{"service", "p99", "description"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := ValidateColumns([]string{"service", "latency"}); err == nil || !strings.Contains(err.Error(), "latency") {
+		t.Errorf("Expected an unknown column error, got %v", err)
+	}
+}
+
+func TestFormatAnomalies_FirstSeenMarker(t *testing.T) {
+	anomalies := tableAnomalies()
+	anomalies[0].FirstSeen = true
+
+	text := NewFormatter("text").FormatAnomalies(anomalies)
+	if !strings.Contains(text, "1. [NEW] High error rate") {
+		t.Errorf("Expected the NEW marker on the first occurrence, got:\n%s", text)
+	}
+
+	table := NewFormatter("table")
+	table.SetColumns([]string{"service", "trend"})
+	if fields := strings.Fields(strings.Split(table.FormatAnomalies(anomalies), "\n")[2]); strings.Join(fields, ",") != "reviews,NEW" {
+		t.Errorf("Expected NEW in the trend column, got %v", fields)
+	}
+
+	anomalies[0].FirstSeen = false
+	if text := NewFormatter("text").FormatAnomalies(anomalies); strings.Contains(text, "[NEW]") {
+		t.Errorf("Expected no marker on an ongoing anomaly, got:\n%s", text)
+	}
+}
+
+func TestFormatAnomalies_StableOrder(t *testing.T) {
+	at := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	anomalies := []anomaly.Anomaly{
+		{Type: anomaly.RetryStorm, ServiceName: "reviews", Severity: 1.2, Timestamp: at},
+		{Type: anomaly.ErrorRateHigh, ServiceName: "ratings", Severity: 3.5, Timestamp: at},
+		{Type: anomaly.TrafficSpike, ServiceName: "reviews", Severity: 1.2, Timestamp: at},
+		{Type: anomaly.TimeoutAnomaly, ServiceName: "cart", Severity: 1.2, Timestamp: at},
+		{Type: anomaly.ErrorRateHigh, ServiceName: "reviews", Severity: 2.0, Timestamp: at},
+	}
+	expected := []string{
+		"ratings/error_rate_high", "reviews/error_rate_high", "cart/timeout_anomaly",
+		"reviews/retry_storm", "reviews/traffic_spike",
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	var first string
+	for run := 0; run < 20; run++ {
+		shuffled := append([]anomaly.Anomaly(nil), anomalies...)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+		var decoded []anomaly.Anomaly
+		formatted := NewFormatter("json").FormatAnomalies(shuffled)
+		if err := json.Unmarshal([]byte(formatted), &decoded); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var order []string
+		for _, a := range decoded {
+			order = append(order, a.ServiceName+"/"+string(a.Type))
+		}
+		if strings.Join(order, " ") != strings.Join(expected, " ") {
+			t.Fatalf("Expected %v on run %d, got %v", expected, run, order)
+		}
+
+		text := NewFormatter("text").FormatAnomalies(shuffled)
+		if run == 0 {
+			first = text
+		} else if text != first {
+			t.Fatalf("Expected identical text output on run %d, got\n%s\nthen\n%s", run, first, text)
+		}
+	}
+}
+
+func TestFormatText_SortedMetrics(t *testing.T) {
+	a := anomaly.Anomaly{
+		Type:        anomaly.ErrorRateHigh,
+		ServiceName: "reviews",
+		Severity:    2,
+		Metrics: map[string]float64{
+			"upstream_error_rate": 0.2,
+			"error_rate":          0.1,
+			"request_count":       500,
+			"edge_error_rate":     0.3,
+		},
+	}
+	expected := "   Metrics:\n" +
+		"     edge_error_rate: 0.30\n" +
+		"     error_rate: 0.10\n" +
+		"     request_count: 500.00\n" +
+		"     upstream_error_rate: 0.20\n"
+
+	for run := 0; run < 20; run++ {
+		if text := NewFormatter("text").FormatAnomalies([]anomaly.Anomaly{a}); !strings.Contains(text, expected) {
+			t.Fatalf("Expected metrics sorted by name on run %d, got\n%s", run, text)
+		}
+	}
+}
+
+func TestFormatJSON_NoAnomalies(t *testing.T) {
+	for _, anomalies := range [][]anomaly.Anomaly{nil, {}} {
+		got := NewFormatter("json").FormatAnomalies(anomalies)
+		if got != "[]\n" {
+			t.Errorf("Expected an empty JSON array, got %q", got)
+		}
+
+		var decoded []anomaly.Anomaly
+		if err := json.Unmarshal([]byte(got), &decoded); err != nil || decoded == nil {
+			t.Errorf("Expected output that decodes to an empty list, got %v (err %v)", decoded, err)
+		}
+	}
+}
+
+func TestWriteMetrics_ComparedToBaseline(t *testing.T) {
+	metrics := tableMetrics()
+	metrics[0].Latency.P99 = 140 * time.Millisecond
+	baselines := map[string]health.Baseline{
+		"shop/reviews": {LatencyP99: 100 * time.Millisecond, RequestsPerSecond: 10, ErrorRate: 1.2},
+	}
+
+	text := NewFormatter("text")
+	text.SetBaselines(baselines)
+	var out bytes.Buffer
+	if err := text.WriteMetrics(&out, metrics); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, expected := range []string{
+		"P99=140ms (+40% vs baseline)",
+		"( 12.5 RPS) (+25% vs baseline)",
+		"Errors: 3.20% (+2.00pp vs baseline)",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, out.String())
+		}
+	}
+
+	table := NewFormatter("table")
+	table.SetColumns([]string{"service", "p99", "error_rate"})
+	table.SetBaselines(baselines)
+	lines := strings.Split(strings.TrimRight(table.formatMetricsTable(metrics), "\n"), "\n")
+	if row := strings.Join(strings.Fields(lines[2]), " "); row != "reviews 140ms (+40%) 3.20 (+2.00pp)" {
+		t.Errorf("Expected deviations in the table cells, got %q", row)
+	}
+
+	// The health score is rated against the baseline too
+	baseline := baselines["shop/reviews"]
+	scored := fmt.Sprintf("Health: %.0f/100", health.HealthScore(metrics[0], &baseline, health.DefaultWeights()))
+	if scored == fmt.Sprintf("Health: %.0f/100", health.HealthScore(metrics[0], nil, health.DefaultWeights())) {
+		t.Fatalf("Expected the baseline to change the health score")
+	}
+	if !strings.Contains(out.String(), scored) {
+		t.Errorf("Expected %q in:\n%s", scored, out.String())
+	}
+
+	// Services without a baseline aren't annotated
+	table.SetBaselines(map[string]health.Baseline{"shop/ratings": {LatencyP99: time.Second}})
+	if strings.Contains(table.formatMetricsTable(metrics), "(") {
+		t.Errorf("Expected no deviation without a baseline, got:\n%s", table.formatMetricsTable(metrics))
+	}
+}
@@ -0,0 +1,52 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"smanalyzer/pkg/istio"
+)
+
+// FormatPlan renders what a dry run found: each service with the pods its
+// collection would try and how each would be scraped.
+func (f *Formatter) FormatPlan(plan []istio.PlannedService) string {
+	if f.format == JSON {
+		data, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return fmt.Sprintf("failed to marshal plan: %v\n", err)
+		}
+		return string(data) + "\n"
+	}
+
+	pods, scraped := 0, 0
+	namespaces := make(map[string]bool)
+	for _, service := range plan {
+		namespaces[service.Cluster+"/"+service.Namespace] = true
+		pods += len(service.Pods)
+		for _, pod := range service.Pods {
+			if !pod.Fallback {
+				scraped++
+			}
+		}
+	}
+
+	var output strings.Builder
+	fmt.Fprintf(&output, "Dry run: %d services in %d namespaces, %d pods to scrape (%d more as fallbacks). Nothing was collected.\n\n",
+		len(plan), len(namespaces), scraped, pods-scraped)
+	for _, service := range plan {
+		fmt.Fprintf(&output, "%s.%s", service.Name, service.Namespace)
+		if service.Cluster != "" {
+			fmt.Fprintf(&output, " in cluster %s", service.Cluster)
+		}
+		fmt.Fprintf(&output, ": %d pods\n", len(service.Pods))
+		for _, pod := range service.Pods {
+			fallback := ""
+			if pod.Fallback {
+				fallback = " (fallback)"
+			}
+			fmt.Fprintf(&output, "  %s: %s%s\n", pod.Pod, pod.Method, fallback)
+		}
+	}
+	return output.String()
+}
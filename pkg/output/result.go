@@ -0,0 +1,40 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/health"
+	"smanalyzer/pkg/istio"
+)
+
+// ScanResult is everything a scan reports. JSON writes it as a single
+// object, so stdout stays one parseable document whichever sections a scan
+// asked for.
+type ScanResult struct {
+	// Metrics of the shown services, when the scan compares them against
+	// baselines or shows the changed ones
+	Metrics []*istio.ServiceMeshMetrics `json:"metrics,omitempty"`
+	// Comparisons before and after each service's rollout, with
+	// --compare-window
+	Comparisons []health.WindowComparison `json:"comparisons,omitempty"`
+	// Incidents the failing services were grouped into by root cause
+	Incidents []anomaly.Incident `json:"incidents,omitempty"`
+	Anomalies []anomaly.Anomaly  `json:"anomalies"`
+	// Omitted counts the services --top left out
+	Omitted int `json:"omitted"`
+}
+
+// WriteJSON writes result to w as one JSON object, with the anomalies most
+// severe first and described as FormatAnomalies describes them.
+func (f *Formatter) WriteJSON(w io.Writer, result ScanResult) error {
+	result.Anomalies = f.describe(sortAnomalies(result.Anomalies))
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal scan result: %w", err)
+	}
+	fmt.Fprintln(w, string(data))
+	return nil
+}
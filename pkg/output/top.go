@@ -0,0 +1,184 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/istio"
+)
+
+// TopServices is what remains of a scan's results after keeping only the
+// worst services.
+type TopServices struct {
+	// Metrics of the kept services, worst first
+	Metrics []*istio.ServiceMeshMetrics
+	// Anomalies of the kept services, in their original order
+	Anomalies []anomaly.Anomaly
+	// Omitted counts the services left out that had anomalies; healthy
+	// ones left out aren't worth a mention
+	Omitted int
+}
+
+// TopOrder is what Top ranks services by first.
+type TopOrder string
+
+const (
+	// ByHealth ranks by anomaly count, then the highest anomaly severity,
+	// error rate and P99 latency
+	ByHealth TopOrder = "health"
+	// ByErrorRate, ByLatency and ByTraffic rank by error rate, P99
+	// latency or requests per second, with ties broken as by ByHealth
+	ByErrorRate TopOrder = "error-rate"
+	ByLatency   TopOrder = "p99"
+	ByTraffic   TopOrder = "rps"
+)
+
+// ParseTopOrder returns the ranking named health, error-rate, p99 or rps.
+func ParseTopOrder(name string) (TopOrder, error) {
+	switch order := TopOrder(name); order {
+	case "":
+		return ByHealth, nil
+	case ByHealth, ByErrorRate, ByLatency, ByTraffic:
+		return order, nil
+	}
+	return "", fmt.Errorf("invalid ranking %q: expected health, error-rate, p99 or rps", name)
+}
+
+// serviceBadness is what services are ranked by for Top, compared field by
+// field.
+type serviceBadness struct {
+	key       string
+	anomalies int
+	severity  float64
+	errorRate float64
+	p99       time.Duration
+	rps       float64
+}
+
+func (a serviceBadness) worseThan(b serviceBadness, order TopOrder) bool {
+	switch {
+	case order == ByErrorRate && a.errorRate != b.errorRate:
+		return a.errorRate > b.errorRate
+	case order == ByLatency && a.p99 != b.p99:
+		return a.p99 > b.p99
+	case order == ByTraffic && a.rps != b.rps:
+		return a.rps > b.rps
+	}
+	if a.anomalies != b.anomalies {
+		return a.anomalies > b.anomalies
+	}
+	if a.severity != b.severity {
+		return a.severity > b.severity
+	}
+	if a.errorRate != b.errorRate {
+		return a.errorRate > b.errorRate
+	}
+	if a.p99 != b.p99 {
+		return a.p99 > b.p99
+	}
+	return a.key < b.key
+}
+
+// Top keeps the n unhealthiest services: those with the most anomalies,
+// then the highest anomaly severity, error rate and P99 latency. Services
+// are identified by series key, so anomalies without collected metrics still
+// count. n <= 0 keeps everything.
+func Top(n int, metrics []*istio.ServiceMeshMetrics, anomalies []anomaly.Anomaly) TopServices {
+	return TopBy(n, ByHealth, metrics, anomalies)
+}
+
+// TopBy keeps the n services ranked first by order, worst (or busiest)
+// first.
+func TopBy(n int, order TopOrder, metrics []*istio.ServiceMeshMetrics, anomalies []anomaly.Anomaly) TopServices {
+	if n <= 0 {
+		return TopServices{Metrics: metrics, Anomalies: anomalies}
+	}
+
+	services := make(map[string]*serviceBadness)
+	service := func(key string) *serviceBadness {
+		if services[key] == nil {
+			services[key] = &serviceBadness{key: key}
+		}
+		return services[key]
+	}
+	for _, m := range metrics {
+		s := service(m.SeriesKey())
+		s.errorRate = m.Errors.ErrorRate
+		s.p99 = m.Latency.P99
+		s.rps = m.Traffic.RequestsPerSecond
+	}
+	for _, a := range anomalies {
+		s := service(anomalySeriesKey(a))
+		s.anomalies++
+		s.severity = max(s.severity, a.Severity)
+	}
+
+	ranked := make([]serviceBadness, 0, len(services))
+	for _, s := range services {
+		ranked = append(ranked, *s)
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].worseThan(ranked[j], order) })
+
+	if len(ranked) <= n {
+		n = len(ranked)
+	}
+	rank := make(map[string]int, n)
+	for i, s := range ranked[:n] {
+		rank[s.key] = i
+	}
+
+	var top TopServices
+	for _, s := range ranked[n:] {
+		if s.anomalies > 0 {
+			top.Omitted++
+		}
+	}
+	for _, m := range metrics {
+		if _, kept := rank[m.SeriesKey()]; kept {
+			top.Metrics = append(top.Metrics, m)
+		}
+	}
+	sort.SliceStable(top.Metrics, func(i, j int) bool {
+		return rank[top.Metrics[i].SeriesKey()] < rank[top.Metrics[j].SeriesKey()]
+	})
+	for _, a := range anomalies {
+		if _, kept := rank[anomalySeriesKey(a)]; kept {
+			top.Anomalies = append(top.Anomalies, a)
+		}
+	}
+	return top
+}
+
+func anomalySeriesKey(a anomaly.Anomaly) string {
+	m := istio.ServiceMeshMetrics{ServiceName: a.ServiceName, Namespace: a.Namespace, Cluster: a.Cluster}
+	return m.SeriesKey()
+}
+
+// FormatIncidents lists the root causes failing services were traced to.
+// Like FormatOmitted it is empty for JSON, where they are part of the
+// ScanResult instead.
+func (f *Formatter) FormatIncidents(incidents []anomaly.Incident) string {
+	if len(incidents) == 0 || f.format == JSON {
+		return ""
+	}
+
+	var output strings.Builder
+	output.WriteString("Likely root causes:\n")
+	for _, incident := range incidents {
+		fmt.Fprintf(&output, "  %s, taking down %s\n", incident.RootCause, strings.Join(incident.Collateral, ", "))
+	}
+	return output.String() + "\n"
+}
+
+// FormatOmitted is the footer noting how many services with anomalies Top
+// left out. It is empty when none were, and for JSON so the output stays
+// parseable.
+func (f *Formatter) FormatOmitted(omitted int) string {
+	if omitted == 0 || f.format == JSON {
+		return ""
+	}
+	return fmt.Sprintf("\n%d more services with anomalies omitted\n", omitted)
+}
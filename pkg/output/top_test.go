@@ -0,0 +1,161 @@
+package output
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/istio"
+)
+
+func topMetrics() []*istio.ServiceMeshMetrics {
+	service := func(name string, errorRate float64, p99 time.Duration) *istio.ServiceMeshMetrics {
+		return &istio.ServiceMeshMetrics{
+			ServiceName: name,
+			Namespace:   "shop",
+			Errors:      istio.ErrorMetrics{ErrorRate: errorRate},
+			Latency:     istio.LatencyMetrics{P99: p99},
+		}
+	}
+	return []*istio.ServiceMeshMetrics{
+		service("cart", 0.1, 20*time.Millisecond),
+		service("reviews", 4, 300*time.Millisecond),
+		service("ratings", 9, 80*time.Millisecond),
+		service("catalog", 0.1, 900*time.Millisecond),
+		service("search", 2, 50*time.Millisecond),
+	}
+}
+
+func topAnomalies() []anomaly.Anomaly {
+	return []anomaly.Anomaly{
+		{Type: anomaly.ErrorRateHigh, ServiceName: "reviews", Namespace: "shop", Severity: 1.5},
+		{Type: anomaly.LatencyAnomaly, ServiceName: "reviews", Namespace: "shop", Severity: 1.2},
+		{Type: anomaly.ErrorRateHigh, ServiceName: "ratings", Namespace: "shop", Severity: 2.5},
+		{Type: anomaly.RetryStorm, ServiceName: "search", Namespace: "shop", Severity: 1.1},
+	}
+}
+
+func TestTop_KeepsWorstServices(t *testing.T) {
+	top := Top(3, topMetrics(), topAnomalies())
+
+	f := NewFormatter("table")
+	f.SetColumns([]string{"service"})
+	lines := strings.Split(strings.TrimRight(f.formatMetricsTable(top.Metrics), "\n"), "\n")
+	if len(lines) != 2+3 {
+		t.Fatalf("Expected header, rule and 3 rows, got %q", lines)
+	}
+
+	// reviews has the most anomalies; ratings and search one each, ratings
+	// the more severe
+	var rows []string
+	for _, line := range lines[2:] {
+		rows = append(rows, strings.TrimSpace(line))
+	}
+	if strings.Join(rows, ",") != "reviews,ratings,search" {
+		t.Errorf("Expected reviews, ratings, search worst first, got %v", rows)
+	}
+
+	if len(top.Anomalies) != 4 {
+		t.Errorf("Expected every anomaly of the kept services, got %d", len(top.Anomalies))
+	}
+	// cart and catalog were left out, but had no anomalies
+	if top.Omitted != 0 {
+		t.Errorf("Expected no services with anomalies omitted, got %d", top.Omitted)
+	}
+
+	top = Top(1, topMetrics(), topAnomalies())
+	if top.Omitted != 2 {
+		t.Errorf("Expected ratings and search omitted, got %d", top.Omitted)
+	}
+	if footer := f.FormatOmitted(top.Omitted); !strings.Contains(footer, "2 more services with anomalies omitted") {
+		t.Errorf("Expected an omitted footer, got %q", footer)
+	}
+}
+
+func TestTop_ErrorRateThenLatencyWithoutAnomalies(t *testing.T) {
+	top := Top(2, topMetrics(), nil)
+
+	if len(top.Metrics) != 2 || top.Metrics[0].ServiceName != "ratings" || top.Metrics[1].ServiceName != "reviews" {
+		t.Fatalf("Expected ratings then reviews by error rate, got %v", top.Metrics)
+	}
+
+	// cart and catalog tie on error rate; catalog's P99 is worse
+	top = Top(5, topMetrics(), nil)
+	if top.Metrics[3].ServiceName != "catalog" || top.Metrics[4].ServiceName != "cart" {
+		t.Errorf("Expected catalog ranked above cart by P99, got %s then %s", top.Metrics[3].ServiceName, top.Metrics[4].ServiceName)
+	}
+	if top.Omitted != 0 {
+		t.Errorf("Expected nothing omitted, got %d", top.Omitted)
+	}
+}
+
+func TestTopBy_RendersOnlyTopRows(t *testing.T) {
+	metrics := topMetrics()
+	for i, rps := range []float64{50, 5, 1, 20, 80} {
+		metrics[i].Traffic.RequestsPerSecond = rps
+	}
+
+	f := NewFormatter("table")
+	f.SetColumns([]string{"service"})
+	for _, test := range []struct {
+		order   TopOrder
+		want    string
+		omitted int
+	}{
+		// Anomalies don't count first, so search's retry storm doesn't
+		// lift it above reviews' error rate
+		{ByErrorRate, "ratings,reviews", 1},
+		{ByLatency, "catalog,reviews", 2},
+		{ByTraffic, "search,cart", 2},
+	} {
+		top := TopBy(2, test.order, metrics, topAnomalies())
+		lines := strings.Split(strings.TrimRight(f.formatMetricsTable(top.Metrics), "\n"), "\n")
+		var rows []string
+		for _, line := range lines[2:] {
+			rows = append(rows, strings.TrimSpace(line))
+		}
+		if strings.Join(rows, ",") != test.want {
+			t.Errorf("Expected rows %s by %s, got %v", test.want, test.order, rows)
+		}
+		if top.Omitted != test.omitted {
+			t.Errorf("Expected %d omitted services with anomalies by %s, got %d", test.omitted, test.order, top.Omitted)
+		}
+	}
+}
+
+func TestParseTopOrder(t *testing.T) {
+	if order, err := ParseTopOrder(""); err != nil || order != ByHealth {
+		t.Errorf("Expected health by default, got %q, %v", order, err)
+	}
+	if order, err := ParseTopOrder("p99"); err != nil || order != ByLatency {
+		t.Errorf("Expected p99, got %q, %v", order, err)
+	}
+	if _, err := ParseTopOrder("latency"); err == nil {
+		t.Error("Expected an error for an unknown ranking")
+	}
+}
+
+func TestTop_ZeroKeepsEverything(t *testing.T) {
+	top := Top(0, topMetrics(), topAnomalies())
+	if len(top.Metrics) != 5 || len(top.Anomalies) != 4 || top.Omitted != 0 {
+		t.Errorf("Expected everything kept, got %d metrics, %d anomalies, %d omitted", len(top.Metrics), len(top.Anomalies), top.Omitted)
+	}
+	if footer := NewFormatter("json").FormatOmitted(3); footer != "" {
+		t.Errorf("Expected no footer in JSON output, got %q", footer)
+	}
+}
+
+func TestFormatIncidents(t *testing.T) {
+	incidents := []anomaly.Incident{{RootCause: "ratings", Collateral: []string{"productpage", "reviews"}}}
+
+	if text := NewFormatter("text").FormatIncidents(incidents); !strings.Contains(text, "ratings, taking down productpage, reviews") {
+		t.Errorf("Expected the root cause and its collateral, got %q", text)
+	}
+	if text := NewFormatter("json").FormatIncidents(incidents); text != "" {
+		t.Errorf("Expected nothing in JSON output, got %q", text)
+	}
+	if text := NewFormatter("text").FormatIncidents(nil); text != "" {
+		t.Errorf("Expected nothing without incidents, got %q", text)
+	}
+}
@@ -0,0 +1,51 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"smanalyzer/pkg/health"
+)
+
+// WriteComparisons writes each service's before/after comparison with a
+// verdict per signal.
+func (f *Formatter) WriteComparisons(w io.Writer, comparisons []health.WindowComparison) error {
+	if f.format == JSON {
+		data, err := json.MarshalIndent(comparisons, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal comparisons: %w", err)
+		}
+		fmt.Fprintln(w, string(data))
+		return nil
+	}
+
+	for _, c := range comparisons {
+		outcome := "no regression"
+		if c.Regressed() {
+			outcome = "REGRESSED"
+		}
+		fmt.Fprintf(w, "Before/after %s, %v either side of %s: %s\n", c.Service, c.Window, c.Split.Format("2006-01-02 15:04:05 MST"), outcome)
+		for _, s := range c.Signals {
+			if s.Verdict == health.NotEnoughData {
+				fmt.Fprintf(w, "  %-12s %s (%d before, %d after)\n", s.Signal, s.Verdict, s.Before.Points, s.After.Points)
+				continue
+			}
+			change := percentChange(s.After.Mean, s.Before.Mean)
+			if change == "" {
+				change = "from zero"
+				if s.After.Mean == s.Before.Mean {
+					change = "+0%"
+				}
+			}
+			verdict := string(s.Verdict)
+			if s.Verdict == health.Regression {
+				verdict = strings.ToUpper(verdict)
+			}
+			fmt.Fprintf(w, "  %-12s %.4g → %.4g (%s, p=%.3f) %s\n", s.Signal, s.Before.Mean, s.After.Mean, change, s.PValue, verdict)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Package profile records how long each phase of a scan takes, so a slow
+// scan can be traced to the API server, parsing, or detection.
+package profile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Phase is the accumulated wall-clock time spent in one named phase.
+type Phase struct {
+	Name     string
+	Duration time.Duration
+	Count    int
+}
+
+// Recorder accumulates phase timings. It is safe for concurrent use.
+type Recorder struct {
+	mutex  sync.Mutex
+	phases []Phase
+	index  map[string]int
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{index: make(map[string]int)}
+}
+
+type contextKey struct{}
+
+// WithRecorder returns a context whose Track calls record into r.
+func WithRecorder(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// Track starts timing a phase and returns the function that ends it. It
+// does nothing when the context carries no Recorder, so callers can time
+// phases unconditionally.
+func Track(ctx context.Context, name string) func() {
+	r, _ := ctx.Value(contextKey{}).(*Recorder)
+	if r == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { r.Add(name, time.Since(start)) }
+}
+
+// Add records d against the named phase. Repeated phases accumulate.
+func (r *Recorder) Add(name string, d time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i, exists := r.index[name]
+	if !exists {
+		i = len(r.phases)
+		r.index[name] = i
+		r.phases = append(r.phases, Phase{Name: name})
+	}
+	r.phases[i].Duration += d
+	r.phases[i].Count++
+}
+
+// Phases returns the recorded phases in the order they were first seen.
+func (r *Recorder) Phases() []Phase {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Phase(nil), r.phases...)
+}
+
+// Phase returns the named phase, if it was recorded.
+func (r *Recorder) Phase(name string) (Phase, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i, exists := r.index[name]
+	if !exists {
+		return Phase{}, false
+	}
+	return r.phases[i], true
+}
+
+// WriteBreakdown writes a table of the phases with their share of total,
+// the wall-clock time since the scan started.
+func (r *Recorder) WriteBreakdown(w io.Writer, total time.Duration) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tCOUNT\tTIME\tSHARE")
+	for _, phase := range r.Phases() {
+		share := 0.0
+		if total > 0 {
+			share = float64(phase.Duration) / float64(total) * 100
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%.1f%%\n", phase.Name, phase.Count, phase.Duration.Round(time.Microsecond), share)
+	}
+	fmt.Fprintf(tw, "total\t\t%v\t\n", total.Round(time.Microsecond))
+	tw.Flush()
+}
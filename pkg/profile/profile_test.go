@@ -0,0 +1,56 @@
+package profile
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrack_AccumulatesRepeatedPhases(t *testing.T) {
+	r := NewRecorder()
+	ctx := WithRecorder(context.Background(), r)
+
+	for i := 0; i < 3; i++ {
+		done := Track(ctx, "detection")
+		time.Sleep(time.Millisecond)
+		done()
+	}
+
+	phase, ok := r.Phase("detection")
+	if !ok {
+		t.Fatal("Expected the detection phase to be recorded")
+	}
+	if phase.Count != 3 {
+		t.Errorf("Expected 3 timings, got %d", phase.Count)
+	}
+	if phase.Duration < 3*time.Millisecond {
+		t.Errorf("Expected at least 3ms, got %v", phase.Duration)
+	}
+}
+
+func TestTrack_WithoutRecorder(t *testing.T) {
+	// Must not panic when profiling is off
+	Track(context.Background(), "detection")()
+}
+
+func TestRecorder_WriteBreakdown(t *testing.T) {
+	r := NewRecorder()
+	r.Add("discovery", 30*time.Millisecond)
+	r.Add("detection", 10*time.Millisecond)
+
+	var out bytes.Buffer
+	r.WriteBreakdown(&out, 100*time.Millisecond)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected header, 2 phases and total, got:\n%s", out.String())
+	}
+	if !strings.HasPrefix(lines[1], "discovery") || !strings.Contains(lines[1], "30.0%") {
+		t.Errorf("Expected discovery first at 30%%, got %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[3], "total") {
+		t.Errorf("Expected a total line, got %q", lines[3])
+	}
+}
@@ -0,0 +1,46 @@
+// Package progress prints the status chatter commands emit while they work,
+// kept separate from their results so it can be silenced for scripting.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	mutex sync.Mutex
+	out   io.Writer = os.Stdout
+	quiet bool
+)
+
+// SetOutput redirects progress messages, e.g. to capture them in tests.
+func SetOutput(w io.Writer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	out = w
+}
+
+// SetQuiet suppresses all progress messages when quiet is true.
+func SetQuiet(q bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	quiet = q
+}
+
+func Printf(format string, args ...interface{}) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !quiet {
+		fmt.Fprintf(out, format, args...)
+	}
+}
+
+func Println(args ...interface{}) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !quiet {
+		fmt.Fprintln(out, args...)
+	}
+}
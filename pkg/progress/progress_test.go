@@ -0,0 +1,36 @@
+package progress
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestQuiet_PrintsNothing(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out)
+	SetQuiet(true)
+	defer SetOutput(os.Stdout)
+	defer SetQuiet(false)
+
+	Println("Connecting to Kubernetes cluster...")
+	Printf("Debug: Found meshed service: %s\n", "reviews.shop")
+
+	if got := out.String(); got != "" {
+		t.Errorf("Expected no progress output, got %q", got)
+	}
+}
+
+func TestNotQuiet_PrintsProgress(t *testing.T) {
+	var out bytes.Buffer
+	SetOutput(&out)
+	defer SetOutput(os.Stdout)
+
+	Println("Connecting to Kubernetes cluster...")
+	Printf("Debug: %d services\n", 2)
+
+	expected := "Connecting to Kubernetes cluster...\nDebug: 2 services\n"
+	if got := out.String(); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
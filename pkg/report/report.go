@@ -0,0 +1,219 @@
+// Package report records scans to JSON and replays them through detection,
+// so thresholds can be tuned against a real incident after the fact.
+package report
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/clock"
+	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/istio"
+	"smanalyzer/pkg/ml"
+	"smanalyzer/pkg/telemetry"
+	"smanalyzer/pkg/timeseries"
+)
+
+// Report is one recorded scan: the metrics collected from each service and
+// the anomalies detected from them.
+type Report struct {
+	GeneratedAt time.Time                   `json:"generated_at"`
+	Namespace   string                      `json:"namespace,omitempty"`
+	Metrics     []*istio.ServiceMeshMetrics `json:"metrics"`
+	Anomalies   []anomaly.Anomaly           `json:"anomalies"`
+}
+
+// Write saves the report as indented JSON, gzip-compressed when path ends
+// in .gz.
+func Write(path string, r *Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	if strings.HasSuffix(path, ".gz") {
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		if _, err := zw.Write(data); err != nil {
+			return fmt.Errorf("failed to compress report: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to compress report: %w", err)
+		}
+		data = compressed.Bytes()
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Read loads a report saved with Write. Gzip-compressed reports are
+// recognized by their content, whatever the file is named.
+func Read(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress report %s: %w", path, err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("failed to decompress report %s: %w", path, err)
+		}
+	}
+
+	r := &Report{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Replay stores the recorded metrics of every report, oldest first, and
+// runs detection over the resulting series with cfg. The anomalies are
+// tagged with their service, namespace and cluster like a live scan.
+func Replay(reports []*Report, cfg *config.Config) ([]anomaly.Anomaly, error) {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+
+	storage := timeseries.NewStorage()
+	latest := make(map[string]*istio.ServiceMeshMetrics)
+	for _, r := range byTime(reports) {
+		for key, metrics := range store(storage, r, cfg.Storage.Labels) {
+			latest[key] = metrics
+		}
+	}
+
+	detector := anomaly.NewDetector(cfg.ToAnomalyDetectionConfig(), ml.NewClusteringEngine(cfg.ToMLConfig()))
+	return detectAll(detector, storage, latest, false)
+}
+
+// SimulateOptions controls how Simulate paces a replay.
+type SimulateOptions struct {
+	// Speed is how fast recorded time passes: 0 replays instantly, 1 in
+	// real time, and N at N times real time.
+	Speed float64
+	// Cooldown suppresses repeats of an anomaly for a service within this
+	// much recorded time; zero reports every repeat.
+	Cooldown time.Duration
+	// Sleep waits between reports; nil uses time.Sleep.
+	Sleep func(time.Duration)
+}
+
+// Simulate replays the reports as the scans that recorded them ran: a
+// simulated clock steps through their timestamps, waiting between them
+// according to Speed, and detection runs after each report. The anomalies
+// of every step are returned in order, less those suppressed by Cooldown,
+// with FirstSeen set on the first of each type for a service.
+func Simulate(reports []*Report, cfg *config.Config, opts SimulateOptions) ([]anomaly.Anomaly, error) {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+	if opts.Speed < 0 {
+		return nil, fmt.Errorf("replay speed must not be negative, got %v", opts.Speed)
+	}
+	sleep := opts.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	ordered := byTime(reports)
+	if len(ordered) == 0 {
+		return nil, nil
+	}
+
+	simulated := clock.NewSimulated(ordered[0].GeneratedAt)
+	suppressor := anomaly.NewSuppressor(opts.Cooldown, simulated)
+	storage := timeseries.NewStorage()
+	detector := anomaly.NewDetector(cfg.ToAnomalyDetectionConfig(), ml.NewClusteringEngine(cfg.ToMLConfig()))
+	storage.SetClock(simulated)
+	detector.SetClock(simulated)
+
+	var anomalies []anomaly.Anomaly
+	for _, r := range ordered {
+		if gap := r.GeneratedAt.Sub(simulated.Now()); opts.Speed > 0 && gap > 0 {
+			sleep(time.Duration(float64(gap) / opts.Speed))
+		}
+		simulated.Set(r.GeneratedAt)
+
+		found, err := detectAll(detector, storage, store(storage, r, cfg.Storage.Labels), true)
+		if err != nil {
+			return nil, err
+		}
+		anomalies = append(anomalies, suppressor.Filter(found)...)
+	}
+
+	return anomalies, nil
+}
+
+// byTime orders reports oldest first.
+func byTime(reports []*Report) []*Report {
+	ordered := append([]*Report(nil), reports...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].GeneratedAt.Before(ordered[j].GeneratedAt)
+	})
+	return ordered
+}
+
+// store records a report's metrics at their collection time, with the
+// given labels, and returns them keyed by series.
+func store(storage *timeseries.Storage, r *Report, labels []string) map[string]*istio.ServiceMeshMetrics {
+	stored := make(map[string]*istio.ServiceMeshMetrics)
+	for _, metrics := range r.Metrics {
+		key := metrics.SeriesKey()
+		series := metrics.Normalized.Series()
+		telemetry.IntervalSizeMeans(series, storage.Latest(key, telemetry.SizeCounters...))
+		for metric, value := range series {
+			storage.StoreAt(key, metric, value, metrics.Timestamp, metrics.StoredLabels(labels))
+		}
+		stored[key] = metrics
+	}
+	return stored
+}
+
+// detectAll runs detection for each service, keyed by series, with the
+// metrics last recorded for it, optionally flagging first occurrences.
+func detectAll(detector *anomaly.Detector, storage *timeseries.Storage, latest map[string]*istio.ServiceMeshMetrics, markFirstSeen bool) ([]anomaly.Anomaly, error) {
+	// Replay services in a fixed order so results are comparable across runs
+	keys := make([]string, 0, len(latest))
+	for key := range latest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var anomalies []anomaly.Anomaly
+	for _, key := range keys {
+		found, err := detector.DetectFromStorage(storage, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay %s: %w", key, err)
+		}
+		metrics := latest[key]
+		found = append(found, anomaly.DetectReplicaDivergence(key, metrics.Timestamp, metrics.PodErrorRates(), metrics.PodLatencies())...)
+		found = detector.GateReplicas(found, metrics.Replicas)
+		if markFirstSeen {
+			anomaly.MarkFirstSeen(storage, key, found)
+		}
+		for i := range found {
+			found[i].ServiceName = metrics.ServiceName
+			found[i].Namespace = metrics.Namespace
+			found[i].Cluster = metrics.Cluster
+		}
+		anomalies = append(anomalies, found...)
+	}
+
+	return anomalies, nil
+}
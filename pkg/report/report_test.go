@@ -0,0 +1,265 @@
+package report
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"smanalyzer/pkg/anomaly"
+	"smanalyzer/pkg/config"
+	"smanalyzer/pkg/istio"
+	"smanalyzer/pkg/telemetry"
+)
+
+func serviceAt(name string, at time.Time, requests, errors float64) *istio.ServiceMeshMetrics {
+	return &istio.ServiceMeshMetrics{
+		ServiceName: name,
+		Namespace:   "shop",
+		Timestamp:   at,
+		Normalized:  telemetry.Normalized{Requests: requests, Errors5xx: errors},
+	}
+}
+
+// cumulativeServiceAt returns the counters name has reported by the given
+// scan, failing errorPercent of its requests. Counters are cumulative: a
+// hundred scans' worth were served before the first report, and each scan
+// adds one more.
+func cumulativeServiceAt(name string, at time.Time, scan int, errorPercent float64) *istio.ServiceMeshMetrics {
+	n := float64(100 + scan)
+	return serviceAt(name, at, 100*n, errorPercent*n)
+}
+
+// writeIncidentReports records two scans a minute apart in which reviews
+// errors at 3% and ratings at 8%.
+func writeIncidentReports(t *testing.T) []string {
+	t.Helper()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	var paths []string
+	for i := 0; i < 2; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		path := filepath.Join(dir, at.Format("150405")+".json")
+		err := Write(path, &Report{
+			GeneratedAt: at,
+			Metrics: []*istio.ServiceMeshMetrics{
+				cumulativeServiceAt("reviews", at, i, 3),
+				cumulativeServiceAt("ratings", at, i, 8),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func replayWithThreshold(t *testing.T, paths []string, threshold float64) int {
+	t.Helper()
+
+	var reports []*Report
+	for _, path := range paths {
+		r, err := Read(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		reports = append(reports, r)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Detection.ErrorRateThreshold = threshold
+	anomalies, err := Replay(reports, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, a := range anomalies {
+		if a.Namespace != "shop" {
+			t.Errorf("Expected replayed anomalies to keep their namespace, got %q", a.Namespace)
+		}
+	}
+	return len(anomalies)
+}
+
+func TestReplay_ThresholdsChangeAnomalyCount(t *testing.T) {
+	paths := writeIncidentReports(t)
+
+	if count := replayWithThreshold(t, paths, 0.02); count != 2 {
+		t.Errorf("Expected 2 anomalies at a 2%% threshold, got %d", count)
+	}
+	if count := replayWithThreshold(t, paths, 0.05); count != 1 {
+		t.Errorf("Expected 1 anomaly at a 5%% threshold, got %d", count)
+	}
+}
+
+func TestReplay_SameServiceInTwoNamespaces(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// A healthy staging copy must not dilute the failing shop one
+	var reports []*Report
+	for i := 0; i < 2; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		staging := cumulativeServiceAt("reviews", at, i, 0)
+		staging.Namespace = "staging"
+		reports = append(reports, &Report{
+			GeneratedAt: at,
+			Metrics:     []*istio.ServiceMeshMetrics{cumulativeServiceAt("reviews", at, i, 8), staging},
+		})
+	}
+
+	anomalies, err := Replay(reports, config.DefaultConfig())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(anomalies) != 1 || anomalies[0].Namespace != "shop" {
+		t.Errorf("Expected 1 anomaly for reviews in shop, got %v", anomalies)
+	}
+}
+
+func TestReplay_SingleReplicaService(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var reports []*Report
+	for i := 0; i < 2; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		canary := cumulativeServiceAt("reviews-canary", at, i, 8)
+		canary.Replicas = 1
+		reports = append(reports, &Report{GeneratedAt: at, Metrics: []*istio.ServiceMeshMetrics{canary}})
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Detection.MinReplicas = 2
+	anomalies, err := Replay(reports, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(anomalies) != 1 || anomalies[0].Labels[anomaly.LowReplicasLabel] != "1" {
+		t.Errorf("Expected 1 downgraded anomaly, got %v", anomalies)
+	}
+
+	cfg.Detection.LowReplicaAction = anomaly.LowReplicaSuppress
+	anomalies, err = Replay(reports, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(anomalies) != 0 {
+		t.Errorf("Expected the canary's anomalies suppressed, got %v", anomalies)
+	}
+}
+
+func TestWrite_GzipRoundTrip(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	recorded := &Report{
+		GeneratedAt: at,
+		Namespace:   "shop",
+		Metrics:     []*istio.ServiceMeshMetrics{serviceAt("reviews", at, 100, 8)},
+		Anomalies:   []anomaly.Anomaly{{Type: anomaly.ErrorRateHigh, ServiceName: "reviews", Namespace: "shop", Severity: 1.6}},
+	}
+
+	path := filepath.Join(t.TempDir(), "scan.json.gz")
+	if err := Write(path, recorded); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.HasPrefix(data, gzipMagic) {
+		t.Fatalf("Expected a gzip-compressed report, got %q", data[:min(len(data), 16)])
+	}
+
+	r, err := Read(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !r.GeneratedAt.Equal(at) || r.Namespace != "shop" || len(r.Metrics) != 1 || r.Metrics[0].Normalized.Errors5xx != 8 {
+		t.Errorf("Expected the recorded scan back, got %+v", r)
+	}
+	if len(r.Anomalies) != 1 || r.Anomalies[0].Type != anomaly.ErrorRateHigh {
+		t.Errorf("Expected the recorded anomaly back, got %+v", r.Anomalies)
+	}
+
+	// Compressed content is recognized even without the extension
+	renamed := filepath.Join(t.TempDir(), "scan.json")
+	if err := os.Rename(path, renamed); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := Read(renamed); err != nil {
+		t.Errorf("Expected a renamed compressed report to read, got %v", err)
+	}
+}
+
+func TestRead_MissingFile(t *testing.T) {
+	if _, err := Read(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing report")
+	}
+}
+
+// minuteReports records reviews failing at 8% once a minute for the given
+// number of minutes.
+func minuteReports(minutes int) []*Report {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var reports []*Report
+	for i := 0; i < minutes; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		reports = append(reports, &Report{GeneratedAt: at, Metrics: []*istio.ServiceMeshMetrics{cumulativeServiceAt("reviews", at, i, 8)}})
+	}
+	return reports
+}
+
+func TestSimulate_AcceleratedReplaySuppressesByRecordedTime(t *testing.T) {
+	var slept []time.Duration
+	anomalies, err := Simulate(minuteReports(11), config.DefaultConfig(), SimulateOptions{
+		Speed:    60,
+		Cooldown: 5 * time.Minute,
+		Sleep:    func(d time.Duration) { slept = append(slept, d) },
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The first report has no window yet; the error is raised at 12:01 and
+	// again once each five minute cooldown has passed
+	var raisedAt []string
+	for _, a := range anomalies {
+		raisedAt = append(raisedAt, a.Timestamp.Format("15:04"))
+	}
+	if len(raisedAt) != 2 || raisedAt[0] != "12:01" || raisedAt[1] != "12:06" {
+		t.Errorf("Expected the error raised at 12:01 and 12:06, got %v", raisedAt)
+	}
+
+	if len(slept) != 10 {
+		t.Fatalf("Expected a wait between each of the 11 reports, got %d", len(slept))
+	}
+	for _, d := range slept {
+		if d != time.Second {
+			t.Errorf("Expected a minute at 60x to take a second, got %v", d)
+		}
+	}
+}
+
+func TestSimulate_InstantMatchesAccelerated(t *testing.T) {
+	opts := SimulateOptions{Cooldown: 5 * time.Minute, Sleep: func(time.Duration) { t.Error("Expected no waiting at speed 0") }}
+	anomalies, err := Simulate(minuteReports(11), config.DefaultConfig(), opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(anomalies) != 2 {
+		t.Errorf("Expected suppression by recorded time even when replaying instantly, got %d anomalies", len(anomalies))
+	}
+
+	opts.Cooldown = 0
+	if anomalies, _ := Simulate(minuteReports(11), config.DefaultConfig(), opts); len(anomalies) != 10 {
+		t.Errorf("Expected every repeat without a cooldown, got %d", len(anomalies))
+	}
+}
+
+func TestSimulate_NegativeSpeed(t *testing.T) {
+	if _, err := Simulate(minuteReports(2), nil, SimulateOptions{Speed: -1}); err == nil {
+		t.Error("Expected an error for a negative speed")
+	}
+}
@@ -0,0 +1,207 @@
+package telemetry
+
+import (
+	"math"
+	"time"
+)
+
+// Names of the per-service series recorded from each scrape
+const (
+	TrafficRPS        = "traffic_rps"
+	LatencyP50        = "latency_p50"
+	LatencyP90        = "latency_p90"
+	LatencyP95        = "latency_p95"
+	LatencyP99        = "latency_p99"
+	ErrorRate         = "error_rate"
+	UpstreamErrorRate = "upstream_error_rate"
+	SaturationCPU     = "saturation_cpu"
+	RequestCount      = "request_count"
+	ResponseTime      = "response_time"
+	RetryCount        = "retry_count"
+	TimeoutCount      = "timeout_count"
+	CircuitBreakers   = "circuit_breakers_open"
+	BreakerUsage      = "circuit_breaker_usage"
+	ConnFailures      = "connection_failures"
+	EjectionsActive   = "outlier_ejections_active"
+	Ejections         = "outlier_ejections_total"
+	RequestSize       = "request_size_mean"
+	ResponseSize      = "response_size_mean"
+	RequestBytes      = "request_bytes"
+	RequestSizeCount  = "request_size_count"
+	ResponseBytes     = "response_bytes"
+	ResponseSizeCount = "response_size_count"
+)
+
+// SizeCounters are the cumulative size histogram series the mean sizes of
+// a scrape are derived from by IntervalSizeMeans.
+var SizeCounters = []string{RequestBytes, RequestSizeCount, ResponseBytes, ResponseSizeCount}
+
+// ChangeTolerances are the relative changes between scans below which
+// --changes-only treats a series as unchanged. Only gauges and ratios are
+// compared, since the cumulative counters grow with any traffic.
+// Percentiles jitter from scrape to scrape, so they need to move further
+// than the error rates.
+var ChangeTolerances = map[string]float64{
+	ErrorRate:         0.01,
+	UpstreamErrorRate: 0.01,
+	SaturationCPU:     0.01,
+	CircuitBreakers:   0.01,
+	BreakerUsage:      0.01,
+	EjectionsActive:   0.01,
+	LatencyP50:        0.1,
+	LatencyP90:        0.1,
+	LatencyP95:        0.1,
+	LatencyP99:        0.1,
+	ResponseTime:      0.1,
+}
+
+// scrapeWindow is the period cumulative counters are assumed to cover when
+// approximating per-second rates from a single scrape.
+const scrapeWindow = 60
+
+// Normalized is the mesh-agnostic form of a service's golden signals. Every
+// collector fills one in from its own metric names so nothing downstream
+// needs to know which mesh the numbers came from. Counters are raw totals;
+// derived rates are computed by the methods below.
+type Normalized struct {
+	// Requests served. For L4-only data planes this is connections opened.
+	Requests  float64 `json:"requests"`
+	Errors4xx float64 `json:"errors_4xx"`
+	Errors5xx float64 `json:"errors_5xx"`
+	// OtherErrors are failures without an HTTP error status, e.g. gRPC
+	// failures on a 200 or TCP connections closed with a failure flag
+	OtherErrors float64 `json:"other_errors"`
+	// MaskedFailures are upstream failures hidden from the client by a
+	// successful retry
+	MaskedFailures float64 `json:"masked_failures"`
+
+	Retries             float64 `json:"retries"`
+	Timeouts            float64 `json:"timeouts"`
+	CircuitBreakersOpen float64 `json:"circuit_breakers_open"`
+	ConnectionFailures  float64 `json:"connection_failures"`
+	// CircuitBreakerUsage is the largest fraction of a circuit breaker
+	// threshold in use on any upstream cluster, zero when the proxy
+	// doesn't track remaining capacity
+	CircuitBreakerUsage float64 `json:"circuit_breaker_usage"`
+	// EjectionsActive are the upstream hosts outlier detection has
+	// ejected now, summed over upstream clusters; Ejections is the
+	// cumulative count of ejections
+	EjectionsActive float64 `json:"outlier_ejections_active"`
+	Ejections       float64 `json:"outlier_ejections_total"`
+
+	LatencyP50 time.Duration `json:"latency_p50"`
+	LatencyP90 time.Duration `json:"latency_p90"`
+	LatencyP95 time.Duration `json:"latency_p95"`
+	LatencyP99 time.Duration `json:"latency_p99"`
+	// LatencyAbsent marks a scrape whose percentiles were dropped as
+	// implausible. They read zero but are left out of Series, so they
+	// don't pull the stored latencies, and the baselines, down.
+	LatencyAbsent bool `json:"latency_absent,omitempty"`
+
+	InboundBytes      float64 `json:"inbound_bytes"`
+	OutboundBytes     float64 `json:"outbound_bytes"`
+	ActiveConnections float64 `json:"active_connections"`
+	PendingRequests   float64 `json:"pending_requests"`
+	CPUUsage          float64 `json:"cpu_usage"`
+	MemoryUsage       float64 `json:"memory_usage"`
+
+	// RequestSizeMean and ResponseSizeMean are the mean body sizes per
+	// request in bytes since the proxy started, zero when it doesn't report
+	// size histograms. RequestSizeCount and ResponseSizeCount are the
+	// requests those histograms measured, and InboundBytes and OutboundBytes
+	// their sums.
+	RequestSizeMean   float64 `json:"request_size_mean"`
+	ResponseSizeMean  float64 `json:"response_size_mean"`
+	RequestSizeCount  float64 `json:"request_size_count"`
+	ResponseSizeCount float64 `json:"response_size_count"`
+}
+
+// RequestsPerSecond approximates the request rate over the last minute.
+func (n Normalized) RequestsPerSecond() float64 {
+	return n.Requests / scrapeWindow
+}
+
+// ErrorRate is the fraction of requests that failed from the client's view.
+func (n Normalized) ErrorRate() float64 {
+	if n.Requests <= 0 {
+		return 0
+	}
+	return (n.Errors4xx + n.Errors5xx + n.OtherErrors) / n.Requests
+}
+
+// UpstreamErrorRate is the fraction of requests that hit an upstream failure,
+// including the ones a retry recovered.
+func (n Normalized) UpstreamErrorRate() float64 {
+	if n.Requests <= 0 {
+		return 0
+	}
+	return math.Min(1, (n.Errors4xx+n.Errors5xx+n.OtherErrors+n.MaskedFailures)/n.Requests)
+}
+
+// MeanLatency approximates the mean as the average of the known percentiles.
+func (n Normalized) MeanLatency() time.Duration {
+	return (n.LatencyP50 + n.LatencyP90 + n.LatencyP95 + n.LatencyP99) / 4
+}
+
+// Series returns the value of each recorded series for this scrape.
+func (n Normalized) Series() map[string]float64 {
+	series := map[string]float64{
+		TrafficRPS:        n.RequestsPerSecond(),
+		LatencyP50:        float64(n.LatencyP50.Milliseconds()),
+		LatencyP90:        float64(n.LatencyP90.Milliseconds()),
+		LatencyP95:        float64(n.LatencyP95.Milliseconds()),
+		LatencyP99:        float64(n.LatencyP99.Milliseconds()),
+		ErrorRate:         n.ErrorRate(),
+		UpstreamErrorRate: n.UpstreamErrorRate(),
+		SaturationCPU:     n.CPUUsage,
+		RequestCount:      n.Requests,
+		ResponseTime:      float64(n.MeanLatency().Milliseconds()),
+		RetryCount:        n.Retries,
+		TimeoutCount:      n.Timeouts,
+		CircuitBreakers:   n.CircuitBreakersOpen,
+		BreakerUsage:      n.CircuitBreakerUsage,
+		ConnFailures:      n.ConnectionFailures,
+		EjectionsActive:   n.EjectionsActive,
+		Ejections:         n.Ejections,
+		RequestSize:       n.RequestSizeMean,
+		ResponseSize:      n.ResponseSizeMean,
+		RequestBytes:      n.InboundBytes,
+		RequestSizeCount:  n.RequestSizeCount,
+		ResponseBytes:     n.OutboundBytes,
+		ResponseSizeCount: n.ResponseSizeCount,
+	}
+	if n.LatencyAbsent {
+		for _, metric := range []string{LatencyP50, LatencyP90, LatencyP95, LatencyP99, ResponseTime} {
+			delete(series, metric)
+		}
+	}
+	return series
+}
+
+// IntervalSizeMeans replaces the lifetime mean sizes in series with the
+// means over the requests measured since the scrape whose SizeCounters
+// previous holds, so a sudden change in size isn't averaged away by the
+// proxy's whole history. An interval without requests has no mean and
+// reads zero. Without a previous scrape, or when the counters reset with
+// a restarted proxy, the lifetime means are kept.
+func IntervalSizeMeans(series, previous map[string]float64) {
+	for _, size := range []struct{ mean, sum, count string }{
+		{RequestSize, RequestBytes, RequestSizeCount},
+		{ResponseSize, ResponseBytes, ResponseSizeCount},
+	} {
+		sum, hasSum := previous[size.sum]
+		count, hasCount := previous[size.count]
+		if !hasSum || !hasCount {
+			continue
+		}
+		bytes, requests := series[size.sum]-sum, series[size.count]-count
+		switch {
+		case bytes < 0 || requests < 0:
+			continue
+		case requests == 0:
+			series[size.mean] = 0
+		default:
+			series[size.mean] = bytes / requests
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package telemetry
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNormalized_Series(t *testing.T) {
+	n := Normalized{
+		Requests:            600,
+		Errors4xx:           6,
+		Errors5xx:           24,
+		MaskedFailures:      30,
+		LatencyP50:          10 * time.Millisecond,
+		LatencyP90:          20 * time.Millisecond,
+		LatencyP95:          30 * time.Millisecond,
+		LatencyP99:          100 * time.Millisecond,
+		CPUUsage:            0.4,
+		Retries:             45,
+		Timeouts:            3,
+		ConnectionFailures:  7,
+		InboundBytes:        51200,
+		OutboundBytes:       204800,
+		RequestSizeMean:     512,
+		ResponseSizeMean:    2048,
+		RequestSizeCount:    100,
+		ResponseSizeCount:   100,
+		CircuitBreakerUsage: 0.85,
+		EjectionsActive:     2,
+		Ejections:           9,
+	}
+
+	series := n.Series()
+
+	expected := map[string]float64{
+		TrafficRPS:        10,
+		LatencyP50:        10,
+		LatencyP90:        20,
+		LatencyP95:        30,
+		LatencyP99:        100,
+		ErrorRate:         0.05,
+		UpstreamErrorRate: 0.1,
+		SaturationCPU:     0.4,
+		RequestCount:      600,
+		ResponseTime:      40,
+		RetryCount:        45,
+		TimeoutCount:      3,
+		CircuitBreakers:   0,
+		BreakerUsage:      0.85,
+		ConnFailures:      7,
+		EjectionsActive:   2,
+		Ejections:         9,
+		RequestSize:       512,
+		ResponseSize:      2048,
+		RequestBytes:      51200,
+		RequestSizeCount:  100,
+		ResponseBytes:     204800,
+		ResponseSizeCount: 100,
+	}
+	for metric, want := range expected {
+		got, exists := series[metric]
+		if !exists {
+			t.Errorf("Expected series %s", metric)
+			continue
+		}
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("Expected %s=%v, got %v", metric, want, got)
+		}
+	}
+	if len(series) != len(expected) {
+		t.Errorf("Expected %d series, got %d", len(expected), len(series))
+	}
+}
+
+func TestIntervalSizeMeans(t *testing.T) {
+	// 10000 responses of 2KiB, then 100 of 64KiB: barely a change in the
+	// lifetime mean, all of it since the last scrape
+	previous := Normalized{OutboundBytes: 10000 * 2048, ResponseSizeCount: 10000, RequestSizeCount: 10000}.Series()
+	latest := Normalized{OutboundBytes: 10000*2048 + 100*65536, ResponseSizeCount: 10100, RequestSizeCount: 10000}
+	latest.ResponseSizeMean = latest.OutboundBytes / latest.ResponseSizeCount
+
+	series := latest.Series()
+	IntervalSizeMeans(series, previous)
+	if series[ResponseSize] != 65536 {
+		t.Errorf("Expected a 64KiB mean since the last scrape, got %v", series[ResponseSize])
+	}
+	if series[RequestSize] != 0 {
+		t.Errorf("Expected no request size mean without requests measured, got %v", series[RequestSize])
+	}
+
+	// A restarted proxy counts from zero, so its lifetime mean is the
+	// mean since the restart
+	restarted := Normalized{OutboundBytes: 4096, ResponseSizeCount: 2, ResponseSizeMean: 2048}
+	series = restarted.Series()
+	IntervalSizeMeans(series, previous)
+	if series[ResponseSize] != 2048 {
+		t.Errorf("Expected the mean since the restart, got %v", series[ResponseSize])
+	}
+
+	series = latest.Series()
+	IntervalSizeMeans(series, nil)
+	if series[ResponseSize] != latest.ResponseSizeMean {
+		t.Errorf("Expected the lifetime mean without a previous scrape, got %v", series[ResponseSize])
+	}
+}
+
+func TestNormalized_NoRequests(t *testing.T) {
+	n := Normalized{Errors5xx: 5, MaskedFailures: 5}
+
+	if n.ErrorRate() != 0 || n.UpstreamErrorRate() != 0 {
+		t.Errorf("Expected zero error rates without requests, got %v/%v", n.ErrorRate(), n.UpstreamErrorRate())
+	}
+}
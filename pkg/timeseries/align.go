@@ -0,0 +1,108 @@
+package timeseries
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// AggFunc reduces the values that fell into one aligned bucket, in
+// timestamp order, to a single value.
+type AggFunc func(values []float64) float64
+
+// Aggregations for AlignToInterval.
+var (
+	AggMean AggFunc = func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	}
+	AggMin AggFunc = func(values []float64) float64 {
+		min := values[0]
+		for _, v := range values[1:] {
+			min = math.Min(min, v)
+		}
+		return min
+	}
+	AggMax AggFunc = func(values []float64) float64 {
+		max := values[0]
+		for _, v := range values[1:] {
+			max = math.Max(max, v)
+		}
+		return max
+	}
+	AggSum AggFunc = func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	}
+	// AggLast keeps the latest value, for cumulative counters
+	AggLast AggFunc = func(values []float64) float64 {
+		return values[len(values)-1]
+	}
+)
+
+// ParseAggFunc returns the aggregation named mean, min, max, sum or last.
+func ParseAggFunc(name string) (AggFunc, error) {
+	switch name {
+	case "mean", "":
+		return AggMean, nil
+	case "min":
+		return AggMin, nil
+	case "max":
+		return AggMax, nil
+	case "sum":
+		return AggSum, nil
+	case "last":
+		return AggLast, nil
+	}
+	return nil, fmt.Errorf("invalid aggregation %q: expected mean, min, max, sum or last", name)
+}
+
+// AlignToInterval returns a copy of the series with each point snapped to
+// the nearest interval boundary (as time.Time.Round places them), and the
+// points sharing a boundary reduced by agg (the mean when nil). Two series
+// aligned to the same interval can then be compared point for point even
+// though their scrapes were a few seconds apart. Boundaries without points
+// are left out rather than filled, and each aligned point keeps the labels
+// of the latest point it replaced. A non-positive interval returns the
+// points unchanged.
+func (ts *TimeSeries) AlignToInterval(interval time.Duration, agg AggFunc) *TimeSeries {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+
+	aligned := &TimeSeries{ServiceName: ts.ServiceName, Metric: ts.Metric}
+	if interval <= 0 {
+		aligned.Points = append([]DataPoint(nil), ts.Points...)
+		return aligned
+	}
+	if agg == nil {
+		agg = AggMean
+	}
+
+	// Points are kept in timestamp order, so a bucket's points are
+	// consecutive
+	var values []float64
+	flush := func() {
+		if len(values) > 0 {
+			last := &aligned.Points[len(aligned.Points)-1]
+			last.Value = agg(values)
+			values = values[:0]
+		}
+	}
+	for _, point := range ts.Points {
+		boundary := point.Timestamp.Round(interval)
+		if n := len(aligned.Points); n == 0 || !aligned.Points[n-1].Timestamp.Equal(boundary) {
+			flush()
+			aligned.Points = append(aligned.Points, DataPoint{Timestamp: boundary})
+		}
+		aligned.Points[len(aligned.Points)-1].Labels = point.Labels
+		values = append(values, point.Value)
+	}
+	flush()
+	return aligned
+}
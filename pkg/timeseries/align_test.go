@@ -0,0 +1,97 @@
+package timeseries
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeSeries_AlignToInterval(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	storage := NewStorage()
+
+	// Two services scraped every 30s, reviews a little early and ratings a
+	// little late
+	for i, offset := range []time.Duration{-2 * time.Second, time.Second, -3 * time.Second} {
+		storage.StoreAt("shop/reviews", "latency_p99", float64(100+i), base.Add(time.Duration(i)*30*time.Second+offset), nil)
+	}
+	for i, offset := range []time.Duration{4 * time.Second, 2 * time.Second, 5 * time.Second} {
+		storage.StoreAt("shop/ratings", "latency_p99", float64(200+i), base.Add(time.Duration(i)*30*time.Second+offset), nil)
+	}
+
+	reviews, _ := storage.GetSeries("shop/reviews", "latency_p99")
+	ratings, _ := storage.GetSeries("shop/ratings", "latency_p99")
+	alignedReviews := reviews.AlignToInterval(30*time.Second, AggMean)
+	alignedRatings := ratings.AlignToInterval(30*time.Second, AggMean)
+
+	if len(alignedReviews.Points) != 3 || len(alignedRatings.Points) != 3 {
+		t.Fatalf("Expected 3 aligned points each, got %d and %d", len(alignedReviews.Points), len(alignedRatings.Points))
+	}
+	for i := range alignedReviews.Points {
+		want := base.Add(time.Duration(i) * 30 * time.Second)
+		if !alignedReviews.Points[i].Timestamp.Equal(want) || !alignedRatings.Points[i].Timestamp.Equal(want) {
+			t.Errorf("Point %d: expected both series at %v, got %v and %v", i, want, alignedReviews.Points[i].Timestamp, alignedRatings.Points[i].Timestamp)
+		}
+		if alignedReviews.Points[i].Value != float64(100+i) {
+			t.Errorf("Point %d: expected value %d, got %v", i, 100+i, alignedReviews.Points[i].Value)
+		}
+	}
+
+	if points, _ := storage.GetSeries("shop/reviews", "latency_p99"); !points.Points[0].Timestamp.Equal(base.Add(-2 * time.Second)) {
+		t.Error("Expected the stored series to be left as is")
+	}
+}
+
+func TestTimeSeries_AlignToInterval_Aggregation(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	series := &TimeSeries{Points: []DataPoint{
+		{Timestamp: base.Add(-4 * time.Second), Value: 2},
+		{Timestamp: base.Add(3 * time.Second), Value: 6},
+		{Timestamp: base.Add(14 * time.Second), Value: 4},
+		{Timestamp: base.Add(16 * time.Second), Value: 9},
+		// Nothing near base+60s, so that boundary is left out
+		{Timestamp: base.Add(89 * time.Second), Value: 1},
+	}}
+
+	cases := []struct {
+		agg  AggFunc
+		name string
+		want []float64
+	}{
+		{nil, "mean", []float64{4, 9, 1}},
+		{AggMean, "mean", []float64{4, 9, 1}},
+		{AggMin, "min", []float64{2, 9, 1}},
+		{AggMax, "max", []float64{6, 9, 1}},
+		{AggSum, "sum", []float64{12, 9, 1}},
+		{AggLast, "last", []float64{4, 9, 1}},
+	}
+	for _, c := range cases {
+		aligned := series.AlignToInterval(30*time.Second, c.agg)
+		if len(aligned.Points) != len(c.want) {
+			t.Errorf("%s: expected %d points, got %+v", c.name, len(c.want), aligned.Points)
+			continue
+		}
+		for i, want := range c.want {
+			if aligned.Points[i].Value != want {
+				t.Errorf("%s: point %d expected %v, got %v", c.name, i, want, aligned.Points[i].Value)
+			}
+		}
+		if !aligned.Points[2].Timestamp.Equal(base.Add(90 * time.Second)) {
+			t.Errorf("%s: expected the last point at %v, got %v", c.name, base.Add(90*time.Second), aligned.Points[2].Timestamp)
+		}
+	}
+
+	if raw := series.AlignToInterval(0, AggMean); len(raw.Points) != len(series.Points) {
+		t.Errorf("Expected a zero interval to keep every point, got %d", len(raw.Points))
+	}
+}
+
+func TestParseAggFunc(t *testing.T) {
+	for _, name := range []string{"", "mean", "min", "max", "sum", "last"} {
+		if _, err := ParseAggFunc(name); err != nil {
+			t.Errorf("Unexpected error for %q: %v", name, err)
+		}
+	}
+	if _, err := ParseAggFunc("median"); err == nil {
+		t.Error("Expected an error for an unknown aggregation")
+	}
+}
@@ -0,0 +1,133 @@
+package timeseries
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Aggregate summarizes the raw points a compacted point replaced.
+type Aggregate struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// CompactionTier downsamples points older than After into buckets of
+// Resolution.
+type CompactionTier struct {
+	After      time.Duration `yaml:"after" json:"after"`
+	Resolution time.Duration `yaml:"resolution" json:"resolution"`
+}
+
+// CompactionPolicy lists the tiers applied to older data. A point is
+// bucketed by the tier with the largest After it is older than; points
+// younger than every tier stay raw.
+type CompactionPolicy struct {
+	Tiers []CompactionTier
+}
+
+// DefaultCompactionPolicy keeps raw points for 6 hours, 5-minute means up
+// to a week, and daily means beyond that.
+func DefaultCompactionPolicy() *CompactionPolicy {
+	return &CompactionPolicy{Tiers: []CompactionTier{
+		{After: 6 * time.Hour, Resolution: 5 * time.Minute},
+		{After: 7 * 24 * time.Hour, Resolution: 24 * time.Hour},
+	}}
+}
+
+// resolution returns the bucket size for a point of the given age, or zero
+// to keep it raw.
+func (p *CompactionPolicy) resolution(age time.Duration) time.Duration {
+	var resolution time.Duration
+	var after time.Duration = -1
+	for _, tier := range p.Tiers {
+		if age >= tier.After && tier.After > after && tier.Resolution > 0 {
+			after = tier.After
+			resolution = tier.Resolution
+		}
+	}
+	return resolution
+}
+
+// SetCompactionPolicy makes Save compact the storage before writing it.
+// A nil policy disables compaction.
+func (s *Storage) SetCompactionPolicy(policy *CompactionPolicy) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.compaction = policy
+}
+
+// Compact downsamples every series according to the policy, relative to
+// now. Each bucket becomes one point at the bucket start carrying the mean
+// as its value and the min, max and count of the points it replaced.
+// Compacting already compacted data is safe; aggregates merge by count.
+func (s *Storage) Compact(policy *CompactionPolicy, now time.Time) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, series := range s.series {
+		series.mutex.Lock()
+		series.Points = compactPoints(series.Points, policy, now)
+		series.mutex.Unlock()
+	}
+}
+
+type bucketKey struct {
+	resolution time.Duration
+	start      int64
+}
+
+func compactPoints(points []DataPoint, policy *CompactionPolicy, now time.Time) []DataPoint {
+	var compacted []DataPoint
+	buckets := make(map[bucketKey]int)
+
+	for _, point := range points {
+		resolution := policy.resolution(now.Sub(point.Timestamp))
+		if resolution == 0 {
+			compacted = append(compacted, point)
+			continue
+		}
+
+		start := point.Timestamp.Truncate(resolution)
+		key := bucketKey{resolution: resolution, start: start.UnixNano()}
+		if i, exists := buckets[key]; exists {
+			compacted[i] = mergePoints(compacted[i], point)
+			continue
+		}
+
+		buckets[key] = len(compacted)
+		aggregated := mergePoints(DataPoint{}, point)
+		aggregated.Timestamp = start
+		aggregated.Labels = point.Labels
+		compacted = append(compacted, aggregated)
+	}
+
+	sort.SliceStable(compacted, func(i, j int) bool {
+		return compacted[i].Timestamp.Before(compacted[j].Timestamp)
+	})
+	return compacted
+}
+
+// mergePoints folds point into bucket, weighting means by count.
+func mergePoints(bucket, point DataPoint) DataPoint {
+	incoming := Aggregate{Min: point.Value, Max: point.Value, Count: 1}
+	if point.Aggregate != nil {
+		incoming = *point.Aggregate
+	}
+
+	if bucket.Aggregate == nil {
+		bucket.Value = point.Value
+		bucket.Aggregate = &incoming
+		return bucket
+	}
+
+	merged := *bucket.Aggregate
+	total := merged.Count + incoming.Count
+	bucket.Value = (bucket.Value*float64(merged.Count) + point.Value*float64(incoming.Count)) / float64(total)
+	merged.Min = math.Min(merged.Min, incoming.Min)
+	merged.Max = math.Max(merged.Max, incoming.Max)
+	merged.Count = total
+	bucket.Aggregate = &merged
+	return bucket
+}
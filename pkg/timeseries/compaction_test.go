@@ -0,0 +1,112 @@
+package timeseries
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStorage_Compact_ShrinksAndPreservesAggregates(t *testing.T) {
+	storage := NewStorage()
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	// One point a minute for the last 8 hours: 6h stay raw, 2h get 5-minute buckets
+	for i := 0; i < 8*60; i++ {
+		storage.StoreAt("reviews", "request_count", float64(i%10), now.Add(-time.Duration(i)*time.Minute), nil)
+	}
+
+	storage.Compact(DefaultCompactionPolicy(), now)
+	points := storage.GetLatestN("reviews", "request_count", 1000)
+
+	// Minutes 0-359 stay raw; minutes 360-479 (04:01 to 06:00) fall in the
+	// 25 five-minute buckets from 04:00 to 06:00
+	if len(points) != 360+25 {
+		t.Fatalf("Expected %d points after compaction, got %d", 360+25, len(points))
+	}
+
+	total := 0
+	for _, p := range points {
+		if p.Aggregate == nil {
+			total++
+			continue
+		}
+		total += p.Aggregate.Count
+		if p.Aggregate.Min > p.Value || p.Aggregate.Max < p.Value {
+			t.Errorf("Expected mean %.2f within [%.2f, %.2f]", p.Value, p.Aggregate.Min, p.Aggregate.Max)
+		}
+	}
+	if total != 8*60 {
+		t.Errorf("Expected aggregates to account for all %d raw points, got %d", 8*60, total)
+	}
+
+	for i := 1; i < len(points); i++ {
+		if points[i].Timestamp.Before(points[i-1].Timestamp) {
+			t.Fatal("Expected compacted points to stay in timestamp order")
+		}
+	}
+}
+
+func TestStorage_Compact_BucketValues(t *testing.T) {
+	storage := NewStorage()
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	bucket := now.Add(-7 * time.Hour).Truncate(5 * time.Minute)
+
+	for i, v := range []float64{2, 8, 4, 6, 10} {
+		storage.StoreAt("reviews", "latency_p99", v, bucket.Add(time.Duration(i)*time.Minute), nil)
+	}
+
+	storage.Compact(DefaultCompactionPolicy(), now)
+	points := storage.GetLatestN("reviews", "latency_p99", 10)
+	if len(points) != 1 {
+		t.Fatalf("Expected 1 bucket, got %d", len(points))
+	}
+
+	p := points[0]
+	if !p.Timestamp.Equal(bucket) {
+		t.Errorf("Expected bucket start %v, got %v", bucket, p.Timestamp)
+	}
+	if p.Value != 6 || p.Aggregate.Min != 2 || p.Aggregate.Max != 10 || p.Aggregate.Count != 5 {
+		t.Errorf("Expected mean 6, min 2, max 10, count 5, got %v %+v", p.Value, *p.Aggregate)
+	}
+}
+
+func TestStorage_Compact_MergesAcrossTiers(t *testing.T) {
+	storage := NewStorage()
+	now := time.Date(2024, 1, 20, 12, 0, 0, 0, time.UTC)
+	day := now.Add(-10 * 24 * time.Hour).Truncate(24 * time.Hour)
+
+	for i := 0; i < 24*12; i++ {
+		storage.StoreAt("reviews", "request_count", float64(i%4), day.Add(time.Duration(i)*5*time.Minute), nil)
+	}
+
+	// First pass a week earlier leaves 5-minute buckets; the second folds
+	// them into one daily point
+	storage.Compact(DefaultCompactionPolicy(), day.Add(3*24*time.Hour))
+	storage.Compact(DefaultCompactionPolicy(), now)
+
+	points := storage.GetLatestN("reviews", "request_count", 1000)
+	if len(points) != 1 {
+		t.Fatalf("Expected 1 daily point, got %d", len(points))
+	}
+	if p := points[0]; math.Abs(p.Value-1.5) > 1e-9 || p.Aggregate.Count != 24*12 || p.Aggregate.Min != 0 || p.Aggregate.Max != 3 {
+		t.Errorf("Expected mean 1.5 over %d points in [0, 3], got %v %+v", 24*12, p.Value, *p.Aggregate)
+	}
+}
+
+func TestStorage_Save_CompactsWithPolicy(t *testing.T) {
+	storage := NewStorage()
+	storage.SetCompactionPolicy(&CompactionPolicy{Tiers: []CompactionTier{{After: time.Hour, Resolution: time.Hour}}})
+
+	old := time.Now().Add(-3 * time.Hour).Truncate(time.Hour)
+	for i := 0; i < 30; i++ {
+		storage.StoreAt("reviews", "request_count", 1, old.Add(time.Duration(i)*time.Minute), nil)
+	}
+	storage.Store("reviews", "request_count", 1, nil)
+
+	if err := storage.Save(t.TempDir() + "/series.json"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := len(storage.GetLatestN("reviews", "request_count", 100)); n != 2 {
+		t.Errorf("Expected 1 bucket and 1 raw point after save, got %d", n)
+	}
+}
@@ -0,0 +1,62 @@
+package timeseries
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Save writes a snapshot of every series to path as JSON, compacting first
+// when a compaction policy is set. The snapshot is written to a temporary
+// file and renamed into place, so Store calls made during the save are safe
+// and a reader never sees a partial file.
+func (s *Storage) Save(path string) error {
+	s.mutex.RLock()
+	policy := s.compaction
+	s.mutex.RUnlock()
+	if policy != nil {
+		s.Compact(policy, s.now())
+	}
+
+	data, err := json.Marshal(s.Snapshot())
+	if err != nil {
+		return fmt.Errorf("failed to marshal time series: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create time series file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write time series file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write time series file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace time series file: %w", err)
+	}
+	return nil
+}
+
+// Load replaces the stored series with those saved at path. The returned
+// error wraps os.ErrNotExist when nothing has been saved there yet.
+func (s *Storage) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read time series file: %w", err)
+	}
+
+	var snapshot []*TimeSeries
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse time series file: %w", err)
+	}
+
+	s.Restore(snapshot)
+	return nil
+}
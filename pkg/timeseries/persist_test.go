@@ -0,0 +1,97 @@
+package timeseries
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStorage_SaveLoad_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "series.json")
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	storage := NewStorage()
+	storage.StoreAt("reviews", "request_count", 100, base, map[string]string{"version": "v1"})
+	storage.StoreAt("reviews", "request_count", 120, base.Add(time.Minute), nil)
+	storage.StoreAt("ratings", "error_rate", 0.02, base, nil)
+
+	if err := storage.Save(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	loaded := NewStorage()
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	points := loaded.GetLatestN("reviews", "request_count", 10)
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 points, got %d", len(points))
+	}
+	if !points[0].Timestamp.Equal(base) || points[0].Value != 100 || points[0].Labels["version"] != "v1" {
+		t.Errorf("Expected first point to round-trip, got %+v", points[0])
+	}
+	if points[1].Value != 120 {
+		t.Errorf("Expected second point value 120, got %f", points[1].Value)
+	}
+
+	if _, exists := loaded.GetSeries("ratings", "error_rate"); !exists {
+		t.Error("Expected ratings error_rate series to round-trip")
+	}
+
+	// A later run appends to what it loaded
+	loaded.StoreAt("reviews", "request_count", 140, base.Add(2*time.Minute), nil)
+	if err := loaded.Save(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	again := NewStorage()
+	if err := again.Load(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := len(again.GetLatestN("reviews", "request_count", 10)); n != 3 {
+		t.Errorf("Expected 3 accumulated points, got %d", n)
+	}
+}
+
+func TestStorage_Load_Missing(t *testing.T) {
+	err := NewStorage().Load(filepath.Join(t.TempDir(), "missing.json"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+}
+
+func TestStorage_Save_ConcurrentStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "series.json")
+	storage := NewStorage()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				storage.Store("reviews", "request_count", float64(j), nil)
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := storage.Save(path); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	wg.Wait()
+
+	if err := storage.Save(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	loaded := NewStorage()
+	if err := loaded.Load(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := len(loaded.GetLatestN("reviews", "request_count", 1000)); n != 500 {
+		t.Errorf("Expected 500 points, got %d", n)
+	}
+}
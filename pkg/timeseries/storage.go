@@ -1,14 +1,21 @@
 package timeseries
 
 import (
+	"math"
+	"sort"
 	"sync"
 	"time"
+
+	"smanalyzer/pkg/clock"
 )
 
 type DataPoint struct {
 	Timestamp time.Time   `json:"timestamp"`
 	Value     float64     `json:"value"`
 	Labels    map[string]string `json:"labels"`
+	// Aggregate is set on points produced by compaction, whose Value is
+	// the mean of the raw points they replaced
+	Aggregate *Aggregate  `json:"aggregate,omitempty"`
 }
 
 type TimeSeries struct {
@@ -18,19 +25,50 @@ type TimeSeries struct {
 	mutex       sync.RWMutex
 }
 
+// seriesKey identifies a stored series. A struct keeps a ':' in a service
+// name from colliding with another service's metric.
+type seriesKey struct {
+	service string
+	metric  string
+}
+
 type Storage struct {
-	series map[string]*TimeSeries
-	mutex  sync.RWMutex
+	series     map[seriesKey]*TimeSeries
+	mutex      sync.RWMutex
+	compaction *CompactionPolicy
+	clock      clock.Clock
 }
 
 func NewStorage() *Storage {
 	return &Storage{
-		series: make(map[string]*TimeSeries),
+		series: make(map[seriesKey]*TimeSeries),
+		clock:  clock.Real{},
 	}
 }
 
+// SetClock replaces the clock that timestamps Store calls and ages points
+// for compaction.
+func (s *Storage) SetClock(c clock.Clock) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.clock = c
+}
+
+func (s *Storage) now() time.Time {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.clock.Now()
+}
+
 func (s *Storage) Store(serviceName, metric string, value float64, labels map[string]string) {
-	key := serviceName + ":" + metric
+	s.StoreAt(serviceName, metric, value, s.now(), labels)
+}
+
+// StoreAt records a point observed at ts rather than now, e.g. when
+// replaying or backfilling. Points are kept in timestamp order, so late
+// arrivals are inserted after any existing points with the same time.
+func (s *Storage) StoreAt(serviceName, metric string, value float64, ts time.Time, labels map[string]string) {
+	key := seriesKey{service: serviceName, metric: metric}
 	
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -44,18 +82,24 @@ func (s *Storage) Store(serviceName, metric string, value float64, labels map[st
 	}
 	
 	point := DataPoint{
-		Timestamp: time.Now(),
+		Timestamp: ts,
 		Value:     value,
 		Labels:    labels,
 	}
 	
-	s.series[key].mutex.Lock()
-	s.series[key].Points = append(s.series[key].Points, point)
-	s.series[key].mutex.Unlock()
+	series := s.series[key]
+	series.mutex.Lock()
+	i := sort.Search(len(series.Points), func(i int) bool {
+		return series.Points[i].Timestamp.After(ts)
+	})
+	series.Points = append(series.Points, DataPoint{})
+	copy(series.Points[i+1:], series.Points[i:])
+	series.Points[i] = point
+	series.mutex.Unlock()
 }
 
 func (s *Storage) GetSeries(serviceName, metric string) (*TimeSeries, bool) {
-	key := serviceName + ":" + metric
+	key := seriesKey{service: serviceName, metric: metric}
 	
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -98,4 +142,166 @@ func (s *Storage) GetLatestN(serviceName, metric string, n int) []DataPoint {
 	}
 	
 	return points[len(points)-n:]
-}
\ No newline at end of file
+}
+
+// Latest returns the latest value stored for each of the service's
+// metrics, leaving out the ones with nothing stored.
+func (s *Storage) Latest(serviceName string, metrics ...string) map[string]float64 {
+	values := make(map[string]float64, len(metrics))
+	for _, metric := range metrics {
+		if points := s.GetLatestN(serviceName, metric, 1); len(points) > 0 {
+			values[metric] = points[0].Value
+		}
+	}
+	return values
+}
+
+// GetMatching returns the points of a series whose labels include every
+// label in selector, e.g. {"version": "v2"} to compare a canary with the
+// stable release.
+func (s *Storage) GetMatching(serviceName, metric string, selector map[string]string) []DataPoint {
+	series, exists := s.GetSeries(serviceName, metric)
+	if !exists {
+		return nil
+	}
+
+	series.mutex.RLock()
+	defer series.mutex.RUnlock()
+
+	var result []DataPoint
+	for _, point := range series.Points {
+		if point.matches(selector) {
+			result = append(result, point)
+		}
+	}
+	return result
+}
+
+func (p DataPoint) matches(selector map[string]string) bool {
+	for key, value := range selector {
+		if p.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot returns a copy of every stored series, safe to serialize while
+// the storage keeps receiving points.
+func (s *Storage) Snapshot() []*TimeSeries {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	snapshot := make([]*TimeSeries, 0, len(s.series))
+	for _, series := range s.series {
+		series.mutex.RLock()
+		points := make([]DataPoint, len(series.Points))
+		copy(points, series.Points)
+		series.mutex.RUnlock()
+
+		snapshot = append(snapshot, &TimeSeries{
+			ServiceName: series.ServiceName,
+			Metric:      series.Metric,
+			Points:      points,
+		})
+	}
+	return snapshot
+}
+
+// Restore replaces the stored series with the given ones, e.g. from a Snapshot.
+func (s *Storage) Restore(snapshot []*TimeSeries) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.series = make(map[seriesKey]*TimeSeries)
+	for _, series := range snapshot {
+		points := make([]DataPoint, len(series.Points))
+		copy(points, series.Points)
+
+		s.series[seriesKey{service: series.ServiceName, metric: series.Metric}] = &TimeSeries{
+			ServiceName: series.ServiceName,
+			Metric:      series.Metric,
+			Points:      points,
+		}
+	}
+}
+
+// LastSeen returns the time of the newest point of the metric for each
+// service that has one.
+func (s *Storage) LastSeen(metric string) map[string]time.Time {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	seen := make(map[string]time.Time)
+	for key, series := range s.series {
+		if key.metric != metric {
+			continue
+		}
+		series.mutex.RLock()
+		if n := len(series.Points); n > 0 {
+			seen[key.service] = series.Points[n-1].Timestamp
+		}
+		series.mutex.RUnlock()
+	}
+	return seen
+}
+
+// StorageStats summarizes what a Storage holds.
+type StorageStats struct {
+	Series int `json:"series"`
+	Points int `json:"points"`
+	// Oldest and Newest are zero when no points are stored
+	Oldest time.Time `json:"oldest"`
+	Newest time.Time `json:"newest"`
+	// MetricPoints counts the points stored for each metric across services
+	MetricPoints map[string]int `json:"metric_points"`
+}
+
+// Stats counts the stored series and points and finds the time span they
+// cover.
+func (s *Storage) Stats() StorageStats {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stats := StorageStats{Series: len(s.series), MetricPoints: make(map[string]int)}
+	for key, series := range s.series {
+		series.mutex.RLock()
+		if n := len(series.Points); n > 0 {
+			stats.Points += n
+			stats.MetricPoints[key.metric] += n
+			// Points are kept in timestamp order
+			if oldest := series.Points[0].Timestamp; stats.Oldest.IsZero() || oldest.Before(stats.Oldest) {
+				stats.Oldest = oldest
+			}
+			if newest := series.Points[n-1].Timestamp; newest.After(stats.Newest) {
+				stats.Newest = newest
+			}
+		}
+		series.mutex.RUnlock()
+	}
+	return stats
+}
+
+// Changed reports whether any of values, keyed by metric, differs from the
+// latest point stored for the service by more than the metric's tolerance
+// relative to the larger of the two. Metrics without a tolerance are not
+// compared; a cumulative counter grows with any traffic, so it would always
+// read changed. A metric with nothing stored counts as changed, so a new
+// service is always reported.
+func (s *Storage) Changed(serviceName string, values map[string]float64, tolerances map[string]float64) bool {
+	for metric, value := range values {
+		epsilon, ok := tolerances[metric]
+		if !ok {
+			continue
+		}
+		points := s.GetLatestN(serviceName, metric, 1)
+		if len(points) == 0 {
+			return true
+		}
+		previous := points[0].Value
+		if math.Abs(value-previous) > epsilon*math.Max(math.Abs(value), math.Abs(previous)) {
+			return true
+		}
+	}
+	return false
+}
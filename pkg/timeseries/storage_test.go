@@ -1,8 +1,11 @@
 package timeseries
 
 import (
+	"reflect"
 	"testing"
 	"time"
+
+	"smanalyzer/pkg/clock"
 )
 
 func TestStorage_Store(t *testing.T) {
@@ -53,7 +56,7 @@ func TestStorage_GetTimeRange(t *testing.T) {
 	now := time.Now()
 	labels := map[string]string{}
 	
-	storage.series["test:metric"] = &TimeSeries{
+	storage.series[seriesKey{service: "test", metric: "metric"}] = &TimeSeries{
 		ServiceName: "test",
 		Metric:      "metric",
 		Points: []DataPoint{
@@ -150,4 +153,207 @@ func TestStorage_ConcurrentAccess(t *testing.T) {
 	if !exists2 || len(series2.Points) != 100 {
 		t.Errorf("Expected 100 points for service2, got %d", len(series2.Points))
 	}
-}
\ No newline at end of file
+}
+func TestStorage_StoreAt_OutOfOrder(t *testing.T) {
+	storage := NewStorage()
+	
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	labels := map[string]string{}
+	
+	// Inserted out of order, e.g. a backfill arriving after live scrapes
+	storage.StoreAt("test-service", "metric", 3.0, base.Add(3*time.Minute), labels)
+	storage.StoreAt("test-service", "metric", 1.0, base.Add(1*time.Minute), labels)
+	storage.StoreAt("test-service", "metric", 4.0, base.Add(4*time.Minute), labels)
+	storage.StoreAt("test-service", "metric", 2.0, base.Add(2*time.Minute), labels)
+	
+	points := storage.GetTimeRange("test-service", "metric", base, base.Add(5*time.Minute))
+	if len(points) != 4 {
+		t.Fatalf("Expected 4 points in range, got %d", len(points))
+	}
+	for i, point := range points {
+		if point.Value != float64(i+1) {
+			t.Errorf("Expected point %d value %f, got %f", i, float64(i+1), point.Value)
+		}
+	}
+	
+	points = storage.GetTimeRange("test-service", "metric", base.Add(90*time.Second), base.Add(210*time.Second))
+	if len(points) != 2 || points[0].Value != 2.0 || points[1].Value != 3.0 {
+		t.Errorf("Expected points 2 and 3 in range, got %v", points)
+	}
+	
+	latest := storage.GetLatestN("test-service", "metric", 2)
+	if len(latest) != 2 || latest[0].Value != 3.0 || latest[1].Value != 4.0 {
+		t.Errorf("Expected latest points 3 and 4, got %v", latest)
+	}
+}
+
+func TestStorage_StoreAt_EqualTimestampsKeepArrivalOrder(t *testing.T) {
+	storage := NewStorage()
+	
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	labels := map[string]string{}
+	storage.StoreAt("test-service", "metric", 1.0, ts, labels)
+	storage.StoreAt("test-service", "metric", 2.0, ts, labels)
+	
+	latest := storage.GetLatestN("test-service", "metric", 1)
+	if len(latest) != 1 || latest[0].Value != 2.0 {
+		t.Errorf("Expected the later arrival last, got %v", latest)
+	}
+}
+
+func TestStorage_SameServiceInTwoNamespaces(t *testing.T) {
+	storage := NewStorage()
+	
+	labels := map[string]string{}
+	storage.Store("default/reviews", "request_count", 10.0, labels)
+	storage.Store("staging/reviews", "request_count", 99.0, labels)
+	
+	prod := storage.GetLatestN("default/reviews", "request_count", 10)
+	staging := storage.GetLatestN("staging/reviews", "request_count", 10)
+	if len(prod) != 1 || prod[0].Value != 10.0 {
+		t.Errorf("Expected only the default namespace's point, got %v", prod)
+	}
+	if len(staging) != 1 || staging[0].Value != 99.0 {
+		t.Errorf("Expected only the staging namespace's point, got %v", staging)
+	}
+}
+
+func TestStorage_ColonInServiceNameDoesNotCollide(t *testing.T) {
+	storage := NewStorage()
+	
+	labels := map[string]string{}
+	storage.Store("a:b", "c", 1.0, labels)
+	storage.Store("a", "b:c", 2.0, labels)
+	
+	if points := storage.GetLatestN("a:b", "c", 10); len(points) != 1 || points[0].Value != 1.0 {
+		t.Errorf("Expected a separate series for service a:b, got %v", points)
+	}
+	if points := storage.GetLatestN("a", "b:c", 10); len(points) != 1 || points[0].Value != 2.0 {
+		t.Errorf("Expected a separate series for metric b:c, got %v", points)
+	}
+}
+
+func TestStorage_LastSeen(t *testing.T) {
+	storage := NewStorage()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	storage.StoreAt("shop/reviews", "request_count", 1, base, nil)
+	storage.StoreAt("shop/reviews", "request_count", 2, base.Add(time.Minute), nil)
+	storage.StoreAt("shop/ratings", "error_rate", 0.1, base.Add(time.Hour), nil)
+
+	seen := storage.LastSeen("request_count")
+	if len(seen) != 1 {
+		t.Fatalf("Expected 1 service with request_count, got %v", seen)
+	}
+	if !seen["shop/reviews"].Equal(base.Add(time.Minute)) {
+		t.Errorf("Expected newest point time %v, got %v", base.Add(time.Minute), seen["shop/reviews"])
+	}
+}
+
+func TestStorage_Stats(t *testing.T) {
+	storage := NewStorage()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if stats := storage.Stats(); stats.Series != 0 || stats.Points != 0 || !stats.Oldest.IsZero() || !stats.Newest.IsZero() {
+		t.Errorf("Expected empty stats for empty storage, got %+v", stats)
+	}
+
+	storage.StoreAt("shop/reviews", "request_count", 1, base.Add(time.Minute), nil)
+	storage.StoreAt("shop/reviews", "request_count", 2, base.Add(2*time.Minute), nil)
+	storage.StoreAt("shop/reviews", "error_rate", 0.1, base.Add(time.Hour), nil)
+	storage.StoreAt("shop/ratings", "request_count", 3, base, nil)
+	storage.StoreAt("shop/ratings", "request_count", 4, base.Add(30*time.Minute), nil)
+
+	stats := storage.Stats()
+	if stats.Series != 3 || stats.Points != 5 {
+		t.Errorf("Expected 3 series with 5 points, got %d and %d", stats.Series, stats.Points)
+	}
+	if !stats.Oldest.Equal(base) || !stats.Newest.Equal(base.Add(time.Hour)) {
+		t.Errorf("Expected points from %v to %v, got %v to %v", base, base.Add(time.Hour), stats.Oldest, stats.Newest)
+	}
+	want := map[string]int{"request_count": 4, "error_rate": 1}
+	if !reflect.DeepEqual(stats.MetricPoints, want) {
+		t.Errorf("Expected metric point counts %v, got %v", want, stats.MetricPoints)
+	}
+}
+
+func TestStorage_Changed(t *testing.T) {
+	storage := NewStorage()
+	storage.Store("shop/reviews", "latency_p99", 200, nil)
+	storage.Store("shop/reviews", "error_rate", 0, nil)
+	storage.Store("shop/reviews", "request_count", 1000, nil)
+	tolerances := map[string]float64{"latency_p99": 0.1, "error_rate": 0.01, "retry_count": 0.01}
+
+	cases := []struct {
+		name    string
+		values  map[string]float64
+		changed bool
+	}{
+		{"same values", map[string]float64{"latency_p99": 200, "error_rate": 0}, false},
+		{"within tolerance", map[string]float64{"latency_p99": 215, "error_rate": 0}, false},
+		{"beyond tolerance", map[string]float64{"latency_p99": 260, "error_rate": 0}, true},
+		{"errors from zero", map[string]float64{"latency_p99": 200, "error_rate": 0.001}, true},
+		{"new metric", map[string]float64{"retry_count": 0}, true},
+		{"counter without tolerance", map[string]float64{"latency_p99": 200, "request_count": 1500}, false},
+	}
+	for _, c := range cases {
+		if changed := storage.Changed("shop/reviews", c.values, tolerances); changed != c.changed {
+			t.Errorf("%s: expected changed=%v, got %v", c.name, c.changed, changed)
+		}
+	}
+	if !storage.Changed("shop/ratings", map[string]float64{"latency_p99": 200}, tolerances) {
+		t.Error("Expected a service with no history to count as changed")
+	}
+}
+
+func TestStorage_Store_UsesClock(t *testing.T) {
+	storage := NewStorage()
+	simulated := clock.NewSimulated(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	storage.SetClock(simulated)
+
+	storage.Store("test-service", "request_count", 100.0, nil)
+	simulated.Advance(time.Minute)
+	storage.Store("test-service", "request_count", 200.0, nil)
+
+	points := storage.GetLatestN("test-service", "request_count", 2)
+	if len(points) != 2 {
+		t.Fatalf("Expected 2 points, got %d", len(points))
+	}
+	if !points[0].Timestamp.Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) ||
+		!points[1].Timestamp.Equal(time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC)) {
+		t.Errorf("Expected points stamped by the simulated clock, got %v and %v", points[0].Timestamp, points[1].Timestamp)
+	}
+}
+
+func TestStorage_GetMatching(t *testing.T) {
+	storage := NewStorage()
+	storage.Store("reviews", "request_count", 100, map[string]string{"version": "v1", "cluster": "east"})
+	storage.Store("reviews", "request_count", 20, map[string]string{"version": "v2", "cluster": "east"})
+	storage.Store("reviews", "request_count", 90, map[string]string{"version": "v1", "cluster": "west"})
+
+	canary := storage.GetMatching("reviews", "request_count", map[string]string{"version": "v2"})
+	if len(canary) != 1 || canary[0].Value != 20 {
+		t.Errorf("Expected the v2 point, got %v", canary)
+	}
+
+	if east := storage.GetMatching("reviews", "request_count", map[string]string{"version": "v1", "cluster": "east"}); len(east) != 1 || east[0].Value != 100 {
+		t.Errorf("Expected the v1 east point, got %v", east)
+	}
+	if all := storage.GetMatching("reviews", "request_count", nil); len(all) != 3 {
+		t.Errorf("Expected every point with an empty selector, got %d", len(all))
+	}
+	if missing := storage.GetMatching("ratings", "request_count", nil); missing != nil {
+		t.Errorf("Expected no points for an unknown series, got %v", missing)
+	}
+}
+
+func TestStorage_Latest(t *testing.T) {
+	storage := NewStorage()
+	storage.Store("reviews", "request_bytes", 1024, nil)
+	storage.Store("reviews", "request_bytes", 4096, nil)
+
+	latest := storage.Latest("reviews", "request_bytes", "request_size_count")
+	if len(latest) != 1 || latest["request_bytes"] != 4096 {
+		t.Errorf("Expected only the latest request_bytes, got %v", latest)
+	}
+}